@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"test-ordent/config"
+	"test-ordent/internal/auth"
+	"test-ordent/internal/catalog"
+	"test-ordent/internal/database"
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+)
+
+const usage = `Usage: admin <command> [flags]
+
+Commands:
+  products import -file <path> [-format csv|jsonl] [-dry-run] [-best-effort]
+  products export [-format csv|jsonl] [-out <path>]
+  users create-admin -username <name> -email <address> -full-name <name>
+      Creates the first admin account. The password is read from
+      ADMIN_PASSWORD or, if unset, from the first line of stdin. Further
+      admins are invited from the API.
+  config print [-redacted=true|false] [-set <setting>=<value>]...
+      Prints the effective configuration, after environment variables and
+      overrides, then reports any validation errors. Secrets are redacted
+      unless -redacted=false.
+
+The configuration is read from CONFIG_PATH (default ./config/config.yaml)
+and APP_* environment variables, e.g. APP_DATABASE_HOST.
+`
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("missing command")
+	}
+
+	switch args[0] + " " + args[1] {
+	case "products import":
+		return importProducts(args[2:])
+	case "products export":
+		return exportProducts(args[2:])
+	case "users create-admin":
+		return createAdmin(args[2:])
+	case "config print":
+		return printConfig(args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", args[0]+" "+args[1])
+	}
+}
+
+func importProducts(args []string) error {
+	flags := flag.NewFlagSet("products import", flag.ContinueOnError)
+	path := flags.String("file", "", "CSV or JSONL file to import")
+	formatName := flags.String("format", "", "csv or jsonl (default: from the file extension)")
+	dryRun := flags.Bool("dry-run", false, "validate and report without saving")
+	bestEffort := flags.Bool("best-effort", false, "import every valid row instead of all-or-nothing")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		return fmt.Errorf("-file is required")
+	}
+
+	var format catalog.Format
+	var err error
+	if *formatName != "" {
+		format, err = catalog.ParseFormat(*formatName)
+	} else {
+		format, err = catalog.FormatFromFilename(*path)
+	}
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(*path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	db, cfg, err := connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	importer := catalog.NewImporter(repository.NewProductRepository(db, cfg.Events.StockLowThreshold))
+	report, err := importer.Import(context.Background(), file, catalog.ImportOptions{
+		Format: format,
+		Atomic: !*bestEffort,
+		DryRun: *dryRun,
+	})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d rows failed", report.Failed, report.Total)
+	}
+	return nil
+}
+
+func exportProducts(args []string) error {
+	flags := flag.NewFlagSet("products export", flag.ContinueOnError)
+	formatName := flags.String("format", "csv", "csv or jsonl")
+	out := flags.String("out", "", "output file (default: stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	format, err := catalog.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+
+	db, cfg, err := connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	return catalog.NewExporter(repository.NewProductRepository(db, cfg.Events.StockLowThreshold)).Export(context.Background(), w, format)
+}
+
+func createAdmin(args []string) error {
+	flags := flag.NewFlagSet("users create-admin", flag.ContinueOnError)
+	username := flags.String("username", "", "admin username")
+	email := flags.String("email", "", "admin email address")
+	fullName := flags.String("full-name", "", "admin full name")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" || *email == "" || *fullName == "" {
+		return fmt.Errorf("-username, -email and -full-name are required")
+	}
+
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if len(password) < 6 {
+		return fmt.Errorf("password must be at least 6 characters")
+	}
+
+	db, _, err := connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	userRepo := repository.NewUserRepository(db)
+	admins, err := userRepo.CountByRole(ctx, auth.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if admins > 0 {
+		return fmt.Errorf("an admin already exists; invite further admins through POST /api/admin/invitations")
+	}
+
+	exists, err := userRepo.ExistsByUsernameOrEmail(ctx, *username, *email)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("username or email already exists")
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	id, err := userRepo.Create(ctx, &model.User{
+		Username:      *username,
+		Email:         *email,
+		PasswordHash:  string(hashedPassword),
+		FullName:      *fullName,
+		Role:          auth.RoleAdmin,
+		EmailVerified: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created admin %q with ID %d\n", *username, id)
+	return nil
+}
+
+func printConfig(args []string) error {
+	flags := flag.NewFlagSet("config print", flag.ContinueOnError)
+	redacted := flags.Bool("redacted", true, "replace secrets with "+config.Redacted)
+	var overrides config.Overrides
+	flags.Var(&overrides, "set", "override a setting, e.g. -set database.host=db (repeatable)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(configPath(), overrides...)
+	if cfg == nil {
+		return err
+	}
+	if printErr := cfg.Print(os.Stdout, *redacted); printErr != nil {
+		return printErr
+	}
+	return err
+}
+
+func configPath() string {
+	if path := os.Getenv("CONFIG_PATH"); path != "" {
+		return path
+	}
+	return "./config/config.yaml"
+}
+
+func connect() (*sql.DB, *config.Config, error) {
+	cfg, err := config.LoadConfig(configPath())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	db, err := database.NewPostgresConnection(cfg.Database)
+	return db, cfg, err
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -12,11 +19,27 @@ import (
 
 	"test-ordent/config"
 	_ "test-ordent/docs"
+	"test-ordent/internal/account"
 	"test-ordent/internal/auth"
+	"test-ordent/internal/catalog"
 	"test-ordent/internal/database"
+	"test-ordent/internal/events"
 	"test-ordent/internal/handler"
+	"test-ordent/internal/health"
+	"test-ordent/internal/mail"
+	"test-ordent/internal/metrics"
 	"test-ordent/internal/model"
+	"test-ordent/internal/oidc"
+	"test-ordent/internal/ratelimit"
+	"test-ordent/internal/reload"
 	"test-ordent/internal/repository"
+	"test-ordent/internal/security"
+	"test-ordent/internal/server"
+	"test-ordent/internal/storage"
+	"test-ordent/internal/tracing"
+	"test-ordent/internal/version"
+	"test-ordent/internal/webhook"
+	"test-ordent/internal/worker"
 	"test-ordent/pkg/logger"
 )
 
@@ -34,57 +57,296 @@ import (
 // @in header
 // @name Authorization
 // @description Type "Bearer" followed by a space and the JWT token.
+// @securityDefinitions.apiKey APIKeyAuth
+// @in header
+// @name X-API-Key
+// @description API key for server-to-server integrations, created at /admin/api-keys.
 func main() {
-	cfg, err := loadConfig()
+	configPath, overrides := parseFlags()
+	cfg, err := config.LoadConfig(configPath, overrides...)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
-	if cfg.Auth.JWTSecret == "" {
-		log.Fatal("JWT secret cannot be empty")
+
+	logger, err := logger.New(os.Stdout, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		log.Fatalf("Failed to initialize logger: %v", err)
 	}
+	slog.SetDefault(logger.Logger)
+	if err := run(cfg, configPath, overrides, logger); err != nil {
+		logger.Error("Server failed", "error", err)
+		os.Exit(1)
+	}
+	logger.Info("Server stopped")
+}
 
-	logger := initLogger(cfg.Server.Debug)
+// run serves until SIGINT or SIGTERM, then drains requests and stops the
+// background workers before the database is closed. It returns errors rather
+// than exiting so that this cleanup always runs.
+func run(cfg *config.Config, configPath string, overrides []string, logger *logger.Logger) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Installed first and shut down last, so spans of draining requests and
+	// stopping workers are still exported.
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, version.Version)
+	if err != nil {
+		return fmt.Errorf("failed to initialize tracing: %w", err)
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			logger.Error("Failed to flush traces", "error", err)
+		}
+	}()
 
     db, err := database.NewPostgresConnection(cfg.Database)
     if err != nil {
-        logger.Fatal("Failed to connect to database:", err)
+        return fmt.Errorf("failed to connect to database: %w", err)
     }
     defer db.Close()
 
     userRepo := repository.NewUserRepository(db)
-    productRepo := repository.NewProductRepository(db)
+    productRepo := repository.NewProductRepository(db, cfg.Events.StockLowThreshold)
     cartRepo := repository.NewCartRepository(db)
-    orderRepo := repository.NewOrderRepository(db)
+    orderRepo := repository.NewOrderRepository(db, cfg.Events.StockLowThreshold)
+    productImageRepo := repository.NewProductImageRepository(db)
+    priceRepo := repository.NewPriceRepository(db)
+    actionTokenRepo := repository.NewActionTokenRepository(db)
+    invitationRepo := repository.NewInvitationRepository(db)
+    twoFactorRepo := repository.NewTwoFactorRepository(db)
+    identityRepo := repository.NewIdentityRepository(db)
+    apiKeyRepo := repository.NewAPIKeyRepository(db)
+	webhookRepo := repository.NewWebhookRepository(db)
+
+	fileStorage, err := storage.New(cfg.Storage)
+	if err != nil {
+		return fmt.Errorf("failed to initialize storage: %w", err)
+	}
+
+	mailer, err := mail.New(cfg.Mail)
+	if err != nil {
+		return fmt.Errorf("failed to initialize mailer: %w", err)
+	}
+	accounts := account.NewService(userRepo, actionTokenRepo, auth.NewActionTokenSigner(cfg.Auth.JWTSecret), mailer,
+		cfg.Mail.LinkBaseURL, cfg.Auth.VerifyEmailTTL, cfg.Auth.ResetPasswordTTL)
+	// Password resets still being sent finish before the database closes.
+	defer accounts.Wait()
+	inviter := account.NewInviter(invitationRepo, mailer, cfg.Mail.LinkBaseURL, cfg.Auth.InvitationTTL)
+	twoFactor := account.NewTwoFactor(twoFactorRepo, auth.NewSecretCipher(cfg.Auth.EncryptionKey, auth.CipherLabelTOTP), auth.NewActionTokenSigner(cfg.Auth.JWTSecret),
+		cfg.Auth.TwoFactor.Issuer, cfg.Auth.TwoFactor.RequiredRoles, cfg.Auth.TwoFactor.ChallengeTTL)
+
+	var attemptStore auth.AttemptStore
+	switch cfg.Auth.Lockout.Store {
+	case "postgres":
+		attemptStore = repository.NewPostgresAttemptStore(db)
+	case "memory", "":
+		attemptStore = auth.NewMemoryAttemptStore()
+	default:
+		return fmt.Errorf("unknown login attempt store %q", cfg.Auth.Lockout.Store)
+	}
+	loginGuard := auth.NewLoginGuard(attemptStore, auth.LockoutPolicy{
+		MaxAccountFailures: cfg.Auth.Lockout.MaxAccountFailures,
+		MaxIPFailures:      cfg.Auth.Lockout.MaxIPFailures,
+		BaseDelay:          cfg.Auth.Lockout.BaseDelay,
+		MaxDelay:           cfg.Auth.Lockout.MaxDelay,
+		LockoutDuration:    cfg.Auth.Lockout.LockoutDuration,
+		Window:             cfg.Auth.Lockout.Window,
+	})
+
+	// In-process subscribers register on the bus; every event is also sent
+	// to the configured HTTP sinks and NATS.
+	eventBus := events.NewBus()
+	eventSink, err := events.NewSink(cfg.Events, eventBus)
+	if err != nil {
+		return fmt.Errorf("failed to initialize event sinks: %w", err)
+	}
+	defer eventSink.Close()
+	// Partner webhooks are queued as the relay publishes each event.
+	eventBus.Subscribe(events.AllEvents, webhookRepo.Enqueue)
+	webhookCipher := auth.NewSecretCipher(cfg.Auth.EncryptionKey, auth.CipherLabelWebhook)
+	webhookDeliverer := webhook.NewDeliverer(webhookRepo, webhookCipher, cfg.Webhooks)
+
+	// Workers outlive the HTTP server so that requests being drained can
+	// still rely on them; they are stopped, and waited for, afterwards.
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	defer func() {
+		stopWorkers()
+		workers.Wait()
+		logger.Info("Background workers stopped")
+	}()
+	startWorker := func(run func(context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(workerCtx)
+		}()
+	}
+	startWorker(worker.NewPriceScheduler(priceRepo, cfg.Pricing.ScheduleInterval, logger).Run)
+	startWorker(worker.NewOutboxRelay(repository.NewOutboxRepository(db), eventSink, cfg.Events.RelayInterval, cfg.Events.BatchSize,
+		cfg.Events.RetryBackoff, cfg.Events.MaxRetryBackoff, cfg.Events.Retention, logger).Run)
+	startWorker(worker.NewWebhookDispatcher(webhookDeliverer, cfg.Webhooks.DeliveryInterval, cfg.Webhooks.BatchSize, logger).Run)
+
+	appMetrics := metrics.New()
+	appMetrics.RegisterDB(db, cfg.Database.DBName)
 
 	e := echo.New()
-	e.Use(middleware.Logger())
+	server.Configure(e, cfg.Server)
+	e.Logger = logger.Echo()
+	e.HTTPErrorHandler = handler.HTTPErrorHandler
+	e.Use(tracing.Middleware)
+	e.Use(logger.Middleware("/healthz", "/readyz", "/metrics"))
+	e.Use(appMetrics.Middleware)
 	e.Use(middleware.Recover())
-	e.Use(middleware.CORS())
 
-	jwtMiddleware := auth.NewJWTMiddleware(cfg.Auth.JWTSecret)
+	// The rate limit store is kept across reloads, so buckets survive them.
+	rateLimitStore, err := ratelimit.NewStore(cfg.RateLimit)
+	if err != nil {
+		return fmt.Errorf("failed to initialize rate limit store: %w", err)
+	}
+	readiness := health.NewReadiness(cfg.Server.HealthCheckTimeout)
+	readiness.Register(health.Check{Name: "database", Checker: health.CheckerFunc(db.PingContext)})
+	readiness.Register(health.Check{Name: "schema", Checker: health.CheckerFunc(func(ctx context.Context) error {
+		return database.CheckSchema(ctx, db, database.RequiredTables)
+	})})
+	// Rate limiting fails open, so the server stays ready without Redis.
+	if checker, ok := rateLimitStore.(health.Checker); ok {
+		readiness.Register(health.Check{Name: "rate_limit_store", Checker: checker, Optional: true})
+	}
+
+	jwtMiddleware := auth.NewJWTMiddleware(cfg.Auth.JWTSecret, userRepo, cfg.Auth.TwoFactor.RequiredRoles)
+	// Routes for integrations also accept an API key.
+	apiKeyMiddleware := auth.NewAPIKeyMiddleware(apiKeyRepo, userRepo, jwtMiddleware)
+
+	httpMiddleware, err := buildHTTPMiddleware(cfg, rateLimitStore, apiKeyMiddleware.Identify)
+	if err != nil {
+		return fmt.Errorf("invalid HTTP configuration: %w", err)
+	}
+	reloadableMiddleware := reload.NewMiddleware(httpMiddleware)
+	e.Use(reloadableMiddleware.Handle)
+
+	reloader := reload.New(configPath, overrides, cfg, logger)
+	reloader.Register(func(next *config.Config) (func(), error) {
+		mw, err := buildHTTPMiddleware(next, rateLimitStore, apiKeyMiddleware.Identify)
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			reloadableMiddleware.Swap(mw)
+			// Validated by LoadConfig.
+			_ = logger.SetLevel(next.Log.Level)
+		}, nil
+	})
+	startWorker(func(ctx context.Context) {
+		if err := reloader.Watch(ctx); err != nil {
+			logger.Error("Config watcher stopped", "error", err)
+		}
+	})
 
 	api := e.Group("/api")
 	
-	authHandler := handler.NewAuthHandler(userRepo, cfg.Auth.JWTSecret, cfg.Auth.TokenExpiry, cfg.Auth.AdminSecret)
+	authHandler := handler.NewAuthHandler(userRepo, accounts, loginGuard, repository.NewLoginAuditRepository(db), cfg.Auth.JWTSecret, cfg.Auth.TokenExpiry, inviter, twoFactor, cfg.Auth.RequireVerifiedEmail, appMetrics)
 	api.POST("/auth/login", authHandler.Login)
+	api.POST("/auth/login/2fa", authHandler.LoginTwoFactor)
 	api.POST("/auth/register", authHandler.Register)
-	api.POST("/auth/admin-register", authHandler.RegisterAdmin)
+	api.POST("/auth/accept-invitation", authHandler.AcceptInvitation)
+	api.POST("/auth/forgot-password", authHandler.ForgotPassword)
+	api.POST("/auth/reset-password", authHandler.ResetPassword)
+	api.POST("/auth/verify-email", authHandler.VerifyEmail)
+
+	oidcHandler := handler.NewOIDCHandler(oidc.NewRegistry(cfg.OIDC), account.NewSocialLogin(userRepo, identityRepo), twoFactor,
+		auth.NewSecretCipher(cfg.Auth.EncryptionKey, auth.CipherLabelOIDCState), cfg.Auth.JWTSecret, cfg.Auth.TokenExpiry,
+		cfg.OIDC.StateTTL, cfg.OIDC.SuccessRedirectURL, cfg.Auth.RequireVerifiedEmail)
+	api.GET("/auth/oidc/providers", oidcHandler.ListProviders)
+	api.GET("/auth/oidc/:provider/login", oidcHandler.Login)
+	api.GET("/auth/oidc/:provider/callback", oidcHandler.Callback)
+	api.GET("/users/me/identities", oidcHandler.ListIdentities, jwtMiddleware.RequireAuth)
+
+	userHandler := handler.NewUserHandler(userRepo, accounts)
+	api.GET("/users/me", userHandler.GetMe, jwtMiddleware.RequireAuth)
+	api.PATCH("/users/me", userHandler.UpdateMe, jwtMiddleware.RequireAuth)
+	api.DELETE("/users/me", userHandler.DeleteMe, jwtMiddleware.RequireAuth)
+	api.PUT("/users/me/password", userHandler.ChangePassword, jwtMiddleware.RequireAuth)
+	api.POST("/users/me/verify-email", userHandler.ResendVerification, jwtMiddleware.RequireAuth)
+
+	twoFactorHandler := handler.NewTwoFactorHandler(userRepo, twoFactor)
+	api.GET("/users/me/2fa", twoFactorHandler.GetTwoFactor, jwtMiddleware.RequireAuth)
+	api.POST("/users/me/2fa/enroll", twoFactorHandler.EnrollTwoFactor, jwtMiddleware.RequireAuth)
+	api.POST("/users/me/2fa/activate", twoFactorHandler.ActivateTwoFactor, jwtMiddleware.RequireAuth)
+	api.POST("/users/me/2fa/recovery-codes", twoFactorHandler.RegenerateRecoveryCodes, jwtMiddleware.RequireAuth)
+	api.DELETE("/users/me/2fa", twoFactorHandler.DisableTwoFactor, jwtMiddleware.RequireAuth)
+
+	api.GET("/admin/users", userHandler.ListUsers, jwtMiddleware.RequirePermission(auth.PermUsersRead))
+	api.PUT("/admin/users/:id/status", userHandler.UpdateUserStatus, jwtMiddleware.RequirePermission(auth.PermUsersManage))
+	api.PUT("/admin/users/:id/role", userHandler.UpdateUserRole, jwtMiddleware.RequirePermission(auth.PermRolesManage))
+	api.GET("/admin/roles", userHandler.ListRoles, jwtMiddleware.RequirePermission(auth.PermUsersRead))
+	api.DELETE("/admin/users/:id/2fa", twoFactorHandler.ResetUserTwoFactor, jwtMiddleware.RequirePermission(auth.PermUsersManage))
 
-	productHandler := handler.NewProductHandler(productRepo)
+	invitationHandler := handler.NewInvitationHandler(userRepo, invitationRepo, inviter)
+	api.POST("/admin/invitations", invitationHandler.CreateInvitation, jwtMiddleware.RequirePermission(auth.PermRolesManage))
+	api.GET("/admin/invitations", invitationHandler.ListInvitations, jwtMiddleware.RequirePermission(auth.PermRolesManage))
+	api.DELETE("/admin/invitations/:id", invitationHandler.RevokeInvitation, jwtMiddleware.RequirePermission(auth.PermRolesManage))
+
+	auditHandler := handler.NewAuditHandler(repository.NewAuditEventRepository(db))
+	api.GET("/admin/audit-events", auditHandler.ListAuditEvents, jwtMiddleware.RequirePermission(auth.PermAuditRead))
+	api.GET("/admin/audit-events/verify", auditHandler.VerifyAuditLog, jwtMiddleware.RequirePermission(auth.PermAuditRead))
+
+	apiKeyHandler := handler.NewAPIKeyHandler(apiKeyRepo, cfg.Auth.APIKeys.DefaultTTL, cfg.Auth.APIKeys.MaxTTL)
+	api.POST("/admin/api-keys", apiKeyHandler.CreateAPIKey, jwtMiddleware.RequirePermission(auth.PermAPIKeysManage))
+	api.GET("/admin/api-keys", apiKeyHandler.ListAPIKeys, jwtMiddleware.RequirePermission(auth.PermAPIKeysManage))
+	api.DELETE("/admin/api-keys/:id", apiKeyHandler.RevokeAPIKey, jwtMiddleware.RequirePermission(auth.PermAPIKeysManage))
+
+	webhookHandler := handler.NewWebhookHandler(webhookRepo, webhookDeliverer, webhookCipher)
+	api.POST("/admin/webhooks", webhookHandler.CreateWebhook, jwtMiddleware.RequirePermission(auth.PermWebhooksManage))
+	api.GET("/admin/webhooks", webhookHandler.ListWebhooks, jwtMiddleware.RequirePermission(auth.PermWebhooksManage))
+	api.GET("/admin/webhooks/:id", webhookHandler.GetWebhook, jwtMiddleware.RequirePermission(auth.PermWebhooksManage))
+	api.PUT("/admin/webhooks/:id", webhookHandler.UpdateWebhook, jwtMiddleware.RequirePermission(auth.PermWebhooksManage))
+	api.DELETE("/admin/webhooks/:id", webhookHandler.DeleteWebhook, jwtMiddleware.RequirePermission(auth.PermWebhooksManage))
+	api.POST("/admin/webhooks/:id/ping", webhookHandler.PingWebhook, jwtMiddleware.RequirePermission(auth.PermWebhooksManage))
+	api.GET("/admin/webhooks/:id/deliveries", webhookHandler.ListWebhookDeliveries, jwtMiddleware.RequirePermission(auth.PermWebhooksManage))
+	api.GET("/admin/webhooks/:id/deliveries/:deliveryId", webhookHandler.GetWebhookDelivery, jwtMiddleware.RequirePermission(auth.PermWebhooksManage))
+	api.POST("/admin/webhooks/:id/deliveries/:deliveryId/redeliver", webhookHandler.RedeliverWebhook, jwtMiddleware.RequirePermission(auth.PermWebhooksManage))
+
+	productHandler := handler.NewProductHandler(productRepo, productImageRepo)
 	api.GET("/products", productHandler.GetProducts)
+	api.GET("/products/archived", productHandler.GetArchivedProducts, apiKeyMiddleware.RequirePermission(auth.PermProductsWrite))
 	api.GET("/products/:id", productHandler.GetProduct)
-	api.POST("/products", productHandler.CreateProduct, jwtMiddleware.RequireAdmin)
-	api.PUT("/products/:id", productHandler.UpdateProduct, jwtMiddleware.RequireAdmin)
-	api.DELETE("/products/:id", productHandler.DeleteProduct, jwtMiddleware.RequireAdmin)
+	api.POST("/products", productHandler.CreateProduct, apiKeyMiddleware.RequirePermission(auth.PermProductsWrite))
+	api.PUT("/products/:id", productHandler.UpdateProduct, apiKeyMiddleware.RequirePermission(auth.PermProductsWrite))
+	api.PATCH("/products/:id", productHandler.PatchProduct, apiKeyMiddleware.RequirePermission(auth.PermProductsWrite))
+	api.DELETE("/products/:id", productHandler.DeleteProduct, apiKeyMiddleware.RequirePermission(auth.PermProductsWrite))
+	api.POST("/products/:id/restore", productHandler.RestoreProduct, apiKeyMiddleware.RequirePermission(auth.PermProductsWrite))
 
-	cartHandler := handler.NewCartHandler(cartRepo, productRepo, db)
+	productImageHandler := handler.NewProductImageHandler(productRepo, productImageRepo, fileStorage, cfg.Storage.MaxUploadSize, cfg.Storage.MaxImageDimension, cfg.Storage.MaxImagePixels, cfg.Storage.ThumbnailSizes)
+	api.GET("/products/:id/images", productImageHandler.GetImages)
+	api.POST("/products/:id/images", productImageHandler.UploadImage, apiKeyMiddleware.RequirePermission(auth.PermProductsWrite))
+	api.PUT("/products/:id/images/order", productImageHandler.ReorderImages, apiKeyMiddleware.RequirePermission(auth.PermProductsWrite))
+	api.DELETE("/products/:id/images/:imageId", productImageHandler.DeleteImage, apiKeyMiddleware.RequirePermission(auth.PermProductsWrite))
+
+	priceHandler := handler.NewPriceHandler(productRepo, priceRepo)
+	api.GET("/products/:id/price-history", priceHandler.GetPriceHistory, apiKeyMiddleware.RequirePermission(auth.PermPricesManage))
+	api.GET("/products/:id/price-schedules", priceHandler.GetPriceSchedules, apiKeyMiddleware.RequirePermission(auth.PermPricesManage))
+	api.POST("/products/:id/price-schedules", priceHandler.CreatePriceSchedule, apiKeyMiddleware.RequirePermission(auth.PermPricesManage))
+	api.DELETE("/products/:id/price-schedules/:scheduleId", priceHandler.CancelPriceSchedule, apiKeyMiddleware.RequirePermission(auth.PermPricesManage))
+
+	catalogHandler := handler.NewCatalogHandler(catalog.NewImporter(productRepo), catalog.NewExporter(productRepo))
+	api.POST("/products/import", catalogHandler.ImportProducts, apiKeyMiddleware.RequirePermission(auth.PermProductsImport))
+	api.GET("/products/export", catalogHandler.ExportProducts, apiKeyMiddleware.RequirePermission(auth.PermProductsImport))
+
+	cartHandler := handler.NewCartHandler(cartRepo, productRepo, db, appMetrics)
 	api.GET("/cart", cartHandler.GetCart, jwtMiddleware.RequireAuth)
 	api.POST("/cart/items", cartHandler.AddItem, jwtMiddleware.RequireAuth)
 	api.DELETE("/cart/items/:id", cartHandler.RemoveItem, jwtMiddleware.RequireAuth)
 
-    orderHandler := handler.NewOrderHandler(orderRepo, cartRepo, productRepo, db)
+    orderHandler := handler.NewOrderHandler(orderRepo, cartRepo, productRepo, db, appMetrics)
     api.POST("/orders", orderHandler.CreateOrder, jwtMiddleware.RequireAuth)
     api.GET("/orders", orderHandler.GetOrders, jwtMiddleware.RequireAuth)
+	api.GET("/admin/orders", orderHandler.ListAdminOrders, apiKeyMiddleware.RequirePermission(auth.PermOrdersRead))
+	api.GET("/admin/orders/:id", orderHandler.GetAdminOrder, apiKeyMiddleware.RequirePermission(auth.PermOrdersRead))
+	api.PUT("/admin/orders/:id/status", orderHandler.UpdateOrderStatus, apiKeyMiddleware.RequirePermission(auth.PermOrdersFulfil))
+	api.POST("/admin/orders/:id/refund", orderHandler.RefundOrder, apiKeyMiddleware.RequirePermission(auth.PermOrdersRefund))
 
 	api.GET("/categories", func(c echo.Context) error {
 		rows, err := db.Query("SELECT id, name, description FROM categories")
@@ -112,24 +374,61 @@ func main() {
 		})
 	})
 
+	if local, ok := fileStorage.(*storage.LocalStorage); ok {
+		e.Static(cfg.Storage.Local.BaseURL, local.Dir())
+	}
+
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
 
+	e.GET("/healthz", health.Liveness)
+	e.GET("/readyz", readiness.Handler)
+	e.GET("/version", version.Handler)
+	e.GET("/metrics", appMetrics.Handler())
+
 	serverAddr := fmt.Sprintf(":%d", cfg.Server.Port)
-	logger.Info(fmt.Sprintf("Server starting on %s", serverAddr))
-	if err := e.Start(serverAddr); err != http.ErrServerClosed {
-		logger.Fatal("Failed to start server:", err)
-	}
+	logger.Info("Server starting", "version", version.Version, "addr", serverAddr)
+	return server.Serve(ctx, e, serverAddr, cfg.Server, readiness, logger)
 }
 
-func loadConfig() (*config.Config, error) {
-	configPath := os.Getenv("CONFIG_PATH")
-	if configPath == "" {
-		configPath = "./config/config.yaml"
+// parseFlags returns the config file and the -set overrides, which are
+// layered over the defaults and APP_* environment variables.
+func parseFlags() (string, []string) {
+	defaultPath := os.Getenv("CONFIG_PATH")
+	if defaultPath == "" {
+		defaultPath = "./config/config.yaml"
 	}
-	
-	return config.LoadConfig(configPath)
+
+	configPath := flag.String("config", defaultPath, "path of the YAML config file")
+	var overrides config.Overrides
+	flag.Var(&overrides, "set", "override a setting, e.g. -set database.host=db (repeatable)")
+	flag.Parse()
+
+	return *configPath, overrides
 }
 
-func initLogger(debug bool) *logger.Logger {
-	return logger.NewLogger(debug)
-}
\ No newline at end of file
+// buildHTTPMiddleware builds the middleware that hot reload can replace:
+// CORS, security headers, body limits and rate limiting.
+func buildHTTPMiddleware(cfg *config.Config, rateLimitStore ratelimit.Store, apiKeys ratelimit.APIKeyIdentifier) (echo.MiddlewareFunc, error) {
+	cors, err := security.CORS(cfg.CORS)
+	if err != nil {
+		return nil, err
+	}
+	mws := []echo.MiddlewareFunc{cors}
+	if cfg.SecurityHeaders.Enabled {
+		mws = append(mws, security.Headers(cfg.SecurityHeaders, "/swagger/"))
+	}
+	bodyLimit, err := security.BodyLimit(cfg.BodyLimit)
+	if err != nil {
+		return nil, err
+	}
+	mws = append(mws, bodyLimit)
+
+	if cfg.RateLimit.Enabled {
+		limiter, err := ratelimit.New(rateLimitStore, cfg.RateLimit, cfg.Auth.JWTSecret, apiKeys)
+		if err != nil {
+			return nil, err
+		}
+		mws = append(mws, limiter.Middleware)
+	}
+	return reload.Chain(mws...), nil
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Change is a setting that differs between two configurations. Old and New
+// are rendered as in the config file, with secrets redacted.
+type Change struct {
+	Key        string
+	Old        string
+	New        string
+	Reloadable bool
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Key, c.Old, c.New)
+}
+
+// Diff lists the settings that differ between old and new. Lists and maps
+// are compared as a whole.
+func Diff(old, new *Config) []Change {
+	return diffValues(reflect.ValueOf(*old), reflect.ValueOf(*new), "", false)
+}
+
+func diffValues(old, new reflect.Value, key string, reloadable bool) []Change {
+	var changes []Change
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		if key != "" {
+			name = key + "." + name
+		}
+		fieldReloadable := reloadable || field.Tag.Get("reload") == "true"
+
+		oldField, newField := old.Field(i), new.Field(i)
+		if oldField.Kind() == reflect.Struct {
+			changes = append(changes, diffValues(oldField, newField, name, fieldReloadable)...)
+			continue
+		}
+		if reflect.DeepEqual(oldField.Interface(), newField.Interface()) {
+			continue
+		}
+
+		change := Change{Key: name, Reloadable: fieldReloadable}
+		if field.Tag.Get("secret") == "true" {
+			change.Old, change.New = redacted(oldField), redacted(newField)
+		} else {
+			change.Old, change.New = render(printValue(oldField, true)), render(printValue(newField, true))
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func redacted(v reflect.Value) string {
+	if v.IsZero() {
+		return `""`
+	}
+	return Redacted
+}
+
+// render writes a printed value on a single line, in YAML flow style.
+func render(v interface{}) string {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprintf("%v: %s", item.Key, render(item.Value))
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = render(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case string:
+		if v == "" {
+			return `""`
+		}
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix starts the environment variables that override settings, e.g.
+// APP_DATABASE_HOST for database.host.
+const EnvPrefix = "APP"
+
+// fileSuffix marks an environment variable holding the path of a file with
+// the setting's value, e.g. APP_AUTH_JWT_SECRET_FILE=/run/secrets/jwt.
+const fileSuffix = "_FILE"
+
+// LoadConfig builds the configuration from, in increasing precedence, the
+// defaults, the YAML file at path (skipped when path is empty), environment
+// variables and overrides of the form "database.host=db". The result is
+// validated; on a validation error the configuration is returned as well.
+func LoadConfig(path string, overrides ...string) (*Config, error) {
+	v := viper.New()
+	if path != "" {
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+	}
+
+	keys := settingKeys(reflect.TypeOf(Config{}), "")
+	for _, key := range keys {
+		if !key.fromEnv {
+			continue
+		}
+		env := EnvName(key.name)
+		if err := v.BindEnv(key.name, env); err != nil {
+			return nil, err
+		}
+		if file, ok := os.LookupEnv(env + fileSuffix); ok {
+			if _, set := os.LookupEnv(env); set {
+				return nil, fmt.Errorf("both %s and %s are set", env, env+fileSuffix)
+			}
+			value, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", env+fileSuffix, err)
+			}
+			v.Set(key.name, strings.TrimRight(string(value), "\r\n"))
+		}
+	}
+
+	for _, override := range overrides {
+		name, value, ok := strings.Cut(override, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || !isSettingKey(keys, name) {
+			return nil, fmt.Errorf("invalid override %q, expected <setting>=<value> for a known setting", override)
+		}
+		v.Set(name, value)
+	}
+
+	cfg := Default()
+	err := v.Unmarshal(cfg, func(dc *mapstructure.DecoderConfig) {
+		dc.TagName = "yaml"
+		// Lists and maps that are set replace the defaults rather than
+		// being merged into them.
+		dc.ZeroFields = true
+		dc.ErrorUnused = true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode configuration: %w", err)
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// EnvName returns the environment variable for a setting key.
+func EnvName(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+type settingKey struct {
+	name string
+	// fromEnv is false for maps and lists of sections, which cannot be
+	// written as a single environment variable.
+	fromEnv bool
+	// prefix is set for maps, whose entries are addressed as name.<key>.
+	prefix bool
+}
+
+// settingKeys lists the keys of every setting in t, a struct type, by their
+// yaml tags.
+func settingKeys(t reflect.Type, parent string) []settingKey {
+	var keys []settingKey
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := tag
+		if parent != "" {
+			name = parent + "." + tag
+		}
+
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			keys = append(keys, settingKeys(field.Type, name)...)
+		case reflect.Map:
+			keys = append(keys, settingKey{name: name, prefix: true})
+		case reflect.Slice:
+			keys = append(keys, settingKey{name: name, fromEnv: field.Type.Elem().Kind() != reflect.Struct})
+		default:
+			keys = append(keys, settingKey{name: name, fromEnv: true})
+		}
+	}
+	return keys
+}
+
+func isSettingKey(keys []settingKey, name string) bool {
+	for _, key := range keys {
+		if key.name == name && key.fromEnv || key.prefix && strings.HasPrefix(name, key.name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Overrides collects repeated -set flags.
+type Overrides []string
+
+func (o *Overrides) String() string {
+	return strings.Join(*o, ", ")
+}
+
+func (o *Overrides) Set(value string) error {
+	*o = append(*o, value)
+	return nil
+}
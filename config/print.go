@@ -0,0 +1,67 @@
+package config
+
+import (
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Redacted replaces the value of every setting tagged secret:"true" when the
+// configuration is printed.
+const Redacted = "[REDACTED]"
+
+// Print writes the configuration as YAML, in the layout of the config file.
+// Durations are written as in the file ("15m0s"); with redact, secrets that
+// are set are replaced by Redacted.
+func (c *Config) Print(w io.Writer, redact bool) error {
+	out, err := yaml.Marshal(printValue(reflect.ValueOf(*c), redact))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+func printValue(v reflect.Value, redact bool) interface{} {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		return time.Duration(v.Int()).String()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		var out yaml.MapSlice
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			value := printValue(v.Field(i), redact)
+			if redact && field.Tag.Get("secret") == "true" && !v.Field(i).IsZero() {
+				value = Redacted
+			}
+			out = append(out, yaml.MapItem{Key: name, Value: value})
+		}
+		return out
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		out := yaml.MapSlice{}
+		for _, key := range keys {
+			out = append(out, yaml.MapItem{Key: key.Interface(), Value: printValue(v.MapIndex(key), redact)})
+		}
+		return out
+	case reflect.Slice:
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = printValue(v.Index(i), redact)
+		}
+		return out
+	default:
+		return v.Interface()
+	}
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// minJWTSecretLength keeps the HMAC key used for tokens and action tokens
+// out of brute-force range.
+const minJWTSecretLength = 16
+
+// minEncryptionKeyLength does the same for the key that encrypts stored
+// secrets.
+const minEncryptionKeyLength = 16
+
+// ValidationError lists every problem found in a configuration, so they can
+// all be fixed at once.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) check(ok bool, format string, args ...interface{}) {
+	if !ok {
+		v.problems = append(v.problems, fmt.Sprintf(format, args...))
+	}
+}
+
+func (v *validator) oneOf(key, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.problems = append(v.problems, fmt.Sprintf("%s must be one of %s, got %q", key, strings.Join(allowed, ", "), value))
+}
+
+// Validate checks the configuration and returns a *ValidationError listing
+// every problem, or nil.
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	v.check(c.Server.Port > 0 && c.Server.Port < 65536, "server.port must be between 1 and 65535")
+	v.check(c.Server.ReadTimeout >= 0 && c.Server.ReadHeaderTimeout >= 0 && c.Server.WriteTimeout >= 0 && c.Server.IdleTimeout >= 0,
+		"server read, write and idle timeouts must not be negative (0 disables them)")
+	v.check(c.Server.DrainDelay >= 0, "server.drain_delay must not be negative")
+	v.check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	v.check(c.Server.HealthCheckTimeout > 0, "server.health_check_timeout must be positive")
+	for i, cidr := range c.Server.TrustedProxies {
+		_, _, err := net.ParseCIDR(cidr)
+		v.check(err == nil, "server.trusted_proxies[%d] must be a CIDR range, got %q", i, cidr)
+	}
+
+	v.oneOf("log.level", c.Log.Level, "debug", "info", "warn", "error")
+	v.oneOf("log.format", c.Log.Format, "json", "text")
+
+	if c.Tracing.Enabled {
+		v.oneOf("tracing.exporter", c.Tracing.Exporter, "otlp", "stdout")
+		v.check(c.Tracing.ServiceName != "", "tracing.service_name is required")
+		v.check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+	}
+
+	v.check(c.Database.Host != "", "database.host is required")
+	v.check(c.Database.Port > 0 && c.Database.Port < 65536, "database.port must be between 1 and 65535")
+	v.check(c.Database.User != "", "database.user is required")
+	v.check(c.Database.DBName != "", "database.dbname is required")
+	v.oneOf("database.sslmode", c.Database.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full")
+	v.check(c.Database.MaxOpenConns >= 0 && c.Database.MaxIdleConns >= 0, "database.max_open_conns and max_idle_conns must not be negative")
+	v.check(c.Database.MaxOpenConns == 0 || c.Database.MaxIdleConns <= c.Database.MaxOpenConns, "database.max_idle_conns must not exceed max_open_conns")
+
+	v.check(c.Auth.JWTSecret != "", "auth.jwt_secret is required (set %s or %s%s)", EnvName("auth.jwt_secret"), EnvName("auth.jwt_secret"), fileSuffix)
+	v.check(c.Auth.JWTSecret == "" || len(c.Auth.JWTSecret) >= minJWTSecretLength, "auth.jwt_secret must be at least %d characters", minJWTSecretLength)
+	v.check(c.Auth.EncryptionKey != "", "auth.encryption_key is required (set %s or %s%s)", EnvName("auth.encryption_key"), EnvName("auth.encryption_key"), fileSuffix)
+	v.check(c.Auth.EncryptionKey == "" || len(c.Auth.EncryptionKey) >= minEncryptionKeyLength, "auth.encryption_key must be at least %d characters", minEncryptionKeyLength)
+	v.check(c.Auth.EncryptionKey == "" || c.Auth.EncryptionKey != c.Auth.JWTSecret, "auth.encryption_key must differ from auth.jwt_secret")
+	v.check(c.Auth.TokenExpiry > 0, "auth.token_expiry must be positive")
+	v.check(c.Auth.InvitationTTL > 0 && c.Auth.VerifyEmailTTL > 0 && c.Auth.ResetPasswordTTL > 0,
+		"auth.invitation_ttl, verify_email_ttl and reset_password_ttl must be positive")
+	v.oneOf("auth.lockout.store", c.Auth.Lockout.Store, "memory", "postgres")
+	v.check(c.Auth.Lockout.MaxAccountFailures > 0 && c.Auth.Lockout.MaxIPFailures > 0, "auth.lockout max failures must be positive")
+	v.check(c.Auth.Lockout.BaseDelay <= c.Auth.Lockout.MaxDelay, "auth.lockout.base_delay must not exceed max_delay")
+	v.check(c.Auth.TwoFactor.ChallengeTTL > 0, "auth.two_factor.challenge_ttl must be positive")
+	v.check(c.Auth.APIKeys.DefaultTTL > 0 && c.Auth.APIKeys.DefaultTTL <= c.Auth.APIKeys.MaxTTL,
+		"auth.api_keys.default_ttl must be positive and not exceed max_ttl")
+
+	if c.CORS.AllowCredentials {
+		for _, origin := range c.CORS.AllowedOrigins {
+			v.check(origin != "*", "cors.allow_credentials cannot be used with the \"*\" origin")
+		}
+	}
+	v.check(c.BodyLimit.Default >= 0, "body_limit.default must not be negative")
+	for i, route := range c.BodyLimit.Routes {
+		v.check(route.Limit > 0 && len(route.Paths) > 0, "body_limit.routes[%d] needs paths and a positive limit", i)
+	}
+
+	v.oneOf("storage.driver", c.Storage.Driver, "local", "s3")
+	v.check(c.Storage.MaxUploadSize > 0, "storage.max_upload_size must be positive")
+	v.check(c.Storage.MaxImageDimension > 0, "storage.max_image_dimension must be positive")
+	v.check(c.Storage.MaxImagePixels > 0, "storage.max_image_pixels must be positive")
+	if c.Storage.Driver == "s3" {
+		v.check(c.Storage.S3.Bucket != "" && c.Storage.S3.PublicURL != "", "storage.s3.bucket and public_url are required for the s3 driver")
+	}
+
+	v.check(c.Pricing.ScheduleInterval > 0, "pricing.schedule_interval must be positive")
+
+	v.check(c.Events.RelayInterval > 0, "events.relay_interval must be positive")
+	v.check(c.Events.BatchSize > 0, "events.batch_size must be positive")
+	v.check(c.Events.RetryBackoff > 0 && c.Events.MaxRetryBackoff >= c.Events.RetryBackoff,
+		"events.retry_backoff must be positive and not exceed max_retry_backoff")
+	v.check(c.Events.Retention > 0, "events.retention must be positive")
+	v.check(c.Events.StockLowThreshold >= 0, "events.stock_low_threshold must not be negative")
+	for i, sink := range c.Events.HTTPSinks {
+		u, err := url.Parse(sink.URL)
+		v.check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"events.http_sinks[%d].url must be an http or https URL", i)
+	}
+	if c.Events.NATS.Enabled {
+		u, err := url.Parse(c.Events.NATS.URL)
+		v.check(err == nil && u.Scheme == "nats" && u.Host != "", "events.nats.url must be a nats:// URL")
+	}
+
+	v.check(c.Webhooks.DeliveryInterval > 0, "webhooks.delivery_interval must be positive")
+	v.check(c.Webhooks.BatchSize > 0 && c.Webhooks.Concurrency > 0, "webhooks.batch_size and concurrency must be positive")
+	v.check(c.Webhooks.Timeout > 0, "webhooks.timeout must be positive")
+	v.check(c.Webhooks.MaxAttempts > 0, "webhooks.max_attempts must be positive")
+	v.check(c.Webhooks.RetryBackoff > 0 && c.Webhooks.MaxRetryBackoff >= c.Webhooks.RetryBackoff,
+		"webhooks.retry_backoff must be positive and not exceed max_retry_backoff")
+	for i, cidr := range c.Webhooks.AllowedNetworks {
+		_, _, err := net.ParseCIDR(cidr)
+		v.check(err == nil, "webhooks.allowed_networks[%d] must be a CIDR range, got %q", i, cidr)
+	}
+
+	v.oneOf("mail.driver", c.Mail.Driver, "smtp", "file", "memory")
+	v.check(c.Mail.From != "", "mail.from is required")
+	if c.Mail.Driver == "smtp" {
+		v.check(c.Mail.SMTP.Host != "", "mail.smtp.host is required for the smtp driver")
+	}
+
+	v.check(c.OIDC.StateTTL > 0, "oidc.state_ttl must be positive")
+	for name, provider := range c.OIDC.Providers {
+		v.check(provider.IssuerURL != "" && provider.ClientID != "" && provider.RedirectURL != "",
+			"oidc.providers.%s needs issuer_url, client_id and redirect_url", name)
+	}
+
+	v.oneOf("rate_limit.store", c.RateLimit.Store, "memory", "redis")
+	if c.RateLimit.Store == "redis" {
+		v.check(c.RateLimit.Redis.Addr != "", "rate_limit.redis.addr is required for the redis store")
+	}
+	if c.RateLimit.Enabled {
+		policies := append([]RateLimitPolicy{c.RateLimit.Default}, c.RateLimit.Policies...)
+		for i, policy := range policies {
+			key := "rate_limit.default"
+			if i > 0 {
+				key = fmt.Sprintf("rate_limit.policies[%d]", i-1)
+				v.check(policy.Name != "", "%s.name is required", key)
+			}
+			if policy.Key != "" {
+				v.oneOf(key+".key", policy.Key, "ip", "user", "api_key")
+			}
+			v.check(policy.Requests >= 0 && policy.Burst >= 0 && (policy.Requests == 0 || policy.Per > 0),
+				"%s needs a positive per when requests are set", key)
+		}
+	}
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
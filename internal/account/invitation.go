@@ -0,0 +1,120 @@
+package account
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"test-ordent/internal/auth"
+	"test-ordent/internal/mail"
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+)
+
+// InvitableRoles are the roles an invitation may grant: every staff role.
+// Customers sign up on their own.
+var InvitableRoles = auth.StaffRoles()
+
+var ErrInvalidRole = errors.New("role cannot be granted by invitation")
+
+// Inviter lets administrators invite people to privileged accounts.
+// Invitations are single-use and expire.
+type Inviter struct {
+	invitationRepo repository.InvitationRepository
+	mailer         mail.Mailer
+	linkBaseURL    string
+	ttl            time.Duration
+}
+
+func NewInviter(invitationRepo repository.InvitationRepository, mailer mail.Mailer, linkBaseURL string, ttl time.Duration) *Inviter {
+	return &Inviter{
+		invitationRepo: invitationRepo,
+		mailer:         mailer,
+		linkBaseURL:    strings.TrimSuffix(linkBaseURL, "/"),
+		ttl:            ttl,
+	}
+}
+
+type invitationData struct {
+	Email     string
+	Role      string
+	InvitedBy string
+	Link      string
+	ExpiresIn string
+}
+
+// Invite stores an invitation and emails its link. The token is returned as
+// well, since it cannot be recovered later. A failed email does not undo the
+// invitation; the caller gets the token and the mail error.
+func (i *Inviter) Invite(ctx context.Context, email, role string, inviter *model.User) (*model.Invitation, string, error) {
+	if !isInvitableRole(role) {
+		return nil, "", ErrInvalidRole
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	invitation, err := i.invitationRepo.Create(ctx, email, role, inviter.ID, hashInvitationToken(token), time.Now().Add(i.ttl))
+	if err != nil {
+		return nil, "", err
+	}
+
+	msg, err := mail.Render(mail.TemplateInvitation, email, invitationData{
+		Email:     email,
+		Role:      role,
+		InvitedBy: inviter.Username,
+		Link:      i.linkBaseURL + "/accept-invitation?token=" + url.QueryEscape(token),
+		ExpiresIn: formatDuration(i.ttl),
+	})
+	if err == nil {
+		err = i.mailer.Send(ctx, msg)
+	}
+	return invitation, token, err
+}
+
+// Accept creates the invited account. The email address and role come from
+// the invitation, not from the caller.
+func (i *Inviter) Accept(ctx context.Context, token, username, password, fullName string) (*model.User, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := i.invitationRepo.Accept(ctx, hashInvitationToken(token), &model.User{
+		Username:     username,
+		PasswordHash: string(hashedPassword),
+		FullName:     fullName,
+	})
+	if err != nil {
+		if err.Error() == "invitation not found" {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func isInvitableRole(role string) bool {
+	for _, r := range InvitableRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func hashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,194 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"test-ordent/internal/auth"
+	"test-ordent/internal/mail"
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+	"test-ordent/pkg/logger"
+)
+
+// ErrInvalidToken is returned for tokens that are malformed, expired, already
+// used or no longer match the account.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// resetSendTimeout bounds a password reset sent in the background.
+const resetSendTimeout = 30 * time.Second
+
+// Service runs the flows that confirm an action through an emailed link:
+// email verification and password reset.
+type Service struct {
+	userRepo    repository.UserRepository
+	tokenRepo   repository.ActionTokenRepository
+	signer      *auth.ActionTokenSigner
+	mailer      mail.Mailer
+	linkBaseURL string
+	verifyTTL   time.Duration
+	resetTTL    time.Duration
+	background  sync.WaitGroup
+}
+
+func NewService(userRepo repository.UserRepository, tokenRepo repository.ActionTokenRepository, signer *auth.ActionTokenSigner, mailer mail.Mailer, linkBaseURL string, verifyTTL, resetTTL time.Duration) *Service {
+	return &Service{
+		userRepo:    userRepo,
+		tokenRepo:   tokenRepo,
+		signer:      signer,
+		mailer:      mailer,
+		linkBaseURL: strings.TrimSuffix(linkBaseURL, "/"),
+		verifyTTL:   verifyTTL,
+		resetTTL:    resetTTL,
+	}
+}
+
+type messageData struct {
+	Name      string
+	Email     string
+	Link      string
+	ExpiresIn string
+}
+
+// SendVerification emails user a link that verifies their current address.
+func (s *Service) SendVerification(ctx context.Context, user *model.User) error {
+	return s.send(ctx, user, auth.PurposeVerifyEmail, user.Email, s.verifyTTL, "/verify-email", mail.TemplateVerifyEmail)
+}
+
+// RequestPasswordReset emails a reset link to the account with the given
+// address. The lookup and the email happen in the background, so neither the
+// result nor the response time tells callers whether an account exists;
+// failures are logged.
+func (s *Service) RequestPasswordReset(ctx context.Context, email string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resetSendTimeout)
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		defer cancel()
+		if err := s.sendPasswordReset(ctx, email); err != nil {
+			logger.FromContext(ctx).Error("failed to send password reset email", "error", err)
+		}
+	}()
+}
+
+// Wait blocks until password resets sent in the background are done.
+func (s *Service) Wait() {
+	s.background.Wait()
+}
+
+func (s *Service) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := s.userRepo.FindByEmail(ctx, email)
+	if err != nil {
+		if err.Error() == "user not found" {
+			return nil
+		}
+		return err
+	}
+	if user.Status == model.UserStatusDeleted {
+		return nil
+	}
+	return s.send(ctx, user, auth.PurposeResetPassword, "", s.resetTTL, "/reset-password", mail.TemplateResetPassword)
+}
+
+// ResetPassword redeems a reset token and sets the new password.
+func (s *Service) ResetPassword(ctx context.Context, token, newPassword string) error {
+	claims, err := s.redeem(ctx, token, auth.PurposeResetPassword)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.UpdatePassword(ctx, claims.UserID, string(hashedPassword)); err != nil {
+		if err.Error() == "user not found" {
+			return ErrInvalidToken
+		}
+		return err
+	}
+	return nil
+}
+
+// VerifyEmail redeems a verification token. A token sent to an address the
+// user has since changed is rejected.
+func (s *Service) VerifyEmail(ctx context.Context, token string) error {
+	claims, err := s.redeem(ctx, token, auth.PurposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+
+	if err := s.userRepo.MarkEmailVerified(ctx, claims.UserID, claims.Email); err != nil {
+		if err.Error() == "user not found" {
+			return ErrInvalidToken
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *Service) send(ctx context.Context, user *model.User, purpose, email string, ttl time.Duration, path, template string) error {
+	claims, token, err := s.signer.Issue(purpose, user.ID, email, ttl)
+	if err != nil {
+		return err
+	}
+	if err := s.tokenRepo.Create(ctx, user.ID, purpose, claims.Nonce, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		return err
+	}
+
+	name := user.FullName
+	if name == "" {
+		name = user.Username
+	}
+	msg, err := mail.Render(template, user.Email, messageData{
+		Name:      name,
+		Email:     user.Email,
+		Link:      s.linkBaseURL + path + "?token=" + url.QueryEscape(token),
+		ExpiresIn: formatDuration(ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, msg)
+}
+
+func (s *Service) redeem(ctx context.Context, token, purpose string) (*auth.ActionClaims, error) {
+	claims, err := s.signer.Parse(token, purpose)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := s.tokenRepo.Consume(ctx, claims.UserID, purpose, claims.Nonce); err != nil {
+		if err.Error() == "token already used" {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	return claims, nil
+}
+
+// formatDuration renders ttl for people, e.g. "48 hours" or "30 minutes".
+func formatDuration(ttl time.Duration) string {
+	switch {
+	case ttl >= time.Hour && ttl%time.Hour == 0:
+		return plural(int(ttl/time.Hour), "hour")
+	case ttl >= time.Minute:
+		return plural(int(ttl/time.Minute), "minute")
+	default:
+		return ttl.String()
+	}
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return strconv.Itoa(n) + " " + unit + "s"
+}
@@ -0,0 +1,151 @@
+package account
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+
+	"test-ordent/internal/auth"
+	"test-ordent/internal/model"
+	"test-ordent/internal/oidc"
+	"test-ordent/internal/repository"
+)
+
+var (
+	ErrEmailRequired = errors.New("identity provider did not share an email address")
+	// ErrEmailNotVerified is returned when an account with the identity's
+	// email exists but the provider has not verified the address, so the
+	// identity cannot be trusted to own that account.
+	ErrEmailNotVerified = errors.New("email address not verified by identity provider")
+)
+
+const usernameAttempts = 5
+
+// SocialLogin maps identities from external providers to users. A known
+// identity signs in its linked user; an unknown one is linked to the user
+// with the same email address if the provider verified it, or gets a new
+// customer account otherwise.
+type SocialLogin struct {
+	userRepo     repository.UserRepository
+	identityRepo repository.IdentityRepository
+}
+
+func NewSocialLogin(userRepo repository.UserRepository, identityRepo repository.IdentityRepository) *SocialLogin {
+	return &SocialLogin{
+		userRepo:     userRepo,
+		identityRepo: identityRepo,
+	}
+}
+
+func (s *SocialLogin) Resolve(ctx context.Context, identity *oidc.Identity) (*model.User, error) {
+	user, err := s.identityRepo.FindUser(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		if err := s.identityRepo.RecordLogin(ctx, identity.Provider, identity.Subject); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	if err.Error() != "identity not found" {
+		return nil, err
+	}
+
+	if identity.Email == "" {
+		return nil, ErrEmailRequired
+	}
+
+	user, err = s.userRepo.FindByEmail(ctx, identity.Email)
+	if err == nil {
+		return s.link(ctx, user, identity)
+	}
+	if err.Error() != "user not found" {
+		return nil, err
+	}
+
+	return s.create(ctx, identity)
+}
+
+// Identities lists the external identities linked to a user.
+func (s *SocialLogin) Identities(ctx context.Context, userID uint) ([]model.UserIdentity, error) {
+	return s.identityRepo.ListByUser(ctx, userID)
+}
+
+func (s *SocialLogin) link(ctx context.Context, user *model.User, identity *oidc.Identity) (*model.User, error) {
+	if !identity.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	if err := s.identityRepo.Link(ctx, user.ID, identity.Provider, identity.Subject, identity.Email); err != nil {
+		return nil, err
+	}
+	if !user.EmailVerified {
+		if err := s.userRepo.MarkEmailVerified(ctx, user.ID, identity.Email); err != nil {
+			return nil, err
+		}
+		user.EmailVerified = true
+	}
+	return user, nil
+}
+
+func (s *SocialLogin) create(ctx context.Context, identity *oidc.Identity) (*model.User, error) {
+	username, err := s.freeUsername(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.identityRepo.CreateUser(ctx, &model.User{
+		Username:      username,
+		Email:         identity.Email,
+		FullName:      identity.Name,
+		Role:          auth.RoleCustomer,
+		EmailVerified: identity.EmailVerified,
+	}, identity.Provider, identity.Subject)
+}
+
+// freeUsername derives a username from the identity, adding a random suffix
+// when it is taken.
+func (s *SocialLogin) freeUsername(ctx context.Context, identity *oidc.Identity) (string, error) {
+	base := sanitizeUsername(identity.PreferredUsername)
+	if base == "" {
+		base = sanitizeUsername(strings.SplitN(identity.Email, "@", 2)[0])
+	}
+	if len(base) < 3 {
+		base = "user" + base
+	}
+	if len(base) > 40 {
+		base = base[:40]
+	}
+
+	candidate := base
+	for i := 0; i < usernameAttempts; i++ {
+		_, err := s.userRepo.FindByUsername(ctx, candidate)
+		if err != nil {
+			if err.Error() == "user not found" {
+				return candidate, nil
+			}
+			return "", err
+		}
+
+		n, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			return "", err
+		}
+		candidate = base + "-" + leftPad(n.String(), 4)
+	}
+	return "", errors.New("no free username for " + base)
+}
+
+func sanitizeUsername(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func leftPad(s string, width int) string {
+	return strings.Repeat("0", width-len(s)) + s
+}
@@ -0,0 +1,257 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"test-ordent/internal/auth"
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled  = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotEnrolled = errors.New("no pending two-factor enrolment")
+	ErrTwoFactorRequired    = errors.New("two-factor authentication is required for this role")
+	ErrInvalidCode          = errors.New("invalid code")
+)
+
+// TwoFactor manages TOTP enrolment and checks the second login step.
+// Enrolment is two-step: Enroll hands out a secret, and Activate turns it on
+// once the user proves their authenticator app produces valid codes.
+type TwoFactor struct {
+	repo          repository.TwoFactorRepository
+	cipher        *auth.SecretCipher
+	signer        *auth.ActionTokenSigner
+	issuer        string
+	requiredRoles []string
+	challengeTTL  time.Duration
+	now           func() time.Time
+}
+
+func NewTwoFactor(repo repository.TwoFactorRepository, cipher *auth.SecretCipher, signer *auth.ActionTokenSigner, issuer string, requiredRoles []string, challengeTTL time.Duration) *TwoFactor {
+	return &TwoFactor{
+		repo:          repo,
+		cipher:        cipher,
+		signer:        signer,
+		issuer:        issuer,
+		requiredRoles: requiredRoles,
+		challengeTTL:  challengeTTL,
+		now:           time.Now,
+	}
+}
+
+// Required reports whether users with role must use two-factor
+// authentication.
+func (t *TwoFactor) Required(role string) bool {
+	for _, r := range t.requiredRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *TwoFactor) Status(ctx context.Context, user *model.User) (*model.TwoFactorStatusResponse, error) {
+	status := &model.TwoFactorStatusResponse{
+		Enabled:  user.TwoFactorEnabled,
+		Required: t.Required(user.Role),
+	}
+	if user.TwoFactorEnabled {
+		remaining, err := t.repo.CountRecoveryCodes(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		status.RecoveryCodesRemaining = remaining
+	}
+	return status, nil
+}
+
+// Enroll creates a new secret for user. Enrolling again before activation
+// replaces the previous secret.
+func (t *TwoFactor) Enroll(ctx context.Context, user *model.User) (*model.TwoFactorEnrollResponse, error) {
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, uri, err := auth.GenerateTOTPKey(t.issuer, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := t.cipher.Encrypt(secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.repo.SavePending(ctx, user.ID, encrypted); err != nil {
+		if err.Error() == "two-factor already enabled" {
+			return nil, ErrTwoFactorEnabled
+		}
+		return nil, err
+	}
+
+	return &model.TwoFactorEnrollResponse{Secret: secret, OTPAuthURI: uri}, nil
+}
+
+// Activate turns on two-factor authentication when code matches the pending
+// secret, and returns the recovery codes. They are only stored hashed, so this
+// is the only time they can be shown.
+func (t *TwoFactor) Activate(ctx context.Context, userID uint, code string) ([]string, error) {
+	tf, err := t.repo.Find(ctx, userID)
+	if err != nil {
+		if err.Error() == "two-factor not found" {
+			return nil, ErrTwoFactorNotEnrolled
+		}
+		return nil, err
+	}
+	if tf.ConfirmedAt != nil {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	step, err := t.check(tf, code)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := t.repo.Activate(ctx, userID, step, hashes); err != nil {
+		if err.Error() == "two-factor not found" {
+			return nil, ErrTwoFactorEnabled
+		}
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify checks a TOTP code for a user with two-factor authentication enabled.
+// Each code is accepted only once.
+func (t *TwoFactor) Verify(ctx context.Context, userID uint, code string) error {
+	tf, err := t.repo.Find(ctx, userID)
+	if err != nil {
+		if err.Error() == "two-factor not found" {
+			return ErrTwoFactorNotEnabled
+		}
+		return err
+	}
+	if tf.ConfirmedAt == nil {
+		return ErrTwoFactorNotEnabled
+	}
+
+	step, err := t.check(tf, code)
+	if err != nil {
+		return err
+	}
+	used, err := t.repo.UseStep(ctx, userID, step)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// VerifyRecoveryCode redeems one of the user's recovery codes.
+func (t *TwoFactor) VerifyRecoveryCode(ctx context.Context, userID uint, code string) error {
+	used, err := t.repo.UseRecoveryCode(ctx, userID, auth.HashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a TOTP
+// code.
+func (t *TwoFactor) RegenerateRecoveryCodes(ctx context.Context, userID uint, code string) ([]string, error) {
+	if err := t.Verify(ctx, userID, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := t.repo.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable turns two-factor authentication off after checking a TOTP code.
+// Users whose role requires it cannot turn it off.
+func (t *TwoFactor) Disable(ctx context.Context, user *model.User, code string) error {
+	if t.Required(user.Role) {
+		return ErrTwoFactorRequired
+	}
+	if err := t.Verify(ctx, user.ID, code); err != nil {
+		return err
+	}
+	if err := t.repo.Delete(ctx, user.ID); err != nil {
+		if err.Error() == "two-factor not found" {
+			return ErrTwoFactorNotEnabled
+		}
+		return err
+	}
+	return nil
+}
+
+// Reset turns two-factor authentication off without a code, for users who
+// lost both their authenticator and their recovery codes.
+func (t *TwoFactor) Reset(ctx context.Context, userID uint) error {
+	if err := t.repo.Delete(ctx, userID); err != nil {
+		if err.Error() == "two-factor not found" {
+			return ErrTwoFactorNotEnabled
+		}
+		return err
+	}
+	return nil
+}
+
+// IssueChallenge returns the short-lived token that stands for a login whose
+// password step succeeded.
+func (t *TwoFactor) IssueChallenge(userID uint) (string, error) {
+	_, token, err := t.signer.Issue(auth.PurposeLoginChallenge, userID, "", t.challengeTTL)
+	return token, err
+}
+
+// ParseChallenge returns the user a challenge token was issued to.
+func (t *TwoFactor) ParseChallenge(token string) (uint, error) {
+	claims, err := t.signer.Parse(token, auth.PurposeLoginChallenge)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+	return claims.UserID, nil
+}
+
+func (t *TwoFactor) check(tf *model.TwoFactor, code string) (int64, error) {
+	secret, err := t.cipher.Decrypt(tf.Secret)
+	if err != nil {
+		return 0, err
+	}
+	step, ok := auth.ValidateTOTP(secret, code, t.now(), tf.LastStep)
+	if !ok {
+		return 0, ErrInvalidCode
+	}
+	return step, nil
+}
+
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
@@ -0,0 +1,176 @@
+// Package audit carries the request details recorded with audit events and
+// computes the hash chain that makes the audit log tamper-evident.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/internal/model"
+	"test-ordent/internal/tracing"
+	"test-ordent/pkg/logger"
+)
+
+// GenesisHash is the PrevHash of the first event.
+var GenesisHash = strings.Repeat("0", 64)
+
+// Metadata identifies who made a change and the request it came from.
+// Changes made outside HTTP requests, e.g. by the CLI, have no actor.
+type Metadata struct {
+	ActorUserID   uint
+	ActorAPIKeyID int
+	IPAddress     string
+	UserAgent     string
+	RequestID     string
+	TraceID       string
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying m.
+func NewContext(ctx context.Context, m Metadata) context.Context {
+	return context.WithValue(ctx, contextKey{}, m)
+}
+
+// FromContext returns the metadata carried by ctx, or none.
+func FromContext(ctx context.Context) Metadata {
+	m, _ := ctx.Value(contextKey{}).(Metadata)
+	return m
+}
+
+// Context returns the request context carrying the metadata of c: the
+// authenticated user or API key, client address, user agent, request ID and
+// trace ID. Handlers pass it to the repository methods that audit changes.
+func Context(c echo.Context) context.Context {
+	req := c.Request()
+	m := Metadata{
+		IPAddress: clientIP(c),
+		UserAgent: req.UserAgent(),
+		RequestID: logger.RequestID(c),
+		TraceID:   tracing.TraceID(req.Context()),
+	}
+	if userID, ok := c.Get("user_id").(uint); ok {
+		m.ActorUserID = userID
+	}
+	if apiKeyID, ok := c.Get("api_key_id").(int); ok {
+		m.ActorAPIKeyID = apiKeyID
+	}
+	return NewContext(req.Context(), m)
+}
+
+// clientIP is the client address in canonical form. The address comes from
+// the server's IP extractor; anything that is not an IP is left out rather
+// than failing the audited change.
+func clientIP(c echo.Context) string {
+	ip := net.ParseIP(c.RealIP())
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// Snapshot encodes v, the state of an entity, for Before or After. A nil v
+// gives nil, stored as NULL.
+func Snapshot(v interface{}) (json.RawMessage, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if string(raw) == "null" {
+		return nil, nil
+	}
+	return canonical(raw)
+}
+
+// canonical re-encodes a JSON document with sorted keys and no spacing, so
+// it hashes the same after a round trip through a JSONB column, which
+// reorders keys.
+func canonical(raw json.RawMessage) (json.RawMessage, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// Hash returns the hash of e: the SHA-256 of its PrevHash and of every
+// recorded field other than ID and Hash.
+func Hash(e *model.AuditEvent) (string, error) {
+	before, err := canonical(e.Before)
+	if err != nil {
+		return "", fmt.Errorf("before: %w", err)
+	}
+	after, err := canonical(e.After)
+	if err != nil {
+		return "", fmt.Errorf("after: %w", err)
+	}
+	payload, err := json.Marshal([]interface{}{
+		e.PrevHash,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		e.ActorUserID,
+		e.ActorAPIKeyID,
+		e.Action,
+		e.EntityType,
+		e.EntityID,
+		before,
+		after,
+		e.IPAddress,
+		e.UserAgent,
+		e.RequestID,
+		e.TraceID,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Verifier checks a log one event at a time, in insertion order.
+type Verifier struct {
+	prev    string
+	checked int
+}
+
+func NewVerifier() *Verifier {
+	return &Verifier{prev: GenesisHash}
+}
+
+// Next checks that e follows the previous event and that its hash matches
+// its contents.
+func (v *Verifier) Next(e *model.AuditEvent) error {
+	if e.PrevHash != v.prev {
+		return fmt.Errorf("event %d does not follow the previous event", e.ID)
+	}
+	hash, err := Hash(e)
+	if err != nil {
+		return fmt.Errorf("event %d: %w", e.ID, err)
+	}
+	if hash != e.Hash {
+		return fmt.Errorf("event %d was modified", e.ID)
+	}
+	v.prev = e.Hash
+	v.checked++
+	return nil
+}
+
+// Checked returns the number of events verified so far.
+func (v *Verifier) Checked() int {
+	return v.checked
+}
+
+// LastHash returns the hash of the last verified event, or GenesisHash.
+func (v *Verifier) LastHash() string {
+	return v.prev
+}
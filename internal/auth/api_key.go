@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/internal/model"
+	"test-ordent/pkg/logger"
+)
+
+// APIKeyHeader is the request header API keys are sent in.
+const APIKeyHeader = "X-API-Key"
+
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// apiKeyScheme starts every key, so leaked keys are easy to recognise in
+// logs and by secret scanners.
+const apiKeyScheme = "sk_"
+
+// APIKeyPermissions are the permissions an API key may be scoped to. Keys are
+// for integrations such as the ERP and warehouse systems; managing users,
+// roles and keys stays with people.
+var APIKeyPermissions = []string{
+	PermProductsWrite,
+	PermProductsImport,
+	PermPricesManage,
+	PermOrdersRead,
+	PermOrdersFulfil,
+	PermOrdersRefund,
+}
+
+// IsAPIKeyPermission reports whether an API key may be scoped to perm.
+func IsAPIKeyPermission(perm string) bool {
+	return contains(APIKeyPermissions, perm)
+}
+
+// GenerateAPIKey creates a new key of the form sk_<prefix>_<secret>. The
+// prefix identifies the key in listings and logs; only a hash of the whole
+// key is stored.
+func GenerateAPIKey() (key, prefix string, err error) {
+	raw := make([]byte, 4+32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	prefix = apiKeyScheme + hex.EncodeToString(raw[:4])
+	return prefix + "_" + base64.RawURLEncoding.EncodeToString(raw[4:]), prefix, nil
+}
+
+// APIKeyPrefix returns the identifying prefix of key.
+func APIKeyPrefix(key string) (string, bool) {
+	if !strings.HasPrefix(key, apiKeyScheme) {
+		return "", false
+	}
+	i := strings.Index(key[len(apiKeyScheme):], "_")
+	if i <= 0 {
+		return "", false
+	}
+	return key[:len(apiKeyScheme)+i], true
+}
+
+// HashAPIKey returns the value stored for key.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyStore loads API keys for authentication.
+type APIKeyStore interface {
+	FindByPrefix(ctx context.Context, prefix string) (*model.APIKey, error)
+	TouchLastUsed(ctx context.Context, id int, at time.Time) error
+}
+
+// APIKeyMiddleware accepts API keys on routes that integrations call, and
+// hands requests without one to the JWT middleware.
+type APIKeyMiddleware struct {
+	keys  APIKeyStore
+	users UserLookup
+	jwt   *JWTMiddleware
+}
+
+func NewAPIKeyMiddleware(keys APIKeyStore, users UserLookup, jwt *JWTMiddleware) *APIKeyMiddleware {
+	return &APIKeyMiddleware{
+		keys:  keys,
+		users: users,
+		jwt:   jwt,
+	}
+}
+
+// RequirePermission authenticates the request with the X-API-Key header if it
+// is present, and with a JWT otherwise. A key grants the permissions it was
+// scoped to, as far as the admin who created it still holds them; requests
+// made with it act as that admin.
+func (m *APIKeyMiddleware) RequirePermission(perm string) echo.MiddlewareFunc {
+	requireJWT := m.jwt.RequirePermission(perm)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		withJWT := requireJWT(next)
+		return func(c echo.Context) error {
+			key := c.Request().Header.Get(APIKeyHeader)
+			if key == "" {
+				return withJWT(c)
+			}
+
+			apiKey, user, err := m.authenticateRequest(c, key)
+			if errors.Is(err, ErrInvalidAPIKey) {
+				return c.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: "Invalid API key"})
+			}
+			if err != nil {
+				logger.FromEcho(c).Error("failed to authenticate API key", slog.Any("error", err))
+				return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to authenticate API key"})
+			}
+
+			permissions := []string{}
+			for _, p := range apiKey.Permissions {
+				if HasPermission(user.Role, p) {
+					permissions = append(permissions, p)
+				}
+			}
+			if !contains(permissions, perm) {
+				return c.JSON(http.StatusForbidden, model.ErrorResponse{Error: "Permission " + perm + " required"})
+			}
+
+			if err := m.keys.TouchLastUsed(c.Request().Context(), apiKey.ID, time.Now()); err != nil {
+				c.Logger().Errorf("failed to record use of API key %s: %v", apiKey.Prefix, err)
+			}
+
+			c.Set("user_id", user.ID)
+			c.Set("api_key_id", apiKey.ID)
+			c.Set("permissions", permissions)
+
+			return next(c)
+		}
+	}
+}
+
+// Identify authenticates the X-API-Key header, if there is one, and returns
+// the key's ID. The rate limiter runs before authentication and uses it to
+// count requests by key without trusting the header; the result is kept on c,
+// so RequirePermission does not look the key up again.
+func (m *APIKeyMiddleware) Identify(c echo.Context) (int, bool) {
+	key := c.Request().Header.Get(APIKeyHeader)
+	if key == "" {
+		return 0, false
+	}
+	apiKey, _, err := m.authenticateRequest(c, key)
+	if err != nil {
+		return 0, false
+	}
+	return apiKey.ID, true
+}
+
+// authenticatedKeyContext holds the outcome of authenticating a request's key.
+const authenticatedKeyContext = "auth.api_key"
+
+type authenticatedKey struct {
+	apiKey *model.APIKey
+	user   *model.User
+	err    error
+}
+
+func (m *APIKeyMiddleware) authenticateRequest(c echo.Context, key string) (*model.APIKey, *model.User, error) {
+	if done, ok := c.Get(authenticatedKeyContext).(*authenticatedKey); ok {
+		return done.apiKey, done.user, done.err
+	}
+	apiKey, user, err := m.authenticate(c.Request().Context(), key)
+	c.Set(authenticatedKeyContext, &authenticatedKey{apiKey: apiKey, user: user, err: err})
+	return apiKey, user, err
+}
+
+// authenticate returns ErrInvalidAPIKey when key does not grant access, and
+// other errors when it could not be checked.
+func (m *APIKeyMiddleware) authenticate(ctx context.Context, key string) (*model.APIKey, *model.User, error) {
+	prefix, ok := APIKeyPrefix(key)
+	if !ok {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	apiKey, err := m.keys.FindByPrefix(ctx, prefix)
+	if err != nil {
+		if err.Error() == "api key not found" {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+	if !hmac.Equal([]byte(apiKey.KeyHash), []byte(HashAPIKey(key))) ||
+		apiKey.RevokedAt != nil || !apiKey.ExpiresAt.After(time.Now()) || apiKey.CreatedBy == nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	user, err := m.users.FindByID(ctx, *apiKey.CreatedBy)
+	if err != nil {
+		if err.Error() == "user not found" {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+	if user.Status != model.UserStatusActive {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	return apiKey, user, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -7,16 +7,21 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
+// JWTClaims carries the user's role and its permissions at the time the token
+// was issued. The permissions are there for clients to adapt their UI; the
+// server resolves them again from the user's current role on every request.
 type JWTClaims struct {
-	UserID uint   `json:"user_id"`
-	Role   string `json:"role"`
+	UserID      uint     `json:"user_id"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions,omitempty"`
 	jwt.RegisteredClaims
 }
 
 func GenerateToken(userID uint, role, secret string, expiry time.Duration) (string, error) {
 	claims := JWTClaims{
-		UserID: userID,
-		Role:   role,
+		UserID:      userID,
+		Role:        role,
+		Permissions: PermissionsFor(role),
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"test-ordent/internal/model"
+)
+
+// AttemptStore keeps failed login counters and the attempts in progress.
+// Failures whose last failure is older than the reset period passed to
+// Reserve and Fail start over, as do pending attempts last reserved that long
+// ago, e.g. by a server that stopped mid-login.
+type AttemptStore interface {
+	// Reserve adds a pending attempt at key, unless blocked returns a wait
+	// for the counter as it stands; then that wait is returned and nothing
+	// is added. Checking and adding are one atomic step.
+	Reserve(ctx context.Context, key string, now time.Time, resetAfter time.Duration, blocked func(model.LoginAttempts) time.Duration) (time.Duration, error)
+	// Fail turns a pending attempt into a failure at now.
+	Fail(ctx context.Context, key string, now time.Time, resetAfter time.Duration) error
+	// Release drops a pending attempt that did not fail. The failure count
+	// and time are left as they were.
+	Release(ctx context.Context, key string) error
+	Reset(ctx context.Context, key string) error
+}
+
+// LockoutPolicy configures LoginGuard. Every failure doubles the wait before
+// the next attempt, starting at BaseDelay and capped at MaxDelay; after
+// MaxFailures the key is locked for LockoutDuration.
+type LockoutPolicy struct {
+	MaxAccountFailures int
+	MaxIPFailures      int
+	BaseDelay          time.Duration
+	MaxDelay           time.Duration
+	LockoutDuration    time.Duration
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+}
+
+// LoginGuard throttles login attempts per account and per client IP.
+type LoginGuard struct {
+	store  AttemptStore
+	policy LockoutPolicy
+}
+
+func NewLoginGuard(store AttemptStore, policy LockoutPolicy) *LoginGuard {
+	return &LoginGuard{store: store, policy: policy}
+}
+
+func accountKey(username string) string {
+	return "account:" + strings.ToLower(username)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Attempt reserves a login attempt at username from ip. It returns how long
+// the caller has to wait instead, or zero when the attempt may go ahead; the
+// caller then reports it with Fail or Release. Attempts in progress count
+// towards the lockout limits, so a burst of parallel requests cannot slip
+// past them, but do not delay each other otherwise.
+func (g *LoginGuard) Attempt(ctx context.Context, username, ip string, now time.Time) (time.Duration, error) {
+	wait, err := g.store.Reserve(ctx, ipKey(ip), now, g.resetAfter(), g.blocked(g.policy.MaxIPFailures, now))
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+	wait, err = g.store.Reserve(ctx, accountKey(username), now, g.resetAfter(), g.blocked(g.policy.MaxAccountFailures, now))
+	if err != nil || wait > 0 {
+		// The IP is not charged for an attempt that did not happen.
+		if releaseErr := g.store.Release(ctx, ipKey(ip)); err == nil {
+			err = releaseErr
+		}
+		return wait, err
+	}
+	return 0, nil
+}
+
+// Fail records a reserved attempt as a failed login for the account and the
+// IP.
+func (g *LoginGuard) Fail(ctx context.Context, username, ip string, now time.Time) error {
+	if err := g.store.Fail(ctx, accountKey(username), now, g.resetAfter()); err != nil {
+		return err
+	}
+	return g.store.Fail(ctx, ipKey(ip), now, g.resetAfter())
+}
+
+// Release ends a reserved attempt that did not fail.
+func (g *LoginGuard) Release(ctx context.Context, username, ip string) error {
+	if err := g.store.Release(ctx, accountKey(username)); err != nil {
+		return err
+	}
+	return g.store.Release(ctx, ipKey(ip))
+}
+
+// Succeed clears the account's counter. The IP counter is kept, so logging
+// in to one account cannot be used to keep guessing at others.
+func (g *LoginGuard) Succeed(ctx context.Context, username string) error {
+	return g.store.Reset(ctx, accountKey(username))
+}
+
+func (g *LoginGuard) blocked(maxFailures int, now time.Time) func(model.LoginAttempts) time.Duration {
+	return func(attempts model.LoginAttempts) time.Duration {
+		return g.wait(attempts, maxFailures, now)
+	}
+}
+
+func (g *LoginGuard) wait(attempts model.LoginAttempts, maxFailures int, now time.Time) time.Duration {
+	if attempts.Failures == 0 || now.Sub(attempts.LastFailure) >= g.resetAfter() {
+		attempts.Failures = 0
+	}
+	if wait := g.failureWait(attempts, maxFailures, now); wait > 0 {
+		return wait
+	}
+	// Attempts in progress may still fail and reach the limit, so only as
+	// many run at once as there are failures left before it, and one at a
+	// time once a lockout has run out. The next waits until they are done.
+	if maxFailures > 0 && attempts.Pending >= max(maxFailures-attempts.Failures, 1) {
+		return g.policy.BaseDelay
+	}
+	return 0
+}
+
+// failureWait is the wait imposed by the failures so far.
+func (g *LoginGuard) failureWait(attempts model.LoginAttempts, maxFailures int, now time.Time) time.Duration {
+	if attempts.Failures == 0 {
+		return 0
+	}
+
+	var until time.Time
+	if maxFailures > 0 && attempts.Failures >= maxFailures {
+		until = attempts.LastFailure.Add(g.policy.LockoutDuration)
+	} else {
+		until = attempts.LastFailure.Add(g.backoff(attempts.Failures))
+	}
+
+	if wait := until.Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// resetAfter is how long counters live after the last failure. It is never
+// shorter than a lockout, so a lockout cannot expire early with its counter.
+func (g *LoginGuard) resetAfter() time.Duration {
+	if g.policy.LockoutDuration > g.policy.Window {
+		return g.policy.LockoutDuration
+	}
+	return g.policy.Window
+}
+
+func (g *LoginGuard) backoff(failures int) time.Duration {
+	delay := g.policy.BaseDelay
+	for i := 1; i < failures && delay < g.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > g.policy.MaxDelay {
+		delay = g.policy.MaxDelay
+	}
+	return delay
+}
+
+// MemoryAttemptStore keeps counters in process memory. It suits a single
+// server; use the Postgres store when several instances share the load.
+type MemoryAttemptStore struct {
+	mu        sync.Mutex
+	attempts  map[string]model.LoginAttempts
+	lastSweep time.Time
+}
+
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{attempts: make(map[string]model.LoginAttempts)}
+}
+
+func (s *MemoryAttemptStore) Reserve(ctx context.Context, key string, now time.Time, resetAfter time.Duration, blocked func(model.LoginAttempts) time.Duration) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop stale counters now and then so the map does not grow with every
+	// IP that ever failed a login.
+	if now.Sub(s.lastSweep) >= resetAfter {
+		for k, a := range s.attempts {
+			if a.Expire(now, resetAfter) == (model.LoginAttempts{}) {
+				delete(s.attempts, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	attempts := s.attempts[key].Expire(now, resetAfter)
+	if wait := blocked(attempts); wait > 0 {
+		return wait, nil
+	}
+	attempts.Pending++
+	attempts.LastAttempt = now
+	s.attempts[key] = attempts
+	return 0, nil
+}
+
+func (s *MemoryAttemptStore) Fail(ctx context.Context, key string, now time.Time, resetAfter time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts := s.attempts[key].Expire(now, resetAfter)
+	if attempts.Pending > 0 {
+		attempts.Pending--
+	}
+	attempts.Failures++
+	attempts.LastFailure = now
+	s.attempts[key] = attempts
+	return nil
+}
+
+func (s *MemoryAttemptStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts, ok := s.attempts[key]
+	if !ok {
+		return nil
+	}
+	if attempts.Pending > 0 {
+		attempts.Pending--
+	}
+	if attempts.Failures == 0 && attempts.Pending == 0 {
+		delete(s.attempts, key)
+		return nil
+	}
+	s.attempts[key] = attempts
+	return nil
+}
+
+func (s *MemoryAttemptStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
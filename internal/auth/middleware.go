@@ -1,32 +1,48 @@
 package auth
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 
 	"test-ordent/internal/model"
+	"test-ordent/pkg/logger"
 )
 
+// UserLookup loads the current state of a token's user, so suspensions,
+// deletions, role and password changes apply to tokens that were already
+// issued.
+type UserLookup interface {
+	FindByID(ctx context.Context, id uint) (*model.User, error)
+}
+
 type JWTMiddleware struct {
 	jwtSecret string
+	users     UserLookup
+	// twoFactorRoles are the roles whose permissions are only granted once
+	// the user has enabled two-factor authentication.
+	twoFactorRoles []string
 }
 
-func NewJWTMiddleware(jwtSecret string) *JWTMiddleware {
+func NewJWTMiddleware(jwtSecret string, users UserLookup, twoFactorRoles []string) *JWTMiddleware {
     if jwtSecret == "" {
-        log.Println("WARNING: Empty JWT secret provided to middleware")
+        slog.Warn("Empty JWT secret provided to middleware")
     }
     return &JWTMiddleware{
-        jwtSecret: jwtSecret,
+        jwtSecret:      jwtSecret,
+        users:          users,
+        twoFactorRoles: twoFactorRoles,
     }
 }
 
 func (m *JWTMiddleware) RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
     return func(c echo.Context) error {
         if m.jwtSecret == "" {
-            log.Println("ERROR: JWT secret is empty!")
+            logger.FromEcho(c).Error("JWT secret is empty")
             return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Server configuration error"})
         }
 
@@ -48,27 +64,73 @@ func (m *JWTMiddleware) RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
             return c.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: "Invalid token"})
         }
 
+        role := claims.Role
+        twoFactor := false
+        if m.users != nil {
+            user, err := m.users.FindByID(c.Request().Context(), claims.UserID)
+            if err != nil || user.Status == model.UserStatusDeleted {
+                return c.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: "Invalid token"})
+            }
+            if user.Status == model.UserStatusSuspended {
+                return c.JSON(http.StatusForbidden, model.ErrorResponse{Error: "Account suspended"})
+            }
+            if issuedBeforePasswordChange(claims, user) {
+                return c.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: "Password changed, log in again"})
+            }
+            role = user.Role
+            twoFactor = user.TwoFactorEnabled
+        }
+
         c.Set("user_id", claims.UserID)
-        c.Set("role", claims.Role)
+        c.Set("role", role)
+        c.Set("permissions", PermissionsFor(role))
+        c.Set("two_factor", twoFactor)
+        if claims.IssuedAt != nil {
+            c.Set("token_issued_at", claims.IssuedAt.Time)
+        }
 
         return next(c)
     }
 }
 
-func (m *JWTMiddleware) RequireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		err := m.RequireAuth(func(c echo.Context) error {
-			role, ok := c.Get("role").(string)
-			if !ok || role != "admin" {
-				return c.JSON(http.StatusForbidden, model.ErrorResponse{Error: "Admin role required"})
+// issuedBeforePasswordChange reports whether the token predates the user's
+// last password change, so changing or resetting the password ends every
+// existing session. The issue time only has second precision; a token from
+// the same second as the change is still accepted so that logging in right
+// after a reset works.
+func issuedBeforePasswordChange(claims *JWTClaims, user *model.User) bool {
+	if user.PasswordChangedAt == nil {
+		return false
+	}
+	if claims.IssuedAt == nil {
+		return true
+	}
+	return claims.IssuedAt.Time.Before(user.PasswordChangedAt.Truncate(time.Second))
+}
+
+// RequirePermission authenticates the request and rejects it unless the
+// user's current role grants perm. For roles that require two-factor
+// authentication the user must also have enabled it.
+func (m *JWTMiddleware) RequirePermission(perm string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return m.RequireAuth(func(c echo.Context) error {
+			role, _ := c.Get("role").(string)
+			if !HasPermission(role, perm) {
+				return c.JSON(http.StatusForbidden, model.ErrorResponse{Error: "Permission " + perm + " required"})
+			}
+			if twoFactor, _ := c.Get("two_factor").(bool); !twoFactor && m.requiresTwoFactor(role) {
+				return c.JSON(http.StatusForbidden, model.ErrorResponse{Error: "Two-factor authentication must be enabled for this role"})
 			}
 			return next(c)
-		})(c)
+		})
+	}
+}
 
-		if err != nil {
-			return err
+func (m *JWTMiddleware) requiresTwoFactor(role string) bool {
+	for _, r := range m.twoFactorRoles {
+		if r == role {
+			return true
 		}
-
-		return nil
 	}
-}
\ No newline at end of file
+	return false
+}
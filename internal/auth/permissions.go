@@ -0,0 +1,110 @@
+package auth
+
+import "sort"
+
+// Permissions guard individual API operations. Routes require a permission
+// rather than a role, so a role can be given exactly the access it needs.
+const (
+	PermProductsWrite  = "products:write"
+	PermProductsImport = "products:import"
+	PermPricesManage   = "prices:manage"
+	PermOrdersRead     = "orders:read"
+	PermOrdersFulfil   = "orders:fulfil"
+	PermOrdersRefund   = "orders:refund"
+	PermUsersRead      = "users:read"
+	PermUsersManage    = "users:manage"
+	PermRolesManage    = "roles:manage"
+	PermAPIKeysManage  = "api_keys:manage"
+	PermAuditRead      = "audit:read"
+	PermWebhooksManage = "webhooks:manage"
+)
+
+const (
+	RoleCustomer        = "customer"
+	RoleAdmin           = "admin"
+	RoleCatalogManager  = "catalog_manager"
+	RoleFulfilmentStaff = "fulfilment_staff"
+	RoleSupportAgent    = "support_agent"
+)
+
+// AllPermissions lists every permission, in the order they are documented.
+var AllPermissions = []string{
+	PermProductsWrite,
+	PermProductsImport,
+	PermPricesManage,
+	PermOrdersRead,
+	PermOrdersFulfil,
+	PermOrdersRefund,
+	PermUsersRead,
+	PermUsersManage,
+	PermRolesManage,
+	PermAPIKeysManage,
+	PermAuditRead,
+	PermWebhooksManage,
+}
+
+var rolePermissions = map[string][]string{
+	RoleCustomer: {},
+	RoleAdmin:    AllPermissions,
+	RoleCatalogManager: {
+		PermProductsWrite,
+		PermProductsImport,
+		PermPricesManage,
+	},
+	RoleFulfilmentStaff: {
+		PermOrdersRead,
+		PermOrdersFulfil,
+	},
+	RoleSupportAgent: {
+		PermOrdersRead,
+		PermOrdersRefund,
+		PermUsersRead,
+	},
+}
+
+// IsRole reports whether role is a known role.
+func IsRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Roles returns the names of all known roles, sorted.
+func Roles() []string {
+	roles := make([]string, 0, len(rolePermissions))
+	for role := range rolePermissions {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// StaffRoles returns every role other than customer, sorted. These are the
+// roles that can only be granted by an administrator.
+func StaffRoles() []string {
+	roles := []string{}
+	for _, role := range Roles() {
+		if role != RoleCustomer {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// PermissionsFor returns the permissions granted to role. Unknown roles get
+// none.
+func PermissionsFor(role string) []string {
+	perms := rolePermissions[role]
+	out := make([]string, len(perms))
+	copy(out, perms)
+	return out
+}
+
+// HasPermission reports whether role grants perm.
+func HasPermission(role, perm string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
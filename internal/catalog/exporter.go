@@ -0,0 +1,74 @@
+package catalog
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+)
+
+type Exporter struct {
+	productRepo repository.ProductRepository
+}
+
+func NewExporter(productRepo repository.ProductRepository) *Exporter {
+	return &Exporter{productRepo: productRepo}
+}
+
+// Export streams every active product to w in a format Import accepts, so an
+// export can be edited and imported again.
+func (e *Exporter) Export(ctx context.Context, w io.Writer, format Format) error {
+	switch format {
+	case FormatCSV:
+		return e.exportCSV(ctx, w)
+	case FormatJSONL:
+		return e.exportJSONL(ctx, w)
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+func (e *Exporter) exportCSV(ctx context.Context, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvColumns); err != nil {
+		return err
+	}
+
+	err := e.productRepo.StreamAll(ctx, func(p *model.ProductResponse) error {
+		return writer.Write([]string{
+			p.SKU,
+			p.Name,
+			p.Description,
+			strconv.FormatFloat(p.Price, 'f', -1, 64),
+			strconv.Itoa(p.Stock),
+			strconv.Itoa(p.CategoryID),
+			p.ImageURL,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func (e *Exporter) exportJSONL(ctx context.Context, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	return e.productRepo.StreamAll(ctx, func(p *model.ProductResponse) error {
+		return encoder.Encode(model.ProductRequest{
+			SKU:         p.SKU,
+			Name:        p.Name,
+			Description: p.Description,
+			Price:       p.Price,
+			Stock:       p.Stock,
+			CategoryID:  uint(p.CategoryID),
+			ImageURL:    p.ImageURL,
+		})
+	})
+}
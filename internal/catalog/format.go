@@ -0,0 +1,39 @@
+package catalog
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+type Format string
+
+const (
+	FormatCSV   Format = "csv"
+	FormatJSONL Format = "jsonl"
+)
+
+var csvColumns = []string{"sku", "name", "description", "price", "stock", "category_id", "image_url"}
+
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "csv":
+		return FormatCSV, nil
+	case "jsonl", "ndjson":
+		return FormatJSONL, nil
+	default:
+		return "", fmt.Errorf("unsupported format %q, use csv or jsonl", name)
+	}
+}
+
+// FormatFromFilename guesses the format from a file extension.
+func FormatFromFilename(name string) (Format, error) {
+	return ParseFormat(strings.TrimPrefix(filepath.Ext(name), "."))
+}
+
+func (f Format) ContentType() string {
+	if f == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
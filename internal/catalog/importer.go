@@ -0,0 +1,265 @@
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+)
+
+type ImportOptions struct {
+	Format Format
+	// Atomic imports all rows or none of them; otherwise valid rows are
+	// written even when other rows fail.
+	Atomic bool
+	// DryRun validates and executes the import, then rolls it back.
+	DryRun bool
+	// ActorID is recorded as the author of price changes; 0 for none.
+	ActorID uint
+}
+
+// FileError reports an import file that cannot be read at all, such as a
+// malformed CSV header, as opposed to a failure of the database.
+type FileError struct {
+	Err error
+}
+
+func (e *FileError) Error() string { return e.Err.Error() }
+
+func (e *FileError) Unwrap() error { return e.Err }
+
+type Importer struct {
+	productRepo repository.ProductRepository
+}
+
+func NewImporter(productRepo repository.ProductRepository) *Importer {
+	return &Importer{productRepo: productRepo}
+}
+
+type importRow struct {
+	line    int
+	product model.ProductRequest
+	err     error
+}
+
+// Import reads products from r and upserts them by SKU. Errors about
+// individual rows are collected in the report; the returned error is only set
+// when the input cannot be read at all, as a *FileError, or the database
+// fails.
+func (i *Importer) Import(ctx context.Context, r io.Reader, opts ImportOptions) (*model.ProductImportReport, error) {
+	var rows []importRow
+	var err error
+	switch opts.Format {
+	case FormatCSV:
+		rows, err = readCSV(r)
+	case FormatJSONL:
+		rows, err = readJSONL(r)
+	default:
+		err = fmt.Errorf("unsupported format %q", opts.Format)
+	}
+	if err != nil {
+		return nil, &FileError{Err: err}
+	}
+
+	report := &model.ProductImportReport{
+		DryRun: opts.DryRun,
+		Atomic: opts.Atomic,
+		Total:  len(rows),
+		Errors: []model.ProductImportError{},
+	}
+
+	seen := make(map[string]int, len(rows))
+	var valid []importRow
+	for _, row := range rows {
+		if row.err == nil {
+			row.err = validateProduct(&row.product)
+		}
+		if row.err == nil {
+			if first, ok := seen[row.product.SKU]; ok {
+				row.err = fmt.Errorf("duplicate sku, already used on line %d", first)
+			} else {
+				seen[row.product.SKU] = row.line
+			}
+		}
+		if row.err != nil {
+			addImportError(report, row)
+			continue
+		}
+		valid = append(valid, row)
+	}
+
+	if len(valid) == 0 || (opts.Atomic && report.Failed > 0) {
+		return report, nil
+	}
+
+	products := make([]model.ProductRequest, len(valid))
+	for n, row := range valid {
+		products[n] = row.product
+	}
+
+	results, err := i.productRepo.UpsertBySKU(ctx, products, opts.Atomic, opts.DryRun, opts.ActorID)
+	if err != nil {
+		return nil, err
+	}
+
+	aborted := false
+	for n, result := range results {
+		if result.Err != nil {
+			valid[n].err = result.Err
+			addImportError(report, valid[n])
+			aborted = opts.Atomic
+			continue
+		}
+		if result.Created {
+			report.Created++
+		} else {
+			report.Updated++
+		}
+	}
+	if aborted {
+		report.Created, report.Updated = 0, 0
+	}
+
+	return report, nil
+}
+
+func addImportError(report *model.ProductImportReport, row importRow) {
+	report.Failed++
+	report.Errors = append(report.Errors, model.ProductImportError{
+		Line:  row.line,
+		SKU:   row.product.SKU,
+		Error: row.err.Error(),
+	})
+}
+
+func validateProduct(p *model.ProductRequest) error {
+	p.SKU = strings.TrimSpace(p.SKU)
+	switch {
+	case p.SKU == "":
+		return errors.New("sku is required")
+	case len(p.SKU) > 64:
+		return errors.New("sku must be at most 64 characters")
+	case strings.TrimSpace(p.Name) == "":
+		return errors.New("name is required")
+	case p.Price <= 0:
+		return errors.New("price must be greater than 0")
+	case p.Stock < 0:
+		return errors.New("stock cannot be negative")
+	case p.CategoryID == 0:
+		return errors.New("category_id is required")
+	}
+	return nil
+}
+
+func readCSV(r io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid csv header: %w", err)
+	}
+
+	index := make(map[string]int, len(header))
+	for n, column := range header {
+		column = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+		known := false
+		for _, c := range csvColumns {
+			known = known || c == column
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown csv column %q", column)
+		}
+		index[column] = n
+	}
+	for _, required := range []string{"sku", "name", "price", "stock", "category_id"} {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("csv column %q is required", required)
+		}
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) && parseErr.Err == csv.ErrFieldCount {
+				rows = append(rows, importRow{line: parseErr.StartLine, err: errors.New("wrong number of fields")})
+				continue
+			}
+			return nil, fmt.Errorf("invalid csv: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		get := func(column string) string {
+			if n, ok := index[column]; ok {
+				return strings.TrimSpace(record[n])
+			}
+			return ""
+		}
+
+		row := importRow{line: line}
+		row.product = model.ProductRequest{
+			SKU:         get("sku"),
+			Name:        get("name"),
+			Description: get("description"),
+			ImageURL:    get("image_url"),
+		}
+		if row.product.Price, err = strconv.ParseFloat(get("price"), 64); err != nil {
+			row.err = errors.New("price must be a number")
+		} else if row.product.Stock, err = strconv.Atoi(get("stock")); err != nil {
+			row.err = errors.New("stock must be an integer")
+		} else if categoryID, err := strconv.ParseUint(get("category_id"), 10, 32); err != nil {
+			row.err = errors.New("category_id must be a positive integer")
+		} else {
+			row.product.CategoryID = uint(categoryID)
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func readJSONL(r io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []importRow
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		row := importRow{line: line}
+		decoder := json.NewDecoder(bytes.NewReader(text))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&row.product); err != nil {
+			row.err = fmt.Errorf("invalid json: %v", err)
+		}
+		rows = append(rows, row)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("invalid jsonl: %w", err)
+	}
+
+	return rows, nil
+}
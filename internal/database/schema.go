@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// RequiredTables are the tables created by query.sql. Keep the list in step
+// with it, so a database missing part of the schema is not reported ready.
+var RequiredTables = []string{
+	"users",
+	"user_identities",
+	"admin_invitations",
+	"api_keys",
+	"action_tokens",
+	"login_attempts",
+	"login_failures",
+	"audit_events",
+	"user_two_factor",
+	"user_recovery_codes",
+	"categories",
+	"products",
+	"product_images",
+	"product_price_history",
+	"product_price_schedules",
+	"orders",
+	"order_items",
+	"cart",
+	"cart_items",
+	"outbox_events",
+	"webhook_endpoints",
+	"webhook_deliveries",
+	"webhook_delivery_attempts",
+}
+
+// CheckSchema returns an error listing the required tables that are missing
+// from the current schema.
+func CheckSchema(ctx context.Context, db *sql.DB, tables []string) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT table_name FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_name = ANY($1)`, pq.Array(tables))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	found := make(map[string]bool, len(tables))
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		found[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, table := range tables {
+		if !found[table] {
+			missing = append(missing, table)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("schema is out of date, missing tables: %s (apply query.sql)", strings.Join(missing, ", "))
+	}
+	return nil
+}
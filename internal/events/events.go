@@ -0,0 +1,108 @@
+// Package events publishes the domain events recorded in the outbox. The
+// relay hands every event to a Sink; the sinks here deliver them to
+// in-process subscribers, HTTP endpoints and a NATS server.
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"test-ordent/config"
+	"test-ordent/internal/model"
+)
+
+// Sink publishes events. Publish returns once the event has been accepted;
+// an error makes the relay try the event again later, so sinks may see an
+// event more than once.
+type Sink interface {
+	Publish(ctx context.Context, event model.Event) error
+}
+
+// Handler handles an event delivered by a Bus.
+type Handler func(ctx context.Context, event model.Event) error
+
+// AllEvents subscribes a handler to every event type.
+const AllEvents = "*"
+
+// Bus delivers events to handlers in the same process, in the order they
+// subscribed.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{handlers: make(map[string][]Handler)}
+}
+
+// Subscribe registers fn for events of eventType, or of every type for
+// AllEvents.
+func (b *Bus) Subscribe(eventType string, fn Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], fn)
+}
+
+// Publish calls every handler subscribed to the type of event. All of them
+// are called even if one fails; the event is redelivered to all of them, so
+// handlers have to tolerate duplicates.
+func (b *Bus) Publish(ctx context.Context, event model.Event) error {
+	b.mu.RLock()
+	handlers := append(append([]Handler(nil), b.handlers[event.Type]...), b.handlers[AllEvents]...)
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, fn := range handlers {
+		if err := fn(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Fanout publishes every event to all of its sinks in turn.
+type Fanout []Sink
+
+func (f Fanout) Publish(ctx context.Context, event model.Event) error {
+	var errs []error
+	for _, sink := range f {
+		if err := sink.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes the sinks that hold connections.
+func (f Fanout) Close() error {
+	var errs []error
+	for _, sink := range f {
+		if closer, ok := sink.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewSink returns the sinks configured in cfg, after bus.
+func NewSink(cfg config.EventsConfig, bus *Bus) (Fanout, error) {
+	sinks := Fanout{bus}
+	for i, hook := range cfg.HTTPSinks {
+		sink, err := NewHTTPSink(hook.URL, hook.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("events.http_sinks[%d]: %w", i, err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if cfg.NATS.Enabled {
+		publisher, err := NewNATSPublisher(cfg.NATS.URL, cfg.NATS.SubjectPrefix, cfg.NATS.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("events.nats: %w", err)
+		}
+		sinks = append(sinks, publisher)
+	}
+	return sinks, nil
+}
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/internal/audit"
+	"test-ordent/internal/auth"
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+)
+
+type APIKeyHandler struct {
+	apiKeyRepo repository.APIKeyRepository
+	defaultTTL time.Duration
+	maxTTL     time.Duration
+}
+
+func NewAPIKeyHandler(apiKeyRepo repository.APIKeyRepository, defaultTTL, maxTTL time.Duration) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyRepo: apiKeyRepo,
+		defaultTTL: defaultTTL,
+		maxTTL:     maxTTL,
+	}
+}
+
+// CreateAPIKey godoc
+// @Summary Create an API key
+// @Description Create an expiring API key for a server-to-server integration, scoped to some of the caller's permissions. The key is returned only this once.
+// @Tags api-keys
+// @Accept json
+// @Produce json
+// @Param request body model.CreateAPIKeyRequest true "Name, permissions and expiry"
+// @Success 201 {object} model.CreateAPIKeyResponse
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 403 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/api-keys [post]
+func (h *APIKeyHandler) CreateAPIKey(c echo.Context) error {
+	var req model.CreateAPIKeyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request"})
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Name is required and must be at most 100 characters"})
+	}
+	if len(req.Permissions) == 0 {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "At least one permission is required"})
+	}
+	role, _ := c.Get("role").(string)
+	for _, perm := range req.Permissions {
+		if !auth.IsAPIKeyPermission(perm) {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Permission must be one of: " + strings.Join(auth.APIKeyPermissions, ", ")})
+		}
+		if !auth.HasPermission(role, perm) {
+			return c.JSON(http.StatusForbidden, model.ErrorResponse{Error: "You cannot grant permission " + perm})
+		}
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(h.defaultTTL)
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+	}
+	if !expiresAt.After(now) || expiresAt.After(now.Add(h.maxTTL)) {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Expiry must be in the future and within " + formatTTL(h.maxTTL)})
+	}
+
+	key, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		return internalError(c, "Failed to create API key", err)
+	}
+	createdBy := c.Get("user_id").(uint)
+	apiKey, err := h.apiKeyRepo.Create(audit.Context(c), &model.APIKey{
+		Name:        req.Name,
+		Prefix:      prefix,
+		KeyHash:     auth.HashAPIKey(key),
+		Permissions: req.Permissions,
+		CreatedBy:   &createdBy,
+		ExpiresAt:   expiresAt,
+	})
+	if err != nil {
+		return internalError(c, "Failed to create API key", err)
+	}
+
+	return c.JSON(http.StatusCreated, model.CreateAPIKeyResponse{APIKey: *apiKey, Key: key})
+}
+
+// ListAPIKeys godoc
+// @Summary List API keys
+// @Description List all API keys with their prefix, scope, expiry and when they were last used, newest first
+// @Tags api-keys
+// @Produce json
+// @Success 200 {object} model.APIKeysResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/api-keys [get]
+func (h *APIKeyHandler) ListAPIKeys(c echo.Context) error {
+	keys, err := h.apiKeyRepo.List(c.Request().Context())
+	if err != nil {
+		return internalError(c, "Failed to list API keys", err)
+	}
+
+	return c.JSON(http.StatusOK, model.APIKeysResponse{APIKeys: keys})
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke an API key
+// @Description Revoke an API key; requests using it are rejected from then on
+// @Tags api-keys
+// @Produce json
+// @Param id path int true "API key ID"
+// @Success 200 {object} model.APIKey
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 409 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/api-keys/{id} [delete]
+func (h *APIKeyHandler) RevokeAPIKey(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid API key ID"})
+	}
+
+	apiKey, err := h.apiKeyRepo.Revoke(audit.Context(c), id)
+	if err != nil {
+		switch err.Error() {
+		case "api key not found":
+			return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "API key not found"})
+		case "api key already revoked":
+			return c.JSON(http.StatusConflict, model.ErrorResponse{Error: "API key is already revoked"})
+		}
+		return internalError(c, "Failed to revoke API key", err)
+	}
+
+	return c.JSON(http.StatusOK, apiKey)
+}
+
+// formatTTL renders whole days as such, e.g. "365 days".
+func formatTTL(d time.Duration) string {
+	if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		return strconv.Itoa(int(d/(24*time.Hour))) + " days"
+	}
+	return d.String()
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+type AuditHandler struct {
+	auditRepo repository.AuditEventRepository
+}
+
+func NewAuditHandler(auditRepo repository.AuditEventRepository) *AuditHandler {
+	return &AuditHandler{auditRepo: auditRepo}
+}
+
+// ListAuditEvents godoc
+// @Summary List audit events
+// @Description List administrative changes, newest first, filtered by actor, action, entity and time
+// @Tags audit
+// @Produce json
+// @Param actor_id query int false "ID of the user who made the change"
+// @Param action query string false "Action, e.g. product.update"
+// @Param entity_type query string false "product, invitation, user, order, webhook or api_key"
+// @Param entity_id query string false "ID of the changed entity"
+// @Param from query string false "Earliest time, RFC 3339"
+// @Param to query string false "Time before which events are listed, RFC 3339"
+// @Param page query int false "Page number, starting at 1"
+// @Param limit query int false "Page size (max 200)"
+// @Success 200 {object} model.AuditEventsResponse
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/audit-events [get]
+func (h *AuditHandler) ListAuditEvents(c echo.Context) error {
+	filter := model.AuditFilter{
+		Action:     c.QueryParam("action"),
+		EntityType: c.QueryParam("entity_type"),
+		EntityID:   c.QueryParam("entity_id"),
+		Limit:      defaultAuditPageSize,
+	}
+
+	if value := c.QueryParam("actor_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || id == 0 {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid actor_id"})
+		}
+		filter.ActorUserID = uint(id)
+	}
+	for name, dest := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := c.QueryParam(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid " + name + ", expected RFC 3339"})
+			}
+			*dest = &t
+		}
+	}
+
+	page := 1
+	if value := c.QueryParam("page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid page"})
+		}
+		page = n
+	}
+	if value := c.QueryParam("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxAuditPageSize {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid limit"})
+		}
+		filter.Limit = n
+	}
+	filter.Offset = (page - 1) * filter.Limit
+
+	events, total, err := h.auditRepo.List(c.Request().Context(), filter)
+	if err != nil {
+		return internalError(c, "Failed to list audit events", err)
+	}
+
+	return c.JSON(http.StatusOK, model.AuditEventsResponse{Events: events, Total: total})
+}
+
+// VerifyAuditLog godoc
+// @Summary Verify the audit log
+// @Description Recompute the hash chain over every audit event and report the first event that was modified, removed or inserted out of order
+// @Tags audit
+// @Produce json
+// @Success 200 {object} model.AuditVerification
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/audit-events/verify [get]
+func (h *AuditHandler) VerifyAuditLog(c echo.Context) error {
+	result, err := h.auditRepo.Verify(c.Request().Context())
+	if err != nil {
+		return internalError(c, "Failed to verify audit log", err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
@@ -1,36 +1,59 @@
 package handler
 
 import (
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"golang.org/x/crypto/bcrypt"
 
+	"test-ordent/internal/account"
+	"test-ordent/internal/audit"
 	"test-ordent/internal/auth"
+	"test-ordent/internal/metrics"
 	"test-ordent/internal/model"
 	"test-ordent/internal/repository"
 )
 
+// dummyPasswordHash is compared against when a login names an unknown user
+// or an account without a password, so those take as long as a wrong
+// password and response times do not tell which usernames exist.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
 type AuthHandler struct {
     userRepo    repository.UserRepository
+    accounts    *account.Service
+    loginGuard  *auth.LoginGuard
+    loginAudit  repository.LoginAuditRepository
     jwtSecret   string
     tokenExpiry time.Duration
-    adminSecret string
+    inviter     *account.Inviter
+    twoFactor   *account.TwoFactor
+    requireVerifiedEmail bool
+    metrics     *metrics.Metrics
 }
 
-func NewAuthHandler(userRepo repository.UserRepository, jwtSecret string, tokenExpiry time.Duration, adminSecret string) *AuthHandler {
+func NewAuthHandler(userRepo repository.UserRepository, accounts *account.Service, loginGuard *auth.LoginGuard, loginAudit repository.LoginAuditRepository, jwtSecret string, tokenExpiry time.Duration, inviter *account.Inviter, twoFactor *account.TwoFactor, requireVerifiedEmail bool, metrics *metrics.Metrics) *AuthHandler {
     return &AuthHandler{
         userRepo:    userRepo,
+        accounts:    accounts,
+        loginGuard:  loginGuard,
+        loginAudit:  loginAudit,
         jwtSecret:   jwtSecret,
         tokenExpiry: tokenExpiry,
-        adminSecret: adminSecret,
+        inviter:     inviter,
+        twoFactor:   twoFactor,
+        requireVerifiedEmail: requireVerifiedEmail,
+        metrics:     metrics,
     }
 }
 
 // Login godoc
 // @Summary Login user
-// @Description Login with username and password
+// @Description Login with username and password. For accounts with two-factor authentication no token is returned; instead the challenge token has to be exchanged at /auth/login/2fa together with a code.
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -38,6 +61,8 @@ func NewAuthHandler(userRepo repository.UserRepository, jwtSecret string, tokenE
 // @Success 200 {object} model.LoginResponse
 // @Failure 400 {object} model.ErrorResponse
 // @Failure 401 {object} model.ErrorResponse
+// @Failure 403 {object} model.ErrorResponse
+// @Failure 429 {object} model.ErrorResponse
 // @Router /auth/login [post]
 func (h *AuthHandler) Login(c echo.Context) error {
 	var req model.LoginRequest
@@ -45,33 +70,206 @@ func (h *AuthHandler) Login(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request"})
 	}
 
-	user, err := h.userRepo.FindByUsername(req.Username)
+	wait, err := h.loginGuard.Attempt(c.Request().Context(), req.Username, c.RealIP(), time.Now())
+	if err != nil {
+		return internalError(c, "Database error", err)
+	}
+	if wait > 0 {
+		h.recordLoginFailure(c, req.Username, 0, model.LoginFailureLockedOut)
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return c.JSON(http.StatusTooManyRequests, model.ErrorResponse{Error: "Too many failed login attempts, try again later"})
+	}
+
+	user, err := h.userRepo.FindByUsername(c.Request().Context(), req.Username)
 	if err != nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+		h.failLogin(c, req.Username, 0, model.LoginFailureUnknownUser)
 		return c.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: "Invalid credentials"})
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+	passwordHash := []byte(user.PasswordHash)
+	if len(passwordHash) == 0 {
+		passwordHash = dummyPasswordHash
+	}
+	if err := bcrypt.CompareHashAndPassword(passwordHash, []byte(req.Password)); err != nil {
+		h.failLogin(c, req.Username, user.ID, model.LoginFailureWrongPassword)
 		return c.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: "Invalid credentials"})
 	}
+	h.releaseLogin(c, req.Username)
 
-	token, err := auth.GenerateToken(user.ID, user.Role, h.jwtSecret, h.tokenExpiry)
+	if user.Status != model.UserStatusActive {
+		return c.JSON(http.StatusForbidden, model.ErrorResponse{Error: "Account suspended"})
+	}
+
+	if h.requireVerifiedEmail && !user.EmailVerified {
+		return c.JSON(http.StatusForbidden, model.ErrorResponse{Error: "Email address not verified"})
+	}
+
+	// With two-factor authentication the failed attempt counter is only reset
+	// once the code has been checked, so guessing codes stays throttled.
+	if user.TwoFactorEnabled {
+		response, err := loginChallenge(h.twoFactor, user)
+		if err != nil {
+			return internalError(c, "Failed to generate token", err)
+		}
+		return c.JSON(http.StatusOK, response)
+	}
+
+	return h.completeLogin(c, user)
+}
+
+// LoginTwoFactor godoc
+// @Summary Complete a two-factor login
+// @Description Exchange the challenge token returned by /auth/login and a TOTP code (or an unused recovery code) for an access token
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param login body model.LoginTwoFactorRequest true "Challenge token and code"
+// @Success 200 {object} model.LoginResponse
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 401 {object} model.ErrorResponse
+// @Failure 403 {object} model.ErrorResponse
+// @Failure 429 {object} model.ErrorResponse
+// @Router /auth/login/2fa [post]
+func (h *AuthHandler) LoginTwoFactor(c echo.Context) error {
+	var req model.LoginTwoFactorRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request"})
+	}
+	if (req.Code == "") == (req.RecoveryCode == "") {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Provide either code or recovery_code"})
+	}
+
+	userID, err := h.twoFactor.ParseChallenge(req.ChallengeToken)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to generate token"})
+		return c.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: "Invalid or expired challenge"})
+	}
+	user, err := h.userRepo.FindByID(c.Request().Context(), userID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: "Invalid or expired challenge"})
 	}
 
-	return c.JSON(http.StatusOK, model.LoginResponse{
-		Token: token,
-		User: model.UserResponse{
-			ID:       user.ID,
-			Username: user.Username,
-			Role:     user.Role,
-		},
+	if user.Status != model.UserStatusActive {
+		return c.JSON(http.StatusForbidden, model.ErrorResponse{Error: "Account suspended"})
+	}
+
+	wait, err := h.loginGuard.Attempt(c.Request().Context(), user.Username, c.RealIP(), time.Now())
+	if err != nil {
+		return internalError(c, "Database error", err)
+	}
+	if wait > 0 {
+		h.recordLoginFailure(c, user.Username, user.ID, model.LoginFailureLockedOut)
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return c.JSON(http.StatusTooManyRequests, model.ErrorResponse{Error: "Too many failed login attempts, try again later"})
+	}
+
+	if req.Code != "" {
+		err = h.twoFactor.Verify(c.Request().Context(), user.ID, req.Code)
+	} else {
+		err = h.twoFactor.VerifyRecoveryCode(c.Request().Context(), user.ID, req.RecoveryCode)
+	}
+	if err != nil {
+		if err == account.ErrInvalidCode || err == account.ErrTwoFactorNotEnabled {
+			h.failLogin(c, user.Username, user.ID, model.LoginFailureWrongCode)
+			return c.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: "Invalid code"})
+		}
+		h.releaseLogin(c, user.Username)
+		return internalError(c, "Failed to verify code", err)
+	}
+	h.releaseLogin(c, user.Username)
+
+	return h.completeLogin(c, user)
+}
+
+// completeLogin resets the failed attempt counter and issues the access token.
+func (h *AuthHandler) completeLogin(c echo.Context, user *model.User) error {
+	if err := h.loginGuard.Succeed(c.Request().Context(), user.Username); err != nil {
+		c.Logger().Errorf("failed to reset login attempts for %q: %v", user.Username, err)
+	}
+
+	response, err := loginToken(user, h.jwtSecret, h.tokenExpiry, h.twoFactor)
+	if err != nil {
+		return internalError(c, "Failed to generate token", err)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// loginChallenge is the response to a first login step that still needs a
+// second factor.
+func loginChallenge(twoFactor *account.TwoFactor, user *model.User) (*model.LoginResponse, error) {
+	challenge, err := twoFactor.IssueChallenge(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.LoginResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+		User:              userResponse(user),
+	}, nil
+}
+
+// loginToken is the response to a completed login.
+func loginToken(user *model.User, jwtSecret string, tokenExpiry time.Duration, twoFactor *account.TwoFactor) (*model.LoginResponse, error) {
+	token, err := auth.GenerateToken(user.ID, user.Role, jwtSecret, tokenExpiry)
+	if err != nil {
+		return nil, err
+	}
+	return &model.LoginResponse{
+		Token:                  token,
+		TwoFactorSetupRequired: !user.TwoFactorEnabled && twoFactor.Required(user.Role),
+		User:                   userResponse(user),
+	}, nil
+}
+
+func userResponse(user *model.User) model.UserResponse {
+	return model.UserResponse{
+		ID:       user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+	}
+}
+
+// failLogin records a reserved login attempt as failed.
+func (h *AuthHandler) failLogin(c echo.Context, username string, userID uint, reason string) {
+	if err := h.loginGuard.Fail(c.Request().Context(), username, c.RealIP(), time.Now()); err != nil {
+		c.Logger().Errorf("failed to count login failure for %q: %v", username, err)
+	}
+	h.recordLoginFailure(c, username, userID, reason)
+}
+
+// releaseLogin ends the attempt reserved for a login step that did not fail.
+func (h *AuthHandler) releaseLogin(c echo.Context, username string) {
+	if err := h.loginGuard.Release(c.Request().Context(), username, c.RealIP()); err != nil {
+		c.Logger().Errorf("failed to release login attempt for %q: %v", username, err)
+	}
+}
+
+func (h *AuthHandler) recordLoginFailure(c echo.Context, username string, userID uint, reason string) {
+	h.metrics.LoginFailed(reason)
+	err := h.loginAudit.RecordFailure(c.Request().Context(), &model.LoginFailure{
+		Username:  truncate(username, 100),
+		UserID:    userID,
+		IPAddress: truncate(c.RealIP(), 45),
+		UserAgent: truncate(c.Request().UserAgent(), 255),
+		Reason:    reason,
 	})
+	if err != nil {
+		c.Logger().Errorf("failed to audit login failure for %q: %v", username, err)
+	}
+}
+
+// truncate shortens s to at most max characters.
+func truncate(s string, max int) string {
+	if runes := []rune(s); len(runes) > max {
+		return string(runes[:max])
+	}
+	return s
 }
 
 // Register godoc
 // @Summary Register a new user
-// @Description Register with username, email and password
+// @Description Register with username, email and password. A verification email is sent; when login requires a verified email, no token is returned.
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -86,9 +284,9 @@ func (h *AuthHandler) Register(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request"})
 	}
 
-	exists, err := h.userRepo.ExistsByUsernameOrEmail(req.Username, req.Email)
+	exists, err := h.userRepo.ExistsByUsernameOrEmail(c.Request().Context(), req.Username, req.Email)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Database error"})
+		return internalError(c, "Database error", err)
 	}
 
 	if exists {
@@ -97,7 +295,7 @@ func (h *AuthHandler) Register(c echo.Context) error {
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to hash password"})
+		return internalError(c, "Failed to hash password", err)
 	}
 
 	user := &model.User{
@@ -105,17 +303,32 @@ func (h *AuthHandler) Register(c echo.Context) error {
 		Email:        req.Email,
 		PasswordHash: string(hashedPassword),
 		FullName:     req.FullName,
-		Role:         "customer",
+		Role:         auth.RoleCustomer,
 	}
 
-	userID, err := h.userRepo.Create(user)
+	userID, err := h.userRepo.Create(c.Request().Context(), user)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to create user"})
+		return internalError(c, "Failed to create user", err)
+	}
+
+	user.ID = userID
+	if err := h.accounts.SendVerification(c.Request().Context(), user); err != nil {
+		c.Logger().Errorf("failed to send verification email to user %d: %v", userID, err)
 	}
 
-	token, err := auth.GenerateToken(userID, "customer", h.jwtSecret, h.tokenExpiry)
+	if h.requireVerifiedEmail {
+		return c.JSON(http.StatusCreated, model.RegisterResponse{
+			User: model.UserResponse{
+				ID:       userID,
+				Username: req.Username,
+				Role:     auth.RoleCustomer,
+			},
+		})
+	}
+
+	token, err := auth.GenerateToken(userID, auth.RoleCustomer, h.jwtSecret, h.tokenExpiry)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to generate token"})
+		return internalError(c, "Failed to generate token", err)
 	}
 
 	return c.JSON(http.StatusCreated, model.RegisterResponse{
@@ -123,70 +336,139 @@ func (h *AuthHandler) Register(c echo.Context) error {
 		User: model.UserResponse{
 			ID:       userID,
 			Username: req.Username,
-			Role:     "customer",
+			Role:     auth.RoleCustomer,
 		},
 	})
 }
 
-// RegisterAdmin godoc
-// @Summary Register a new admin
-// @Description Register an admin with secret code
+// AcceptInvitation godoc
+// @Summary Accept an invitation
+// @Description Create an account from an invitation issued by an administrator. The email address and role are taken from the invitation.
 // @Tags auth
 // @Accept json
 // @Produce json
-// @Param register body model.RegisterAdminRequest true "Admin Registration data"
+// @Param invitation body model.AcceptInvitationRequest true "Invitation token and account data"
 // @Success 201 {object} model.RegisterResponse
 // @Failure 400 {object} model.ErrorResponse
 // @Failure 409 {object} model.ErrorResponse
-// @Router /auth/admin-register [post]
-func (h *AuthHandler) RegisterAdmin(c echo.Context) error {
-    var req model.RegisterAdminRequest
-    if err := c.Bind(&req); err != nil {
-        return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request"})
-    }
+// @Router /auth/accept-invitation [post]
+func (h *AuthHandler) AcceptInvitation(c echo.Context) error {
+	var req model.AcceptInvitationRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request"})
+	}
 
-    if req.AdminSecret != h.adminSecret {
-        return c.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: "Invalid admin secret"})
-    }
+	req.Username = strings.TrimSpace(req.Username)
+	if len(req.Username) < 3 || len(req.Username) > 50 {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Username must be 3 to 50 characters"})
+	}
+	if len(req.Password) < 6 {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Password must be at least 6 characters"})
+	}
+	if strings.TrimSpace(req.FullName) == "" {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Full name is required"})
+	}
 
-    exists, err := h.userRepo.ExistsByUsernameOrEmail(req.Username, req.Email)
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Database error"})
-    }
+	user, err := h.inviter.Accept(audit.Context(c), req.Token, req.Username, req.Password, strings.TrimSpace(req.FullName))
+	if err != nil {
+		if err == account.ErrInvalidToken {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid or expired invitation"})
+		}
+		if err.Error() == "username or email already exists" {
+			return c.JSON(http.StatusConflict, model.ErrorResponse{Error: "Username or email already exists"})
+		}
+		return internalError(c, "Failed to create user", err)
+	}
 
-    if exists {
-        return c.JSON(http.StatusConflict, model.ErrorResponse{Error: "Username or email already exists"})
-    }
+	token, err := auth.GenerateToken(user.ID, user.Role, h.jwtSecret, h.tokenExpiry)
+	if err != nil {
+		return internalError(c, "Failed to generate token", err)
+	}
 
-    hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to hash password"})
-    }
+	return c.JSON(http.StatusCreated, model.RegisterResponse{
+		Token: token,
+		User: model.UserResponse{
+			ID:       user.ID,
+			Username: user.Username,
+			Role:     user.Role,
+		},
+	})
+}
 
-    user := &model.User{
-        Username:     req.Username,
-        Email:        req.Email,
-        PasswordHash: string(hashedPassword),
-        FullName:     req.FullName,
-        Role:         "admin",
-    }
+// ForgotPassword godoc
+// @Summary Request a password reset
+// @Description Email a single-use password reset link. The response is the same whether or not the address belongs to an account.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body model.ForgotPasswordRequest true "Account email"
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} model.ErrorResponse
+// @Router /auth/forgot-password [post]
+func (h *AuthHandler) ForgotPassword(c echo.Context) error {
+	var req model.ForgotPasswordRequest
+	if err := c.Bind(&req); err != nil || strings.TrimSpace(req.Email) == "" {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Email is required"})
+	}
 
-    userID, err := h.userRepo.Create(user)
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to create user"})
-    }
+	h.accounts.RequestPasswordReset(c.Request().Context(), strings.TrimSpace(req.Email))
 
-    token, err := auth.GenerateToken(userID, "admin", h.jwtSecret, h.tokenExpiry)
-    if err != nil {
-        return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to generate token"})
-    }
+	return c.JSON(http.StatusAccepted, map[string]string{"message": "If the address belongs to an account, a reset link has been sent"})
+}
+
+// ResetPassword godoc
+// @Summary Reset password
+// @Description Set a new password using the token from a password reset email; tokens issued before the reset stop working
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body model.ResetPasswordRequest true "Reset token and new password"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Router /auth/reset-password [post]
+func (h *AuthHandler) ResetPassword(c echo.Context) error {
+	var req model.ResetPasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request"})
+	}
+	if len(req.NewPassword) < 6 {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "New password must be at least 6 characters"})
+	}
 
-    return c.JSON(http.StatusCreated, model.RegisterResponse{
-        Token: token,
-        User: model.UserResponse{
-            ID:       userID,
-            Username: req.Username,
-            Role:     "admin",
-        },
-    })
-}
\ No newline at end of file
+	if err := h.accounts.ResetPassword(c.Request().Context(), req.Token, req.NewPassword); err != nil {
+		if err == account.ErrInvalidToken {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid or expired token"})
+		}
+		return internalError(c, "Failed to reset password", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Password has been reset"})
+}
+
+// VerifyEmail godoc
+// @Summary Verify email address
+// @Description Confirm an email address using the token from a verification email
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body model.VerifyEmailRequest true "Verification token"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Router /auth/verify-email [post]
+func (h *AuthHandler) VerifyEmail(c echo.Context) error {
+	var req model.VerifyEmailRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request"})
+	}
+
+	if err := h.accounts.VerifyEmail(c.Request().Context(), req.Token); err != nil {
+		if err == account.ErrInvalidToken {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid or expired token"})
+		}
+		return internalError(c, "Failed to verify email", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Email address verified"})
+}
@@ -2,11 +2,13 @@ package handler
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/labstack/echo/v4"
 
+	"test-ordent/internal/metrics"
 	"test-ordent/internal/model"
 	"test-ordent/internal/repository"
 )
@@ -15,13 +17,15 @@ type CartHandler struct {
     cartRepo    repository.CartRepository
     productRepo repository.ProductRepository
     db          *sql.DB 
+    metrics     *metrics.Metrics
 }
 
-func NewCartHandler(cartRepo repository.CartRepository, productRepo repository.ProductRepository, db *sql.DB) *CartHandler {
+func NewCartHandler(cartRepo repository.CartRepository, productRepo repository.ProductRepository, db *sql.DB, metrics *metrics.Metrics) *CartHandler {
     return &CartHandler{
         cartRepo:    cartRepo,
         productRepo: productRepo,
         db:          db,
+        metrics:     metrics,
     }
 }
 
@@ -38,24 +42,24 @@ func NewCartHandler(cartRepo repository.CartRepository, productRepo repository.P
 func (h *CartHandler) GetCart(c echo.Context) error {
 	userID := c.Get("user_id").(uint)
 
-	cart, err := h.cartRepo.FindByUserID(userID)
+	cart, err := h.cartRepo.FindByUserID(c.Request().Context(), userID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Database error"})
+		return internalError(c, "Database error", err)
 	}
 
 	var cartID uint
 	if cart == nil {
-		cartID, err = h.cartRepo.Create(userID)
+		cartID, err = h.cartRepo.Create(c.Request().Context(), userID)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to create cart"})
+			return internalError(c, "Failed to create cart", err)
 		}
 	} else {
 		cartID = cart.ID
 	}
 
-	items, err := h.cartRepo.GetCartItems(cartID)
+	items, err := h.cartRepo.GetCartItems(c.Request().Context(), cartID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Database error"})
+		return internalError(c, "Database error", err)
 	}
 
 	var total float64
@@ -91,21 +95,22 @@ func (h *CartHandler) AddItem(c echo.Context) error {
         return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request"})
     }
 
-    stock, err := h.productRepo.GetStock(int(req.ProductID))
+    stock, err := h.productRepo.GetStock(c.Request().Context(), int(req.ProductID))
     if err != nil {
         if err.Error() == "product not found" {
             return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Product not found"})
         }
-        return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Database error"})
+        return internalError(c, "Database error", err)
     }
 
     if stock < req.Quantity {
+        h.metrics.OutOfStock(metrics.StageCart)
         return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Not enough stock"})
     }
 
     tx, err := h.db.Begin()
     if err != nil {
-        return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to begin transaction"})
+        return internalError(c, "Failed to begin transaction", err)
     }
     
     var txErr error
@@ -115,60 +120,66 @@ func (h *CartHandler) AddItem(c echo.Context) error {
         }
     }()
 
-    cart, err := h.cartRepo.FindByUserID(userID)
+    cart, err := h.cartRepo.FindByUserID(c.Request().Context(), userID)
     if err != nil {
-        return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Database error"})
+        return internalError(c, "Database error", err)
     }
 
     var cartID uint
     if cart == nil {
-        cartID, err = h.cartRepo.Create(userID)
+        cartID, err = h.cartRepo.Create(c.Request().Context(), userID)
         if err != nil {
-            return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to create cart"})
+            return internalError(c, "Failed to create cart", err)
         }
     } else {
         cartID = cart.ID
     }
 
-    cartItem, err := h.cartRepo.FindCartItemByProductID(cartID, req.ProductID)
+    cartItem, err := h.cartRepo.FindCartItemByProductID(c.Request().Context(), cartID, req.ProductID)
     if err != nil {
-        return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Database error"})
+        return internalError(c, "Database error", err)
     }
 
     var totalQuantity int
     if cartItem == nil {
-        err = h.cartRepo.AddItem(cartID, req.ProductID, req.Quantity)
+        err = h.cartRepo.AddItem(c.Request().Context(), cartID, req.ProductID, req.Quantity)
         if err != nil {
-            return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to add item to cart"})
+            return internalError(c, "Failed to add item to cart", err)
         }
         totalQuantity = req.Quantity
     } else {
         newQuantity := cartItem.Quantity + req.Quantity
         if newQuantity > stock {
+            h.metrics.OutOfStock(metrics.StageCart)
             return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Not enough stock"})
         }
 
-        err = h.cartRepo.UpdateItemQuantity(cartItem.ID, newQuantity)
+        err = h.cartRepo.UpdateItemQuantity(c.Request().Context(), cartItem.ID, newQuantity)
         if err != nil {
-            return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to update cart item"})
+            return internalError(c, "Failed to update cart item", err)
         }
         totalQuantity = req.Quantity
     }
 
-    err = h.productRepo.DecreaseStock(int(req.ProductID), totalQuantity)
+    err = h.productRepo.DecreaseStock(c.Request().Context(), int(req.ProductID), totalQuantity)
     if err != nil {
-        return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to update product stock: " + err.Error()})
+        if errors.Is(err, repository.ErrInsufficientStock) {
+            h.metrics.OutOfStock(metrics.StageCart)
+            return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Not enough stock"})
+        }
+        return internalError(c, "Failed to update product stock", err)
     }
 
-    err = h.cartRepo.UpdateLastModified(cartID)
+    err = h.cartRepo.UpdateLastModified(c.Request().Context(), cartID)
     if err != nil {
-        return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to update cart"})
+        return internalError(c, "Failed to update cart", err)
     }
 
 	if err = tx.Commit(); err != nil {
         txErr = err
-        return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to commit transaction"})
+        return internalError(c, "Failed to commit transaction", err)
     }
+    h.metrics.CartItemAdded()
     
     return h.GetCart(c)
 }
@@ -194,34 +205,34 @@ func (h *CartHandler) RemoveItem(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid item ID"})
 	}
 
-	cart, err := h.cartRepo.FindByUserID(userID)
+	cart, err := h.cartRepo.FindByUserID(c.Request().Context(), userID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Database error"})
+		return internalError(c, "Database error", err)
 	}
 	if cart == nil {
 		return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Cart not found"})
 	}
 
-	cartItem, err := h.cartRepo.FindCartItemByID(uint(itemID))
+	cartItem, err := h.cartRepo.FindCartItemByID(c.Request().Context(), uint(itemID))
 	if err != nil {
 		if err.Error() == "cart item not found" {
 			return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Item not found in your cart"})
 		}
-		return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Database error"})
+		return internalError(c, "Database error", err)
 	}
 
 	if cartItem.CartID != cart.ID {
 		return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Item not found in your cart"})
 	}
 
-	err = h.cartRepo.RemoveItem(uint(itemID))
+	err = h.cartRepo.RemoveItem(c.Request().Context(), uint(itemID))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to remove item from cart"})
+		return internalError(c, "Failed to remove item from cart", err)
 	}
 
-	err = h.cartRepo.UpdateLastModified(cart.ID)
+	err = h.cartRepo.UpdateLastModified(c.Request().Context(), cart.ID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to update cart"})
+		return internalError(c, "Failed to update cart", err)
 	}
 
 	return h.GetCart(c)
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/internal/audit"
+	"test-ordent/internal/catalog"
+	"test-ordent/internal/model"
+)
+
+const maxImportSize = 20 << 20
+
+type CatalogHandler struct {
+	importer *catalog.Importer
+	exporter *catalog.Exporter
+}
+
+func NewCatalogHandler(importer *catalog.Importer, exporter *catalog.Exporter) *CatalogHandler {
+	return &CatalogHandler{
+		importer: importer,
+		exporter: exporter,
+	}
+}
+
+// ImportProducts godoc
+// @Summary Import products
+// @Description Create or update products by SKU from a CSV or JSON Lines file. CSV files need a header row with sku, name, price, stock, category_id and optionally description and image_url.
+// @Tags products
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "CSV or JSONL file"
+// @Param format query string false "csv or jsonl, defaults to the file extension"
+// @Param dry_run query bool false "Validate and report without saving"
+// @Param atomic query bool false "Import all rows or none (default true); false imports every valid row"
+// @Success 200 {object} model.ProductImportReport
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 413 {object} model.ErrorResponse
+// @Failure 422 {object} model.ProductImportReport
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /products/import [post]
+func (h *CatalogHandler) ImportProducts(c echo.Context) error {
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxImportSize)
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return c.JSON(http.StatusRequestEntityTooLarge, model.ErrorResponse{Error: fmt.Sprintf("Import file exceeds the maximum size of %d bytes", maxImportSize)})
+		}
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Import file is required"})
+	}
+
+	var format catalog.Format
+	if name := c.QueryParam("format"); name != "" {
+		format, err = catalog.ParseFormat(name)
+	} else {
+		format, err = catalog.FormatFromFilename(fileHeader.Filename)
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: err.Error()})
+	}
+
+	opts := catalog.ImportOptions{Format: format, Atomic: true, ActorID: c.Get("user_id").(uint)}
+	if value := c.QueryParam("dry_run"); value != "" {
+		if opts.DryRun, err = strconv.ParseBool(value); err != nil {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid dry_run value"})
+		}
+	}
+	if value := c.QueryParam("atomic"); value != "" {
+		if opts.Atomic, err = strconv.ParseBool(value); err != nil {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid atomic value"})
+		}
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Failed to read import file"})
+	}
+	defer file.Close()
+
+	report, err := h.importer.Import(audit.Context(c), file, opts)
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Import file is truncated"})
+		}
+		var fileErr *catalog.FileError
+		if errors.As(err, &fileErr) {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid import file: " + fileErr.Error()})
+		}
+		return internalError(c, "Failed to import products", err)
+	}
+
+	if opts.Atomic && report.Failed > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, report)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// ExportProducts godoc
+// @Summary Export products
+// @Description Stream all active products as CSV or JSON Lines in the same layout the import accepts
+// @Tags products
+// @Produce text/csv
+// @Produce application/x-ndjson
+// @Param format query string false "csv (default) or jsonl"
+// @Success 200 {file} file
+// @Failure 400 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /products/export [get]
+func (h *CatalogHandler) ExportProducts(c echo.Context) error {
+	format := catalog.FormatCSV
+	if name := c.QueryParam("format"); name != "" {
+		var err error
+		if format, err = catalog.ParseFormat(name); err != nil {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: err.Error()})
+		}
+	}
+
+	filename := fmt.Sprintf("products-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+	c.Response().Header().Set(echo.HeaderContentType, format.ContentType())
+	c.Response().Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Response().WriteHeader(http.StatusOK)
+
+	// Headers are already sent, so a failure halfway through can only cut
+	// the stream short.
+	if err := h.exporter.Export(c.Request().Context(), c.Response(), format); err != nil {
+		c.Logger().Errorf("product export failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/internal/model"
+	"test-ordent/internal/tracing"
+	"test-ordent/pkg/logger"
+)
+
+// internalError answers with a 500 carrying message, which is safe to show to
+// clients, and the trace ID, and records err to be logged with the request.
+func internalError(c echo.Context, message string, err error) error {
+	logger.SetError(c, err)
+	ctx := c.Request().Context()
+	tracing.RecordError(ctx, err)
+	return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: message, TraceID: tracing.TraceID(ctx)})
+}
+
+// HTTPErrorHandler answers errors returned by handlers and middleware, such
+// as unknown routes or oversized bodies, with an ErrorResponse. Server
+// errors carry the trace ID instead of their cause, which is only logged.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	status := http.StatusInternalServerError
+	message := http.StatusText(status)
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		status = httpErr.Code
+		message = http.StatusText(status)
+		if m, ok := httpErr.Message.(string); ok && status < http.StatusInternalServerError {
+			message = m
+		}
+	}
+
+	resp := model.ErrorResponse{Error: message}
+	if status >= http.StatusInternalServerError {
+		resp.TraceID = tracing.TraceID(c.Request().Context())
+	}
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(status)
+	} else {
+		err = c.JSON(status, resp)
+	}
+	if err != nil {
+		logger.FromEcho(c).Error("Failed to write error response", "error", err)
+	}
+}
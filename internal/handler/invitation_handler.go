@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/internal/account"
+	"test-ordent/internal/audit"
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+)
+
+type InvitationHandler struct {
+	userRepo       repository.UserRepository
+	invitationRepo repository.InvitationRepository
+	inviter        *account.Inviter
+}
+
+func NewInvitationHandler(userRepo repository.UserRepository, invitationRepo repository.InvitationRepository, inviter *account.Inviter) *InvitationHandler {
+	return &InvitationHandler{
+		userRepo:       userRepo,
+		invitationRepo: invitationRepo,
+		inviter:        inviter,
+	}
+}
+
+// CreateInvitation godoc
+// @Summary Invite a staff member
+// @Description Issue a single-use, expiring invitation for the given role and email it. The token is returned once so it can be passed on if the email does not arrive.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param invitation body model.CreateInvitationRequest true "Invitee email and role"
+// @Success 201 {object} model.CreateInvitationResponse
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 409 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/invitations [post]
+func (h *InvitationHandler) CreateInvitation(c echo.Context) error {
+	var req model.CreateInvitationRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request"})
+	}
+
+	req.Email = strings.TrimSpace(req.Email)
+	if address, err := mail.ParseAddress(req.Email); err != nil || address.Address != req.Email {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid email address"})
+	}
+
+	if _, err := h.userRepo.FindByEmail(c.Request().Context(), req.Email); err == nil {
+		return c.JSON(http.StatusConflict, model.ErrorResponse{Error: "A user with this email already exists"})
+	}
+
+	inviter, err := h.userRepo.FindByID(c.Request().Context(), c.Get("user_id").(uint))
+	if err != nil {
+		return internalError(c, "Database error", err)
+	}
+
+	invitation, token, err := h.inviter.Invite(audit.Context(c), req.Email, req.Role, inviter)
+	if err != nil {
+		if err == account.ErrInvalidRole {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Role must be one of: " + strings.Join(account.InvitableRoles, ", ")})
+		}
+		if invitation == nil {
+			return internalError(c, "Failed to create invitation", err)
+		}
+		c.Logger().Errorf("failed to email invitation %d: %v", invitation.ID, err)
+	}
+
+	return c.JSON(http.StatusCreated, model.CreateInvitationResponse{Invitation: *invitation, Token: token})
+}
+
+// ListInvitations godoc
+// @Summary List invitations
+// @Description List all invitations with who issued them and who accepted them, newest first
+// @Tags users
+// @Produce json
+// @Success 200 {object} model.InvitationsResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/invitations [get]
+func (h *InvitationHandler) ListInvitations(c echo.Context) error {
+	invitations, err := h.invitationRepo.List(c.Request().Context())
+	if err != nil {
+		return internalError(c, "Failed to list invitations", err)
+	}
+
+	return c.JSON(http.StatusOK, model.InvitationsResponse{Invitations: invitations})
+}
+
+// RevokeInvitation godoc
+// @Summary Revoke an invitation
+// @Description Revoke an invitation that has not been accepted yet
+// @Tags users
+// @Produce json
+// @Param id path int true "Invitation ID"
+// @Success 200 {object} model.Invitation
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 409 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/invitations/{id} [delete]
+func (h *InvitationHandler) RevokeInvitation(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid invitation ID"})
+	}
+
+	invitation, err := h.invitationRepo.Revoke(audit.Context(c), id)
+	if err != nil {
+		if err.Error() == "invitation not found" {
+			return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Invitation not found"})
+		}
+		if strings.HasPrefix(err.Error(), "invitation already ") {
+			return c.JSON(http.StatusConflict, model.ErrorResponse{Error: "Invitation is already " + strings.TrimPrefix(err.Error(), "invitation already ")})
+		}
+		return internalError(c, "Failed to revoke invitation", err)
+	}
+
+	return c.JSON(http.StatusOK, invitation)
+}
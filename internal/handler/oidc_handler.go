@@ -0,0 +1,237 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/internal/account"
+	"test-ordent/internal/auth"
+	"test-ordent/internal/model"
+	"test-ordent/internal/oidc"
+)
+
+const oidcStateCookie = "oidc_state"
+
+type OIDCHandler struct {
+	providers            *oidc.Registry
+	social               *account.SocialLogin
+	twoFactor            *account.TwoFactor
+	cipher               *auth.SecretCipher
+	jwtSecret            string
+	tokenExpiry          time.Duration
+	stateTTL             time.Duration
+	successRedirectURL   string
+	requireVerifiedEmail bool
+}
+
+func NewOIDCHandler(providers *oidc.Registry, social *account.SocialLogin, twoFactor *account.TwoFactor, cipher *auth.SecretCipher, jwtSecret string, tokenExpiry, stateTTL time.Duration, successRedirectURL string, requireVerifiedEmail bool) *OIDCHandler {
+	return &OIDCHandler{
+		providers:            providers,
+		social:               social,
+		twoFactor:            twoFactor,
+		cipher:               cipher,
+		jwtSecret:            jwtSecret,
+		tokenExpiry:          tokenExpiry,
+		stateTTL:             stateTTL,
+		successRedirectURL:   successRedirectURL,
+		requireVerifiedEmail: requireVerifiedEmail,
+	}
+}
+
+// ListProviders godoc
+// @Summary List identity providers
+// @Description List the external identity providers that can be used to sign in
+// @Tags auth
+// @Produce json
+// @Success 200 {object} model.OIDCProvidersResponse
+// @Router /auth/oidc/providers [get]
+func (h *OIDCHandler) ListProviders(c echo.Context) error {
+	return c.JSON(http.StatusOK, model.OIDCProvidersResponse{Providers: h.providers.Names()})
+}
+
+// Login godoc
+// @Summary Sign in with an identity provider
+// @Description Redirect the browser to the identity provider (authorization code flow with PKCE). The sign-in state is kept in an encrypted cookie until the callback.
+// @Tags auth
+// @Param provider path string true "Provider name"
+// @Success 302
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 502 {object} model.ErrorResponse
+// @Router /auth/oidc/{provider}/login [get]
+func (h *OIDCHandler) Login(c echo.Context) error {
+	provider, err := h.providers.Get(c.Param("provider"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Unknown identity provider"})
+	}
+
+	state, err := oidc.NewLoginState(provider.Name(), h.stateTTL)
+	if err != nil {
+		return internalError(c, "Failed to start sign-in", err)
+	}
+	authURL, err := provider.AuthCodeURL(c.Request().Context(), state.State, state.Nonce, state.Verifier)
+	if err != nil {
+		c.Logger().Errorf("oidc: %v", err)
+		return c.JSON(http.StatusBadGateway, model.ErrorResponse{Error: "Identity provider is unavailable"})
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return internalError(c, "Failed to start sign-in", err)
+	}
+	sealed, err := h.cipher.Encrypt(string(payload))
+	if err != nil {
+		return internalError(c, "Failed to start sign-in", err)
+	}
+	c.SetCookie(h.stateCookie(c, sealed, int(h.stateTTL.Seconds())))
+
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback godoc
+// @Summary Identity provider callback
+// @Description Complete a sign-in started at /auth/oidc/{provider}/login. The identity is linked to the user with the same verified email address, or a new customer account is created. Responds like /auth/login, or redirects to the configured success URL with the response in the URL fragment.
+// @Tags auth
+// @Produce json
+// @Param provider path string true "Provider name"
+// @Param code query string true "Authorization code"
+// @Param state query string true "State"
+// @Success 200 {object} model.LoginResponse
+// @Success 302
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 403 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 409 {object} model.ErrorResponse
+// @Failure 502 {object} model.ErrorResponse
+// @Router /auth/oidc/{provider}/callback [get]
+func (h *OIDCHandler) Callback(c echo.Context) error {
+	provider, err := h.providers.Get(c.Param("provider"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Unknown identity provider"})
+	}
+
+	state, err := h.readState(c)
+	// The state cookie is single-use whatever the outcome.
+	c.SetCookie(h.stateCookie(c, "", -1))
+	if err != nil || state.Provider != provider.Name() || state.State != c.QueryParam("state") || state.Expired(time.Now()) {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid or expired sign-in state"})
+	}
+
+	if providerErr := c.QueryParam("error"); providerErr != "" {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Sign-in was not completed: " + providerErr})
+	}
+	code := c.QueryParam("code")
+	if code == "" {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Authorization code is required"})
+	}
+
+	identity, err := provider.Exchange(c.Request().Context(), code, state.Nonce, state.Verifier)
+	if err != nil {
+		c.Logger().Errorf("oidc: %s: %v", provider.Name(), err)
+		return c.JSON(http.StatusBadGateway, model.ErrorResponse{Error: "Could not verify the sign-in with the identity provider"})
+	}
+
+	user, err := h.social.Resolve(c.Request().Context(), identity)
+	if err != nil {
+		switch {
+		case err == account.ErrEmailRequired:
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "The identity provider did not share an email address"})
+		case err == account.ErrEmailNotVerified:
+			return c.JSON(http.StatusConflict, model.ErrorResponse{Error: "An account with this email already exists; verify the email with the identity provider to link it"})
+		case err.Error() == "identity already linked" || err.Error() == "username or email already exists":
+			return c.JSON(http.StatusConflict, model.ErrorResponse{Error: "Could not link the identity, try again"})
+		}
+		return internalError(c, "Failed to sign in", err)
+	}
+
+	if user.Status != model.UserStatusActive {
+		return c.JSON(http.StatusForbidden, model.ErrorResponse{Error: "Account suspended"})
+	}
+	if h.requireVerifiedEmail && !user.EmailVerified {
+		return c.JSON(http.StatusForbidden, model.ErrorResponse{Error: "Email address not verified"})
+	}
+
+	var response *model.LoginResponse
+	if user.TwoFactorEnabled {
+		response, err = loginChallenge(h.twoFactor, user)
+	} else {
+		response, err = loginToken(user, h.jwtSecret, h.tokenExpiry, h.twoFactor)
+	}
+	if err != nil {
+		return internalError(c, "Failed to generate token", err)
+	}
+
+	if h.successRedirectURL != "" {
+		return c.Redirect(http.StatusFound, h.successRedirectURL+"#"+fragment(response))
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// ListIdentities godoc
+// @Summary List linked identities
+// @Description List the external identity provider accounts linked to the logged in user
+// @Tags users
+// @Produce json
+// @Success 200 {object} model.UserIdentitiesResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /users/me/identities [get]
+func (h *OIDCHandler) ListIdentities(c echo.Context) error {
+	identities, err := h.social.Identities(c.Request().Context(), c.Get("user_id").(uint))
+	if err != nil {
+		return internalError(c, "Failed to list identities", err)
+	}
+
+	return c.JSON(http.StatusOK, model.UserIdentitiesResponse{Identities: identities})
+}
+
+func (h *OIDCHandler) readState(c echo.Context) (*oidc.LoginState, error) {
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := h.cipher.Decrypt(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	var state oidc.LoginState
+	if err := json.Unmarshal([]byte(payload), &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// stateCookie is scoped to the OIDC routes and sent on the top-level
+// redirect back from the provider (SameSite=Lax).
+func (h *OIDCHandler) stateCookie(c echo.Context, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/api/auth/oidc/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// fragment encodes a login response for the success redirect. The fragment is
+// not sent to servers, so the token does not end up in access logs.
+func fragment(response *model.LoginResponse) string {
+	values := url.Values{}
+	if response.Token != "" {
+		values.Set("token", response.Token)
+	}
+	if response.TwoFactorRequired {
+		values.Set("challenge_token", response.ChallengeToken)
+	}
+	if response.TwoFactorSetupRequired {
+		values.Set("two_factor_setup_required", "true")
+	}
+	values.Set("user_id", strconv.FormatUint(uint64(response.User.ID), 10))
+	return values.Encode()
+}
@@ -2,28 +2,39 @@ package handler
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
 
+	"test-ordent/internal/audit"
+	"test-ordent/internal/metrics"
 	"test-ordent/internal/model"
 	"test-ordent/internal/repository"
 )
 
+const (
+	defaultOrderPageSize = 20
+	maxOrderPageSize     = 100
+)
+
 type OrderHandler struct {
     orderRepo   repository.OrderRepository
     cartRepo    repository.CartRepository
     productRepo repository.ProductRepository
     db          *sql.DB  
+    metrics     *metrics.Metrics
 }
 
-func NewOrderHandler(orderRepo repository.OrderRepository, cartRepo repository.CartRepository, productRepo repository.ProductRepository, db *sql.DB) *OrderHandler {
+func NewOrderHandler(orderRepo repository.OrderRepository, cartRepo repository.CartRepository, productRepo repository.ProductRepository, db *sql.DB, metrics *metrics.Metrics) *OrderHandler {
     return &OrderHandler{
         orderRepo:   orderRepo,
         cartRepo:    cartRepo,
         productRepo: productRepo,
         db:          db,
+        metrics:     metrics,
     }
 }
 
@@ -52,18 +63,18 @@ func (h *OrderHandler) CreateOrder(c echo.Context) error {
         return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Shipping address is required"})
     }
     
-    cart, err := h.cartRepo.FindByUserID(userID)
+    cart, err := h.cartRepo.FindByUserID(c.Request().Context(), userID)
     if err != nil {
-        return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to get cart"})
+        return internalError(c, "Failed to get cart", err)
     }
     
     if cart == nil {
         return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Cart is empty"})
     }
     
-    items, err := h.cartRepo.GetCartItems(cart.ID)
+    items, err := h.cartRepo.GetCartItems(c.Request().Context(), cart.ID)
     if err != nil {
-        return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to get cart items"})
+        return internalError(c, "Failed to get cart items", err)
     }
     
     if len(items) == 0 {
@@ -74,34 +85,41 @@ func (h *OrderHandler) CreateOrder(c echo.Context) error {
     orderItems := make([]model.OrderItem, 0, len(items))
     
     for _, item := range items {
-        product, err := h.productRepo.FindByID(int(item.ProductID))
+        product, err := h.productRepo.FindByID(c.Request().Context(), int(item.ProductID))
         if err != nil {
-            return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to get product"})
+            return internalError(c, "Failed to get product", err)
         }
         
         if product.Stock < item.Quantity {
+            h.metrics.OutOfStock(metrics.StageCheckout)
             return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: fmt.Sprintf("Not enough stock for product: %s", product.Name)})
         }
         
-        itemTotal := product.Price * float64(item.Quantity)
+        price := product.EffectivePrice()
+        itemTotal := price * float64(item.Quantity)
         total += itemTotal
         
         orderItems = append(orderItems, model.OrderItem{
             ProductID: item.ProductID,
             Quantity:  item.Quantity,
-            Price:     product.Price,
+            Price:     price,
             Subtotal:  itemTotal,
         })
     }
     
-    orderID, err := h.orderRepo.CreateOrder(userID, total, req.ShippingAddress, orderItems, cart.ID)
+    orderID, err := h.orderRepo.CreateOrder(c.Request().Context(), userID, total, req.ShippingAddress, orderItems, cart.ID)
     if err != nil {
-        return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to create order: " + err.Error()})
+        if errors.Is(err, repository.ErrInsufficientStock) {
+            h.metrics.OutOfStock(metrics.StageCheckout)
+            return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Not enough stock"})
+        }
+        return internalError(c, "Failed to create order", err)
     }
+    h.metrics.OrderCreated(total)
     
-    order, err := h.orderRepo.FindByID(orderID)
+    order, err := h.orderRepo.FindByID(c.Request().Context(), orderID)
     if err != nil {
-        return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to get order"})
+        return internalError(c, "Failed to get order", err)
     }
     
     return c.JSON(http.StatusCreated, order)
@@ -120,18 +138,190 @@ func (h *OrderHandler) CreateOrder(c echo.Context) error {
 func (h *OrderHandler) GetOrders(c echo.Context) error {
 	userID := c.Get("user_id").(uint)
 
-	orders, err := h.orderRepo.FindByUserID(userID)
+	orders, err := h.orderRepo.FindByUserID(c.Request().Context(), userID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Database error"})
+		return internalError(c, "Database error", err)
 	}
 
 	for i := range orders {
-		orderItems, err := h.orderRepo.GetOrderItems(orders[i].ID)
+		orderItems, err := h.orderRepo.GetOrderItems(c.Request().Context(), orders[i].ID)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Database error"})
+			return internalError(c, "Database error", err)
 		}
 		orders[i].Items = orderItems
 	}
 
 	return c.JSON(http.StatusOK, model.OrdersResponse{Orders: orders})
-}
\ No newline at end of file
+}
+
+// UpdateOrderStatus godoc
+// @Summary Change an order's status
+// @Description Move an order along pending, paid, shipped and delivered, or cancel it before it ships. Publishes an order.status_changed event.
+// @Tags orders
+// @Accept json
+// @Produce json
+// @Param id path int true "Order ID"
+// @Param status body model.UpdateOrderStatusRequest true "New status"
+// @Success 200 {object} model.Order
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 409 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/orders/{id}/status [put]
+func (h *OrderHandler) UpdateOrderStatus(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid order ID"})
+	}
+
+	var req model.UpdateOrderStatusRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request"})
+	}
+	// Refunds have their own endpoint and permission.
+	if !model.IsOrderStatus(req.Status) || req.Status == model.OrderStatusRefunded {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Status must be pending, paid, shipped, delivered or cancelled"})
+	}
+
+	order, err := h.orderRepo.UpdateStatus(audit.Context(c), uint(id), req.Status)
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidStatusTransition) {
+			return c.JSON(http.StatusConflict, model.ErrorResponse{Error: "Invalid status change: " + err.Error()})
+		}
+		if err.Error() == "order not found" {
+			return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Order not found"})
+		}
+		return internalError(c, "Failed to update order status", err)
+	}
+
+	return c.JSON(http.StatusOK, order)
+}
+
+// ListAdminOrders godoc
+// @Summary List all orders
+// @Description List orders of all customers, newest first, e.g. paid orders waiting to be shipped
+// @Tags orders
+// @Produce json
+// @Param status query string false "pending, paid, shipped, delivered, cancelled or refunded"
+// @Param user_id query int false "Customer user ID"
+// @Param page query int false "Page number, starting at 1"
+// @Param limit query int false "Page size (max 100)"
+// @Success 200 {object} model.AdminOrdersResponse
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/orders [get]
+func (h *OrderHandler) ListAdminOrders(c echo.Context) error {
+	filter := model.OrderFilter{
+		Status: c.QueryParam("status"),
+		Limit:  defaultOrderPageSize,
+	}
+	if filter.Status != "" && !model.IsOrderStatus(filter.Status) {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid status"})
+	}
+	if value := c.QueryParam("user_id"); value != "" {
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || n == 0 {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid user_id"})
+		}
+		filter.UserID = uint(n)
+	}
+
+	page := 1
+	if value := c.QueryParam("page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid page"})
+		}
+		page = n
+	}
+	if value := c.QueryParam("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxOrderPageSize {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid limit"})
+		}
+		filter.Limit = n
+	}
+	filter.Offset = (page - 1) * filter.Limit
+
+	orders, total, err := h.orderRepo.List(c.Request().Context(), filter)
+	if err != nil {
+		return internalError(c, "Failed to list orders", err)
+	}
+
+	return c.JSON(http.StatusOK, model.AdminOrdersResponse{Orders: orders, Total: total})
+}
+
+// GetAdminOrder godoc
+// @Summary Get an order
+// @Description Get any customer's order with its items
+// @Tags orders
+// @Produce json
+// @Param id path int true "Order ID"
+// @Success 200 {object} model.OrderDetail
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/orders/{id} [get]
+func (h *OrderHandler) GetAdminOrder(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid order ID"})
+	}
+
+	order, err := h.orderRepo.FindByID(c.Request().Context(), uint(id))
+	if err != nil {
+		if err.Error() == "order not found" {
+			return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Order not found"})
+		}
+		return internalError(c, "Failed to get order", err)
+	}
+	items, err := h.orderRepo.GetOrderItems(c.Request().Context(), order.ID)
+	if err != nil {
+		return internalError(c, "Failed to get order", err)
+	}
+	if items == nil {
+		items = []model.OrderItemDetail{}
+	}
+
+	return c.JSON(http.StatusOK, model.OrderDetail{Order: *order, Items: items})
+}
+
+// RefundOrder godoc
+// @Summary Refund an order
+// @Description Mark a paid, shipped or delivered order as refunded. A paid order's items go back into stock; shipped goods are not restocked. Publishes an order.status_changed event.
+// @Tags orders
+// @Produce json
+// @Param id path int true "Order ID"
+// @Success 200 {object} model.Order
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 409 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /admin/orders/{id}/refund [post]
+func (h *OrderHandler) RefundOrder(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid order ID"})
+	}
+
+	order, err := h.orderRepo.Refund(audit.Context(c), uint(id))
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidStatusTransition) {
+			return c.JSON(http.StatusConflict, model.ErrorResponse{Error: "Order cannot be refunded: " + err.Error()})
+		}
+		if err.Error() == "order not found" {
+			return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Order not found"})
+		}
+		return internalError(c, "Failed to refund order", err)
+	}
+
+	return c.JSON(http.StatusOK, order)
+}
@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+)
+
+type PriceHandler struct {
+	productRepo repository.ProductRepository
+	priceRepo   repository.PriceRepository
+}
+
+func NewPriceHandler(productRepo repository.ProductRepository, priceRepo repository.PriceRepository) *PriceHandler {
+	return &PriceHandler{
+		productRepo: productRepo,
+		priceRepo:   priceRepo,
+	}
+}
+
+// GetPriceHistory godoc
+// @Summary Get product price history
+// @Description Get every price change of a product, newest first, including sale prices applied by schedules
+// @Tags products
+// @Produce json
+// @Param id path int true "Product ID"
+// @Success 200 {object} model.PriceHistoryResponse
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /products/{id}/price-history [get]
+func (h *PriceHandler) GetPriceHistory(c echo.Context) error {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid product ID"})
+	}
+
+	exists, err := h.productRepo.ExistsByID(c.Request().Context(), productID)
+	if err != nil {
+		return internalError(c, "Database error", err)
+	}
+	if !exists {
+		return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Product not found"})
+	}
+
+	history, err := h.priceRepo.FindHistory(c.Request().Context(), productID)
+	if err != nil {
+		return internalError(c, "Failed to get price history", err)
+	}
+
+	return c.JSON(http.StatusOK, model.PriceHistoryResponse{History: history})
+}
+
+// GetPriceSchedules godoc
+// @Summary Get product price schedules
+// @Description Get the scheduled sale prices of a product, latest start first
+// @Tags products
+// @Produce json
+// @Param id path int true "Product ID"
+// @Success 200 {object} model.PriceSchedulesResponse
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /products/{id}/price-schedules [get]
+func (h *PriceHandler) GetPriceSchedules(c echo.Context) error {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid product ID"})
+	}
+
+	exists, err := h.productRepo.ExistsByID(c.Request().Context(), productID)
+	if err != nil {
+		return internalError(c, "Database error", err)
+	}
+	if !exists {
+		return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Product not found"})
+	}
+
+	schedules, err := h.priceRepo.FindSchedules(c.Request().Context(), productID)
+	if err != nil {
+		return internalError(c, "Failed to get price schedules", err)
+	}
+
+	return c.JSON(http.StatusOK, model.PriceSchedulesResponse{Schedules: schedules})
+}
+
+// CreatePriceSchedule godoc
+// @Summary Schedule a sale price
+// @Description Schedule a sale price for a time window (RFC 3339 timestamps). The sale price applies while the window is open and the base price is restored afterwards.
+// @Tags products
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param schedule body model.PriceScheduleRequest true "Sale price and window"
+// @Success 201 {object} model.PriceSchedule
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 409 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /products/{id}/price-schedules [post]
+func (h *PriceHandler) CreatePriceSchedule(c echo.Context) error {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid product ID"})
+	}
+
+	var req model.PriceScheduleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request format"})
+	}
+
+	if req.SalePrice <= 0 {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Sale price must be greater than 0"})
+	}
+	if req.StartsAt.IsZero() || req.EndsAt.IsZero() {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "starts_at and ends_at are required"})
+	}
+	if !req.EndsAt.After(req.StartsAt) {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "ends_at must be after starts_at"})
+	}
+	if !req.EndsAt.After(time.Now()) {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "ends_at must be in the future"})
+	}
+
+	schedule, err := h.priceRepo.CreateSchedule(c.Request().Context(), productID, &req, c.Get("user_id").(uint))
+	if err != nil {
+		if errors.Is(err, repository.ErrScheduleOverlap) {
+			return c.JSON(http.StatusConflict, model.ErrorResponse{Error: "Price schedule overlaps an existing schedule"})
+		}
+		if err.Error() == "product not found" {
+			return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Product not found"})
+		}
+		return internalError(c, "Failed to create price schedule", err)
+	}
+
+	return c.JSON(http.StatusCreated, schedule)
+}
+
+// CancelPriceSchedule godoc
+// @Summary Cancel a price schedule
+// @Description Cancel a scheduled or active sale price; an active sale reverts to the base price immediately
+// @Tags products
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param scheduleId path int true "Schedule ID"
+// @Success 200 {object} model.PriceSchedule
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 409 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /products/{id}/price-schedules/{scheduleId} [delete]
+func (h *PriceHandler) CancelPriceSchedule(c echo.Context) error {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid product ID"})
+	}
+	scheduleID, err := strconv.Atoi(c.Param("scheduleId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid schedule ID"})
+	}
+
+	schedule, err := h.priceRepo.CancelSchedule(c.Request().Context(), productID, scheduleID, c.Get("user_id").(uint))
+	if err != nil {
+		if err.Error() == "price schedule not found" {
+			return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Price schedule not found"})
+		}
+		if strings.HasPrefix(err.Error(), "price schedule already ") {
+			return c.JSON(http.StatusConflict, model.ErrorResponse{Error: "Price schedule has already " + strings.TrimPrefix(err.Error(), "price schedule already ")})
+		}
+		return internalError(c, "Failed to cancel price schedule", err)
+	}
+
+	return c.JSON(http.StatusOK, schedule)
+}
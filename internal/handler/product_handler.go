@@ -1,22 +1,30 @@
 package handler
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 
+	"test-ordent/internal/audit"
 	"test-ordent/internal/model"
 	"test-ordent/internal/repository"
 )
 
 type ProductHandler struct {
 	productRepo repository.ProductRepository
+	imageRepo   repository.ProductImageRepository
 }
 
-func NewProductHandler(productRepo repository.ProductRepository) *ProductHandler {
+func NewProductHandler(productRepo repository.ProductRepository, imageRepo repository.ProductImageRepository) *ProductHandler {
 	return &ProductHandler{
 		productRepo: productRepo,
+		imageRepo:   imageRepo,
 	}
 }
 
@@ -32,9 +40,9 @@ func NewProductHandler(productRepo repository.ProductRepository) *ProductHandler
 // @Success 200 {object} model.ProductsResponse
 // @Router /products [get]
 func (h *ProductHandler) GetProducts(c echo.Context) error {
-	products, err := h.productRepo.FindAll()
+	products, err := h.productRepo.FindAll(c.Request().Context())
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Database error"})
+		return internalError(c, "Database error", err)
 	}
 
 	return c.JSON(http.StatusOK, model.ProductsResponse{
@@ -51,6 +59,8 @@ func (h *ProductHandler) GetProducts(c echo.Context) error {
 // @Produce json
 // @Param id path int true "Product ID"
 // @Success 200 {object} model.ProductResponse
+// @Header 200 {string} ETag "Current product version, send it back in If-Match when updating"
+// @Success 304 "Not modified"
 // @Failure 404 {object} model.ErrorResponse
 // @Failure 500 {object} model.ErrorResponse
 // @Router /products/{id} [get]
@@ -60,11 +70,22 @@ func (h *ProductHandler) GetProduct(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid product ID"})
 	}
 
-	product, err := h.productRepo.FindByID(id)
+	product, err := h.productRepo.FindByID(c.Request().Context(), id)
 	if err != nil {
 		return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Product not found"})
 	}
 
+	etag := productETag(product.Version)
+	c.Response().Header().Set("ETag", etag)
+	if c.Request().Header.Get("If-None-Match") == etag {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	product.Images, err = h.imageRepo.FindByProductID(c.Request().Context(), id)
+	if err != nil {
+		return internalError(c, "Database error", err)
+	}
+
 	return c.JSON(http.StatusOK, product)
 }
 
@@ -79,6 +100,7 @@ func (h *ProductHandler) GetProduct(c echo.Context) error {
 // @Failure 400 {object} model.ErrorResponse
 // @Failure 500 {object} model.ErrorResponse
 // @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /products [post]
 func (h *ProductHandler) CreateProduct(c echo.Context) error {
     var req model.ProductRequest
@@ -98,9 +120,9 @@ func (h *ProductHandler) CreateProduct(c echo.Context) error {
         return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Stock cannot be negative"})
     }
 
-    product, err := h.productRepo.Create(&req)
+    product, err := h.productRepo.Create(audit.Context(c), &req, c.Get("user_id").(uint))
     if err != nil {
-        return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to create product"})
+        return internalError(c, "Failed to create product", err)
     }
 
     return c.JSON(http.StatusCreated, product)
@@ -108,17 +130,21 @@ func (h *ProductHandler) CreateProduct(c echo.Context) error {
 
 // UpdateProduct godoc
 // @Summary Update a product
-// @Description Update an existing product
+// @Description Replace every field of an existing product. The If-Match header must carry the ETag from a previous read.
 // @Tags products
 // @Accept json
 // @Produce json
 // @Param id path int true "Product ID"
+// @Param If-Match header string true "ETag of the product version being replaced"
 // @Param product body model.ProductRequest true "Product data"
 // @Success 200 {object} model.ProductResponse
 // @Failure 400 {object} model.ErrorResponse
 // @Failure 404 {object} model.ErrorResponse
+// @Failure 412 {object} model.ErrorResponse
+// @Failure 428 {object} model.ErrorResponse
 // @Failure 500 {object} model.ErrorResponse
 // @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /products/{id} [put]
 func (h *ProductHandler) UpdateProduct(c echo.Context) error {
 	id, err := strconv.Atoi(c.Param("id"))
@@ -126,40 +152,112 @@ func (h *ProductHandler) UpdateProduct(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid product ID"})
 	}
 
+	version, ok, err := ifMatchVersion(c)
+	if !ok {
+		return err
+	}
+
 	var req model.ProductRequest
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request"})
 	}
 
-	exists, err := h.productRepo.ExistsByID(id)
+	if req.Name == "" {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Product name is required"})
+	}
+
+	if req.Price <= 0 {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Price must be greater than 0"})
+	}
+
+	if req.Stock < 0 {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Stock cannot be negative"})
+	}
+
+	if req.CategoryID == 0 {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Category ID is required, use PATCH to change individual fields"})
+	}
+
+	product, err := h.productRepo.Update(audit.Context(c), id, version, &req, c.Get("user_id").(uint))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Database error"})
+		return productWriteError(c, err, "Failed to update product")
 	}
 
-	if !exists {
-		return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Product not found"})
+	c.Response().Header().Set("ETag", productETag(product.Version))
+	return c.JSON(http.StatusOK, product)
+}
+
+// PatchProduct godoc
+// @Summary Partially update a product
+// @Description Update only the fields present in the body (JSON merge patch, RFC 7396). The If-Match header must carry the ETag from a previous read.
+// @Tags products
+// @Accept json
+// @Accept application/merge-patch+json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param If-Match header string true "ETag of the product version being patched"
+// @Param product body object true "Fields to change"
+// @Success 200 {object} model.ProductResponse
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 412 {object} model.ErrorResponse
+// @Failure 415 {object} model.ErrorResponse
+// @Failure 428 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /products/{id} [patch]
+func (h *ProductHandler) PatchProduct(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid product ID"})
 	}
 
-	product, err := h.productRepo.Update(id, &req)
+	version, ok, err := ifMatchVersion(c)
+	if !ok {
+		return err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if mediaType != "application/merge-patch+json" && mediaType != echo.MIMEApplicationJSON {
+		return c.JSON(http.StatusUnsupportedMediaType, model.ErrorResponse{Error: "Content-Type must be application/merge-patch+json"})
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(c.Request().Body).Decode(&fields); err != nil || fields == nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Request body must be a JSON object"})
+	}
+
+	patch, err := parseProductPatch(fields)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to update product"})
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: err.Error()})
 	}
 
+	product, err := h.productRepo.Patch(audit.Context(c), id, version, patch, c.Get("user_id").(uint))
+	if err != nil {
+		return productWriteError(c, err, "Failed to update product")
+	}
+
+	c.Response().Header().Set("ETag", productETag(product.Version))
 	return c.JSON(http.StatusOK, product)
 }
 
 // DeleteProduct godoc
 // @Summary Delete a product
-// @Description Delete an existing product
+// @Description Archive an existing product. It disappears from listings and carts but stays visible in past orders and can be restored. The If-Match header must carry the ETag from a previous read.
 // @Tags products
 // @Accept json
 // @Produce json
 // @Param id path int true "Product ID"
+// @Param If-Match header string true "ETag of the product version being archived"
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} model.ErrorResponse
 // @Failure 404 {object} model.ErrorResponse
+// @Failure 412 {object} model.ErrorResponse
+// @Failure 428 {object} model.ErrorResponse
 // @Failure 500 {object} model.ErrorResponse
 // @Security BearerAuth
+// @Security APIKeyAuth
 // @Router /products/{id} [delete]
 func (h *ProductHandler) DeleteProduct(c echo.Context) error {
 	id, err := strconv.Atoi(c.Param("id"))
@@ -167,13 +265,165 @@ func (h *ProductHandler) DeleteProduct(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid product ID"})
 	}
 
-	err = h.productRepo.Delete(id)
+	version, ok, err := ifMatchVersion(c)
+	if !ok {
+		return err
+	}
+
+	if err := h.productRepo.Delete(audit.Context(c), id, version); err != nil {
+		return productWriteError(c, err, "Failed to delete product")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Product deleted successfully"})
+}
+
+// GetArchivedProducts godoc
+// @Summary Get archived products
+// @Description Get list of deleted (archived) products that can be restored
+// @Tags products
+// @Accept json
+// @Produce json
+// @Success 200 {object} model.ProductsResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /products/archived [get]
+func (h *ProductHandler) GetArchivedProducts(c echo.Context) error {
+	products, err := h.productRepo.FindArchived(c.Request().Context())
+	if err != nil {
+		return internalError(c, "Database error", err)
+	}
+
+	return c.JSON(http.StatusOK, model.ProductsResponse{
+		Products: products,
+		Total:    len(products),
+	})
+}
+
+// RestoreProduct godoc
+// @Summary Restore an archived product
+// @Description Make a deleted (archived) product available again. The If-Match header must carry the version listed in /products/archived, quoted like an ETag.
+// @Tags products
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param If-Match header string true "ETag of the archived product version"
+// @Success 200 {object} model.ProductResponse
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 412 {object} model.ErrorResponse
+// @Failure 428 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /products/{id}/restore [post]
+func (h *ProductHandler) RestoreProduct(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid product ID"})
+	}
+
+	version, ok, err := ifMatchVersion(c)
+	if !ok {
+		return err
+	}
+
+	product, err := h.productRepo.Restore(audit.Context(c), id, version)
 	if err != nil {
-		if err.Error() == "product not found" {
-			return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Product not found"})
+		if err.Error() == "archived product not found" {
+			return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Archived product not found"})
 		}
-		return c.JSON(http.StatusInternalServerError, model.ErrorResponse{Error: "Failed to delete product"})
+		return productWriteError(c, err, "Failed to restore product")
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{"message": "Product deleted successfully"})
-}
\ No newline at end of file
+	c.Response().Header().Set("ETag", productETag(product.Version))
+	return c.JSON(http.StatusOK, product)
+}
+
+func productETag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// ifMatchVersion returns the product version required by the If-Match
+// header. When the header is missing or malformed it answers the request
+// with 428 or 412 and returns false.
+func ifMatchVersion(c echo.Context) (int, bool, error) {
+	ifMatch := c.Request().Header.Get("If-Match")
+	if ifMatch == "" {
+		return 0, false, c.JSON(http.StatusPreconditionRequired, model.ErrorResponse{Error: "If-Match header with the product ETag is required"})
+	}
+	version, ok := parseIfMatch(ifMatch)
+	if !ok {
+		return 0, false, c.JSON(http.StatusPreconditionFailed, model.ErrorResponse{Error: "If-Match does not match the current product version"})
+	}
+	return version, true, nil
+}
+
+// parseIfMatch extracts the product version from an If-Match value. "*"
+// matches any version and is returned as 0.
+func parseIfMatch(header string) (int, bool) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return 0, true
+	}
+
+	version, err := strconv.Atoi(strings.Trim(header, `"`))
+	if err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+func productWriteError(c echo.Context, err error, message string) error {
+	switch err.Error() {
+	case "product not found":
+		return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Product not found"})
+	case "product version mismatch":
+		return c.JSON(http.StatusPreconditionFailed, model.ErrorResponse{Error: "Product was modified by someone else, reload it and try again"})
+	}
+	return internalError(c, message, err)
+}
+
+func parseProductPatch(fields map[string]json.RawMessage) (*model.ProductPatch, error) {
+	patch := &model.ProductPatch{}
+	for name, raw := range fields {
+		isNull := string(raw) == "null"
+		switch name {
+		case "sku":
+			if isNull || json.Unmarshal(raw, &patch.SKU) != nil || *patch.SKU == "" {
+				return nil, errors.New("SKU must be a non-empty string")
+			}
+		case "name":
+			if isNull || json.Unmarshal(raw, &patch.Name) != nil || *patch.Name == "" {
+				return nil, errors.New("Product name must be a non-empty string")
+			}
+		case "description":
+			if isNull {
+				patch.Description = new(string)
+			} else if json.Unmarshal(raw, &patch.Description) != nil {
+				return nil, errors.New("Description must be a string")
+			}
+		case "price":
+			if isNull || json.Unmarshal(raw, &patch.Price) != nil || *patch.Price <= 0 {
+				return nil, errors.New("Price must be greater than 0")
+			}
+		case "stock":
+			if isNull || json.Unmarshal(raw, &patch.Stock) != nil || *patch.Stock < 0 {
+				return nil, errors.New("Stock must be a non-negative integer")
+			}
+		case "category_id":
+			if isNull || json.Unmarshal(raw, &patch.CategoryID) != nil || *patch.CategoryID == 0 {
+				return nil, errors.New("Category ID must be a positive integer")
+			}
+		case "image_url":
+			if isNull {
+				patch.ImageURL = new(string)
+			} else if json.Unmarshal(raw, &patch.ImageURL) != nil {
+				return nil, errors.New("Image URL must be a string")
+			}
+		default:
+			return nil, fmt.Errorf("Unknown or read-only field: %s", name)
+		}
+	}
+	return patch, nil
+}
@@ -0,0 +1,318 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+	"test-ordent/internal/storage"
+	"test-ordent/pkg/imaging"
+)
+
+type ProductImageHandler struct {
+	productRepo    repository.ProductRepository
+	imageRepo      repository.ProductImageRepository
+	storage        storage.Storage
+	maxUploadSize  int64
+	maxDimension   int
+	maxPixels      int64
+	thumbnailSizes map[string]int
+}
+
+func NewProductImageHandler(productRepo repository.ProductRepository, imageRepo repository.ProductImageRepository, store storage.Storage, maxUploadSize int64, maxDimension int, maxPixels int64, thumbnailSizes map[string]int) *ProductImageHandler {
+	return &ProductImageHandler{
+		productRepo:    productRepo,
+		imageRepo:      imageRepo,
+		storage:        store,
+		maxUploadSize:  maxUploadSize,
+		maxDimension:   maxDimension,
+		maxPixels:      maxPixels,
+		thumbnailSizes: thumbnailSizes,
+	}
+}
+
+// GetImages godoc
+// @Summary Get product images
+// @Description Get the ordered list of images of a product
+// @Tags products
+// @Produce json
+// @Param id path int true "Product ID"
+// @Success 200 {object} model.ProductImagesResponse
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Router /products/{id}/images [get]
+func (h *ProductImageHandler) GetImages(c echo.Context) error {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid product ID"})
+	}
+
+	exists, err := h.productRepo.ExistsByID(c.Request().Context(), productID)
+	if err != nil {
+		return internalError(c, "Database error", err)
+	}
+	if !exists {
+		return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Product not found"})
+	}
+
+	images, err := h.imageRepo.FindByProductID(c.Request().Context(), productID)
+	if err != nil {
+		return internalError(c, "Database error", err)
+	}
+
+	return c.JSON(http.StatusOK, model.ProductImagesResponse{Images: images})
+}
+
+// UploadImage godoc
+// @Summary Upload a product image
+// @Description Upload an image (JPEG, PNG, GIF or WebP) for a product; thumbnails are generated automatically
+// @Tags products
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param image formData file true "Image file"
+// @Success 201 {object} model.ProductImage
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 413 {object} model.ErrorResponse
+// @Failure 415 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /products/{id}/images [post]
+func (h *ProductImageHandler) UploadImage(c echo.Context) error {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid product ID"})
+	}
+
+	exists, err := h.productRepo.ExistsByID(c.Request().Context(), productID)
+	if err != nil {
+		return internalError(c, "Database error", err)
+	}
+	if !exists {
+		return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Product not found"})
+	}
+
+	// Cap the whole request body so an oversized upload is rejected while
+	// being read instead of after it has been buffered to disk.
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, h.maxUploadSize+1<<20)
+
+	fileHeader, err := c.FormFile("image")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return c.JSON(http.StatusRequestEntityTooLarge, model.ErrorResponse{Error: h.tooLargeMessage()})
+		}
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Image file is required"})
+	}
+	if fileHeader.Size > h.maxUploadSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, model.ErrorResponse{Error: h.tooLargeMessage()})
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Failed to read image"})
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, h.maxUploadSize+1))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Failed to read image"})
+	}
+	if int64(len(data)) > h.maxUploadSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, model.ErrorResponse{Error: h.tooLargeMessage()})
+	}
+
+	contentType, err := imaging.DetectContentType(data)
+	if err != nil {
+		return c.JSON(http.StatusUnsupportedMediaType, model.ErrorResponse{Error: "Unsupported image type: " + contentType})
+	}
+
+	img, err := imaging.Decode(data, h.maxDimension, h.maxPixels)
+	if errors.Is(err, imaging.ErrTooManyPixels) {
+		return c.JSON(http.StatusRequestEntityTooLarge, model.ErrorResponse{Error: fmt.Sprintf("Image exceeds the maximum of %d pixels per side and %d pixels in total", h.maxDimension, h.maxPixels)})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid image file"})
+	}
+
+	ctx := c.Request().Context()
+	prefix := fmt.Sprintf("products/%d/%s", productID, randomHex(8))
+	var storedKeys []string
+	cleanup := func() {
+		for _, key := range storedKeys {
+			h.storage.Delete(context.Background(), key)
+		}
+	}
+
+	originalKey := prefix + "/original" + imaging.Extension(contentType)
+	if err := h.storage.Put(ctx, originalKey, data, contentType); err != nil {
+		return internalError(c, "Failed to store image", err)
+	}
+	storedKeys = append(storedKeys, originalKey)
+
+	bounds := img.Bounds()
+	image := &model.ProductImage{
+		ProductID:   productID,
+		URL:         h.storage.URL(originalKey),
+		StorageKey:  originalKey,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+		Thumbnails:  make(map[string]model.ProductImageVariant, len(h.thumbnailSizes)),
+	}
+
+	for name, size := range h.thumbnailSizes {
+		thumb := imaging.Thumbnail(img, size)
+		encoded, thumbType, err := imaging.Encode(thumb, contentType)
+		if err != nil {
+			cleanup()
+			return internalError(c, "Failed to generate thumbnail", err)
+		}
+
+		key := prefix + "/" + name + imaging.Extension(thumbType)
+		if err := h.storage.Put(ctx, key, encoded, thumbType); err != nil {
+			cleanup()
+			return internalError(c, "Failed to store image", err)
+		}
+		storedKeys = append(storedKeys, key)
+
+		thumbBounds := thumb.Bounds()
+		image.Thumbnails[name] = model.ProductImageVariant{
+			URL:        h.storage.URL(key),
+			StorageKey: key,
+			Width:      thumbBounds.Dx(),
+			Height:     thumbBounds.Dy(),
+		}
+	}
+
+	created, err := h.imageRepo.Create(ctx, image)
+	if err != nil {
+		cleanup()
+		return internalError(c, "Failed to save image", err)
+	}
+
+	return c.JSON(http.StatusCreated, created)
+}
+
+// ReorderImages godoc
+// @Summary Reorder product images
+// @Description Set the display order of a product's images; the first image becomes the product's image_url
+// @Tags products
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param order body model.ReorderProductImagesRequest true "Image IDs in the new order"
+// @Success 200 {object} model.ProductImagesResponse
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /products/{id}/images/order [put]
+func (h *ProductImageHandler) ReorderImages(c echo.Context) error {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid product ID"})
+	}
+
+	var req model.ReorderProductImagesRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request"})
+	}
+
+	exists, err := h.productRepo.ExistsByID(c.Request().Context(), productID)
+	if err != nil {
+		return internalError(c, "Database error", err)
+	}
+	if !exists {
+		return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Product not found"})
+	}
+
+	if err := h.imageRepo.Reorder(c.Request().Context(), productID, req.ImageIDs); err != nil {
+		if err.Error() == "image list does not match product images" {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Image IDs must list every image of the product exactly once"})
+		}
+		return internalError(c, "Failed to reorder images", err)
+	}
+
+	return h.GetImages(c)
+}
+
+// DeleteImage godoc
+// @Summary Delete a product image
+// @Description Delete a product image together with its thumbnails
+// @Tags products
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param imageId path int true "Image ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Security APIKeyAuth
+// @Router /products/{id}/images/{imageId} [delete]
+func (h *ProductImageHandler) DeleteImage(c echo.Context) error {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid product ID"})
+	}
+
+	imageID, err := strconv.Atoi(c.Param("imageId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid image ID"})
+	}
+
+	image, err := h.imageRepo.FindByID(c.Request().Context(), imageID)
+	if err != nil {
+		if err.Error() == "product image not found" {
+			return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Image not found"})
+		}
+		return internalError(c, "Database error", err)
+	}
+	if image.ProductID != productID {
+		return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Image not found"})
+	}
+
+	if err := h.imageRepo.Delete(c.Request().Context(), imageID); err != nil {
+		if err.Error() == "product image not found" {
+			return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Image not found"})
+		}
+		return internalError(c, "Failed to delete image", err)
+	}
+
+	// The row is gone at this point; a file that fails to delete is only
+	// orphaned storage, so it does not fail the request.
+	ctx := c.Request().Context()
+	h.storage.Delete(ctx, image.StorageKey)
+	for _, variant := range image.Thumbnails {
+		h.storage.Delete(ctx, variant.StorageKey)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Image deleted successfully"})
+}
+
+func (h *ProductImageHandler) tooLargeMessage() string {
+	return fmt.Sprintf("Image exceeds the maximum size of %d bytes", h.maxUploadSize)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
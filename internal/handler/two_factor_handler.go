@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+
+	"test-ordent/internal/account"
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+)
+
+type TwoFactorHandler struct {
+	userRepo  repository.UserRepository
+	twoFactor *account.TwoFactor
+}
+
+func NewTwoFactorHandler(userRepo repository.UserRepository, twoFactor *account.TwoFactor) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		userRepo:  userRepo,
+		twoFactor: twoFactor,
+	}
+}
+
+// GetTwoFactor godoc
+// @Summary Get two-factor status
+// @Description Show whether two-factor authentication is enabled, whether the user's role requires it and how many recovery codes are left
+// @Tags users
+// @Produce json
+// @Success 200 {object} model.TwoFactorStatusResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /users/me/2fa [get]
+func (h *TwoFactorHandler) GetTwoFactor(c echo.Context) error {
+	user, err := h.userRepo.FindByID(c.Request().Context(), c.Get("user_id").(uint))
+	if err != nil {
+		return internalError(c, "Database error", err)
+	}
+
+	status, err := h.twoFactor.Status(c.Request().Context(), user)
+	if err != nil {
+		return internalError(c, "Database error", err)
+	}
+
+	return c.JSON(http.StatusOK, status)
+}
+
+// EnrollTwoFactor godoc
+// @Summary Start two-factor enrolment
+// @Description Generate a TOTP secret and return it with an otpauth URI for authenticator apps. Two-factor authentication is turned on once a code is confirmed at /users/me/2fa/activate.
+// @Tags users
+// @Produce json
+// @Success 200 {object} model.TwoFactorEnrollResponse
+// @Failure 409 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /users/me/2fa/enroll [post]
+func (h *TwoFactorHandler) EnrollTwoFactor(c echo.Context) error {
+	user, err := h.userRepo.FindByID(c.Request().Context(), c.Get("user_id").(uint))
+	if err != nil {
+		return internalError(c, "Database error", err)
+	}
+
+	enrolment, err := h.twoFactor.Enroll(c.Request().Context(), user)
+	if err != nil {
+		if err == account.ErrTwoFactorEnabled {
+			return c.JSON(http.StatusConflict, model.ErrorResponse{Error: "Two-factor authentication is already enabled"})
+		}
+		return internalError(c, "Failed to start enrolment", err)
+	}
+
+	return c.JSON(http.StatusOK, enrolment)
+}
+
+// ActivateTwoFactor godoc
+// @Summary Activate two-factor authentication
+// @Description Confirm the enrolled secret with a code from the authenticator app. Returns the recovery codes, which are shown only this once.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body model.TwoFactorCodeRequest true "Current TOTP code"
+// @Success 200 {object} model.RecoveryCodesResponse
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 409 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /users/me/2fa/activate [post]
+func (h *TwoFactorHandler) ActivateTwoFactor(c echo.Context) error {
+	var req model.TwoFactorCodeRequest
+	if err := c.Bind(&req); err != nil || req.Code == "" {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Code is required"})
+	}
+
+	codes, err := h.twoFactor.Activate(c.Request().Context(), c.Get("user_id").(uint), req.Code)
+	if err != nil {
+		switch err {
+		case account.ErrInvalidCode:
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid code"})
+		case account.ErrTwoFactorNotEnrolled:
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Start enrolment first"})
+		case account.ErrTwoFactorEnabled:
+			return c.JSON(http.StatusConflict, model.ErrorResponse{Error: "Two-factor authentication is already enabled"})
+		}
+		return internalError(c, "Failed to activate two-factor authentication", err)
+	}
+
+	return c.JSON(http.StatusOK, model.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Regenerate recovery codes
+// @Description Replace all recovery codes with a new set. Requires a current TOTP code.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body model.TwoFactorCodeRequest true "Current TOTP code"
+// @Success 200 {object} model.RecoveryCodesResponse
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /users/me/2fa/recovery-codes [post]
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c echo.Context) error {
+	var req model.TwoFactorCodeRequest
+	if err := c.Bind(&req); err != nil || req.Code == "" {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Code is required"})
+	}
+
+	codes, err := h.twoFactor.RegenerateRecoveryCodes(c.Request().Context(), c.Get("user_id").(uint), req.Code)
+	if err != nil {
+		switch err {
+		case account.ErrInvalidCode:
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid code"})
+		case account.ErrTwoFactorNotEnabled:
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Two-factor authentication is not enabled"})
+		}
+		return internalError(c, "Failed to regenerate recovery codes", err)
+	}
+
+	return c.JSON(http.StatusOK, model.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTwoFactor godoc
+// @Summary Disable two-factor authentication
+// @Description Turn two-factor authentication off. Requires the password and a current TOTP code, and is refused for roles that require two-factor authentication.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param request body model.DisableTwoFactorRequest true "Password and TOTP code"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 401 {object} model.ErrorResponse
+// @Failure 403 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /users/me/2fa [delete]
+func (h *TwoFactorHandler) DisableTwoFactor(c echo.Context) error {
+	var req model.DisableTwoFactorRequest
+	if err := c.Bind(&req); err != nil || req.Code == "" {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Password and code are required"})
+	}
+
+	user, err := h.userRepo.FindByID(c.Request().Context(), c.Get("user_id").(uint))
+	if err != nil {
+		return internalError(c, "Database error", err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		return c.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: "Password is incorrect"})
+	}
+
+	if err := h.twoFactor.Disable(c.Request().Context(), user, req.Code); err != nil {
+		switch err {
+		case account.ErrTwoFactorRequired:
+			return c.JSON(http.StatusForbidden, model.ErrorResponse{Error: "Two-factor authentication is required for your role"})
+		case account.ErrInvalidCode:
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid code"})
+		case account.ErrTwoFactorNotEnabled:
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Two-factor authentication is not enabled"})
+		}
+		return internalError(c, "Failed to disable two-factor authentication", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+// ResetUserTwoFactor godoc
+// @Summary Reset a user's two-factor authentication
+// @Description Turn two-factor authentication off for a user who lost their authenticator and recovery codes. If their role requires it, they have to enrol again before using its permissions.
+// @Tags users
+// @Produce json
+// @Param id path int true "User ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/users/{id}/2fa [delete]
+func (h *TwoFactorHandler) ResetUserTwoFactor(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid user ID"})
+	}
+	if uint(id) == c.Get("user_id").(uint) {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Use /users/me/2fa to manage your own two-factor authentication"})
+	}
+
+	if err := h.twoFactor.Reset(c.Request().Context(), uint(id)); err != nil {
+		if err == account.ErrTwoFactorNotEnabled {
+			return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Two-factor authentication is not enabled for this user"})
+		}
+		return internalError(c, "Failed to reset two-factor authentication", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Two-factor authentication reset"})
+}
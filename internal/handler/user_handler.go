@@ -1 +1,398 @@
-package handler
\ No newline at end of file
+package handler
+
+import (
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+
+	"test-ordent/internal/account"
+	"test-ordent/internal/audit"
+	"test-ordent/internal/auth"
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+)
+
+const (
+	defaultUserPageSize = 20
+	maxUserPageSize     = 100
+)
+
+// recentLoginWindow is how old a login may be to stand in for the password
+// of an account that has none, such as one created through social login.
+const recentLoginWindow = 10 * time.Minute
+
+type UserHandler struct {
+	userRepo repository.UserRepository
+	accounts *account.Service
+}
+
+func NewUserHandler(userRepo repository.UserRepository, accounts *account.Service) *UserHandler {
+	return &UserHandler{
+		userRepo: userRepo,
+		accounts: accounts,
+	}
+}
+
+// GetMe godoc
+// @Summary Get own profile
+// @Description Get the profile of the logged in user, including the permissions granted by their role
+// @Tags users
+// @Produce json
+// @Success 200 {object} model.User
+// @Failure 404 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /users/me [get]
+func (h *UserHandler) GetMe(c echo.Context) error {
+	user, err := h.userRepo.FindByID(c.Request().Context(), c.Get("user_id").(uint))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "User not found"})
+	}
+	user.Permissions = auth.PermissionsFor(user.Role)
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// UpdateMe godoc
+// @Summary Update own profile
+// @Description Change full name and/or email. Changing the email requires the current password, or for accounts without one a login in the last 10 minutes. A new email address has to be verified again.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param profile body model.UpdateProfileRequest true "Fields to change"
+// @Success 200 {object} model.User
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 401 {object} model.ErrorResponse
+// @Failure 409 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /users/me [patch]
+func (h *UserHandler) UpdateMe(c echo.Context) error {
+	var req model.UpdateProfileRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request"})
+	}
+
+	if req.FullName != nil {
+		name := strings.TrimSpace(*req.FullName)
+		if name == "" {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Full name cannot be empty"})
+		}
+		req.FullName = &name
+	}
+	if req.Email != nil {
+		address, err := mail.ParseAddress(*req.Email)
+		if err != nil || address.Address != *req.Email {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid email address"})
+		}
+	}
+	if req.FullName == nil && req.Email == nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Nothing to update"})
+	}
+
+	userID := c.Get("user_id").(uint)
+	// The email address is where password resets go, so changing it takes
+	// the same confirmation as changing the password.
+	if req.Email != nil {
+		if message, err := h.confirmUser(c, userID, req.CurrentPassword, "Current password is incorrect"); err != nil {
+			return internalError(c, "Failed to update profile", err)
+		} else if message != "" {
+			return c.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: message})
+		}
+	}
+
+	user, err := h.userRepo.UpdateProfile(c.Request().Context(), userID, &req)
+	if err != nil {
+		switch err.Error() {
+		case "email already in use":
+			return c.JSON(http.StatusConflict, model.ErrorResponse{Error: "Email already in use"})
+		case "user not found":
+			return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "User not found"})
+		}
+		return internalError(c, "Failed to update profile", err)
+	}
+
+	if req.Email != nil && !user.EmailVerified {
+		if err := h.accounts.SendVerification(c.Request().Context(), user); err != nil {
+			c.Logger().Errorf("failed to send verification email to user %d: %v", user.ID, err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// ResendVerification godoc
+// @Summary Resend verification email
+// @Description Send a new verification link for the current email address; earlier links stop working
+// @Tags users
+// @Produce json
+// @Success 202 {object} map[string]string
+// @Failure 409 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /users/me/verify-email [post]
+func (h *UserHandler) ResendVerification(c echo.Context) error {
+	user, err := h.userRepo.FindByID(c.Request().Context(), c.Get("user_id").(uint))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "User not found"})
+	}
+	if user.EmailVerified {
+		return c.JSON(http.StatusConflict, model.ErrorResponse{Error: "Email address already verified"})
+	}
+
+	if err := h.accounts.SendVerification(c.Request().Context(), user); err != nil {
+		c.Logger().Errorf("failed to send verification email to user %d: %v", user.ID, err)
+		return internalError(c, "Failed to send verification email", err)
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]string{"message": "Verification email sent"})
+}
+
+// ChangePassword godoc
+// @Summary Change own password
+// @Description Change the password of the logged in user; the current password is required. Accounts without a password, created through social login, can set one with a token from a login in the last 10 minutes instead. Every token issued before the change, including the one used for it, stops working.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param password body model.ChangePasswordRequest true "Current and new password"
+// @Success 204
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 401 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /users/me/password [put]
+func (h *UserHandler) ChangePassword(c echo.Context) error {
+	var req model.ChangePasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request"})
+	}
+	if len(req.NewPassword) < 6 {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "New password must be at least 6 characters"})
+	}
+
+	userID := c.Get("user_id").(uint)
+	if message, err := h.confirmUser(c, userID, req.CurrentPassword, "Current password is incorrect"); err != nil {
+		return internalError(c, "Failed to change password", err)
+	} else if message != "" {
+		return c.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: message})
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return internalError(c, "Failed to hash password", err)
+	}
+
+	if err := h.userRepo.UpdatePassword(c.Request().Context(), userID, string(hashedPassword)); err != nil {
+		return internalError(c, "Failed to change password", err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// DeleteMe godoc
+// @Summary Delete own account
+// @Description Delete the account of the logged in user. Personal data is anonymised; orders are kept without it. Accounts without a password, created through social login, confirm with a token from a login in the last 10 minutes instead.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param account body model.DeleteAccountRequest true "Password confirmation"
+// @Success 204
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 401 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /users/me [delete]
+func (h *UserHandler) DeleteMe(c echo.Context) error {
+	var req model.DeleteAccountRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request"})
+	}
+
+	userID := c.Get("user_id").(uint)
+	if message, err := h.confirmUser(c, userID, req.Password, "Password is incorrect"); err != nil {
+		return internalError(c, "Failed to delete account", err)
+	} else if message != "" {
+		return c.JSON(http.StatusUnauthorized, model.ErrorResponse{Error: message})
+	}
+
+	if err := h.userRepo.Anonymize(audit.Context(c), userID); err != nil {
+		return internalError(c, "Failed to delete account", err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListUsers godoc
+// @Summary List users
+// @Description Search users by username, email or full name and filter by role and status
+// @Tags users
+// @Produce json
+// @Param q query string false "Search text"
+// @Param role query string false "Role name, see /admin/roles"
+// @Param status query string false "active, suspended or deleted"
+// @Param page query int false "Page number, starting at 1"
+// @Param limit query int false "Page size (max 100)"
+// @Success 200 {object} model.UsersResponse
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/users [get]
+func (h *UserHandler) ListUsers(c echo.Context) error {
+	filter := model.UserFilter{
+		Query:  strings.TrimSpace(c.QueryParam("q")),
+		Role:   c.QueryParam("role"),
+		Status: c.QueryParam("status"),
+		Limit:  defaultUserPageSize,
+	}
+
+	page := 1
+	if value := c.QueryParam("page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid page"})
+		}
+		page = n
+	}
+	if value := c.QueryParam("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxUserPageSize {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid limit"})
+		}
+		filter.Limit = n
+	}
+	filter.Offset = (page - 1) * filter.Limit
+
+	users, total, err := h.userRepo.List(c.Request().Context(), filter)
+	if err != nil {
+		return internalError(c, "Failed to list users", err)
+	}
+
+	return c.JSON(http.StatusOK, model.UsersResponse{Users: users, Total: total})
+}
+
+// UpdateUserStatus godoc
+// @Summary Suspend or reactivate a user
+// @Description Set a user's status to suspended or active. Suspended users cannot log in and their tokens stop working.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param status body model.UpdateUserStatusRequest true "New status"
+// @Success 200 {object} model.User
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/users/{id}/status [put]
+func (h *UserHandler) UpdateUserStatus(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid user ID"})
+	}
+
+	var req model.UpdateUserStatusRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request"})
+	}
+	if req.Status != model.UserStatusActive && req.Status != model.UserStatusSuspended {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Status must be active or suspended"})
+	}
+	if uint(id) == c.Get("user_id").(uint) {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "You cannot change your own status"})
+	}
+
+	user, err := h.userRepo.UpdateStatus(audit.Context(c), uint(id), req.Status)
+	if err != nil {
+		if err.Error() == "user not found" {
+			return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "User not found"})
+		}
+		return internalError(c, "Failed to update user status", err)
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// UpdateUserRole godoc
+// @Summary Change a user's role
+// @Description Assign one of the roles listed by /admin/roles to a user; takes effect on the user's next request
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param role body model.UpdateUserRoleRequest true "New role"
+// @Success 200 {object} model.User
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/users/{id}/role [put]
+func (h *UserHandler) UpdateUserRole(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid user ID"})
+	}
+
+	var req model.UpdateUserRoleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid request"})
+	}
+	if !auth.IsRole(req.Role) {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Role must be one of: " + strings.Join(auth.Roles(), ", ")})
+	}
+	if uint(id) == c.Get("user_id").(uint) {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "You cannot change your own role"})
+	}
+
+	user, err := h.userRepo.UpdateRole(audit.Context(c), uint(id), req.Role)
+	if err != nil {
+		if err.Error() == "user not found" {
+			return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "User not found"})
+		}
+		return internalError(c, "Failed to update user role", err)
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// ListRoles godoc
+// @Summary List roles
+// @Description List the roles that can be assigned to users and the permissions each one grants
+// @Tags users
+// @Produce json
+// @Success 200 {object} model.RolesResponse
+// @Security BearerAuth
+// @Router /admin/roles [get]
+func (h *UserHandler) ListRoles(c echo.Context) error {
+	roles := []model.Role{}
+	for _, name := range auth.Roles() {
+		roles = append(roles, model.Role{Name: name, Permissions: auth.PermissionsFor(name)})
+	}
+
+	return c.JSON(http.StatusOK, model.RolesResponse{Roles: roles, Permissions: auth.AllPermissions})
+}
+
+// confirmUser checks that the request comes from the account holder before a
+// sensitive change: with the password, or for accounts without one with a
+// recent login. It returns the message to reject the request with, if any,
+// which is wrongPassword for a password that does not match.
+func (h *UserHandler) confirmUser(c echo.Context, userID uint, password, wrongPassword string) (string, error) {
+	user, err := h.userRepo.FindByID(c.Request().Context(), userID)
+	if err != nil {
+		return "", err
+	}
+	if user.PasswordHash == "" {
+		issuedAt, ok := c.Get("token_issued_at").(time.Time)
+		if !ok || time.Since(issuedAt) > recentLoginWindow {
+			return "This account has no password, log in again to confirm it is you", nil
+		}
+		return "", nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return wrongPassword, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,401 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/internal/audit"
+	"test-ordent/internal/auth"
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+	"test-ordent/internal/webhook"
+)
+
+const (
+	defaultDeliveryPageSize = 50
+	maxDeliveryPageSize     = 200
+)
+
+type WebhookHandler struct {
+	webhookRepo repository.WebhookRepository
+	deliverer   *webhook.Deliverer
+	cipher      *auth.SecretCipher
+}
+
+func NewWebhookHandler(webhookRepo repository.WebhookRepository, deliverer *webhook.Deliverer, cipher *auth.SecretCipher) *WebhookHandler {
+	return &WebhookHandler{
+		webhookRepo: webhookRepo,
+		deliverer:   deliverer,
+		cipher:      cipher,
+	}
+}
+
+// CreateWebhook godoc
+// @Summary Create a webhook endpoint
+// @Description Register a partner URL that events of the given types are posted to, signed with a secret that is returned only this once. Use "*" to subscribe to every event type. URLs on loopback, private or link-local addresses are refused unless their network is listed in webhooks.allowed_networks.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param request body model.WebhookEndpointRequest true "Name, URL, event types and whether the endpoint is active"
+// @Success 201 {object} model.CreateWebhookEndpointResponse
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/webhooks [post]
+func (h *WebhookHandler) CreateWebhook(c echo.Context) error {
+	endpoint, message := bindWebhookEndpoint(c)
+	if message == "" {
+		message = h.checkWebhookURL(c, endpoint.URL)
+	}
+	if message != "" {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: message})
+	}
+
+	secret, err := webhook.GenerateSecret()
+	if err != nil {
+		return internalError(c, "Failed to create webhook", err)
+	}
+	endpoint.Secret, err = h.cipher.Encrypt(secret)
+	if err != nil {
+		return internalError(c, "Failed to create webhook", err)
+	}
+	createdBy := c.Get("user_id").(uint)
+	endpoint.CreatedBy = &createdBy
+
+	created, err := h.webhookRepo.CreateEndpoint(audit.Context(c), endpoint)
+	if err != nil {
+		return internalError(c, "Failed to create webhook", err)
+	}
+
+	return c.JSON(http.StatusCreated, model.CreateWebhookEndpointResponse{Endpoint: *created, Secret: secret})
+}
+
+// ListWebhooks godoc
+// @Summary List webhook endpoints
+// @Description List all webhook endpoints with the event types they subscribe to
+// @Tags webhooks
+// @Produce json
+// @Success 200 {object} model.WebhookEndpointsResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/webhooks [get]
+func (h *WebhookHandler) ListWebhooks(c echo.Context) error {
+	endpoints, err := h.webhookRepo.ListEndpoints(c.Request().Context())
+	if err != nil {
+		return internalError(c, "Failed to list webhooks", err)
+	}
+
+	return c.JSON(http.StatusOK, model.WebhookEndpointsResponse{Endpoints: endpoints})
+}
+
+// GetWebhook godoc
+// @Summary Get a webhook endpoint
+// @Tags webhooks
+// @Produce json
+// @Param id path int true "Webhook endpoint ID"
+// @Success 200 {object} model.WebhookEndpoint
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/webhooks/{id} [get]
+func (h *WebhookHandler) GetWebhook(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid webhook ID"})
+	}
+
+	endpoint, err := h.webhookRepo.FindEndpoint(c.Request().Context(), id)
+	if err != nil {
+		return webhookError(c, "Failed to get webhook", err)
+	}
+
+	return c.JSON(http.StatusOK, endpoint)
+}
+
+// UpdateWebhook godoc
+// @Summary Update a webhook endpoint
+// @Description Change an endpoint's name, URL, event types or active flag. Inactive endpoints get no new deliveries and their pending ones wait until they are active again. The secret stays the same. The URL is checked like on creation.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param id path int true "Webhook endpoint ID"
+// @Param request body model.WebhookEndpointRequest true "Name, URL, event types and whether the endpoint is active"
+// @Success 200 {object} model.WebhookEndpoint
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/webhooks/{id} [put]
+func (h *WebhookHandler) UpdateWebhook(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid webhook ID"})
+	}
+	endpoint, message := bindWebhookEndpoint(c)
+	if message == "" {
+		message = h.checkWebhookURL(c, endpoint.URL)
+	}
+	if message != "" {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: message})
+	}
+	endpoint.ID = id
+
+	updated, err := h.webhookRepo.UpdateEndpoint(audit.Context(c), endpoint)
+	if err != nil {
+		return webhookError(c, "Failed to update webhook", err)
+	}
+
+	return c.JSON(http.StatusOK, updated)
+}
+
+// DeleteWebhook godoc
+// @Summary Delete a webhook endpoint
+// @Description Delete an endpoint together with its delivery log
+// @Tags webhooks
+// @Produce json
+// @Param id path int true "Webhook endpoint ID"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/webhooks/{id} [delete]
+func (h *WebhookHandler) DeleteWebhook(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid webhook ID"})
+	}
+
+	if err := h.webhookRepo.DeleteEndpoint(audit.Context(c), id); err != nil {
+		return webhookError(c, "Failed to delete webhook", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Webhook deleted"})
+}
+
+// PingWebhook godoc
+// @Summary Send a test ping
+// @Description Send a signed webhook.ping event to the endpoint right away, even if it is inactive, and return the delivery with the endpoint's response. Pings are not retried.
+// @Tags webhooks
+// @Produce json
+// @Param id path int true "Webhook endpoint ID"
+// @Success 200 {object} model.WebhookDelivery
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/webhooks/{id}/ping [post]
+func (h *WebhookHandler) PingWebhook(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid webhook ID"})
+	}
+
+	delivery, err := h.deliverer.Ping(c.Request().Context(), id)
+	if err != nil {
+		return webhookError(c, "Failed to ping webhook", err)
+	}
+
+	return c.JSON(http.StatusOK, delivery)
+}
+
+// ListWebhookDeliveries godoc
+// @Summary List webhook deliveries
+// @Description List an endpoint's deliveries, newest first, with the status code and error of their last attempt
+// @Tags webhooks
+// @Produce json
+// @Param id path int true "Webhook endpoint ID"
+// @Param status query string false "pending, delivered or dead"
+// @Param page query int false "Page number, starting at 1"
+// @Param limit query int false "Page size (max 200)"
+// @Success 200 {object} model.WebhookDeliveriesResponse
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/webhooks/{id}/deliveries [get]
+func (h *WebhookHandler) ListWebhookDeliveries(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid webhook ID"})
+	}
+	filter := model.WebhookDeliveryFilter{EndpointID: id, Status: c.QueryParam("status"), Limit: defaultDeliveryPageSize}
+	switch filter.Status {
+	case "", model.WebhookDeliveryPending, model.WebhookDeliveryDelivered, model.WebhookDeliveryDead:
+	default:
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Status must be pending, delivered or dead"})
+	}
+
+	page := 1
+	if value := c.QueryParam("page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid page"})
+		}
+		page = n
+	}
+	if value := c.QueryParam("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxDeliveryPageSize {
+			return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid limit"})
+		}
+		filter.Limit = n
+	}
+	filter.Offset = (page - 1) * filter.Limit
+
+	ctx := c.Request().Context()
+	if _, err := h.webhookRepo.FindEndpoint(ctx, id); err != nil {
+		return webhookError(c, "Failed to list webhook deliveries", err)
+	}
+	deliveries, total, err := h.webhookRepo.ListDeliveries(ctx, filter)
+	if err != nil {
+		return internalError(c, "Failed to list webhook deliveries", err)
+	}
+
+	return c.JSON(http.StatusOK, model.WebhookDeliveriesResponse{Deliveries: deliveries, Total: total})
+}
+
+// GetWebhookDelivery godoc
+// @Summary Get a webhook delivery
+// @Description Get a delivery with its payload and every attempt: status code, response body, error and duration
+// @Tags webhooks
+// @Produce json
+// @Param id path int true "Webhook endpoint ID"
+// @Param deliveryId path int true "Delivery ID"
+// @Success 200 {object} model.WebhookDelivery
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/webhooks/{id}/deliveries/{deliveryId} [get]
+func (h *WebhookHandler) GetWebhookDelivery(c echo.Context) error {
+	id, deliveryID, ok := webhookDeliveryParams(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid webhook or delivery ID"})
+	}
+
+	delivery, err := h.webhookRepo.FindDelivery(c.Request().Context(), id, deliveryID)
+	if err != nil {
+		return webhookError(c, "Failed to get webhook delivery", err)
+	}
+
+	return c.JSON(http.StatusOK, delivery)
+}
+
+// RedeliverWebhook godoc
+// @Summary Redeliver a webhook
+// @Description Queue a delivered or dead delivery to be sent again, with a fresh set of attempts
+// @Tags webhooks
+// @Produce json
+// @Param id path int true "Webhook endpoint ID"
+// @Param deliveryId path int true "Delivery ID"
+// @Success 202 {object} model.WebhookDelivery
+// @Failure 400 {object} model.ErrorResponse
+// @Failure 404 {object} model.ErrorResponse
+// @Failure 409 {object} model.ErrorResponse
+// @Failure 500 {object} model.ErrorResponse
+// @Security BearerAuth
+// @Router /admin/webhooks/{id}/deliveries/{deliveryId}/redeliver [post]
+func (h *WebhookHandler) RedeliverWebhook(c echo.Context) error {
+	id, deliveryID, ok := webhookDeliveryParams(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, model.ErrorResponse{Error: "Invalid webhook or delivery ID"})
+	}
+
+	delivery, err := h.webhookRepo.Redeliver(c.Request().Context(), id, deliveryID)
+	if err != nil {
+		if errors.Is(err, repository.ErrDeliveryPending) {
+			return c.JSON(http.StatusConflict, model.ErrorResponse{Error: "Delivery is still pending"})
+		}
+		return webhookError(c, "Failed to redeliver webhook", err)
+	}
+
+	return c.JSON(http.StatusAccepted, delivery)
+}
+
+// bindWebhookEndpoint reads and validates a WebhookEndpointRequest, returning
+// the message to answer with when it is invalid.
+func bindWebhookEndpoint(c echo.Context) (*model.WebhookEndpoint, string) {
+	var req model.WebhookEndpointRequest
+	if err := c.Bind(&req); err != nil {
+		return nil, "Invalid request"
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		return nil, "Name is required and must be at most 100 characters"
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, "URL must be an absolute http or https URL"
+	}
+	if len(req.EventTypes) == 0 {
+		return nil, "At least one event type is required"
+	}
+	for _, eventType := range req.EventTypes {
+		if !isWebhookEventType(eventType) {
+			return nil, "Event type must be one of: " + strings.Join(model.EventTypes, ", ") + " or " + model.WebhookAllEvents
+		}
+	}
+
+	active := true
+	if req.Active != nil {
+		active = *req.Active
+	}
+	return &model.WebhookEndpoint{Name: req.Name, URL: req.URL, EventTypes: req.EventTypes, Active: active}, ""
+}
+
+// checkWebhookURL returns the message to reject an endpoint URL with when it
+// points at the server's own network or cannot be resolved. Deliveries check
+// the address again when they connect, in case the host name changes.
+func (h *WebhookHandler) checkWebhookURL(c echo.Context, rawURL string) string {
+	err := h.deliverer.CheckURL(c.Request().Context(), rawURL)
+	if errors.Is(err, webhook.ErrForbiddenAddress) {
+		return "URL must not point to a loopback, private or link-local address"
+	}
+	if err != nil {
+		return "URL host cannot be resolved"
+	}
+	return ""
+}
+
+func isWebhookEventType(eventType string) bool {
+	if eventType == model.WebhookAllEvents {
+		return true
+	}
+	for _, t := range model.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func webhookDeliveryParams(c echo.Context) (int, int64, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, 0, false
+	}
+	deliveryID, err := strconv.ParseInt(c.Param("deliveryId"), 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return id, deliveryID, true
+}
+
+// webhookError answers the repository's not-found errors with a 404.
+func webhookError(c echo.Context, message string, err error) error {
+	switch err.Error() {
+	case "webhook endpoint not found":
+		return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Webhook not found"})
+	case "webhook delivery not found":
+		return c.JSON(http.StatusNotFound, model.ErrorResponse{Error: "Delivery not found"})
+	}
+	return internalError(c, message, err)
+}
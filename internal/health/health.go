@@ -0,0 +1,183 @@
+// Package health serves the liveness, readiness and version endpoints. Other
+// subsystems register checks for the dependencies they need into Readiness.
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultCheckTimeout bounds a check registered without its own timeout.
+const DefaultCheckTimeout = 2 * time.Second
+
+// Checker reports whether a dependency can be used.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc adapts a function to Checker.
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Check is a named dependency check run on every readiness probe.
+type Check struct {
+	Name    string
+	Checker Checker
+	// Timeout defaults to the Readiness timeout.
+	Timeout time.Duration
+	// Optional checks are reported but do not fail readiness, for
+	// dependencies the server can work without.
+	Optional bool
+}
+
+// CheckResult is the outcome of one check.
+type CheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the readiness probe response.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Readiness statuses.
+const (
+	StatusReady    = "ready"
+	StatusNotReady = "not_ready"
+	StatusDraining = "draining"
+
+	CheckOK     = "ok"
+	CheckFailed = "failed"
+)
+
+// Readiness decides whether the server should receive traffic: every
+// required check passes and the server is not shutting down. Once shutdown
+// starts it fails, so load balancers stop routing new requests to it while
+// in-flight ones drain.
+type Readiness struct {
+	timeout  time.Duration
+	draining atomic.Bool
+	mu       sync.RWMutex
+	checks   []Check
+}
+
+func NewReadiness(timeout time.Duration) *Readiness {
+	if timeout <= 0 {
+		timeout = DefaultCheckTimeout
+	}
+	return &Readiness{timeout: timeout}
+}
+
+// Register adds a check. Names must be unique.
+func (r *Readiness) Register(check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.checks {
+		if existing.Name == check.Name {
+			panic(fmt.Sprintf("health: check %q registered twice", check.Name))
+		}
+	}
+	r.checks = append(r.checks, check)
+}
+
+// SetDraining marks the server as shutting down.
+func (r *Readiness) SetDraining() {
+	r.draining.Store(true)
+}
+
+// Ready reports whether the server accepts new traffic, without running the
+// checks.
+func (r *Readiness) Ready() bool {
+	return !r.draining.Load()
+}
+
+// Run runs every check concurrently, each within its timeout, and reports
+// the overall status. Checks are skipped while draining.
+func (r *Readiness) Run(ctx context.Context) Report {
+	if r.draining.Load() {
+		return Report{Status: StatusDraining}
+	}
+
+	r.mu.RLock()
+	checks := append([]Check(nil), r.checks...)
+	r.mu.RUnlock()
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = r.run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusReady, Checks: make(map[string]CheckResult, len(checks))}
+	for i, check := range checks {
+		report.Checks[check.Name] = results[i]
+		if results[i].Status != CheckOK && !check.Optional {
+			report.Status = StatusNotReady
+		}
+	}
+	return report
+}
+
+func (r *Readiness) run(ctx context.Context, check Check) CheckResult {
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = r.timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check.Checker.Check(ctx)
+	}()
+
+	// A check that ignores its context still cannot hold up the probe.
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+
+	result := CheckResult{Status: CheckOK, Optional: check.Optional, Duration: time.Since(start).Round(time.Millisecond).String()}
+	if err != nil {
+		result.Status = CheckFailed
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Handler serves the readiness probe: 200 when ready, 503 otherwise.
+func (r *Readiness) Handler(c echo.Context) error {
+	report := r.Run(c.Request().Context())
+	if report.Status != StatusReady {
+		return c.JSON(http.StatusServiceUnavailable, report)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// Liveness serves the liveness probe. It only shows that the process can
+// answer requests; dependencies belong to readiness, so an outage does not
+// get the server restarted.
+func Liveness(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,36 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+
+	"test-ordent/config"
+)
+
+// Message is a single email. HTML is optional; when set the message is sent
+// as multipart/alternative with Text as the fallback.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers messages. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the Mailer selected by cfg.Driver.
+func New(cfg config.MailConfig) (Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return NewSMTPMailer(cfg.From, cfg.SMTP), nil
+	case "file", "":
+		return NewFileMailer(cfg.From, cfg.File.Dir)
+	case "memory":
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
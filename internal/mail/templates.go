@@ -0,0 +1,51 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+var (
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/*.txt.tmpl"))
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/*.html.tmpl"))
+)
+
+// Template names. Each has a <name>.txt.tmpl defining "<name>_subject" and
+// "<name>_text", and a <name>.html.tmpl defining "<name>_html".
+const (
+	TemplateVerifyEmail   = "verify_email"
+	TemplateResetPassword = "reset_password"
+	TemplateInvitation    = "invitation"
+)
+
+// Render builds a message for to from the named template.
+func Render(name, to string, data interface{}) (Message, error) {
+	msg := Message{To: to}
+
+	var buf bytes.Buffer
+	if err := textTemplates.ExecuteTemplate(&buf, name+"_subject", data); err != nil {
+		return msg, fmt.Errorf("failed to render %s subject: %w", name, err)
+	}
+	msg.Subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := textTemplates.ExecuteTemplate(&buf, name+"_text", data); err != nil {
+		return msg, fmt.Errorf("failed to render %s text: %w", name, err)
+	}
+	msg.Text = strings.TrimSpace(buf.String()) + "\n"
+
+	buf.Reset()
+	if err := htmlTemplates.ExecuteTemplate(&buf, name+"_html", data); err != nil {
+		return msg, fmt.Errorf("failed to render %s html: %w", name, err)
+	}
+	msg.HTML = strings.TrimSpace(buf.String()) + "\n"
+
+	return msg, nil
+}
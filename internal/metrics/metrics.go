@@ -0,0 +1,166 @@
+// Package metrics exposes Prometheus metrics: HTTP traffic by route, the
+// database connection pool, and business events recorded by the handlers.
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "ecommerce"
+
+// Stages at which a request can be rejected for lack of stock.
+const (
+	StageCart     = "cart"
+	StageCheckout = "checkout"
+)
+
+// unmatchedRoute labels requests that matched no route, so unknown paths
+// cannot create new series.
+const unmatchedRoute = "unmatched"
+
+// Metrics owns a registry with the HTTP, runtime and business metrics.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+
+	ordersCreated prometheus.Counter
+	orderValue    prometheus.Histogram
+	cartAdditions prometheus.Counter
+	loginFailures *prometheus.CounterVec
+	outOfStock    *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests handled, by method, route template and status.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests, by method, route template and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		ordersCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "orders_created_total",
+			Help:      "Orders placed.",
+		}),
+		orderValue: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "order_value",
+			Help:      "Total of placed orders, in the store currency.",
+			Buckets:   prometheus.ExponentialBuckets(10, 10, 7),
+		}),
+		cartAdditions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cart_items_added_total",
+			Help:      "Products added to carts.",
+		}),
+		loginFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "login_failures_total",
+			Help:      "Rejected logins, by reason.",
+		}, []string{"reason"}),
+		outOfStock: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "out_of_stock_rejections_total",
+			Help:      "Requests rejected for lack of stock, by stage (cart or checkout).",
+		}, []string{"stage"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.ordersCreated,
+		m.orderValue,
+		m.cartAdditions,
+		m.loginFailures,
+		m.outOfStock,
+	)
+	return m
+}
+
+// RegisterDB exposes the connection pool statistics of db, labelled with
+// name.
+func (m *Metrics) RegisterDB(db *sql.DB, name string) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Registry returns the registry, for other subsystems to add collectors.
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// Middleware counts and times requests. Routes are labelled by their
+// template, such as /api/products/:id, so IDs do not create new series.
+func (m *Metrics) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+
+		status := c.Response().Status
+		if err != nil {
+			// The error handler has not written the response yet.
+			status = http.StatusInternalServerError
+			var httpErr *echo.HTTPError
+			if errors.As(err, &httpErr) {
+				status = httpErr.Code
+			}
+		}
+		route := c.Path()
+		if route == "" {
+			route = unmatchedRoute
+		}
+
+		labels := prometheus.Labels{
+			"method": c.Request().Method,
+			"route":  route,
+			"status": strconv.Itoa(status),
+		}
+		m.httpRequests.With(labels).Inc()
+		m.httpDuration.With(labels).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// OrderCreated records a placed order and its total.
+func (m *Metrics) OrderCreated(total float64) {
+	m.ordersCreated.Inc()
+	m.orderValue.Observe(total)
+}
+
+// CartItemAdded records a product added to a cart.
+func (m *Metrics) CartItemAdded() {
+	m.cartAdditions.Inc()
+}
+
+// LoginFailed records a rejected login; reason is one of the
+// model.LoginFailure* values.
+func (m *Metrics) LoginFailed(reason string) {
+	m.loginFailures.WithLabelValues(reason).Inc()
+}
+
+// OutOfStock records a request rejected for lack of stock at stage
+// (StageCart or StageCheckout).
+func (m *Metrics) OutOfStock(stage string) {
+	m.outOfStock.WithLabelValues(stage).Inc()
+}
@@ -0,0 +1,44 @@
+package model
+
+import "time"
+
+const (
+	APIKeyActive  = "active"
+	APIKeyRevoked = "revoked"
+	APIKeyExpired = "expired"
+)
+
+// APIKey lets an integration call the API without a user login. Only a hash
+// of the key is stored; the prefix identifies it.
+type APIKey struct {
+	ID            int        `json:"id"`
+	Name          string     `json:"name"`
+	Prefix        string     `json:"prefix"`
+	KeyHash       string     `json:"-"`
+	Permissions   []string   `json:"permissions"`
+	Status        string     `json:"status"`
+	CreatedBy     *uint      `json:"created_by"`
+	CreatedByName string     `json:"created_by_username,omitempty"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	LastUsedAt    *time.Time `json:"last_used_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name        string   `json:"name" validate:"required,max=100"`
+	Permissions []string `json:"permissions" validate:"required"`
+	// ExpiresAt defaults to the configured default lifetime from now.
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKeyResponse carries the key itself. It is shown only once; the
+// database keeps a hash of it.
+type CreateAPIKeyResponse struct {
+	APIKey APIKey `json:"api_key"`
+	Key    string `json:"key"`
+}
+
+type APIKeysResponse struct {
+	APIKeys []APIKey `json:"api_keys"`
+}
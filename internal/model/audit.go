@@ -0,0 +1,87 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Audited actions, named entity.verb.
+const (
+	AuditProductCreate    = "product.create"
+	AuditProductUpdate    = "product.update"
+	AuditProductDelete    = "product.delete"
+	AuditProductRestore   = "product.restore"
+	AuditProductImport    = "product.import"
+	AuditProductRestock   = "product.restock"
+	AuditInvitationCreate = "invitation.create"
+	AuditInvitationRevoke = "invitation.revoke"
+	AuditUserCreate       = "user.create"
+	AuditUserStatus       = "user.update_status"
+	AuditUserRole         = "user.update_role"
+	AuditUserAnonymize    = "user.anonymize"
+	AuditOrderStatus      = "order.update_status"
+	AuditOrderRefund      = "order.refund"
+	AuditWebhookCreate    = "webhook.create"
+	AuditWebhookUpdate    = "webhook.update"
+	AuditWebhookDelete    = "webhook.delete"
+	AuditAPIKeyCreate     = "api_key.create"
+	AuditAPIKeyRevoke     = "api_key.revoke"
+)
+
+const (
+	AuditEntityProduct    = "product"
+	AuditEntityInvitation = "invitation"
+	AuditEntityUser       = "user"
+	AuditEntityOrder      = "order"
+	AuditEntityWebhook    = "webhook"
+	AuditEntityAPIKey     = "api_key"
+)
+
+// AuditEvent records one administrative change: who made it, from where,
+// and the entity before and after. Hash covers every other field and the
+// hash of the previous event, PrevHash.
+type AuditEvent struct {
+	ID            int64           `json:"id"`
+	ActorUserID   *uint           `json:"actor_user_id"`
+	ActorAPIKeyID *int            `json:"actor_api_key_id,omitempty"`
+	Action        string          `json:"action"`
+	EntityType    string          `json:"entity_type"`
+	EntityID      string          `json:"entity_id"`
+	Before        json.RawMessage `json:"before" swaggertype:"object"`
+	After         json.RawMessage `json:"after" swaggertype:"object"`
+	IPAddress     string          `json:"ip_address"`
+	UserAgent     string          `json:"user_agent"`
+	RequestID     string          `json:"request_id,omitempty"`
+	TraceID       string          `json:"trace_id,omitempty"`
+	PrevHash      string          `json:"prev_hash"`
+	Hash          string          `json:"hash"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+type AuditFilter struct {
+	ActorUserID uint
+	Action      string
+	EntityType  string
+	EntityID    string
+	From        *time.Time
+	To          *time.Time
+	Limit       int
+	Offset      int
+}
+
+type AuditEventsResponse struct {
+	Events []AuditEvent `json:"events"`
+	Total  int          `json:"total"`
+}
+
+// AuditVerification is the result of checking the hash chain. LastHash is
+// the hash of the newest event; keeping a copy elsewhere also reveals
+// events removed from the end of the log.
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Checked  int    `json:"checked"`
+	LastHash string `json:"last_hash"`
+	// BrokenAtID is the first event whose hash does not match.
+	BrokenAtID *int64 `json:"broken_at_id,omitempty"`
+	Problem    string `json:"problem,omitempty"`
+}
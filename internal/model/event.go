@@ -0,0 +1,102 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Domain event types, named aggregate.what_happened.
+const (
+	EventOrderCreated       = "order.created"
+	EventOrderStatusChanged = "order.status_changed"
+	EventProductUpdated     = "product.updated"
+	EventStockLow           = "product.stock_low"
+)
+
+// EventTypes lists the event types consumers can subscribe to.
+var EventTypes = []string{EventOrderCreated, EventOrderStatusChanged, EventProductUpdated, EventStockLow}
+
+const (
+	AggregateOrder   = "order"
+	AggregateProduct = "product"
+	AggregateWebhook = "webhook"
+)
+
+// Event is a domain event as published to sinks. ID increases with every
+// event recorded, and events of one aggregate are published in ID order, so
+// consumers can drop duplicates and stale events by remembering the last ID
+// seen per aggregate.
+type Event struct {
+	ID            int64           `json:"id"`
+	Type          string          `json:"type"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   string          `json:"aggregate_id"`
+	Payload       json.RawMessage `json:"payload" swaggertype:"object"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+}
+
+// OutboxEvent is an event waiting in the outbox to be published.
+type OutboxEvent struct {
+	Event
+	// Attempts is the number of failed attempts so far.
+	Attempts int
+}
+
+// OutboxOutcome is the result of one attempt to publish an outbox event.
+// Failed events are tried again after RetryAfter.
+type OutboxOutcome struct {
+	EventID    int64
+	Published  bool
+	Error      string
+	RetryAfter time.Duration
+}
+
+// OrderCreatedEvent is the payload of order.created.
+type OrderCreatedEvent struct {
+	OrderID         uint             `json:"order_id"`
+	UserID          uint             `json:"user_id"`
+	TotalAmount     float64          `json:"total_amount"`
+	Status          string           `json:"status"`
+	ShippingAddress string           `json:"shipping_address"`
+	Items           []OrderEventItem `json:"items"`
+}
+
+type OrderEventItem struct {
+	ProductID uint    `json:"product_id"`
+	Quantity  int     `json:"quantity"`
+	Price     float64 `json:"price"`
+	Subtotal  float64 `json:"subtotal"`
+}
+
+// OrderStatusChangedEvent is the payload of order.status_changed.
+type OrderStatusChangedEvent struct {
+	OrderID     uint    `json:"order_id"`
+	UserID      uint    `json:"user_id"`
+	TotalAmount float64 `json:"total_amount"`
+	OldStatus   string  `json:"old_status"`
+	NewStatus   string  `json:"new_status"`
+}
+
+// Changes reported by product.updated.
+const (
+	ProductChangeCreated  = "created"
+	ProductChangeUpdated  = "updated"
+	ProductChangeDeleted  = "deleted"
+	ProductChangeRestored = "restored"
+)
+
+// ProductUpdatedEvent is the payload of product.updated, sent for every
+// change made by staff or an import, with the product as it is afterwards.
+type ProductUpdatedEvent struct {
+	Change  string           `json:"change"`
+	Product *ProductResponse `json:"product"`
+}
+
+// StockLowEvent is the payload of product.stock_low, sent when the stock of
+// a product drops below the configured threshold.
+type StockLowEvent struct {
+	ProductID int    `json:"product_id"`
+	Name      string `json:"name"`
+	Stock     int    `json:"stock"`
+	Threshold int    `json:"threshold"`
+}
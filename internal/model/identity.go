@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// UserIdentity links a user to an account at an external identity provider.
+type UserIdentity struct {
+	ID          uint       `json:"id"`
+	UserID      uint       `json:"user_id"`
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+type UserIdentitiesResponse struct {
+	Identities []UserIdentity `json:"identities"`
+}
+
+type OIDCProvidersResponse struct {
+	Providers []string `json:"providers"`
+}
@@ -2,6 +2,53 @@ package model
 
 import "time"
 
+// Order statuses. New orders are pending until paid.
+const (
+	OrderStatusPending   = "pending"
+	OrderStatusPaid      = "paid"
+	OrderStatusShipped   = "shipped"
+	OrderStatusDelivered = "delivered"
+	OrderStatusCancelled = "cancelled"
+	// OrderStatusRefunded is only reached through a refund.
+	OrderStatusRefunded = "refunded"
+)
+
+// orderStatusTransitions lists the statuses each status can move to.
+var orderStatusTransitions = map[string][]string{
+	OrderStatusPending:   {OrderStatusPaid, OrderStatusCancelled},
+	OrderStatusPaid:      {OrderStatusShipped, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusShipped:   {OrderStatusDelivered, OrderStatusRefunded},
+	OrderStatusDelivered: {OrderStatusRefunded},
+}
+
+// IsOrderStatus reports whether status is a known order status.
+func IsOrderStatus(status string) bool {
+	switch status {
+	case OrderStatusPending, OrderStatusPaid, OrderStatusShipped, OrderStatusDelivered, OrderStatusCancelled, OrderStatusRefunded:
+		return true
+	}
+	return false
+}
+
+// CanTransitionOrder reports whether an order may move from one status to
+// another.
+func CanTransitionOrder(from, to string) bool {
+	for _, next := range orderStatusTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderReturnsStock reports whether moving an order from one status to
+// another puts its items back into stock: cancelling it, or refunding it
+// before it shipped. Items of a shipped order are with the customer, so
+// refunding it leaves the stock alone; returned goods are restocked by hand.
+func OrderReturnsStock(from, to string) bool {
+	return to == OrderStatusCancelled || (to == OrderStatusRefunded && from == OrderStatusPaid)
+}
+
 type Order struct {
 	ID              uint       `json:"id"`
 	UserID          uint       `json:"user_id"`
@@ -28,6 +75,10 @@ type CreateOrderRequest struct {
 	ShippingAddress string `json:"shipping_address" validate:"required"`
 }
 
+type UpdateOrderStatusRequest struct {
+	Status string `json:"status" validate:"required"`
+}
+
 type OrderResponse struct {
 	ID              uint              `json:"id"`
 	TotalAmount     float64           `json:"total_amount"`
@@ -47,4 +98,23 @@ type OrderItemDetail struct {
 
 type OrdersResponse struct {
 	Orders []OrderResponse `json:"orders"`
+}
+
+// OrderFilter selects orders for staff; zero values match everything.
+type OrderFilter struct {
+	Status string
+	UserID uint
+	Limit  int
+	Offset int
+}
+
+type AdminOrdersResponse struct {
+	Orders []Order `json:"orders"`
+	Total  int     `json:"total"`
+}
+
+// OrderDetail is an order with its items.
+type OrderDetail struct {
+	Order
+	Items []OrderItemDetail `json:"items"`
 }
\ No newline at end of file
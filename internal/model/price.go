@@ -0,0 +1,53 @@
+package model
+
+import "time"
+
+const (
+	PriceSourceManual         = "manual"
+	PriceSourceImport         = "import"
+	PriceSourceScheduleStart  = "schedule_start"
+	PriceSourceScheduleEnd    = "schedule_end"
+	PriceSourceScheduleCancel = "schedule_cancel"
+)
+
+const (
+	PriceScheduleScheduled = "scheduled"
+	PriceScheduleActive    = "active"
+	PriceScheduleEnded     = "ended"
+	PriceScheduleCancelled = "cancelled"
+)
+
+type PriceHistoryEntry struct {
+	ID        int       `json:"id"`
+	ProductID int       `json:"product_id"`
+	OldPrice  *float64  `json:"old_price"`
+	NewPrice  float64   `json:"new_price"`
+	Source    string    `json:"source"`
+	ChangedBy *uint     `json:"changed_by,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+type PriceHistoryResponse struct {
+	History []PriceHistoryEntry `json:"history"`
+}
+
+type PriceScheduleRequest struct {
+	SalePrice float64   `json:"sale_price" validate:"required,gt=0"`
+	StartsAt  time.Time `json:"starts_at" validate:"required"`
+	EndsAt    time.Time `json:"ends_at" validate:"required"`
+}
+
+type PriceSchedule struct {
+	ID        int       `json:"id"`
+	ProductID int       `json:"product_id"`
+	SalePrice float64   `json:"sale_price"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	Status    string    `json:"status"`
+	CreatedBy *uint     `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type PriceSchedulesResponse struct {
+	Schedules []PriceSchedule `json:"schedules"`
+}
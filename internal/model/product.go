@@ -15,6 +15,7 @@ type Product struct {
 }
 
 type ProductRequest struct {
+	SKU         string  `json:"sku"`
 	Name        string  `json:"name" validate:"required"`
 	Description string  `json:"description"`
 	Price       float64 `json:"price" validate:"required,gt=0"`
@@ -23,19 +24,96 @@ type ProductRequest struct {
 	ImageURL    string  `json:"image_url"`
 }
 
+// ProductPatch holds the fields of a JSON merge patch; nil fields are left
+// unchanged.
+type ProductPatch struct {
+	SKU         *string
+	Name        *string
+	Description *string
+	Price       *float64
+	Stock       *int
+	CategoryID  *uint
+	ImageURL    *string
+}
+
 type ProductResponse struct {
-    ID          int        `json:"id"`
-    Name        string     `json:"name"`
-    Description string     `json:"description"`
-    Price       float64    `json:"price"`
-    Stock       int        `json:"stock"`
-    CategoryID  int        `json:"category_id"`
-    ImageURL    string     `json:"image_url"`
-    CreatedAt   time.Time  `json:"created_at"`
-    UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+    ID          int            `json:"id"`
+    SKU         string         `json:"sku,omitempty"`
+    Name        string         `json:"name"`
+    Description string         `json:"description"`
+    Price       float64        `json:"price"`
+    SalePrice   *float64       `json:"sale_price,omitempty"`
+    Stock       int            `json:"stock"`
+    CategoryID  int            `json:"category_id"`
+    ImageURL    string         `json:"image_url"`
+    Version     int            `json:"version"`
+    CreatedAt   time.Time      `json:"created_at"`
+    UpdatedAt   *time.Time     `json:"updated_at,omitempty"`
+    DeletedAt   *time.Time     `json:"deleted_at,omitempty"`
+    Images      []ProductImage `json:"images,omitempty"`
+}
+
+// EffectivePrice is the price a customer pays right now: the active sale
+// price if there is one, otherwise the base price.
+func (p *ProductResponse) EffectivePrice() float64 {
+	if p.SalePrice != nil {
+		return *p.SalePrice
+	}
+	return p.Price
 }
 
 type ProductsResponse struct {
 	Products []ProductResponse `json:"products"`
 	Total    int               `json:"total"`
-}
\ No newline at end of file
+}
+
+type ProductImportReport struct {
+	DryRun  bool                 `json:"dry_run"`
+	Atomic  bool                 `json:"atomic"`
+	Total   int                  `json:"total"`
+	Created int                  `json:"created"`
+	Updated int                  `json:"updated"`
+	Failed  int                  `json:"failed"`
+	Errors  []ProductImportError `json:"errors"`
+}
+
+type ProductImportError struct {
+	Line  int    `json:"line"`
+	SKU   string `json:"sku,omitempty"`
+	Error string `json:"error"`
+}
+
+// ProductUpsertResult is the outcome of writing one imported row.
+type ProductUpsertResult struct {
+	Created bool
+	Err     error
+}
+
+type ProductImage struct {
+	ID          int                            `json:"id"`
+	ProductID   int                            `json:"product_id"`
+	Position    int                            `json:"position"`
+	URL         string                         `json:"url"`
+	StorageKey  string                         `json:"-"`
+	ContentType string                         `json:"content_type"`
+	Size        int64                          `json:"size"`
+	Width       int                            `json:"width"`
+	Height      int                            `json:"height"`
+	Thumbnails  map[string]ProductImageVariant `json:"thumbnails"`
+	CreatedAt   time.Time                      `json:"created_at"`
+}
+
+type ProductImageVariant struct {
+	URL        string `json:"url"`
+	StorageKey string `json:"-"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+}
+
+type ProductImagesResponse struct {
+	Images []ProductImage `json:"images"`
+}
+
+type ReorderProductImagesRequest struct {
+	ImageIDs []int `json:"image_ids" validate:"required"`
+}
@@ -1,12 +1,29 @@
 package model
 
+import "time"
+
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+	UserStatusDeleted   = "deleted"
+)
+
 type User struct {
-	ID           uint   `json:"id"`
-	Username     string `json:"username"`
-	Email        string `json:"email"`
-	PasswordHash string `json:"-"`
-	FullName     string `json:"full_name"`
-	Role         string `json:"role"`
+	ID            uint      `json:"id"`
+	Username      string    `json:"username"`
+	Email         string    `json:"email"`
+	PasswordHash  string    `json:"-"`
+	FullName      string    `json:"full_name"`
+	Role          string    `json:"role"`
+	EmailVerified bool      `json:"email_verified"`
+	Status        string    `json:"status"`
+	CreatedAt     time.Time `json:"created_at"`
+	// TwoFactorEnabled is set once the user has activated TOTP.
+	TwoFactorEnabled bool     `json:"two_factor_enabled"`
+	Permissions      []string `json:"permissions,omitempty"`
+	// PasswordChangedAt is when the password was last changed or reset, if
+	// ever; tokens issued before it are no longer accepted.
+	PasswordChangedAt *time.Time `json:"-"`
 }
 
 type LoginRequest struct {
@@ -27,24 +44,125 @@ type UserResponse struct {
 	Role     string `json:"role"`
 }
 
+// LoginResponse carries either an access token or, for accounts with
+// two-factor authentication, a challenge token to exchange at
+// /auth/login/2fa.
 type LoginResponse struct {
-	Token string       `json:"token"`
-	User  UserResponse `json:"user"`
+	Token             string `json:"token,omitempty"`
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
+	// TwoFactorSetupRequired tells the client that the user's role requires
+	// two-factor authentication, which has to be enrolled before any
+	// permission of the role can be used.
+	TwoFactorSetupRequired bool         `json:"two_factor_setup_required,omitempty"`
+	User                   UserResponse `json:"user"`
 }
 
 type RegisterResponse struct {
-	Token string       `json:"token"`
+	Token string       `json:"token,omitempty"`
 	User  UserResponse `json:"user"`
 }
 
 type ErrorResponse struct {
 	Error string `json:"error"`
+	// TraceID identifies the request in traces and logs; it is sent with
+	// server errors so they can be reported.
+	TraceID string `json:"trace_id,omitempty"`
+}
+
+type UpdateProfileRequest struct {
+	FullName *string `json:"full_name"`
+	Email    *string `json:"email" validate:"omitempty,email"`
+	// CurrentPassword is required to change the email address.
+	CurrentPassword string `json:"current_password,omitempty"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=6"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+type UserFilter struct {
+	Query  string
+	Role   string
+	Status string
+	Limit  int
+	Offset int
+}
+
+type UsersResponse struct {
+	Users []User `json:"users"`
+	Total int    `json:"total"`
+}
+
+type UpdateUserStatusRequest struct {
+	Status string `json:"status" validate:"required,oneof=active suspended"`
+}
+
+type UpdateUserRoleRequest struct {
+	Role string `json:"role" validate:"required"`
+}
+
+type Role struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+type RolesResponse struct {
+	Roles       []Role   `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,min=6"`
 }
 
-type RegisterAdminRequest struct {
-	Username    string `json:"username" validate:"required,min=3,max=50"`
-	Email       string `json:"email" validate:"required,email"`
-	Password    string `json:"password" validate:"required,min=6"`
-	FullName    string `json:"full_name" validate:"required"`
-	AdminSecret string `json:"admin_secret" validate:"required"`
-}
\ No newline at end of file
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// LoginAttempts is the failed login counter for an account or client IP,
+// with the attempts that are still being checked.
+type LoginAttempts struct {
+	Failures    int
+	LastFailure time.Time
+	Pending     int
+	LastAttempt time.Time
+}
+
+// Expire clears the failures and pending attempts that are older than
+// resetAfter.
+func (a LoginAttempts) Expire(now time.Time, resetAfter time.Duration) LoginAttempts {
+	if now.Sub(a.LastFailure) >= resetAfter {
+		a.Failures, a.LastFailure = 0, time.Time{}
+	}
+	if now.Sub(a.LastAttempt) >= resetAfter {
+		a.Pending, a.LastAttempt = 0, time.Time{}
+	}
+	return a
+}
+
+const (
+	LoginFailureUnknownUser   = "unknown_user"
+	LoginFailureWrongPassword = "wrong_password"
+	LoginFailureLockedOut     = "locked_out"
+	LoginFailureWrongCode     = "wrong_2fa_code"
+)
+
+// LoginFailure is an audit record of a rejected login.
+type LoginFailure struct {
+	Username  string
+	UserID    uint
+	IPAddress string
+	UserAgent string
+	Reason    string
+}
@@ -0,0 +1,110 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryDead      = "dead"
+)
+
+// EventWebhookPing is the event type of test deliveries.
+const EventWebhookPing = "webhook.ping"
+
+// WebhookAllEvents in EventTypes subscribes an endpoint to every event type.
+const WebhookAllEvents = "*"
+
+// WebhookEndpoint is a partner URL that events of the subscribed types are
+// posted to. Secret, the signing secret encrypted, is never returned.
+type WebhookEndpoint struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Active     bool      `json:"active"`
+	Secret     string    `json:"-"`
+	CreatedBy  *uint     `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Subscribes reports whether events of eventType go to the endpoint.
+func (e *WebhookEndpoint) Subscribes(eventType string) bool {
+	for _, t := range e.EventTypes {
+		if t == eventType || t == WebhookAllEvents {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookEndpointRequest struct {
+	Name       string   `json:"name" validate:"required,max=100"`
+	URL        string   `json:"url" validate:"required"`
+	EventTypes []string `json:"event_types" validate:"required"`
+	// Active defaults to true.
+	Active *bool `json:"active"`
+}
+
+// CreateWebhookEndpointResponse carries the signing secret, shown only
+// once.
+type CreateWebhookEndpointResponse struct {
+	Endpoint WebhookEndpoint `json:"endpoint"`
+	Secret   string          `json:"secret"`
+}
+
+type WebhookEndpointsResponse struct {
+	Endpoints []WebhookEndpoint `json:"endpoints"`
+}
+
+// WebhookDelivery is one event sent, or to be sent, to one endpoint.
+// Payload is the request body. Pending deliveries are attempted at
+// NextAttemptAt.
+type WebhookDelivery struct {
+	ID             int64            `json:"id"`
+	EndpointID     int              `json:"endpoint_id"`
+	EventID        *int64           `json:"event_id"`
+	EventType      string           `json:"event_type"`
+	Payload        json.RawMessage  `json:"payload" swaggertype:"object"`
+	Status         string           `json:"status"`
+	Attempts       int              `json:"attempts"`
+	NextAttemptAt  *time.Time       `json:"next_attempt_at,omitempty"`
+	LastStatusCode *int             `json:"last_status_code"`
+	LastError      string           `json:"last_error,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+	DeliveredAt    *time.Time       `json:"delivered_at"`
+	AttemptLog     []WebhookAttempt `json:"attempt_log,omitempty"`
+}
+
+// WebhookAttempt records one request made for a delivery. StatusCode is
+// zero when no response was received.
+type WebhookAttempt struct {
+	StatusCode   int       `json:"status_code,omitempty"`
+	ResponseBody string    `json:"response_body,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	DurationMS   int64     `json:"duration_ms"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// WebhookDispatch is a claimed delivery with the endpoint it goes to.
+type WebhookDispatch struct {
+	Delivery WebhookDelivery
+	URL      string
+	// Secret is the encrypted signing secret.
+	Secret string
+}
+
+type WebhookDeliveryFilter struct {
+	EndpointID int
+	Status     string
+	Limit      int
+	Offset     int
+}
+
+type WebhookDeliveriesResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+	Total      int               `json:"total"`
+}
@@ -0,0 +1,165 @@
+// Package oidc signs users in with external OpenID Connect providers using the
+// authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	"test-ordent/config"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	ErrNonceMismatch   = errors.New("id token nonce does not match")
+	ErrMissingIDToken  = errors.New("token response has no id_token")
+)
+
+// Identity is what a provider asserts about the signed-in user.
+type Identity struct {
+	Provider          string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// Provider is one configured identity provider. Its discovery document is
+// fetched on first use, so the server starts even when a provider is down.
+type Provider struct {
+	name string
+	cfg  config.OIDCProviderConfig
+
+	mu       sync.Mutex
+	provider *gooidc.Provider
+	oauth    *oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+}
+
+func NewProvider(name string, cfg config.OIDCProviderConfig) *Provider {
+	return &Provider{name: name, cfg: cfg}
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider != nil {
+		return nil
+	}
+
+	provider, err := gooidc.NewProvider(ctx, p.cfg.IssuerURL)
+	if err != nil {
+		return fmt.Errorf("discover %s: %w", p.name, err)
+	}
+
+	scopes := p.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"profile", "email"}
+	}
+	p.oauth = &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       append([]string{gooidc.ScopeOpenID}, scopes...),
+	}
+	p.verifier = provider.Verifier(&gooidc.Config{ClientID: p.cfg.ClientID})
+	p.provider = provider
+	return nil
+}
+
+// AuthCodeURL returns the provider's authorization URL for a new sign-in.
+// The caller keeps state, nonce and verifier until the callback.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+	return p.oauth.AuthCodeURL(state, gooidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// Exchange redeems an authorization code and verifies the returned ID token,
+// including that it was issued for this sign-in's nonce.
+func (p *Provider) Exchange(ctx context.Context, code, nonce, verifier string) (*Identity, error) {
+	if err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+
+	token, err := p.oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, ErrMissingIDToken
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("verify id token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     *bool  `json:"email_verified"`
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("parse id token claims: %w", err)
+	}
+
+	return &Identity{
+		Provider:          p.name,
+		Subject:           idToken.Subject,
+		Email:             claims.Email,
+		EmailVerified:     (claims.EmailVerified != nil && *claims.EmailVerified) || p.cfg.TrustEmail,
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+	}, nil
+}
+
+// Registry holds the configured providers by name.
+type Registry struct {
+	providers map[string]*Provider
+}
+
+func NewRegistry(cfg config.OIDCConfig) *Registry {
+	r := &Registry{providers: map[string]*Provider{}}
+	for name, providerCfg := range cfg.Providers {
+		r.providers[name] = NewProvider(name, providerCfg)
+	}
+	return r
+}
+
+func (r *Registry) Get(name string) (*Provider, error) {
+	provider, ok := r.providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return provider, nil
+}
+
+// Names returns the configured provider names, sorted.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,50 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// LoginState is what has to survive the round trip to the provider: state
+// ties the callback to the browser that started the sign-in, nonce ties the ID
+// token to it, and the verifier is the PKCE secret.
+type LoginState struct {
+	Provider  string `json:"p"`
+	State     string `json:"s"`
+	Nonce     string `json:"n"`
+	Verifier  string `json:"v"`
+	ExpiresAt int64  `json:"x"`
+}
+
+func NewLoginState(provider string, ttl time.Duration) (*LoginState, error) {
+	state, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	return &LoginState{
+		Provider:  provider,
+		State:     state,
+		Nonce:     nonce,
+		Verifier:  oauth2.GenerateVerifier(),
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	}, nil
+}
+
+func (s *LoginState) Expired(now time.Time) bool {
+	return now.Unix() >= s.ExpiresAt
+}
+
+func randomString() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
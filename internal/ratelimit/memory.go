@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many requests pass between sweeps of full buckets.
+const sweepEvery = 1000
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	rate    Rate
+}
+
+// MemoryStore keeps buckets in process memory. It suits a single instance;
+// use RedisStore when several instances share the limits.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	takes   int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, rate Rate, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.takes++
+	if s.takes%sweepEvery == 0 {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: rate.capacity(), updated: now}
+		s.buckets[key] = b
+	}
+	b.tokens = rate.refill(b.tokens, now.Sub(b.updated))
+	if now.After(b.updated) {
+		b.updated = now
+	}
+	b.rate = rate
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return rate.result(b.tokens, allowed), nil
+}
+
+// sweep drops buckets that have refilled completely; they are recreated full
+// on the next request.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if b.rate.refill(b.tokens, now.Sub(b.updated)) >= b.rate.capacity() {
+			delete(s.buckets, key)
+		}
+	}
+}
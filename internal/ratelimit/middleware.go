@@ -0,0 +1,195 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/config"
+	"test-ordent/internal/auth"
+	"test-ordent/internal/model"
+)
+
+// Request keys.
+const (
+	KeyIP     = "ip"
+	KeyUser   = "user"
+	KeyAPIKey = "api_key"
+)
+
+// Policy is a rate applied to a set of routes.
+type Policy struct {
+	Name    string
+	Methods []string
+	Paths   []string
+	Rate    Rate
+	Key     string
+}
+
+func newPolicy(cfg config.RateLimitPolicy) (Policy, error) {
+	p := Policy{
+		Name:    cfg.Name,
+		Methods: cfg.Methods,
+		Paths:   cfg.Paths,
+		Rate:    Rate{Requests: cfg.Requests, Per: cfg.Per, Burst: cfg.Burst},
+		Key:     cfg.Key,
+	}
+	if p.Key == "" {
+		p.Key = KeyIP
+	}
+	if p.Key != KeyIP && p.Key != KeyUser && p.Key != KeyAPIKey {
+		return p, fmt.Errorf("rate limit policy %q: unknown key %q", p.Name, p.Key)
+	}
+	if p.Rate.Requests < 0 || p.Rate.Burst < 0 || (p.Rate.Requests > 0 && p.Rate.Per <= 0) {
+		return p, fmt.Errorf("rate limit policy %q: requests, burst and per must be positive", p.Name)
+	}
+	return p, nil
+}
+
+func (p Policy) matches(method, path string) bool {
+	if len(p.Methods) > 0 && !containsFold(p.Methods, method) {
+		return false
+	}
+	for _, pattern := range p.Paths {
+		if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyIdentifier authenticates the API key a request carries and returns
+// its ID; ok is false without a valid key.
+type APIKeyIdentifier func(c echo.Context) (id int, ok bool)
+
+// Limiter applies the configured policies to every request.
+type Limiter struct {
+	store     Store
+	policies  []Policy
+	fallback  Policy
+	jwtSecret string
+	apiKeys   APIKeyIdentifier
+}
+
+// New validates the policies in cfg. The JWT secret and apiKeys are needed
+// to count requests by user and by API key, since the limiter runs before
+// authentication.
+func New(store Store, cfg config.RateLimitConfig, jwtSecret string, apiKeys APIKeyIdentifier) (*Limiter, error) {
+	fallback, err := newPolicy(cfg.Default)
+	if err != nil {
+		return nil, err
+	}
+	if fallback.Name == "" {
+		fallback.Name = "default"
+	}
+
+	l := &Limiter{store: store, fallback: fallback, jwtSecret: jwtSecret, apiKeys: apiKeys}
+	names := map[string]bool{fallback.Name: true}
+	for _, policyCfg := range cfg.Policies {
+		policy, err := newPolicy(policyCfg)
+		if err != nil {
+			return nil, err
+		}
+		if policy.Name == "" || names[policy.Name] {
+			return nil, fmt.Errorf("rate limit policies need unique names, got %q", policy.Name)
+		}
+		names[policy.Name] = true
+		l.policies = append(l.policies, policy)
+	}
+	return l, nil
+}
+
+// Middleware must be registered with Echo#Use, which runs after routing, so
+// policies can match route patterns.
+func (l *Limiter) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		policy := l.policyFor(c)
+		if policy.Rate.Requests == 0 {
+			return next(c)
+		}
+
+		res, err := l.store.Take(c.Request().Context(), policy.Name+":"+l.key(c, policy.Key), policy.Rate, time.Now())
+		if err != nil {
+			// Failing open keeps the API up when the store is down.
+			c.Logger().Errorf("rate limit: %v", err)
+			return next(c)
+		}
+
+		header := c.Response().Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", policy.Rate.Requests, ceilSeconds(policy.Rate.Per), res.Limit))
+
+		if !res.Allowed {
+			header.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			return c.JSON(http.StatusTooManyRequests, model.ErrorResponse{Error: "Too many requests, try again later"})
+		}
+		return next(c)
+	}
+}
+
+func (l *Limiter) policyFor(c echo.Context) Policy {
+	path := c.Path()
+	if path == "" {
+		path = c.Request().URL.Path
+	}
+	for _, policy := range l.policies {
+		if policy.matches(c.Request().Method, path) {
+			return policy
+		}
+	}
+	return l.fallback
+}
+
+// key identifies who a request is counted against. Only authenticated
+// credentials count: an API key falls back to the user, and both fall back to
+// the client IP, which is only taken from proxies the server trusts.
+func (l *Limiter) key(c echo.Context, kind string) string {
+	if kind == KeyAPIKey {
+		if id, ok := c.Get("api_key_id").(int); ok {
+			return "key:" + strconv.Itoa(id)
+		}
+		if l.apiKeys != nil {
+			if id, ok := l.apiKeys(c); ok {
+				return "key:" + strconv.Itoa(id)
+			}
+		}
+		kind = KeyUser
+	}
+	if kind == KeyUser {
+		header := c.Request().Header.Get("Authorization")
+		if token := strings.TrimPrefix(header, "Bearer "); token != header {
+			if claims, err := auth.ValidateToken(token, l.jwtSecret); err == nil {
+				return "user:" + strconv.FormatUint(uint64(claims.UserID), 10)
+			}
+		}
+	}
+	return "ip:" + c.RealIP()
+}
+
+func ceilSeconds(d time.Duration) int {
+	s := int(math.Ceil(d.Seconds()))
+	if s < 0 {
+		return 0
+	}
+	return s
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
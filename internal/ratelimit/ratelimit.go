@@ -0,0 +1,87 @@
+// Package ratelimit throttles requests with token buckets kept in memory or
+// in Redis.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"test-ordent/config"
+)
+
+// Rate allows Requests per Per on average, with bursts of up to Burst
+// requests. A bucket holds Burst tokens and refills at Requests/Per; every
+// request takes one token.
+type Rate struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+func (r Rate) capacity() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+	return float64(r.Requests)
+}
+
+// perSecond is the refill rate in tokens per second.
+func (r Rate) perSecond() float64 {
+	return float64(r.Requests) / r.Per.Seconds()
+}
+
+// refill returns the tokens in a bucket that held tokens elapsed ago.
+func (r Rate) refill(tokens float64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return tokens
+	}
+	return math.Min(r.capacity(), tokens+elapsed.Seconds()*r.perSecond())
+}
+
+// result describes a bucket left with tokens after a request.
+func (r Rate) result(tokens float64, allowed bool) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     int(r.capacity()),
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((r.capacity() - tokens) / r.perSecond()),
+	}
+	if !allowed {
+		res.RetryAfter = seconds((1 - tokens) / r.perSecond())
+	}
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until a rejected request would be allowed.
+	RetryAfter time.Duration
+}
+
+// Store keeps token buckets by key.
+type Store interface {
+	Take(ctx context.Context, key string, rate Rate, now time.Time) (Result, error)
+}
+
+// NewStore creates the store selected by cfg.Store.
+func NewStore(cfg config.RateLimitConfig) (Store, error) {
+	switch cfg.Store {
+	case "memory", "":
+		return NewMemoryStore(), nil
+	case "redis":
+		return NewRedisStore(newRedisClient(cfg.Redis)), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", cfg.Store)
+	}
+}
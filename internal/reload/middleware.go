@@ -0,0 +1,41 @@
+package reload
+
+import (
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Middleware is an Echo middleware that can be replaced while requests are
+// being served. Each request runs the middleware in effect when it started.
+type Middleware struct {
+	current atomic.Pointer[echo.MiddlewareFunc]
+}
+
+func NewMiddleware(mw echo.MiddlewareFunc) *Middleware {
+	m := &Middleware{}
+	m.Swap(mw)
+	return m
+}
+
+// Swap replaces the middleware for subsequent requests.
+func (m *Middleware) Swap(mw echo.MiddlewareFunc) {
+	m.current.Store(&mw)
+}
+
+// Handle is registered with Echo#Use.
+func (m *Middleware) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return (*m.current.Load())(next)(c)
+	}
+}
+
+// Chain combines middleware into one, running them in the given order.
+func Chain(mws ...echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		for i := len(mws) - 1; i >= 0; i-- {
+			next = mws[i](next)
+		}
+		return next
+	}
+}
@@ -0,0 +1,162 @@
+// Package reload applies configuration changes while the server runs. Only
+// settings tagged reload:"true" in package config may change; a reload that
+// touches anything else is refused and the running configuration is kept.
+package reload
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"test-ordent/config"
+	"test-ordent/pkg/logger"
+)
+
+// debounceDelay lets an editor finish writing the file before it is read.
+const debounceDelay = 200 * time.Millisecond
+
+// Component is rebuilt from every new configuration. It returns an error if
+// it cannot use cfg, or a function that puts cfg into effect. Nothing may
+// change before that function is called, so the reload can still be refused.
+type Component func(cfg *config.Config) (apply func(), err error)
+
+// Reloader reloads the configuration from its file, environment variables
+// and overrides, as at startup.
+type Reloader struct {
+	path       string
+	overrides  []string
+	logger     *logger.Logger
+	mu         sync.Mutex
+	current    *config.Config
+	components []Component
+}
+
+func New(path string, overrides []string, current *config.Config, logger *logger.Logger) *Reloader {
+	return &Reloader{
+		path:      path,
+		overrides: overrides,
+		logger:    logger,
+		current:   current,
+	}
+}
+
+// Register adds a component to rebuild on every reload.
+func (r *Reloader) Register(component Component) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.components = append(r.components, component)
+}
+
+// Current returns the configuration in effect.
+func (r *Reloader) Current() *config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Reload loads and validates the configuration and, if only reloadable
+// settings changed, applies it to every component and logs the changes. It
+// returns the changes applied.
+func (r *Reloader) Reload() ([]config.Change, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := config.LoadConfig(r.path, r.overrides...)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := config.Diff(r.current, next)
+	var immutable []string
+	for _, change := range changes {
+		if !change.Reloadable {
+			immutable = append(immutable, change.Key)
+		}
+	}
+	if len(immutable) > 0 {
+		return nil, fmt.Errorf("changes to %s require a restart", strings.Join(immutable, ", "))
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	applies := make([]func(), 0, len(r.components))
+	for _, component := range r.components {
+		apply, err := component(next)
+		if err != nil {
+			return nil, err
+		}
+		applies = append(applies, apply)
+	}
+	for _, apply := range applies {
+		apply()
+	}
+	r.current = next
+
+	for _, change := range changes {
+		r.logger.Info("config reloaded", "setting", change.Key, "old", change.Old, "new", change.New)
+	}
+	return changes, nil
+}
+
+// Watch reloads the configuration when its file changes or the process
+// receives SIGHUP, until ctx is cancelled. Refused reloads are logged.
+func (r *Reloader) Watch(ctx context.Context) error {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	// The directory is watched rather than the file, so files replaced by
+	// a rename (as editors and Kubernetes ConfigMaps do) are still seen.
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	if r.path != "" {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return err
+		}
+		defer watcher.Close()
+		if err := watcher.Add(filepath.Dir(r.path)); err != nil {
+			return err
+		}
+		events, errs = watcher.Events, watcher.Errors
+	}
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hangup:
+			r.reload("SIGHUP")
+		case event := <-events:
+			if r.watches(event.Name) {
+				debounce = time.After(debounceDelay)
+			}
+		case err := <-errs:
+			r.logger.Error("config watch failed", "error", err)
+		case <-debounce:
+			debounce = nil
+			r.reload("file change")
+		}
+	}
+}
+
+func (r *Reloader) watches(name string) bool {
+	// Kubernetes swaps the ..data symlink that the file points through.
+	return filepath.Clean(name) == filepath.Clean(r.path) || filepath.Base(name) == "..data"
+}
+
+func (r *Reloader) reload(trigger string) {
+	if _, err := r.Reload(); err != nil {
+		r.logger.Error("config reload refused, keeping the running configuration", "trigger", trigger, "error", err)
+	}
+}
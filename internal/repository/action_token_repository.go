@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// ActionTokenRepository tracks the nonces of issued action tokens so each
+// token can be redeemed only once.
+type ActionTokenRepository interface {
+	Create(ctx context.Context, userID uint, purpose, nonce string, expiresAt time.Time) error
+	Consume(ctx context.Context, userID uint, purpose, nonce string) error
+}
+
+type PostgresActionTokenRepository struct {
+	db *sql.DB
+}
+
+func NewActionTokenRepository(db *sql.DB) ActionTokenRepository {
+	return &PostgresActionTokenRepository{db: db}
+}
+
+// Create stores a new nonce. Unused tokens the user was given earlier for the
+// same purpose stop working, so only the latest email link is valid.
+func (r *PostgresActionTokenRepository) Create(ctx context.Context, userID uint, purpose, nonce string, expiresAt time.Time) error {
+	ctx, span := startSpan(ctx, "ActionTokenRepository.Create")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM action_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL", userID, purpose)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO action_tokens (user_id, purpose, nonce_hash, expires_at) VALUES ($1, $2, $3, $4)",
+		userID, purpose, hashNonce(nonce), expiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Consume marks the nonce as used. It fails if the nonce is unknown, was
+// already used, was superseded or has expired.
+func (r *PostgresActionTokenRepository) Consume(ctx context.Context, userID uint, purpose, nonce string) error {
+	ctx, span := startSpan(ctx, "ActionTokenRepository.Consume")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE action_tokens SET used_at = NOW()
+		WHERE nonce_hash = $1 AND user_id = $2 AND purpose = $3 AND used_at IS NULL AND expires_at > NOW()`,
+		hashNonce(nonce), userID, purpose,
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return errors.New("token already used")
+	}
+	return nil
+}
+
+// hashNonce keeps raw nonces out of the database, so a leaked table cannot be
+// turned back into working tokens.
+func hashNonce(nonce string) string {
+	sum := sha256.Sum256([]byte(nonce))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,189 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"test-ordent/internal/model"
+)
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *model.APIKey) (*model.APIKey, error)
+	List(ctx context.Context) ([]model.APIKey, error)
+	FindByPrefix(ctx context.Context, prefix string) (*model.APIKey, error)
+	Revoke(ctx context.Context, id int) (*model.APIKey, error)
+	TouchLastUsed(ctx context.Context, id int, at time.Time) error
+}
+
+type PostgresAPIKeyRepository struct {
+	db *sql.DB
+}
+
+func NewAPIKeyRepository(db *sql.DB) APIKeyRepository {
+	return &PostgresAPIKeyRepository{db: db}
+}
+
+const apiKeyColumns = `k.id, k.name, k.prefix, k.key_hash, k.permissions, k.created_by, COALESCE(u.username, ''),
+	k.expires_at, k.last_used_at, k.revoked_at, k.created_at`
+
+const apiKeyFrom = " FROM api_keys k LEFT JOIN users u ON u.id = k.created_by"
+
+func scanAPIKey(row rowScanner) (*model.APIKey, error) {
+	var key model.APIKey
+	var createdBy sql.NullInt64
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.KeyHash, pq.Array(&key.Permissions), &createdBy,
+		&key.CreatedByName, &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt, &key.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("api key not found")
+		}
+		return nil, err
+	}
+	if createdBy.Valid {
+		id := uint(createdBy.Int64)
+		key.CreatedBy = &id
+	}
+
+	switch {
+	case key.RevokedAt != nil:
+		key.Status = model.APIKeyRevoked
+	case !key.ExpiresAt.After(time.Now()):
+		key.Status = model.APIKeyExpired
+	default:
+		key.Status = model.APIKeyActive
+	}
+	return &key, nil
+}
+
+func findAPIKey(ctx context.Context, q rowQuerier, id int) (*model.APIKey, error) {
+	return scanAPIKey(q.QueryRowContext(ctx, "SELECT "+apiKeyColumns+apiKeyFrom+" WHERE k.id = $1", id))
+}
+
+func (r *PostgresAPIKeyRepository) Create(ctx context.Context, key *model.APIKey) (*model.APIKey, error) {
+	ctx, span := startSpan(ctx, "APIKeyRepository.Create")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO api_keys (name, prefix, key_hash, permissions, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		key.Name, key.Prefix, key.KeyHash, pq.Array(key.Permissions), key.CreatedBy, key.ExpiresAt,
+	).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	created, err := findAPIKey(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordAudit(ctx, tx, model.AuditAPIKeyCreate, model.AuditEntityAPIKey, id, nil, created); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+func (r *PostgresAPIKeyRepository) List(ctx context.Context) ([]model.APIKey, error) {
+	ctx, span := startSpan(ctx, "APIKeyRepository.List")
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+apiKeyColumns+apiKeyFrom+" ORDER BY k.created_at DESC, k.id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []model.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (r *PostgresAPIKeyRepository) FindByPrefix(ctx context.Context, prefix string) (*model.APIKey, error) {
+	ctx, span := startSpan(ctx, "APIKeyRepository.FindByPrefix")
+	defer span.End()
+
+	return scanAPIKey(r.db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+apiKeyFrom+" WHERE k.prefix = $1", prefix))
+}
+
+func (r *PostgresAPIKeyRepository) Revoke(ctx context.Context, id int) (*model.APIKey, error) {
+	ctx, span := startSpan(ctx, "APIKeyRepository.Revoke")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	before, err := findAPIKey(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if before.RevokedAt != nil {
+		return nil, errors.New("api key already revoked")
+	}
+
+	// A concurrent revocation leaves nothing for this one to update.
+	result, err := tx.ExecContext(ctx, "UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireRowAffected(result, "api key already revoked"); err != nil {
+		return nil, err
+	}
+	revoked, err := findAPIKey(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordAudit(ctx, tx, model.AuditAPIKeyRevoke, model.AuditEntityAPIKey, id, before, revoked); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return revoked, nil
+}
+
+// TouchLastUsed records that a key was used. Writes are skipped while the
+// recorded time is less than a minute old, so busy integrations do not cause
+// an update per request.
+func (r *PostgresAPIKeyRepository) TouchLastUsed(ctx context.Context, id int, at time.Time) error {
+	ctx, span := startSpan(ctx, "APIKeyRepository.TouchLastUsed")
+	defer span.End()
+
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE api_keys SET last_used_at = $2
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2 - INTERVAL '1 minute')`,
+		id, at,
+	)
+	return err
+}
@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"test-ordent/internal/audit"
+	"test-ordent/internal/model"
+)
+
+// AuditEventRepository reads the audit log. Events are written by the
+// repositories making the changes, in the same transaction.
+type AuditEventRepository interface {
+	List(ctx context.Context, filter model.AuditFilter) ([]model.AuditEvent, int, error)
+	Verify(ctx context.Context) (*model.AuditVerification, error)
+}
+
+type PostgresAuditEventRepository struct {
+	db *sql.DB
+}
+
+func NewAuditEventRepository(db *sql.DB) AuditEventRepository {
+	return &PostgresAuditEventRepository{db: db}
+}
+
+// auditChainLock is the key of the transaction-level advisory lock that
+// serialises writes to the audit log, so every event is chained onto the one
+// committed before it. It is held from the first audit insert until commit,
+// so audited transactions record their events last: a transaction holding
+// it never waits for a row lock, which could deadlock with one that holds
+// the row and waits for the chain, and other transactions queue for it only
+// for the end of an audited one.
+const auditChainLock = 0x61756469
+
+const auditEventColumns = `id, actor_user_id, actor_api_key_id, action, entity_type, entity_id, before, after,
+	ip_address, user_agent, request_id, trace_id, prev_hash, hash, created_at`
+
+func scanAuditEvent(row rowScanner) (*model.AuditEvent, error) {
+	var e model.AuditEvent
+	var actorUserID, actorAPIKeyID sql.NullInt64
+	var before, after []byte
+	err := row.Scan(&e.ID, &actorUserID, &actorAPIKeyID, &e.Action, &e.EntityType, &e.EntityID, &before, &after,
+		&e.IPAddress, &e.UserAgent, &e.RequestID, &e.TraceID, &e.PrevHash, &e.Hash, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if actorUserID.Valid {
+		id := uint(actorUserID.Int64)
+		e.ActorUserID = &id
+	}
+	if actorAPIKeyID.Valid {
+		id := int(actorAPIKeyID.Int64)
+		e.ActorAPIKeyID = &id
+	}
+	e.Before = before
+	e.After = after
+	return &e, nil
+}
+
+// lockAuditChain takes the audit chain lock for the rest of tx.
+func lockAuditChain(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", auditChainLock)
+	return err
+}
+
+// auditEntry is an audit event to be recorded at the end of a transaction
+// that changes several entities.
+type auditEntry struct {
+	action, entityType string
+	entityID           interface{}
+	before, after      interface{}
+}
+
+// recordAudits records entries in order.
+func recordAudits(ctx context.Context, tx *sql.Tx, entries []auditEntry) error {
+	for _, e := range entries {
+		if err := recordAudit(ctx, tx, e.action, e.entityType, e.entityID, e.before, e.after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordAudit appends an event for a change made in tx, with the actor and
+// request details carried by ctx. before and after are the states of the
+// entity, nil when it did not or no longer exists. It takes the audit chain
+// lock, so it has to be the last write of the transaction.
+func recordAudit(ctx context.Context, tx *sql.Tx, action, entityType string, entityID interface{}, before, after interface{}) error {
+	meta := audit.FromContext(ctx)
+	event := model.AuditEvent{
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   fmt.Sprint(entityID),
+		IPAddress:  meta.IPAddress,
+		UserAgent:  meta.UserAgent,
+		RequestID:  meta.RequestID,
+		TraceID:    meta.TraceID,
+		// Stored with microsecond precision; truncated so the hash can be
+		// recomputed from the stored value.
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if meta.ActorUserID != 0 {
+		event.ActorUserID = &meta.ActorUserID
+	}
+	if meta.ActorAPIKeyID != 0 {
+		event.ActorAPIKeyID = &meta.ActorAPIKeyID
+	}
+
+	var err error
+	if event.Before, err = audit.Snapshot(before); err != nil {
+		return err
+	}
+	if event.After, err = audit.Snapshot(after); err != nil {
+		return err
+	}
+
+	if err := lockAuditChain(ctx, tx); err != nil {
+		return err
+	}
+	err = tx.QueryRowContext(ctx, "SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1").Scan(&event.PrevHash)
+	if err == sql.ErrNoRows {
+		event.PrevHash, err = audit.GenesisHash, nil
+	}
+	if err != nil {
+		return err
+	}
+	if event.Hash, err = audit.Hash(&event); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO audit_events (actor_user_id, actor_api_key_id, action, entity_type, entity_id, before, after,
+			ip_address, user_agent, request_id, trace_id, prev_hash, hash, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		event.ActorUserID, event.ActorAPIKeyID, event.Action, event.EntityType, event.EntityID, nullJSON(event.Before), nullJSON(event.After),
+		event.IPAddress, event.UserAgent, event.RequestID, event.TraceID, event.PrevHash, event.Hash, event.CreatedAt,
+	)
+	return err
+}
+
+func nullJSON(raw []byte) interface{} {
+	if raw == nil {
+		return nil
+	}
+	return string(raw)
+}
+
+// List returns a page of events matching filter, newest first, along with
+// the total number of matches.
+func (r *PostgresAuditEventRepository) List(ctx context.Context, filter model.AuditFilter) ([]model.AuditEvent, int, error) {
+	ctx, span := startSpan(ctx, "AuditEventRepository.List")
+	defer span.End()
+
+	where := "WHERE ($1 = 0 OR actor_user_id = $1) AND ($2 = '' OR action = $2) AND ($3 = '' OR entity_type = $3)" +
+		" AND ($4 = '' OR entity_id = $4) AND ($5::timestamptz IS NULL OR created_at >= $5) AND ($6::timestamptz IS NULL OR created_at < $6)"
+	args := []interface{}{filter.ActorUserID, filter.Action, filter.EntityType, filter.EntityID, filter.From, filter.To}
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_events "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+auditEventColumns+" FROM audit_events "+where+" ORDER BY id DESC LIMIT $7 OFFSET $8",
+		append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []model.AuditEvent{}
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		events = append(events, *event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+// Verify walks the whole log in insertion order and recomputes the hash
+// chain, stopping at the first event that does not match.
+func (r *PostgresAuditEventRepository) Verify(ctx context.Context) (*model.AuditVerification, error) {
+	ctx, span := startSpan(ctx, "AuditEventRepository.Verify")
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+auditEventColumns+" FROM audit_events ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	verifier := audit.NewVerifier()
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifier.Next(event); err != nil {
+			return &model.AuditVerification{
+				Checked:    verifier.Checked(),
+				LastHash:   verifier.LastHash(),
+				BrokenAtID: &event.ID,
+				Problem:    err.Error(),
+			}, nil
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &model.AuditVerification{Valid: true, Checked: verifier.Checked(), LastHash: verifier.LastHash()}, nil
+}
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 
@@ -9,17 +10,17 @@ import (
 )
 
 type CartRepository interface {
-	FindByUserID(userID uint) (*model.Cart, error)
-	Create(userID uint) (uint, error)
-	GetCartItems(cartID uint) ([]model.CartItemDetail, error)
-	AddItem(cartID uint, productID uint, quantity int) error
-	UpdateItemQuantity(itemID uint, quantity int) error
-	RemoveItem(itemID uint) error
-	ClearItems(cartID uint) error
-	UpdateLastModified(cartID uint) error
-	FindCartItemByID(itemID uint) (*model.CartItem, error)
-	FindCartItemByProductID(cartID uint, productID uint) (*model.CartItem, error)
-	ClearCart(cartID uint) error
+	FindByUserID(ctx context.Context, userID uint) (*model.Cart, error)
+	Create(ctx context.Context, userID uint) (uint, error)
+	GetCartItems(ctx context.Context, cartID uint) ([]model.CartItemDetail, error)
+	AddItem(ctx context.Context, cartID uint, productID uint, quantity int) error
+	UpdateItemQuantity(ctx context.Context, itemID uint, quantity int) error
+	RemoveItem(ctx context.Context, itemID uint) error
+	ClearItems(ctx context.Context, cartID uint) error
+	UpdateLastModified(ctx context.Context, cartID uint) error
+	FindCartItemByID(ctx context.Context, itemID uint) (*model.CartItem, error)
+	FindCartItemByProductID(ctx context.Context, cartID uint, productID uint) (*model.CartItem, error)
+	ClearCart(ctx context.Context, cartID uint) error
 }
 
 type PostgresCartRepository struct {
@@ -30,11 +31,14 @@ func NewCartRepository(db *sql.DB) CartRepository {
 	return &PostgresCartRepository{db: db}
 }
 
-func (r *PostgresCartRepository) FindByUserID(userID uint) (*model.Cart, error) {
+func (r *PostgresCartRepository) FindByUserID(ctx context.Context, userID uint) (*model.Cart, error) {
+    ctx, span := startSpan(ctx, "CartRepository.FindByUserID")
+    defer span.End()
+
     var cart model.Cart
     var updatedAt sql.NullTime
     
-    err := r.db.QueryRow("SELECT id, user_id, created_at, updated_at FROM cart WHERE user_id = $1", userID).
+    err := r.db.QueryRowContext(ctx, "SELECT id, user_id, created_at, updated_at FROM cart WHERE user_id = $1", userID).
         Scan(&cart.ID, &cart.UserID, &cart.CreatedAt, &updatedAt)
     
     if err != nil {
@@ -48,9 +52,12 @@ func (r *PostgresCartRepository) FindByUserID(userID uint) (*model.Cart, error)
     return &cart, nil
 }
 
-func (r *PostgresCartRepository) Create(userID uint) (uint, error) {
+func (r *PostgresCartRepository) Create(ctx context.Context, userID uint) (uint, error) {
+    ctx, span := startSpan(ctx, "CartRepository.Create")
+    defer span.End()
+
     var id uint
-    err := r.db.QueryRow(`
+    err := r.db.QueryRowContext(ctx, `
         INSERT INTO cart (user_id, created_at, updated_at) 
         VALUES ($1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP) 
         RETURNING id
@@ -61,12 +68,15 @@ func (r *PostgresCartRepository) Create(userID uint) (uint, error) {
     return id, nil
 }
 
-func (r *PostgresCartRepository) GetCartItems(cartID uint) ([]model.CartItemDetail, error) {
-	rows, err := r.db.Query(`
-		SELECT ci.id, ci.product_id, p.name, p.price, ci.quantity
+func (r *PostgresCartRepository) GetCartItems(ctx context.Context, cartID uint) ([]model.CartItemDetail, error) {
+	ctx, span := startSpan(ctx, "CartRepository.GetCartItems")
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT ci.id, ci.product_id, p.name, COALESCE(`+activeSalePriceSQL("p.id")+`, p.price), ci.quantity
 		FROM cart_items ci
 		JOIN products p ON ci.product_id = p.id
-		WHERE ci.cart_id = $1
+		WHERE ci.cart_id = $1 AND p.deleted_at IS NULL
 	`, cartID)
 	if err != nil {
 		return nil, err
@@ -90,14 +100,20 @@ func (r *PostgresCartRepository) GetCartItems(cartID uint) ([]model.CartItemDeta
 	return items, nil
 }
 
-func (r *PostgresCartRepository) AddItem(cartID uint, productID uint, quantity int) error {
-	_, err := r.db.Exec("INSERT INTO cart_items (cart_id, product_id, quantity) VALUES ($1, $2, $3)",
+func (r *PostgresCartRepository) AddItem(ctx context.Context, cartID uint, productID uint, quantity int) error {
+	ctx, span := startSpan(ctx, "CartRepository.AddItem")
+	defer span.End()
+
+	_, err := r.db.ExecContext(ctx, "INSERT INTO cart_items (cart_id, product_id, quantity) VALUES ($1, $2, $3)",
 		cartID, productID, quantity)
 	return err
 }
 
-func (r *PostgresCartRepository) UpdateItemQuantity(itemID uint, quantity int) error {
-	result, err := r.db.Exec("UPDATE cart_items SET quantity = $1, updated_at = NOW() WHERE id = $2",
+func (r *PostgresCartRepository) UpdateItemQuantity(ctx context.Context, itemID uint, quantity int) error {
+	ctx, span := startSpan(ctx, "CartRepository.UpdateItemQuantity")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx, "UPDATE cart_items SET quantity = $1, updated_at = NOW() WHERE id = $2",
 		quantity, itemID)
 	if err != nil {
 		return err
@@ -115,8 +131,11 @@ func (r *PostgresCartRepository) UpdateItemQuantity(itemID uint, quantity int) e
 	return nil
 }
 
-func (r *PostgresCartRepository) RemoveItem(itemID uint) error {
-	result, err := r.db.Exec("DELETE FROM cart_items WHERE id = $1", itemID)
+func (r *PostgresCartRepository) RemoveItem(ctx context.Context, itemID uint) error {
+	ctx, span := startSpan(ctx, "CartRepository.RemoveItem")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx, "DELETE FROM cart_items WHERE id = $1", itemID)
 	if err != nil {
 		return err
 	}
@@ -133,21 +152,30 @@ func (r *PostgresCartRepository) RemoveItem(itemID uint) error {
 	return nil
 }
 
-func (r *PostgresCartRepository) ClearItems(cartID uint) error {
-	_, err := r.db.Exec("DELETE FROM cart_items WHERE cart_id = $1", cartID)
+func (r *PostgresCartRepository) ClearItems(ctx context.Context, cartID uint) error {
+	ctx, span := startSpan(ctx, "CartRepository.ClearItems")
+	defer span.End()
+
+	_, err := r.db.ExecContext(ctx, "DELETE FROM cart_items WHERE cart_id = $1", cartID)
 	return err
 }
 
-func (r *PostgresCartRepository) UpdateLastModified(cartID uint) error {
-	_, err := r.db.Exec("UPDATE cart SET updated_at = NOW() WHERE id = $1", cartID)
+func (r *PostgresCartRepository) UpdateLastModified(ctx context.Context, cartID uint) error {
+	ctx, span := startSpan(ctx, "CartRepository.UpdateLastModified")
+	defer span.End()
+
+	_, err := r.db.ExecContext(ctx, "UPDATE cart SET updated_at = NOW() WHERE id = $1", cartID)
 	return err
 }
 
-func (r *PostgresCartRepository) FindCartItemByID(itemID uint) (*model.CartItem, error) {
+func (r *PostgresCartRepository) FindCartItemByID(ctx context.Context, itemID uint) (*model.CartItem, error) {
+    ctx, span := startSpan(ctx, "CartRepository.FindCartItemByID")
+    defer span.End()
+
     var item model.CartItem
     var updatedAt sql.NullTime
     
-    err := r.db.QueryRow(`
+    err := r.db.QueryRowContext(ctx, `
         SELECT id, cart_id, product_id, quantity, created_at, updated_at 
         FROM cart_items WHERE id = $1
     `, itemID).Scan(&item.ID, &item.CartID, &item.ProductID, &item.Quantity, &item.CreatedAt, &updatedAt)
@@ -163,11 +191,14 @@ func (r *PostgresCartRepository) FindCartItemByID(itemID uint) (*model.CartItem,
     return &item, nil
 }
 
-func (r *PostgresCartRepository) FindCartItemByProductID(cartID uint, productID uint) (*model.CartItem, error) {
+func (r *PostgresCartRepository) FindCartItemByProductID(ctx context.Context, cartID uint, productID uint) (*model.CartItem, error) {
+    ctx, span := startSpan(ctx, "CartRepository.FindCartItemByProductID")
+    defer span.End()
+
     var item model.CartItem
     var updatedAt sql.NullTime
     
-    err := r.db.QueryRow(`
+    err := r.db.QueryRowContext(ctx, `
         SELECT id, cart_id, product_id, quantity, created_at, updated_at 
         FROM cart_items WHERE cart_id = $1 AND product_id = $2
     `, cartID, productID).Scan(&item.ID, &item.CartID, &item.ProductID, &item.Quantity, &item.CreatedAt, &updatedAt)
@@ -183,7 +214,10 @@ func (r *PostgresCartRepository) FindCartItemByProductID(cartID uint, productID
     return &item, nil
 }
 
-func (r *PostgresCartRepository) ClearCart(cartID uint) error {
-    _, err := r.db.Exec("DELETE FROM cart_items WHERE cart_id = $1", cartID)
+func (r *PostgresCartRepository) ClearCart(ctx context.Context, cartID uint) error {
+    ctx, span := startSpan(ctx, "CartRepository.ClearCart")
+    defer span.End()
+
+    _, err := r.db.ExecContext(ctx, "DELETE FROM cart_items WHERE cart_id = $1", cartID)
     return err
 }
\ No newline at end of file
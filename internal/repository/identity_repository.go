@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+
+	"test-ordent/internal/model"
+)
+
+type IdentityRepository interface {
+	FindUser(ctx context.Context, provider, subject string) (*model.User, error)
+	Link(ctx context.Context, userID uint, provider, subject, email string) error
+	CreateUser(ctx context.Context, user *model.User, provider, subject string) (*model.User, error)
+	RecordLogin(ctx context.Context, provider, subject string) error
+	ListByUser(ctx context.Context, userID uint) ([]model.UserIdentity, error)
+}
+
+type PostgresIdentityRepository struct {
+	db *sql.DB
+}
+
+func NewIdentityRepository(db *sql.DB) IdentityRepository {
+	return &PostgresIdentityRepository{db: db}
+}
+
+// FindUser returns the user an external identity is linked to.
+func (r *PostgresIdentityRepository) FindUser(ctx context.Context, provider, subject string) (*model.User, error) {
+	ctx, span := startSpan(ctx, "IdentityRepository.FindUser")
+	defer span.End()
+
+	user, err := scanUser(r.db.QueryRowContext(ctx,
+		"SELECT "+userColumns+" FROM users WHERE id = (SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2)",
+		provider, subject,
+	))
+	if err != nil {
+		if err.Error() == "user not found" {
+			return nil, errors.New("identity not found")
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func (r *PostgresIdentityRepository) Link(ctx context.Context, userID uint, provider, subject, email string) error {
+	ctx, span := startSpan(ctx, "IdentityRepository.Link")
+	defer span.End()
+
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO user_identities (user_id, provider, subject, email, last_login_at) VALUES ($1, $2, $3, $4, NOW())",
+		userID, provider, subject, email,
+	)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return errors.New("identity already linked")
+		}
+		return err
+	}
+	return nil
+}
+
+// CreateUser creates an account for an external identity and links the two
+// in one transaction. The account has no password.
+func (r *PostgresIdentityRepository) CreateUser(ctx context.Context, user *model.User, provider, subject string) (*model.User, error) {
+	ctx, span := startSpan(ctx, "IdentityRepository.CreateUser")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO users (username, email, password_hash, full_name, role, email_verified)
+		VALUES ($1, $2, '', $3, $4, $5) RETURNING id`,
+		user.Username, user.Email, user.FullName, user.Role, user.EmailVerified,
+	).Scan(&user.ID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, errors.New("username or email already exists")
+		}
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO user_identities (user_id, provider, subject, email, last_login_at) VALUES ($1, $2, $3, $4, NOW())",
+		user.ID, provider, subject, user.Email,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	user.Status = model.UserStatusActive
+	return user, nil
+}
+
+func (r *PostgresIdentityRepository) RecordLogin(ctx context.Context, provider, subject string) error {
+	ctx, span := startSpan(ctx, "IdentityRepository.RecordLogin")
+	defer span.End()
+
+	_, err := r.db.ExecContext(ctx, "UPDATE user_identities SET last_login_at = NOW() WHERE provider = $1 AND subject = $2", provider, subject)
+	return err
+}
+
+func (r *PostgresIdentityRepository) ListByUser(ctx context.Context, userID uint) ([]model.UserIdentity, error) {
+	ctx, span := startSpan(ctx, "IdentityRepository.ListByUser")
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id, user_id, provider, subject, email, created_at, last_login_at FROM user_identities WHERE user_id = $1 ORDER BY created_at",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []model.UserIdentity{}
+	for rows.Next() {
+		var identity model.UserIdentity
+		if err := rows.Scan(&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Email,
+			&identity.CreatedAt, &identity.LastLoginAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
@@ -0,0 +1,241 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"test-ordent/internal/audit"
+	"test-ordent/internal/model"
+)
+
+type InvitationRepository interface {
+	Create(ctx context.Context, email, role string, invitedBy uint, tokenHash string, expiresAt time.Time) (*model.Invitation, error)
+	List(ctx context.Context) ([]model.Invitation, error)
+	Revoke(ctx context.Context, id int) (*model.Invitation, error)
+	Accept(ctx context.Context, tokenHash string, user *model.User) (*model.User, error)
+}
+
+type PostgresInvitationRepository struct {
+	db *sql.DB
+}
+
+func NewInvitationRepository(db *sql.DB) InvitationRepository {
+	return &PostgresInvitationRepository{db: db}
+}
+
+const invitationColumns = `i.id, i.email, i.role, i.invited_by, COALESCE(u.username, ''), i.expires_at,
+	i.accepted_at, i.accepted_user_id, i.revoked_at, i.created_at`
+
+func scanInvitation(row rowScanner) (*model.Invitation, error) {
+	var inv model.Invitation
+	var invitedBy, acceptedUserID sql.NullInt64
+	err := row.Scan(&inv.ID, &inv.Email, &inv.Role, &invitedBy, &inv.InvitedByName, &inv.ExpiresAt,
+		&inv.AcceptedAt, &acceptedUserID, &inv.RevokedAt, &inv.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("invitation not found")
+		}
+		return nil, err
+	}
+	if invitedBy.Valid {
+		id := uint(invitedBy.Int64)
+		inv.InvitedBy = &id
+	}
+	if acceptedUserID.Valid {
+		id := uint(acceptedUserID.Int64)
+		inv.AcceptedUserID = &id
+	}
+
+	switch {
+	case inv.AcceptedAt != nil:
+		inv.Status = model.InvitationAccepted
+	case inv.RevokedAt != nil:
+		inv.Status = model.InvitationRevoked
+	case !inv.ExpiresAt.After(time.Now()):
+		inv.Status = model.InvitationExpired
+	default:
+		inv.Status = model.InvitationPending
+	}
+	return &inv, nil
+}
+
+// rowQuerier is satisfied by *sql.DB and *sql.Tx.
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func findInvitation(ctx context.Context, q rowQuerier, id int) (*model.Invitation, error) {
+	return scanInvitation(q.QueryRowContext(ctx, "SELECT "+invitationColumns+" FROM admin_invitations i LEFT JOIN users u ON u.id = i.invited_by WHERE i.id = $1", id))
+}
+
+func (r *PostgresInvitationRepository) Create(ctx context.Context, email, role string, invitedBy uint, tokenHash string, expiresAt time.Time) (*model.Invitation, error) {
+	ctx, span := startSpan(ctx, "InvitationRepository.Create")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO admin_invitations (email, role, invited_by, token_hash, expires_at)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5) RETURNING id`,
+		email, role, invitedBy, tokenHash, expiresAt,
+	).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	inv, err := findInvitation(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordAudit(ctx, tx, model.AuditInvitationCreate, model.AuditEntityInvitation, id, nil, inv); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func (r *PostgresInvitationRepository) List(ctx context.Context) ([]model.Invitation, error) {
+	ctx, span := startSpan(ctx, "InvitationRepository.List")
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+invitationColumns+" FROM admin_invitations i LEFT JOIN users u ON u.id = i.invited_by ORDER BY i.created_at DESC, i.id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []model.Invitation{}
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, *inv)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// Revoke cancels an invitation that has not been accepted yet.
+func (r *PostgresInvitationRepository) Revoke(ctx context.Context, id int) (*model.Invitation, error) {
+	ctx, span := startSpan(ctx, "InvitationRepository.Revoke")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	before, err := findInvitation(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.ExecContext(ctx, "UPDATE admin_invitations SET revoked_at = NOW() WHERE id = $1 AND accepted_at IS NULL AND revoked_at IS NULL", id)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		return nil, errors.New("invitation already " + before.Status)
+	}
+
+	inv, err := findInvitation(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordAudit(ctx, tx, model.AuditInvitationRevoke, model.AuditEntityInvitation, id, before, inv); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// Accept redeems a pending invitation and creates the account in one
+// transaction, so an invitation can never produce two accounts. The account
+// gets the invitation's email and role; its email counts as verified since
+// the invitation was sent there. The new account is audited as created by
+// itself, since the invitee is not signed in yet.
+func (r *PostgresInvitationRepository) Accept(ctx context.Context, tokenHash string, user *model.User) (*model.User, error) {
+	ctx, span := startSpan(ctx, "InvitationRepository.Accept")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var invitationID int
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, email, role FROM admin_invitations
+		WHERE token_hash = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+		FOR UPDATE`,
+		tokenHash,
+	).Scan(&invitationID, &user.Email, &user.Role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("invitation not found")
+		}
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO users (username, email, password_hash, full_name, role, email_verified)
+		VALUES ($1, $2, $3, $4, $5, TRUE) RETURNING id`,
+		user.Username, user.Email, user.PasswordHash, user.FullName, user.Role,
+	).Scan(&user.ID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, errors.New("username or email already exists")
+		}
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE admin_invitations SET accepted_at = NOW(), accepted_user_id = $1 WHERE id = $2", user.ID, invitationID)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := scanUser(tx.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", user.ID))
+	if err != nil {
+		return nil, err
+	}
+	meta := audit.FromContext(ctx)
+	meta.ActorUserID, meta.ActorAPIKeyID = user.ID, 0
+	if err := recordAudit(audit.NewContext(ctx, meta), tx, model.AuditUserCreate, model.AuditEntityUser, user.ID, nil, created); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	user.EmailVerified = true
+	user.Status = model.UserStatusActive
+	return user, nil
+}
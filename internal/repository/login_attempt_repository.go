@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"test-ordent/internal/model"
+)
+
+// PostgresAttemptStore keeps failed login counters and attempts in progress
+// in the database so every server instance sees the same counts. It implements auth.AttemptStore.
+type PostgresAttemptStore struct {
+	db *sql.DB
+}
+
+func NewPostgresAttemptStore(db *sql.DB) *PostgresAttemptStore {
+	return &PostgresAttemptStore{db: db}
+}
+
+func (s *PostgresAttemptStore) Reserve(ctx context.Context, key string, now time.Time, resetAfter time.Duration, blocked func(model.LoginAttempts) time.Duration) (time.Duration, error) {
+	ctx, span := startSpan(ctx, "AttemptStore.Reserve")
+	defer span.End()
+
+	var wait time.Duration
+	err := s.change(ctx, key, now, resetAfter, func(attempts *model.LoginAttempts) bool {
+		if wait = blocked(*attempts); wait > 0 {
+			return false
+		}
+		attempts.Pending++
+		attempts.LastAttempt = now
+		return true
+	})
+	return wait, err
+}
+
+func (s *PostgresAttemptStore) Fail(ctx context.Context, key string, now time.Time, resetAfter time.Duration) error {
+	ctx, span := startSpan(ctx, "AttemptStore.Fail")
+	defer span.End()
+
+	return s.change(ctx, key, now, resetAfter, func(attempts *model.LoginAttempts) bool {
+		if attempts.Pending > 0 {
+			attempts.Pending--
+		}
+		attempts.Failures++
+		attempts.LastFailure = now
+		return true
+	})
+}
+
+// change applies update to the counter at key, with stale failures and
+// attempts cleared, and saves it if update returns true. The row is created
+// first so that concurrent changes queue on its lock.
+func (s *PostgresAttemptStore) change(ctx context.Context, key string, now time.Time, resetAfter time.Duration, update func(*model.LoginAttempts) bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO login_attempts (key, failures, last_failure, pending, last_attempt) VALUES ($1, 0, $2, 0, $2)
+		ON CONFLICT (key) DO NOTHING`, key, now); err != nil {
+		return err
+	}
+	var attempts model.LoginAttempts
+	if err := tx.QueryRowContext(ctx, "SELECT failures, last_failure, pending, last_attempt FROM login_attempts WHERE key = $1 FOR UPDATE", key).
+		Scan(&attempts.Failures, &attempts.LastFailure, &attempts.Pending, &attempts.LastAttempt); err != nil {
+		return err
+	}
+	attempts = attempts.Expire(now, resetAfter)
+	if !update(&attempts) {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE login_attempts SET failures = $2, last_failure = $3, pending = $4, last_attempt = $5 WHERE key = $1",
+		key, attempts.Failures, attempts.LastFailure, attempts.Pending, attempts.LastAttempt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresAttemptStore) Release(ctx context.Context, key string) error {
+	ctx, span := startSpan(ctx, "AttemptStore.Release")
+	defer span.End()
+
+	_, err := s.db.ExecContext(ctx, "UPDATE login_attempts SET pending = pending - 1 WHERE key = $1 AND pending > 0", key)
+	return err
+}
+
+func (s *PostgresAttemptStore) Reset(ctx context.Context, key string) error {
+	ctx, span := startSpan(ctx, "AttemptStore.Reset")
+	defer span.End()
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE key = $1", key)
+	return err
+}
+
+// LoginAuditRepository records failed logins for later review.
+type LoginAuditRepository interface {
+	RecordFailure(ctx context.Context, failure *model.LoginFailure) error
+}
+
+type PostgresLoginAuditRepository struct {
+	db *sql.DB
+}
+
+func NewLoginAuditRepository(db *sql.DB) LoginAuditRepository {
+	return &PostgresLoginAuditRepository{db: db}
+}
+
+func (r *PostgresLoginAuditRepository) RecordFailure(ctx context.Context, failure *model.LoginFailure) error {
+	ctx, span := startSpan(ctx, "LoginAuditRepository.RecordFailure")
+	defer span.End()
+
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO login_failures (username, user_id, ip_address, user_agent, reason)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5)`,
+		failure.Username, failure.UserID, failure.IPAddress, failure.UserAgent, failure.Reason,
+	)
+	return err
+}
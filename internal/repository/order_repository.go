@@ -1,34 +1,60 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"test-ordent/internal/model"
 )
 
 type OrderRepository interface {
-	Create(userID uint, totalAmount float64, shippingAddress string) (uint, error)
-	AddOrderItem(orderID uint, productID uint, quantity int, price float64) error
-	FindByID(id uint) (*model.Order, error)
-	FindByUserID(userID uint) ([]model.OrderResponse, error)
-	GetOrderItems(orderID uint) ([]model.OrderItemDetail, error)
-    AddItem(orderID uint, productID uint, quantity int, price float64, subtotal float64) error
-	CreateOrder(userID uint, total float64, shippingAddress string, items []model.OrderItem, cartID uint) (uint, error)
+	Create(ctx context.Context, userID uint, totalAmount float64, shippingAddress string) (uint, error)
+	AddOrderItem(ctx context.Context, orderID uint, productID uint, quantity int, price float64) error
+	FindByID(ctx context.Context, id uint) (*model.Order, error)
+	FindByUserID(ctx context.Context, userID uint) ([]model.OrderResponse, error)
+	GetOrderItems(ctx context.Context, orderID uint) ([]model.OrderItemDetail, error)
+    AddItem(ctx context.Context, orderID uint, productID uint, quantity int, price float64, subtotal float64) error
+	CreateOrder(ctx context.Context, userID uint, total float64, shippingAddress string, items []model.OrderItem, cartID uint) (uint, error)
+	UpdateStatus(ctx context.Context, id uint, status string) (*model.Order, error)
+	List(ctx context.Context, filter model.OrderFilter) ([]model.Order, int, error)
+	Refund(ctx context.Context, id uint) (*model.Order, error)
+}
+
+// ErrInvalidStatusTransition is returned when an order cannot move from its
+// current status to the requested one.
+var ErrInvalidStatusTransition = errors.New("order status cannot change")
+
+const orderColumns = "id, user_id, total_amount, status, shipping_address, created_at, updated_at"
+
+func scanOrder(row rowScanner) (*model.Order, error) {
+	var order model.Order
+	err := row.Scan(&order.ID, &order.UserID, &order.TotalAmount, &order.Status, &order.ShippingAddress, &order.CreatedAt, &order.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
 }
 
 type PostgresOrderRepository struct {
 	db *sql.DB
+	// stockLowThreshold is the stock below which a StockLow event is
+	// recorded; zero records none.
+	stockLowThreshold int
 }
 
-func NewOrderRepository(db *sql.DB) OrderRepository {
-	return &PostgresOrderRepository{db: db}
+func NewOrderRepository(db *sql.DB, stockLowThreshold int) OrderRepository {
+	return &PostgresOrderRepository{db: db, stockLowThreshold: stockLowThreshold}
 }
 
-func (r *PostgresOrderRepository) Create(userID uint, totalAmount float64, shippingAddress string) (uint, error) {
+func (r *PostgresOrderRepository) Create(ctx context.Context, userID uint, totalAmount float64, shippingAddress string) (uint, error) {
+	ctx, span := startSpan(ctx, "OrderRepository.Create")
+	defer span.End()
+
 	var id uint
-	err := r.db.QueryRow(`
+	err := r.db.QueryRowContext(ctx, `
 		INSERT INTO orders (user_id, total_amount, status, shipping_address)
 		VALUES ($1, $2, $3, $4)
 		RETURNING id
@@ -39,17 +65,23 @@ func (r *PostgresOrderRepository) Create(userID uint, totalAmount float64, shipp
 	return id, nil
 }
 
-func (r *PostgresOrderRepository) AddOrderItem(orderID uint, productID uint, quantity int, price float64) error {
-	_, err := r.db.Exec(`
+func (r *PostgresOrderRepository) AddOrderItem(ctx context.Context, orderID uint, productID uint, quantity int, price float64) error {
+	ctx, span := startSpan(ctx, "OrderRepository.AddOrderItem")
+	defer span.End()
+
+	_, err := r.db.ExecContext(ctx, `
 		INSERT INTO order_items (order_id, product_id, quantity, price)
 		VALUES ($1, $2, $3, $4)
 	`, orderID, productID, quantity, price)
 	return err
 }
 
-func (r *PostgresOrderRepository) FindByID(id uint) (*model.Order, error) {
+func (r *PostgresOrderRepository) FindByID(ctx context.Context, id uint) (*model.Order, error) {
+    ctx, span := startSpan(ctx, "OrderRepository.FindByID")
+    defer span.End()
+
     var order model.Order
-    err := r.db.QueryRow(`
+    err := r.db.QueryRowContext(ctx, `
         SELECT id, user_id, total_amount, status, shipping_address, created_at, updated_at
         FROM orders WHERE id = $1
     `, id).Scan(&order.ID, &order.UserID, &order.TotalAmount, &order.Status, &order.ShippingAddress, &order.CreatedAt, &order.UpdatedAt)
@@ -62,8 +94,11 @@ func (r *PostgresOrderRepository) FindByID(id uint) (*model.Order, error) {
     return &order, nil
 }
 
-func (r *PostgresOrderRepository) FindByUserID(userID uint) ([]model.OrderResponse, error) {
-	rows, err := r.db.Query(`
+func (r *PostgresOrderRepository) FindByUserID(ctx context.Context, userID uint) ([]model.OrderResponse, error) {
+	ctx, span := startSpan(ctx, "OrderRepository.FindByUserID")
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, `
 		SELECT id, total_amount, status, shipping_address, created_at
 		FROM orders
 		WHERE user_id = $1
@@ -92,8 +127,48 @@ func (r *PostgresOrderRepository) FindByUserID(userID uint) ([]model.OrderRespon
 	return orders, nil
 }
 
-func (r *PostgresOrderRepository) GetOrderItems(orderID uint) ([]model.OrderItemDetail, error) {
-	rows, err := r.db.Query(`
+// List returns a page of orders matching filter, newest first, and the
+// number of matching orders.
+func (r *PostgresOrderRepository) List(ctx context.Context, filter model.OrderFilter) ([]model.Order, int, error) {
+	ctx, span := startSpan(ctx, "OrderRepository.List")
+	defer span.End()
+
+	where := "WHERE ($1 = '' OR status = $1) AND ($2 = 0 OR user_id = $2)"
+	args := []interface{}{filter.Status, filter.UserID}
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM orders "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+orderColumns+" FROM orders "+where+" ORDER BY created_at DESC, id DESC LIMIT $3 OFFSET $4",
+		append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	orders := []model.Order{}
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		orders = append(orders, *order)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return orders, total, nil
+}
+
+func (r *PostgresOrderRepository) GetOrderItems(ctx context.Context, orderID uint) ([]model.OrderItemDetail, error) {
+	ctx, span := startSpan(ctx, "OrderRepository.GetOrderItems")
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, `
 		SELECT oi.product_id, p.name, oi.price, oi.quantity
 		FROM order_items oi
 		JOIN products p ON oi.product_id = p.id
@@ -121,23 +196,29 @@ func (r *PostgresOrderRepository) GetOrderItems(orderID uint) ([]model.OrderItem
 	return orderItems, nil
 }
 
-func (r *PostgresOrderRepository) AddItem(orderID uint, productID uint, quantity int, price float64, subtotal float64) error {
-    _, err := r.db.Exec(`
+func (r *PostgresOrderRepository) AddItem(ctx context.Context, orderID uint, productID uint, quantity int, price float64, subtotal float64) error {
+    ctx, span := startSpan(ctx, "OrderRepository.AddItem")
+    defer span.End()
+
+    _, err := r.db.ExecContext(ctx, `
         INSERT INTO order_items (order_id, product_id, quantity, price, subtotal, created_at, updated_at)
         VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
     `, orderID, productID, quantity, price, subtotal)
     return err
 }
 
-func (r *PostgresOrderRepository) CreateOrder(userID uint, total float64, shippingAddress string, items []model.OrderItem, cartID uint) (uint, error) {
-    tx, err := r.db.Begin()
+func (r *PostgresOrderRepository) CreateOrder(ctx context.Context, userID uint, total float64, shippingAddress string, items []model.OrderItem, cartID uint) (uint, error) {
+    ctx, span := startSpan(ctx, "OrderRepository.CreateOrder")
+    defer span.End()
+
+    tx, err := r.db.BeginTx(ctx, nil)
     if err != nil {
         return 0, err
     }
     defer tx.Rollback()
     
     var orderID uint
-    err = tx.QueryRow(`
+    err = tx.QueryRowContext(ctx, `
         INSERT INTO orders (user_id, total_amount, status, shipping_address, created_at, updated_at)
         VALUES ($1, $2, 'pending', $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
         RETURNING id
@@ -148,7 +229,7 @@ func (r *PostgresOrderRepository) CreateOrder(userID uint, total float64, shippi
     }
     
     for _, item := range items {
-        _, err = tx.Exec(`
+        _, err = tx.ExecContext(ctx, `
             INSERT INTO order_items (order_id, product_id, quantity, price, subtotal, created_at, updated_at)
             VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
         `, orderID, item.ProductID, item.Quantity, item.Price, item.Subtotal)
@@ -157,25 +238,177 @@ func (r *PostgresOrderRepository) CreateOrder(userID uint, total float64, shippi
             return 0, err
         }
         
-        _, err = tx.Exec(`
+        var name string
+        var stock int
+        err = tx.QueryRowContext(ctx, `
             UPDATE products 
-            SET stock = stock - $1, updated_at = CURRENT_TIMESTAMP 
-            WHERE id = $2 AND stock >= $1
-        `, item.Quantity, item.ProductID)
+            SET stock = stock - $1, version = version + 1, updated_at = CURRENT_TIMESTAMP 
+            WHERE id = $2 AND stock >= $1 AND deleted_at IS NULL
+            RETURNING name, stock
+        `, item.Quantity, item.ProductID).Scan(&name, &stock)
         
         if err != nil {
+            if err == sql.ErrNoRows {
+                return 0, ErrInsufficientStock
+            }
+            return 0, err
+        }
+
+        if err := recordStockLevel(ctx, tx, r.stockLowThreshold, int(item.ProductID), name, stock+item.Quantity, stock); err != nil {
             return 0, err
         }
     }
     
-    _, err = tx.Exec("DELETE FROM cart_items WHERE cart_id = $1", cartID)
+    _, err = tx.ExecContext(ctx, "DELETE FROM cart_items WHERE cart_id = $1", cartID)
     if err != nil {
         return 0, err
     }
+
+    event := model.OrderCreatedEvent{
+        OrderID:         orderID,
+        UserID:          userID,
+        TotalAmount:     total,
+        Status:          model.OrderStatusPending,
+        ShippingAddress: shippingAddress,
+        Items:           make([]model.OrderEventItem, 0, len(items)),
+    }
+    for _, item := range items {
+        event.Items = append(event.Items, model.OrderEventItem{ProductID: item.ProductID, Quantity: item.Quantity, Price: item.Price, Subtotal: item.Subtotal})
+    }
+    if err := recordEvent(ctx, tx, model.EventOrderCreated, model.AggregateOrder, orderID, event); err != nil {
+        return 0, err
+    }
     
     if err = tx.Commit(); err != nil {
         return 0, err
     }
     
     return orderID, nil
-}
\ No newline at end of file
+}
+
+// UpdateStatus moves an order to status, recording an OrderStatusChanged
+// event and an audit event in the same transaction. It returns
+// ErrInvalidStatusTransition when the current status does not allow it.
+func (r *PostgresOrderRepository) UpdateStatus(ctx context.Context, id uint, status string) (*model.Order, error) {
+	ctx, span := startSpan(ctx, "OrderRepository.UpdateStatus")
+	defer span.End()
+
+	return r.changeStatus(ctx, id, status, model.AuditOrderStatus)
+}
+
+// Refund marks a paid, shipped or delivered order as refunded, like
+// UpdateStatus but audited as a refund. Only orders that had not shipped are
+// restocked.
+func (r *PostgresOrderRepository) Refund(ctx context.Context, id uint) (*model.Order, error) {
+	ctx, span := startSpan(ctx, "OrderRepository.Refund")
+	defer span.End()
+
+	return r.changeStatus(ctx, id, model.OrderStatusRefunded, model.AuditOrderRefund)
+}
+
+func (r *PostgresOrderRepository) changeStatus(ctx context.Context, id uint, status, action string) (*model.Order, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	before, err := scanOrder(tx.QueryRowContext(ctx, "SELECT "+orderColumns+" FROM orders WHERE id = $1 FOR UPDATE", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("order not found")
+		}
+		return nil, err
+	}
+	if !model.CanTransitionOrder(before.Status, status) {
+		return nil, fmt.Errorf("%w from %s to %s", ErrInvalidStatusTransition, before.Status, status)
+	}
+
+	order, err := scanOrder(tx.QueryRowContext(ctx,
+		"UPDATE orders SET status = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1 RETURNING "+orderColumns, id, status))
+	if err != nil {
+		return nil, err
+	}
+
+	var audits []auditEntry
+	if model.OrderReturnsStock(before.Status, order.Status) {
+		if audits, err = r.restock(ctx, tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := recordEvent(ctx, tx, model.EventOrderStatusChanged, model.AggregateOrder, id, model.OrderStatusChangedEvent{
+		OrderID:     order.ID,
+		UserID:      order.UserID,
+		TotalAmount: order.TotalAmount,
+		OldStatus:   before.Status,
+		NewStatus:   order.Status,
+	}); err != nil {
+		return nil, err
+	}
+
+	audits = append(audits, auditEntry{action, model.AuditEntityOrder, id, before, order})
+	if err := recordAudits(ctx, tx, audits); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// restock puts the items of an order back into stock, recording each product
+// change like any other stock update, and returns the audit events for the
+// caller to record last. Archived products are restocked too, so their stock
+// is right if they are restored.
+func (r *PostgresOrderRepository) restock(ctx context.Context, tx *sql.Tx, orderID uint) ([]auditEntry, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT product_id, SUM(quantity)
+		FROM order_items
+		WHERE order_id = $1
+		GROUP BY product_id
+		ORDER BY product_id
+	`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	quantities := map[int]int{}
+	var productIDs []int
+	for rows.Next() {
+		var productID, quantity int
+		if err := rows.Scan(&productID, &quantity); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		quantities[productID] = quantity
+		productIDs = append(productIDs, productID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var audits []auditEntry
+	for _, productID := range productIDs {
+		before, err := scanProduct(tx.QueryRowContext(ctx, "SELECT "+productColumns+" FROM products WHERE id = $1 FOR UPDATE", productID))
+		if err != nil {
+			return nil, err
+		}
+		p, err := scanProduct(tx.QueryRowContext(ctx, `
+			UPDATE products
+			SET stock = stock + $2, version = version + 1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+			RETURNING `+productColumns, productID, quantities[productID]))
+		if err != nil {
+			return nil, err
+		}
+
+		if err := recordProductChange(ctx, tx, r.stockLowThreshold, model.ProductChangeUpdated, before, p); err != nil {
+			return nil, err
+		}
+		audits = append(audits, auditEntry{model.AuditProductRestock, model.AuditEntityProduct, productID, before, p})
+	}
+	return audits, nil
+}
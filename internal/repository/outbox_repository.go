@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"test-ordent/internal/model"
+)
+
+// OutboxRepository hands recorded domain events to the relay. Events are
+// written by the repositories making the changes, in the same transaction,
+// with recordEvent.
+type OutboxRepository interface {
+	// ProcessDue passes the events that are due, at most limit and oldest
+	// first, to publish and saves the outcomes it returns; events without an
+	// outcome are left for a later run. An event is not due while an older
+	// event of the same aggregate waits for a retry. ProcessDue returns false
+	// without calling publish when another relay holds the outbox.
+	ProcessDue(ctx context.Context, limit int, publish func(ctx context.Context, events []model.OutboxEvent) []model.OutboxOutcome) (bool, error)
+	// PurgePublished deletes events published before olderThan ago.
+	PurgePublished(ctx context.Context, olderThan time.Duration) (int, error)
+}
+
+type PostgresOutboxRepository struct {
+	db *sql.DB
+}
+
+func NewOutboxRepository(db *sql.DB) OutboxRepository {
+	return &PostgresOutboxRepository{db: db}
+}
+
+// outboxRelayLock is the key of the session-level advisory lock held by the
+// relay while it publishes a batch, so that with several instances running
+// only one publishes at a time and events of an aggregate stay in order.
+const outboxRelayLock = 0x6f757462
+
+// recordEvent adds an event about a change made in tx to the outbox. Changes
+// to one aggregate have to hold its row lock when recording, so its events
+// get IDs in the order they commit.
+func recordEvent(ctx context.Context, tx execer, eventType, aggregateType string, aggregateID interface{}, payload interface{}) error {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO outbox_events (event_type, aggregate_type, aggregate_id, payload) VALUES ($1, $2, $3, $4)",
+		eventType, aggregateType, fmt.Sprint(aggregateID), string(raw),
+	)
+	return err
+}
+
+// recordStockLevel records a StockLow event when the stock of a product drops
+// from at least threshold to below it. A zero threshold records nothing.
+func recordStockLevel(ctx context.Context, tx execer, threshold, productID int, name string, before, after int) error {
+	if threshold <= 0 || before < threshold || after >= threshold {
+		return nil
+	}
+	return recordEvent(ctx, tx, model.EventStockLow, model.AggregateProduct, productID, model.StockLowEvent{
+		ProductID: productID,
+		Name:      name,
+		Stock:     after,
+		Threshold: threshold,
+	})
+}
+
+// recordProductChange records a ProductUpdated event for p, and a StockLow
+// event when the change took its stock below threshold. before is nil for new
+// products.
+func recordProductChange(ctx context.Context, tx execer, threshold int, change string, before, p *model.ProductResponse) error {
+	if err := recordEvent(ctx, tx, model.EventProductUpdated, model.AggregateProduct, p.ID, model.ProductUpdatedEvent{Change: change, Product: p}); err != nil {
+		return err
+	}
+	if before == nil {
+		return nil
+	}
+	return recordStockLevel(ctx, tx, threshold, p.ID, p.Name, before.Stock, p.Stock)
+}
+
+func (r *PostgresOutboxRepository) ProcessDue(ctx context.Context, limit int, publish func(ctx context.Context, events []model.OutboxEvent) []model.OutboxOutcome) (bool, error) {
+	ctx, span := startSpan(ctx, "OutboxRepository.ProcessDue")
+	defer span.End()
+
+	// The lock belongs to the session, so the connection is kept for the
+	// whole run and no transaction stays open while events are published.
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", outboxRelayLock).Scan(&locked); err != nil {
+		return false, err
+	}
+	if !locked {
+		return false, nil
+	}
+	defer unlockOutboxRelay(ctx, conn)
+
+	events, err := claimDueEvents(ctx, conn, limit)
+	if err != nil || len(events) == 0 {
+		return true, err
+	}
+
+	outcomes := publish(ctx, events)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	for _, outcome := range outcomes {
+		if outcome.Published {
+			_, err = tx.ExecContext(ctx, "UPDATE outbox_events SET published_at = NOW(), last_error = '' WHERE id = $1", outcome.EventID)
+		} else {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE outbox_events
+				SET attempts = attempts + 1, next_attempt_at = NOW() + make_interval(secs => $2), last_error = $3
+				WHERE id = $1
+			`, outcome.EventID, outcome.RetryAfter.Seconds(), outcome.Error)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+// claimDueEvents reads the batch to publish. It needs no transaction: while
+// the relay lock is held, only this run changes unpublished events.
+func claimDueEvents(ctx context.Context, conn *sql.Conn, limit int) ([]model.OutboxEvent, error) {
+	rows, err := conn.QueryContext(ctx, `
+		SELECT e.id, e.event_type, e.aggregate_type, e.aggregate_id, e.payload, e.created_at, e.attempts
+		FROM outbox_events e
+		WHERE e.published_at IS NULL AND e.next_attempt_at <= NOW()
+			AND NOT EXISTS (
+				SELECT 1 FROM outbox_events w
+				WHERE w.published_at IS NULL AND w.aggregate_type = e.aggregate_type AND w.aggregate_id = e.aggregate_id
+					AND w.id < e.id AND w.next_attempt_at > NOW()
+			)
+		ORDER BY e.id
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []model.OutboxEvent
+	for rows.Next() {
+		var e model.OutboxEvent
+		var payload []byte
+		if err := rows.Scan(&e.ID, &e.Type, &e.AggregateType, &e.AggregateID, &payload, &e.OccurredAt, &e.Attempts); err != nil {
+			return nil, err
+		}
+		e.Payload = payload
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// unlockOutboxRelay releases the relay lock, even when ctx was cancelled. If
+// that fails the connection is discarded instead of going back to the pool,
+// which ends the session and with it the lock.
+func unlockOutboxRelay(ctx context.Context, conn *sql.Conn) {
+	if _, err := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", outboxRelayLock); err != nil {
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}
+}
+
+func (r *PostgresOutboxRepository) PurgePublished(ctx context.Context, olderThan time.Duration) (int, error) {
+	ctx, span := startSpan(ctx, "OutboxRepository.PurgePublished")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx,
+		"DELETE FROM outbox_events WHERE published_at < NOW() - make_interval(secs => $1)", olderThan.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,357 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"test-ordent/internal/model"
+)
+
+// activeSalePriceSQL returns a subquery selecting the sale price in effect
+// right now for the product whose id is productIDColumn, or NULL. It only
+// depends on the schedule window, so prices are correct even before the
+// scheduler has marked a schedule active or ended.
+func activeSalePriceSQL(productIDColumn string) string {
+	return `(SELECT s.sale_price FROM product_price_schedules s
+		WHERE s.product_id = ` + productIDColumn + ` AND s.status IN ('scheduled', 'active')
+		AND s.starts_at <= NOW() AND s.ends_at > NOW()
+		ORDER BY s.starts_at DESC LIMIT 1)`
+}
+
+var ErrScheduleOverlap = errors.New("price schedule overlaps an existing schedule")
+
+type PriceRepository interface {
+	FindHistory(ctx context.Context, productID int) ([]model.PriceHistoryEntry, error)
+	FindSchedules(ctx context.Context, productID int) ([]model.PriceSchedule, error)
+	CreateSchedule(ctx context.Context, productID int, req *model.PriceScheduleRequest, actorID uint) (*model.PriceSchedule, error)
+	CancelSchedule(ctx context.Context, productID int, scheduleID int, actorID uint) (*model.PriceSchedule, error)
+	ActivateDue(ctx context.Context, now time.Time) (int, error)
+	EndExpired(ctx context.Context, now time.Time) (int, error)
+}
+
+type PostgresPriceRepository struct {
+	db *sql.DB
+}
+
+func NewPriceRepository(db *sql.DB) PriceRepository {
+	return &PostgresPriceRepository{db: db}
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// recordPriceChange appends a price history entry. An actorID of 0 means the
+// change was not made by a user, e.g. by the CLI or the scheduler.
+func recordPriceChange(ctx context.Context, tx execer, productID int, oldPrice *float64, newPrice float64, actorID uint, source string) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO product_price_history (product_id, old_price, new_price, source, changed_by)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0))`,
+		productID, oldPrice, newPrice, source, actorID,
+	)
+	return err
+}
+
+func (r *PostgresPriceRepository) FindHistory(ctx context.Context, productID int) ([]model.PriceHistoryEntry, error) {
+	ctx, span := startSpan(ctx, "PriceRepository.FindHistory")
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, product_id, old_price, new_price, source, changed_by, changed_at
+		FROM product_price_history WHERE product_id = $1 ORDER BY changed_at DESC, id DESC`,
+		productID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []model.PriceHistoryEntry{}
+	for rows.Next() {
+		var entry model.PriceHistoryEntry
+		var changedBy sql.NullInt64
+		if err := rows.Scan(&entry.ID, &entry.ProductID, &entry.OldPrice, &entry.NewPrice, &entry.Source, &changedBy, &entry.ChangedAt); err != nil {
+			return nil, err
+		}
+		if changedBy.Valid {
+			id := uint(changedBy.Int64)
+			entry.ChangedBy = &id
+		}
+		history = append(history, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+const priceScheduleColumns = "id, product_id, sale_price, starts_at, ends_at, status, created_by, created_at"
+
+func scanPriceSchedule(row rowScanner) (*model.PriceSchedule, error) {
+	var s model.PriceSchedule
+	var createdBy sql.NullInt64
+	if err := row.Scan(&s.ID, &s.ProductID, &s.SalePrice, &s.StartsAt, &s.EndsAt, &s.Status, &createdBy, &s.CreatedAt); err != nil {
+		return nil, err
+	}
+	if createdBy.Valid {
+		id := uint(createdBy.Int64)
+		s.CreatedBy = &id
+	}
+	return &s, nil
+}
+
+func (r *PostgresPriceRepository) FindSchedules(ctx context.Context, productID int) ([]model.PriceSchedule, error) {
+	ctx, span := startSpan(ctx, "PriceRepository.FindSchedules")
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+priceScheduleColumns+" FROM product_price_schedules WHERE product_id = $1 ORDER BY starts_at DESC, id DESC", productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []model.PriceSchedule{}
+	for rows.Next() {
+		s, err := scanPriceSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+// CreateSchedule adds a sale price for the given window. Windows of live
+// schedules on the same product may not overlap.
+func (r *PostgresPriceRepository) CreateSchedule(ctx context.Context, productID int, req *model.PriceScheduleRequest, actorID uint) (*model.PriceSchedule, error) {
+	ctx, span := startSpan(ctx, "PriceRepository.CreateSchedule")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Locking the product serialises schedule creation per product, which
+	// keeps the overlap check below race free.
+	var id int
+	err = tx.QueryRowContext(ctx, "SELECT id FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", productID).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("product not found")
+		}
+		return nil, err
+	}
+
+	var overlaps bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM product_price_schedules
+		WHERE product_id = $1 AND status IN ('scheduled', 'active') AND starts_at < $3 AND ends_at > $2)`,
+		productID, req.StartsAt, req.EndsAt,
+	).Scan(&overlaps)
+	if err != nil {
+		return nil, err
+	}
+	if overlaps {
+		return nil, ErrScheduleOverlap
+	}
+
+	s, err := scanPriceSchedule(tx.QueryRowContext(ctx,
+		`INSERT INTO product_price_schedules (product_id, sale_price, starts_at, ends_at, created_by)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0))
+		RETURNING `+priceScheduleColumns,
+		productID, req.SalePrice, req.StartsAt, req.EndsAt, actorID,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// CancelSchedule stops a schedule that has not ended yet. Cancelling an active
+// sale reverts the product to its base price, which is recorded in the history.
+func (r *PostgresPriceRepository) CancelSchedule(ctx context.Context, productID int, scheduleID int, actorID uint) (*model.PriceSchedule, error) {
+	ctx, span := startSpan(ctx, "PriceRepository.CancelSchedule")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var previousStatus string
+	err = tx.QueryRowContext(ctx,
+		"SELECT status FROM product_price_schedules WHERE id = $1 AND product_id = $2 FOR UPDATE",
+		scheduleID, productID,
+	).Scan(&previousStatus)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("price schedule not found")
+		}
+		return nil, err
+	}
+	if previousStatus != model.PriceScheduleScheduled && previousStatus != model.PriceScheduleActive {
+		return nil, errors.New("price schedule already " + previousStatus)
+	}
+
+	s, err := scanPriceSchedule(tx.QueryRowContext(ctx,
+		"UPDATE product_price_schedules SET status = $1 WHERE id = $2 RETURNING "+priceScheduleColumns,
+		model.PriceScheduleCancelled, scheduleID,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	if previousStatus == model.PriceScheduleActive {
+		if err := recordScheduleRevert(ctx, tx, s, actorID, model.PriceSourceScheduleCancel); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// ActivateDue marks schedules whose window has started as active and records
+// the sale price in the history. It returns the number of schedules started.
+func (r *PostgresPriceRepository) ActivateDue(ctx context.Context, now time.Time) (int, error) {
+	ctx, span := startSpan(ctx, "PriceRepository.ActivateDue")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`UPDATE product_price_schedules s SET status = $1
+		FROM products p
+		WHERE p.id = s.product_id AND s.status = $2 AND s.starts_at <= $3 AND s.ends_at > $3
+		RETURNING s.product_id, p.price, s.sale_price`,
+		model.PriceScheduleActive, model.PriceScheduleScheduled, now,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	type started struct {
+		productID int
+		basePrice float64
+		salePrice float64
+	}
+	var changes []started
+	for rows.Next() {
+		var c started
+		if err := rows.Scan(&c.productID, &c.basePrice, &c.salePrice); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		changes = append(changes, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, c := range changes {
+		if err := recordPriceChange(ctx, tx, c.productID, &c.basePrice, c.salePrice, 0, model.PriceSourceScheduleStart); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(changes), nil
+}
+
+// EndExpired closes schedules whose window has passed. Schedules that were
+// active get a history entry for the revert to the base price. It returns the
+// number of schedules closed.
+func (r *PostgresPriceRepository) EndExpired(ctx context.Context, now time.Time) (int, error) {
+	ctx, span := startSpan(ctx, "PriceRepository.EndExpired")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// A schedule that expired before the scheduler ever saw it active never
+	// changed the price, so it is closed without a history entry.
+	rows, err := tx.QueryContext(ctx,
+		`UPDATE product_price_schedules s SET status = $1
+		FROM product_price_schedules old
+		WHERE old.id = s.id AND s.status IN ($2, $3) AND s.ends_at <= $4
+		RETURNING s.id, s.product_id, s.sale_price, s.starts_at, s.ends_at, s.status, s.created_by, s.created_at, old.status`,
+		model.PriceScheduleEnded, model.PriceScheduleScheduled, model.PriceScheduleActive, now,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var ended []model.PriceSchedule
+	var wasActive []bool
+	for rows.Next() {
+		var s model.PriceSchedule
+		var createdBy sql.NullInt64
+		var previousStatus string
+		if err := rows.Scan(&s.ID, &s.ProductID, &s.SalePrice, &s.StartsAt, &s.EndsAt, &s.Status, &createdBy, &s.CreatedAt, &previousStatus); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ended = append(ended, s)
+		wasActive = append(wasActive, previousStatus == model.PriceScheduleActive)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for i := range ended {
+		if !wasActive[i] {
+			continue
+		}
+		if err := recordScheduleRevert(ctx, tx, &ended[i], 0, model.PriceSourceScheduleEnd); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(ended), nil
+}
+
+// recordScheduleRevert logs the move from a schedule's sale price back to the
+// product's base price.
+func recordScheduleRevert(ctx context.Context, tx *sql.Tx, s *model.PriceSchedule, actorID uint, source string) error {
+	var basePrice float64
+	if err := tx.QueryRowContext(ctx, "SELECT price FROM products WHERE id = $1", s.ProductID).Scan(&basePrice); err != nil {
+		return err
+	}
+	return recordPriceChange(ctx, tx, s.ProductID, &s.SalePrice, basePrice, actorID, source)
+}
@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"test-ordent/internal/model"
+)
+
+type ProductImageRepository interface {
+	FindByProductID(ctx context.Context, productID int) ([]model.ProductImage, error)
+	FindByID(ctx context.Context, id int) (*model.ProductImage, error)
+	Create(ctx context.Context, image *model.ProductImage) (*model.ProductImage, error)
+	Delete(ctx context.Context, id int) error
+	Reorder(ctx context.Context, productID int, imageIDs []int) error
+}
+
+type PostgresProductImageRepository struct {
+	db *sql.DB
+}
+
+func NewProductImageRepository(db *sql.DB) ProductImageRepository {
+	return &PostgresProductImageRepository{db: db}
+}
+
+type storedImageVariant struct {
+	URL        string `json:"url"`
+	StorageKey string `json:"storage_key"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+const productImageColumns = "id, product_id, position, storage_key, url, content_type, size, width, height, thumbnails, created_at"
+
+func scanProductImage(row rowScanner) (*model.ProductImage, error) {
+	var img model.ProductImage
+	var thumbnails []byte
+	if err := row.Scan(&img.ID, &img.ProductID, &img.Position, &img.StorageKey, &img.URL, &img.ContentType,
+		&img.Size, &img.Width, &img.Height, &thumbnails, &img.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	stored := map[string]storedImageVariant{}
+	if err := json.Unmarshal(thumbnails, &stored); err != nil {
+		return nil, err
+	}
+	img.Thumbnails = make(map[string]model.ProductImageVariant, len(stored))
+	for name, v := range stored {
+		img.Thumbnails[name] = model.ProductImageVariant{URL: v.URL, StorageKey: v.StorageKey, Width: v.Width, Height: v.Height}
+	}
+	return &img, nil
+}
+
+func (r *PostgresProductImageRepository) FindByProductID(ctx context.Context, productID int) ([]model.ProductImage, error) {
+	ctx, span := startSpan(ctx, "ProductImageRepository.FindByProductID")
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+productImageColumns+" FROM product_images WHERE product_id = $1 ORDER BY position, id", productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []model.ProductImage{}
+	for rows.Next() {
+		img, err := scanProductImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, *img)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+func (r *PostgresProductImageRepository) FindByID(ctx context.Context, id int) (*model.ProductImage, error) {
+	ctx, span := startSpan(ctx, "ProductImageRepository.FindByID")
+	defer span.End()
+
+	img, err := scanProductImage(r.db.QueryRowContext(ctx, "SELECT "+productImageColumns+" FROM product_images WHERE id = $1", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("product image not found")
+		}
+		return nil, err
+	}
+	return img, nil
+}
+
+func (r *PostgresProductImageRepository) Create(ctx context.Context, image *model.ProductImage) (*model.ProductImage, error) {
+	ctx, span := startSpan(ctx, "ProductImageRepository.Create")
+	defer span.End()
+
+	stored := make(map[string]storedImageVariant, len(image.Thumbnails))
+	for name, v := range image.Thumbnails {
+		stored[name] = storedImageVariant{URL: v.URL, StorageKey: v.StorageKey, Width: v.Width, Height: v.Height}
+	}
+	thumbnails, err := json.Marshal(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the product row so concurrent uploads get distinct positions.
+	if _, err := tx.ExecContext(ctx, "SELECT id FROM products WHERE id = $1 FOR UPDATE", image.ProductID); err != nil {
+		return nil, err
+	}
+
+	created, err := scanProductImage(tx.QueryRowContext(ctx, `
+		INSERT INTO product_images (product_id, position, storage_key, url, content_type, size, width, height, thumbnails)
+		VALUES ($1, (SELECT COALESCE(MAX(position) + 1, 0) FROM product_images WHERE product_id = $1), $2, $3, $4, $5, $6, $7, $8)
+		RETURNING `+productImageColumns,
+		image.ProductID, image.StorageKey, image.URL, image.ContentType, image.Size, image.Width, image.Height, thumbnails,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syncPrimaryImage(ctx, tx, image.ProductID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+func (r *PostgresProductImageRepository) Delete(ctx context.Context, id int) error {
+	ctx, span := startSpan(ctx, "ProductImageRepository.Delete")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var productID int
+	err = tx.QueryRowContext(ctx, "DELETE FROM product_images WHERE id = $1 RETURNING product_id", id).Scan(&productID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("product image not found")
+		}
+		return err
+	}
+
+	if err := syncPrimaryImage(ctx, tx, productID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *PostgresProductImageRepository) Reorder(ctx context.Context, productID int, imageIDs []int) error {
+	ctx, span := startSpan(ctx, "ProductImageRepository.Reorder")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM product_images WHERE product_id = $1", productID).Scan(&count); err != nil {
+		return err
+	}
+	seen := make(map[int]bool, len(imageIDs))
+	for _, imageID := range imageIDs {
+		seen[imageID] = true
+	}
+	if count != len(imageIDs) || len(seen) != len(imageIDs) {
+		return errors.New("image list does not match product images")
+	}
+
+	for position, imageID := range imageIDs {
+		result, err := tx.ExecContext(ctx, "UPDATE product_images SET position = $1 WHERE id = $2 AND product_id = $3", position, imageID, productID)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return errors.New("image list does not match product images")
+		}
+	}
+
+	if err := syncPrimaryImage(ctx, tx, productID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// syncPrimaryImage keeps products.image_url pointing at the first image so
+// clients that only read image_url keep working.
+func syncPrimaryImage(ctx context.Context, tx *sql.Tx, productID int) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE products SET image_url = COALESCE(
+			(SELECT url FROM product_images WHERE product_id = $1 ORDER BY position, id LIMIT 1), ''
+		), version = version + 1, updated_at = NOW()
+		WHERE id = $1
+	`, productID)
+	return err
+}
@@ -1,34 +1,78 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"time"
 
+	"github.com/lib/pq"
+
 	"test-ordent/internal/model"
 )
 
 type ProductRepository interface {
-	FindAll() ([]model.ProductResponse, error)
-	FindByID(id int) (*model.ProductResponse, error)
-	Create(product *model.ProductRequest) (*model.ProductResponse, error)
-	Update(id int, product *model.ProductRequest) (*model.ProductResponse, error)
-	Delete(id int) error
-	ExistsByID(id int) (bool, error)
-	DecreaseStock(id int, quantity int) error
-	GetStock(id int) (int, error)
+	FindAll(ctx context.Context) ([]model.ProductResponse, error)
+	FindByID(ctx context.Context, id int) (*model.ProductResponse, error)
+	Create(ctx context.Context, product *model.ProductRequest, actorID uint) (*model.ProductResponse, error)
+	Update(ctx context.Context, id int, version int, product *model.ProductRequest, actorID uint) (*model.ProductResponse, error)
+	Patch(ctx context.Context, id int, version int, patch *model.ProductPatch, actorID uint) (*model.ProductResponse, error)
+	Delete(ctx context.Context, id int, version int) error
+	Restore(ctx context.Context, id int, version int) (*model.ProductResponse, error)
+	FindArchived(ctx context.Context) ([]model.ProductResponse, error)
+	StreamAll(ctx context.Context, fn func(product *model.ProductResponse) error) error
+	UpsertBySKU(ctx context.Context, products []model.ProductRequest, atomic, dryRun bool, actorID uint) ([]model.ProductUpsertResult, error)
+	ExistsByID(ctx context.Context, id int) (bool, error)
+	DecreaseStock(ctx context.Context, id int, quantity int) error
+	GetStock(ctx context.Context, id int) (int, error)
+}
+
+// ErrInsufficientStock is returned when stock cannot be taken because the
+// product has too little left or no longer exists.
+var ErrInsufficientStock = errors.New("not enough stock or product not found")
+
+// ErrArchivedSKU is reported for import rows whose SKU belongs to an archived
+// product, which an import does not bring back.
+var ErrArchivedSKU = errors.New("sku belongs to an archived product, restore it first")
+
+var productColumns = "id, COALESCE(sku, ''), name, description, price, " + activeSalePriceSQL("products.id") + ", stock, category_id, image_url, version, created_at, updated_at, deleted_at"
+
+func scanProduct(row rowScanner) (*model.ProductResponse, error) {
+	var p model.ProductResponse
+	err := row.Scan(&p.ID, &p.SKU, &p.Name, &p.Description, &p.Price, &p.SalePrice, &p.Stock, &p.CategoryID, &p.ImageURL, &p.Version, &p.CreatedAt, &p.UpdatedAt, &p.DeletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
 }
 
 type PostgresProductRepository struct {
 	db *sql.DB
+	// stockLowThreshold is the stock below which a StockLow event is
+	// recorded; zero records none.
+	stockLowThreshold int
 }
 
-func NewProductRepository(db *sql.DB) ProductRepository {
-	return &PostgresProductRepository{db: db}
+func NewProductRepository(db *sql.DB, stockLowThreshold int) ProductRepository {
+	return &PostgresProductRepository{db: db, stockLowThreshold: stockLowThreshold}
 }
 
-func (r *PostgresProductRepository) FindAll() ([]model.ProductResponse, error) {
-	rows, err := r.db.Query("SELECT id, name, description, price, stock, category_id, image_url, created_at, updated_at FROM products")
+func (r *PostgresProductRepository) FindAll(ctx context.Context) ([]model.ProductResponse, error) {
+	ctx, span := startSpan(ctx, "ProductRepository.FindAll")
+	defer span.End()
+
+	return r.findWhere(ctx, "deleted_at IS NULL")
+}
+
+func (r *PostgresProductRepository) FindArchived(ctx context.Context) ([]model.ProductResponse, error) {
+	ctx, span := startSpan(ctx, "ProductRepository.FindArchived")
+	defer span.End()
+
+	return r.findWhere(ctx, "deleted_at IS NOT NULL")
+}
+
+func (r *PostgresProductRepository) findWhere(ctx context.Context, condition string) ([]model.ProductResponse, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT " + productColumns + " FROM products WHERE " + condition + " ORDER BY id")
 	if err != nil {
 		return nil, err
 	}
@@ -36,11 +80,11 @@ func (r *PostgresProductRepository) FindAll() ([]model.ProductResponse, error) {
 
 	var products []model.ProductResponse
 	for rows.Next() {
-		var p model.ProductResponse
-		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Stock, &p.CategoryID, &p.ImageURL, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		p, err := scanProduct(rows)
+		if err != nil {
 			return nil, err
 		}
-		products = append(products, p)
+		products = append(products, *p)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -50,13 +94,36 @@ func (r *PostgresProductRepository) FindAll() ([]model.ProductResponse, error) {
 	return products, nil
 }
 
-func (r *PostgresProductRepository) FindByID(id int) (*model.ProductResponse, error) {
-	var p model.ProductResponse
-	err := r.db.QueryRow(
-		"SELECT id, name, description, price, stock, category_id, image_url, created_at, updated_at FROM products WHERE id = $1",
-		id,
-	).Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Stock, &p.CategoryID, &p.ImageURL, &p.CreatedAt, &p.UpdatedAt)
+// StreamAll calls fn for every active product without loading the whole
+// catalog into memory. Iteration stops at the first error returned by fn.
+func (r *PostgresProductRepository) StreamAll(ctx context.Context, fn func(product *model.ProductResponse) error) error {
+	ctx, span := startSpan(ctx, "ProductRepository.StreamAll")
+	defer span.End()
 
+	rows, err := r.db.QueryContext(ctx, "SELECT " + productColumns + " FROM products WHERE deleted_at IS NULL ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (r *PostgresProductRepository) FindByID(ctx context.Context, id int) (*model.ProductResponse, error) {
+	ctx, span := startSpan(ctx, "ProductRepository.FindByID")
+	defer span.End()
+
+	p, err := scanProduct(r.db.QueryRowContext(ctx, "SELECT "+productColumns+" FROM products WHERE id = $1 AND deleted_at IS NULL", id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.New("product not found")
@@ -64,44 +131,106 @@ func (r *PostgresProductRepository) FindByID(id int) (*model.ProductResponse, er
 		return nil, err
 	}
 
-	return &p, nil
+	return p, nil
 }
 
-func (r *PostgresProductRepository) Create(product *model.ProductRequest) (*model.ProductResponse, error) {
-    var p model.ProductResponse
-    var updatedAt sql.NullTime 
-    
-    err := r.db.QueryRow(
-        `INSERT INTO products (name, description, price, stock, category_id, image_url, updated_at) 
-        VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP) 
-        RETURNING id, name, description, price, stock, category_id, image_url, created_at, updated_at`,
-        product.Name, product.Description, product.Price, product.Stock, product.CategoryID, product.ImageURL,
-    ).Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Stock, &p.CategoryID, &p.ImageURL, &p.CreatedAt, &updatedAt)
+func (r *PostgresProductRepository) Create(ctx context.Context, product *model.ProductRequest, actorID uint) (*model.ProductResponse, error) {
+    ctx, span := startSpan(ctx, "ProductRepository.Create")
+    defer span.End()
+
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    p, err := scanProduct(tx.QueryRowContext(ctx,
+        `INSERT INTO products (sku, name, description, price, stock, category_id, image_url, updated_at) 
+        VALUES (NULLIF($1, ''), $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP) 
+        RETURNING `+productColumns,
+        product.SKU, product.Name, product.Description, product.Price, product.Stock, product.CategoryID, product.ImageURL,
+    ))
 
     if err != nil {
         return nil, err
     }
+
+    if err := recordPriceChange(ctx, tx, p.ID, nil, p.Price, actorID, model.PriceSourceManual); err != nil {
+        return nil, err
+    }
+
+    if err := recordProductChange(ctx, tx, r.stockLowThreshold, model.ProductChangeCreated, nil, p); err != nil {
+        return nil, err
+    }
+
+    if err := recordAudit(ctx, tx, model.AuditProductCreate, model.AuditEntityProduct, p.ID, nil, p); err != nil {
+        return nil, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
     
-    if updatedAt.Valid {
-        t := updatedAt.Time
-        p.UpdatedAt = &t
-    } else {
+    if p.UpdatedAt == nil {
         t := time.Now()
         p.UpdatedAt = &t
     }
 
-    return &p, nil
+    return p, nil
 }
 
-func (r *PostgresProductRepository) Update(id int, product *model.ProductRequest) (*model.ProductResponse, error) {
-	var p model.ProductResponse
-	err := r.db.QueryRow(
-		`UPDATE products SET name = $1, description = $2, price = $3, stock = $4, category_id = $5, image_url = $6, updated_at = NOW() 
-		WHERE id = $7 
-		RETURNING id, name, description, price, stock, category_id, image_url, created_at, updated_at`,
-		product.Name, product.Description, product.Price, product.Stock, product.CategoryID, product.ImageURL, id,
-	).Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Stock, &p.CategoryID, &p.ImageURL, &p.CreatedAt, &p.UpdatedAt)
+// Update replaces every editable column. A non-zero version makes the write
+// conditional on the row still being at that version.
+func (r *PostgresProductRepository) Update(ctx context.Context, id int, version int, product *model.ProductRequest, actorID uint) (*model.ProductResponse, error) {
+	ctx, span := startSpan(ctx, "ProductRepository.Update")
+	defer span.End()
+
+	return r.updateWithHistory(ctx, id, version, actorID, func(tx *sql.Tx) *sql.Row {
+		return tx.QueryRowContext(ctx,
+			`UPDATE products SET name = $1, description = $2, price = $3, stock = $4, category_id = $5, image_url = $6,
+				sku = COALESCE(NULLIF($9, ''), sku), version = version + 1, updated_at = NOW()
+			WHERE id = $7 AND ($8 = 0 OR version = $8)
+			RETURNING `+productColumns,
+			product.Name, product.Description, product.Price, product.Stock, product.CategoryID, product.ImageURL, id, version, product.SKU,
+		)
+	})
+}
+
+// Patch updates only the fields that are set in patch, with the same version
+// check as Update.
+func (r *PostgresProductRepository) Patch(ctx context.Context, id int, version int, patch *model.ProductPatch, actorID uint) (*model.ProductResponse, error) {
+	ctx, span := startSpan(ctx, "ProductRepository.Patch")
+	defer span.End()
+
+	return r.updateWithHistory(ctx, id, version, actorID, func(tx *sql.Tx) *sql.Row {
+		return tx.QueryRowContext(ctx,
+			`UPDATE products SET
+				sku = COALESCE($9, sku),
+				name = COALESCE($1, name),
+				description = COALESCE($2, description),
+				price = COALESCE($3, price),
+				stock = COALESCE($4, stock),
+				category_id = COALESCE($5, category_id),
+				image_url = COALESCE($6, image_url),
+				version = version + 1, updated_at = NOW()
+			WHERE id = $7 AND ($8 = 0 OR version = $8)
+			RETURNING `+productColumns,
+			patch.Name, patch.Description, patch.Price, patch.Stock, patch.CategoryID, patch.ImageURL, id, version, patch.SKU,
+		)
+	})
+}
 
+// updateWithHistory locks the product, runs update and records a price
+// history entry when the price changed, an audit event and the domain
+// events, all in one transaction.
+func (r *PostgresProductRepository) updateWithHistory(ctx context.Context, id int, version int, actorID uint, update func(tx *sql.Tx) *sql.Row) (*model.ProductResponse, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	before, err := scanProduct(tx.QueryRowContext(ctx, "SELECT "+productColumns+" FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.New("product not found")
@@ -109,60 +238,310 @@ func (r *PostgresProductRepository) Update(id int, product *model.ProductRequest
 		return nil, err
 	}
 
-	return &p, nil
+	p, err := scanProduct(update(tx))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("product version mismatch")
+		}
+		return nil, err
+	}
+
+	if p.Price != before.Price {
+		if err := recordPriceChange(ctx, tx, id, &before.Price, p.Price, actorID, model.PriceSourceManual); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := recordProductChange(ctx, tx, r.stockLowThreshold, model.ProductChangeUpdated, before, p); err != nil {
+		return nil, err
+	}
+
+	if err := recordAudit(ctx, tx, model.AuditProductUpdate, model.AuditEntityProduct, id, before, p); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
 }
 
-func (r *PostgresProductRepository) Delete(id int) error {
-	result, err := r.db.Exec("DELETE FROM products WHERE id = $1", id)
+// UpsertBySKU inserts or updates products matched by SKU inside a single
+// transaction. When atomic is set the first failing row stops the batch and
+// nothing is committed; otherwise every row runs in its own savepoint so a bad
+// row does not affect the others. Rows matching an archived product fail with
+// ErrArchivedSKU. A dry run performs all writes and rolls them back. Results
+// are returned for every row that was attempted.
+func (r *PostgresProductRepository) UpsertBySKU(ctx context.Context, products []model.ProductRequest, atomic, dryRun bool, actorID uint) ([]model.ProductUpsertResult, error) {
+	ctx, span := startSpan(ctx, "ProductRepository.UpsertBySKU")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]model.ProductUpsertResult, 0, len(products))
+	// Audit events are recorded once every row is written, so the audit
+	// chain is only locked for the end of the batch.
+	var audits []auditEntry
+	failed := false
+	for _, product := range products {
+		if !atomic {
+			if _, err := tx.ExecContext(ctx, "SAVEPOINT import_row"); err != nil {
+				return nil, err
+			}
+		}
+
+		var created bool
+		var productID int
+		before, err := scanProduct(tx.QueryRowContext(ctx, "SELECT "+productColumns+" FROM products WHERE sku = $1 FOR UPDATE", product.SKU))
+		if err == sql.ErrNoRows {
+			before, err = nil, nil
+		}
+		if err == nil && before != nil && before.DeletedAt != nil {
+			err = ErrArchivedSKU
+		}
+		if err == nil {
+			err = tx.QueryRowContext(ctx, `
+			INSERT INTO products (sku, name, description, price, stock, category_id, image_url, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP)
+			ON CONFLICT (sku) DO UPDATE SET
+				name = EXCLUDED.name,
+				description = EXCLUDED.description,
+				price = EXCLUDED.price,
+				stock = EXCLUDED.stock,
+				category_id = EXCLUDED.category_id,
+				image_url = EXCLUDED.image_url,
+				version = products.version + 1,
+				updated_at = NOW()
+			RETURNING id, (xmax = 0)
+		`, product.SKU, product.Name, product.Description, product.Price, product.Stock, product.CategoryID, product.ImageURL).Scan(&productID, &created)
+		}
+		if err == nil && (before == nil || before.Price != product.Price) {
+			var previous *float64
+			if before != nil {
+				previous = &before.Price
+			}
+			err = recordPriceChange(ctx, tx, productID, previous, product.Price, actorID, model.PriceSourceImport)
+		}
+		var after *model.ProductResponse
+		if err == nil {
+			after, err = scanProduct(tx.QueryRowContext(ctx, "SELECT "+productColumns+" FROM products WHERE id = $1", productID))
+			if err == nil {
+				change := model.ProductChangeUpdated
+				if before == nil {
+					change = model.ProductChangeCreated
+				}
+				err = recordProductChange(ctx, tx, r.stockLowThreshold, change, before, after)
+			}
+		}
+
+		if err != nil {
+			results = append(results, model.ProductUpsertResult{Err: describeWriteError(err)})
+			failed = true
+			if atomic {
+				return results, nil
+			}
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT import_row"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		results = append(results, model.ProductUpsertResult{Created: created})
+		audits = append(audits, auditEntry{model.AuditProductImport, model.AuditEntityProduct, productID, before, after})
+		if !atomic {
+			if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT import_row"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if dryRun || (atomic && failed) {
+		return results, nil
+	}
+
+	if err := recordAudits(ctx, tx, audits); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// describeWriteError turns constraint violations into messages that can be
+// shown to whoever supplied the data.
+func describeWriteError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "23503":
+			return errors.New("category not found")
+		case "23505":
+			return errors.New("duplicate value violates a unique constraint")
+		case "22001":
+			return errors.New("value too long")
+		case "22003":
+			return errors.New("numeric value out of range")
+		}
+	}
+	return err
+}
+
+// Delete archives the product instead of removing the row, so order history
+// that references it stays intact. The product is also taken out of every
+// cart, since it can no longer be bought. A non-zero version makes the
+// delete conditional on the row still being at that version.
+func (r *PostgresProductRepository) Delete(ctx context.Context, id int, version int) error {
+	ctx, span := startSpan(ctx, "ProductRepository.Delete")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
+	before, err := scanProduct(tx.QueryRowContext(ctx, "SELECT "+productColumns+" FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", id))
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("product not found")
+		}
 		return err
 	}
+	if version != 0 && before.Version != version {
+		return errors.New("product version mismatch")
+	}
 
-	if rowsAffected == 0 {
-		return errors.New("product not found")
+	after, err := scanProduct(tx.QueryRowContext(ctx, "UPDATE products SET deleted_at = NOW(), version = version + 1, updated_at = NOW() WHERE id = $1 RETURNING "+productColumns, id))
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE cart SET updated_at = NOW()
+		WHERE id IN (SELECT cart_id FROM cart_items WHERE product_id = $1)
+	`, id); err != nil {
+		return err
 	}
 
-	return nil
+	if _, err := tx.ExecContext(ctx, "DELETE FROM cart_items WHERE product_id = $1", id); err != nil {
+		return err
+	}
+
+	if err := recordProductChange(ctx, tx, r.stockLowThreshold, model.ProductChangeDeleted, before, after); err != nil {
+		return err
+	}
+
+	if err := recordAudit(ctx, tx, model.AuditProductDelete, model.AuditEntityProduct, id, before, after); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Restore makes an archived product available again, with the same version
+// check as Delete.
+func (r *PostgresProductRepository) Restore(ctx context.Context, id int, version int) (*model.ProductResponse, error) {
+	ctx, span := startSpan(ctx, "ProductRepository.Restore")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	before, err := scanProduct(tx.QueryRowContext(ctx, "SELECT "+productColumns+" FROM products WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE", id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("archived product not found")
+		}
+		return nil, err
+	}
+	if version != 0 && before.Version != version {
+		return nil, errors.New("product version mismatch")
+	}
+
+	p, err := scanProduct(tx.QueryRowContext(ctx,
+		`UPDATE products SET deleted_at = NULL, version = version + 1, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+productColumns,
+		id,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordProductChange(ctx, tx, r.stockLowThreshold, model.ProductChangeRestored, before, p); err != nil {
+		return nil, err
+	}
+
+	if err := recordAudit(ctx, tx, model.AuditProductRestore, model.AuditEntityProduct, id, before, p); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
 }
 
-func (r *PostgresProductRepository) ExistsByID(id int) (bool, error) {
+func (r *PostgresProductRepository) ExistsByID(ctx context.Context, id int) (bool, error) {
+	ctx, span := startSpan(ctx, "ProductRepository.ExistsByID")
+	defer span.End()
+
 	var exists bool
-	err := r.db.QueryRow("SELECT EXISTS(SELECT 1 FROM products WHERE id = $1)", id).Scan(&exists)
+	err := r.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM products WHERE id = $1 AND deleted_at IS NULL)", id).Scan(&exists)
 	if err != nil {
 		return false, err
 	}
 	return exists, nil
 }
 
-func (r *PostgresProductRepository) DecreaseStock(id int, amount int) error {
-    result, err := r.db.Exec(
-        "UPDATE products SET stock = stock - $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND stock >= $1", 
-        amount, id)
-    
+func (r *PostgresProductRepository) DecreaseStock(ctx context.Context, id int, amount int) error {
+    ctx, span := startSpan(ctx, "ProductRepository.DecreaseStock")
+    defer span.End()
+
+    tx, err := r.db.BeginTx(ctx, nil)
     if err != nil {
         return err
     }
+    defer tx.Rollback()
+
+    var name string
+    var stock int
+    err = tx.QueryRowContext(ctx,
+        "UPDATE products SET stock = stock - $1, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND stock >= $1 AND deleted_at IS NULL RETURNING name, stock", 
+        amount, id).Scan(&name, &stock)
     
-    rowsAffected, err := result.RowsAffected()
     if err != nil {
+        if err == sql.ErrNoRows {
+            return ErrInsufficientStock
+        }
         return err
     }
     
-    if rowsAffected == 0 {
-        return errors.New("not enough stock or product not found")
+    if err := recordStockLevel(ctx, tx, r.stockLowThreshold, id, name, stock+amount, stock); err != nil {
+        return err
     }
     
-    return nil
+    return tx.Commit()
 }
 
-func (r *PostgresProductRepository) GetStock(id int) (int, error) {
+func (r *PostgresProductRepository) GetStock(ctx context.Context, id int) (int, error) {
+    ctx, span := startSpan(ctx, "ProductRepository.GetStock")
+    defer span.End()
+
     var stock int
-    err := r.db.QueryRow("SELECT stock FROM products WHERE id = $1", id).Scan(&stock)
+    err := r.db.QueryRowContext(ctx, "SELECT stock FROM products WHERE id = $1 AND deleted_at IS NULL", id).Scan(&stock)
     if err != nil {
         if err == sql.ErrNoRows {
             return 0, errors.New("product not found")
@@ -172,7 +551,10 @@ func (r *PostgresProductRepository) GetStock(id int) (int, error) {
     return stock, nil
 }
 
-func (r *PostgresProductRepository) UpdateStock(id int, newStock int) error {
-    _, err := r.db.Exec("UPDATE products SET stock = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2", newStock, id)
+func (r *PostgresProductRepository) UpdateStock(ctx context.Context, id int, newStock int) error {
+    ctx, span := startSpan(ctx, "ProductRepository.UpdateStock")
+    defer span.End()
+
+    _, err := r.db.ExecContext(ctx, "UPDATE products SET stock = $1, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $2", newStock, id)
     return err
 }
\ No newline at end of file
@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"test-ordent/internal/model"
+)
+
+type TwoFactorRepository interface {
+	Find(ctx context.Context, userID uint) (*model.TwoFactor, error)
+	SavePending(ctx context.Context, userID uint, secret string) error
+	Activate(ctx context.Context, userID uint, step int64, recoveryCodeHashes []string) error
+	UseStep(ctx context.Context, userID uint, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID uint, codeHash string) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID uint, codeHashes []string) error
+	CountRecoveryCodes(ctx context.Context, userID uint) (int, error)
+	Delete(ctx context.Context, userID uint) error
+}
+
+type PostgresTwoFactorRepository struct {
+	db *sql.DB
+}
+
+func NewTwoFactorRepository(db *sql.DB) TwoFactorRepository {
+	return &PostgresTwoFactorRepository{db: db}
+}
+
+func (r *PostgresTwoFactorRepository) Find(ctx context.Context, userID uint) (*model.TwoFactor, error) {
+	ctx, span := startSpan(ctx, "TwoFactorRepository.Find")
+	defer span.End()
+
+	tf := &model.TwoFactor{UserID: userID}
+	err := r.db.QueryRowContext(ctx, "SELECT secret, last_step, confirmed_at FROM user_two_factor WHERE user_id = $1", userID).
+		Scan(&tf.Secret, &tf.LastStep, &tf.ConfirmedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("two-factor not found")
+		}
+		return nil, err
+	}
+	return tf, nil
+}
+
+// SavePending stores a new secret that still has to be confirmed with a code.
+// It replaces an earlier unconfirmed secret but never an active one.
+func (r *PostgresTwoFactorRepository) SavePending(ctx context.Context, userID uint, secret string) error {
+	ctx, span := startSpan(ctx, "TwoFactorRepository.SavePending")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO user_two_factor (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0, created_at = NOW()
+		WHERE user_two_factor.confirmed_at IS NULL`,
+		userID, secret,
+	)
+	if err != nil {
+		return err
+	}
+	return requireRowAffected(result, "two-factor already enabled")
+}
+
+// Activate confirms the pending secret and stores a fresh set of recovery
+// codes.
+func (r *PostgresTwoFactorRepository) Activate(ctx context.Context, userID uint, step int64, recoveryCodeHashes []string) error {
+	ctx, span := startSpan(ctx, "TwoFactorRepository.Activate")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		"UPDATE user_two_factor SET confirmed_at = NOW(), last_step = $2 WHERE user_id = $1 AND confirmed_at IS NULL",
+		userID, step,
+	)
+	if err != nil {
+		return err
+	}
+	if err := requireRowAffected(result, "two-factor not found"); err != nil {
+		return err
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseStep records step as the last accepted TOTP step. It reports false when
+// the step, or a later one, was already used, which makes a code single-use
+// even under concurrent logins.
+func (r *PostgresTwoFactorRepository) UseStep(ctx context.Context, userID uint, step int64) (bool, error) {
+	ctx, span := startSpan(ctx, "TwoFactorRepository.UseStep")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx,
+		"UPDATE user_two_factor SET last_step = $2 WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_step < $2",
+		userID, step,
+	)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used. It reports false when
+// there is no such code.
+func (r *PostgresTwoFactorRepository) UseRecoveryCode(ctx context.Context, userID uint, codeHash string) (bool, error) {
+	ctx, span := startSpan(ctx, "TwoFactorRepository.UseRecoveryCode")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx,
+		"UPDATE user_recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL",
+		userID, codeHash,
+	)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+func (r *PostgresTwoFactorRepository) ReplaceRecoveryCodes(ctx context.Context, userID uint, codeHashes []string) error {
+	ctx, span := startSpan(ctx, "TwoFactorRepository.ReplaceRecoveryCodes")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *PostgresTwoFactorRepository) CountRecoveryCodes(ctx context.Context, userID uint) (int, error) {
+	ctx, span := startSpan(ctx, "TwoFactorRepository.CountRecoveryCodes")
+	defer span.End()
+
+	var count int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL", userID).Scan(&count)
+	return count, err
+}
+
+// Delete turns two-factor authentication off and discards the recovery codes.
+func (r *PostgresTwoFactorRepository) Delete(ctx context.Context, userID uint) error {
+	ctx, span := startSpan(ctx, "TwoFactorRepository.Delete")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM user_two_factor WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+	if err := requireRowAffected(result, "two-factor not found"); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID uint, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
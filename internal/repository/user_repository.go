@@ -1,18 +1,31 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"strings"
 
+	"github.com/lib/pq"
+
+	"test-ordent/internal/auth"
 	"test-ordent/internal/model"
 )
 
 type UserRepository interface {
-	FindByID(id uint) (*model.User, error)
-	FindByUsername(username string) (*model.User, error)
-	FindByEmail(email string) (*model.User, error)
-	Create(user *model.User) (uint, error)
-	ExistsByUsernameOrEmail(username, email string) (bool, error)
+	FindByID(ctx context.Context, id uint) (*model.User, error)
+	FindByUsername(ctx context.Context, username string) (*model.User, error)
+	FindByEmail(ctx context.Context, email string) (*model.User, error)
+	Create(ctx context.Context, user *model.User) (uint, error)
+	ExistsByUsernameOrEmail(ctx context.Context, username, email string) (bool, error)
+	CountByRole(ctx context.Context, role string) (int, error)
+	UpdateProfile(ctx context.Context, id uint, req *model.UpdateProfileRequest) (*model.User, error)
+	UpdatePassword(ctx context.Context, id uint, passwordHash string) error
+	MarkEmailVerified(ctx context.Context, id uint, email string) error
+	Anonymize(ctx context.Context, id uint) error
+	List(ctx context.Context, filter model.UserFilter) ([]model.User, int, error)
+	UpdateStatus(ctx context.Context, id uint, status string) (*model.User, error)
+	UpdateRole(ctx context.Context, id uint, role string) (*model.User, error)
 }
 
 type PostgresUserRepository struct {
@@ -23,60 +36,322 @@ func NewUserRepository(db *sql.DB) UserRepository {
 	return &PostgresUserRepository{db: db}
 }
 
-func (r *PostgresUserRepository) FindByID(id uint) (*model.User, error) {
+const userColumns = `id, username, email, password_hash, COALESCE(full_name, ''), role, email_verified, status, created_at,
+	EXISTS (SELECT 1 FROM user_two_factor t WHERE t.user_id = users.id AND t.confirmed_at IS NOT NULL), password_changed_at`
+
+func scanUser(row rowScanner) (*model.User, error) {
 	user := &model.User{}
-	err := r.db.QueryRow("SELECT id, username, email, password_hash, full_name, role FROM users WHERE id = $1", id).
-		Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.FullName, &user.Role)
+	var passwordChangedAt sql.NullTime
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.FullName, &user.Role,
+		&user.EmailVerified, &user.Status, &user.CreatedAt, &user.TwoFactorEnabled, &passwordChangedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.New("user not found")
 		}
 		return nil, err
 	}
+	if passwordChangedAt.Valid {
+		user.PasswordChangedAt = &passwordChangedAt.Time
+	}
 	return user, nil
 }
 
-func (r *PostgresUserRepository) FindByUsername(username string) (*model.User, error) {
-	user := &model.User{}
-	err := r.db.QueryRow("SELECT id, username, email, password_hash, full_name, role FROM users WHERE username = $1", username).
-		Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.FullName, &user.Role)
+func (r *PostgresUserRepository) FindByID(ctx context.Context, id uint) (*model.User, error) {
+	ctx, span := startSpan(ctx, "UserRepository.FindByID")
+	defer span.End()
+
+	return scanUser(r.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", id))
+}
+
+func (r *PostgresUserRepository) FindByUsername(ctx context.Context, username string) (*model.User, error) {
+	ctx, span := startSpan(ctx, "UserRepository.FindByUsername")
+	defer span.End()
+
+	return scanUser(r.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE username = $1", username))
+}
+
+func (r *PostgresUserRepository) FindByEmail(ctx context.Context, email string) (*model.User, error) {
+	ctx, span := startSpan(ctx, "UserRepository.FindByEmail")
+	defer span.End()
+
+	return scanUser(r.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE email = $1", email))
+}
+
+// Create inserts a user. Staff accounts, such as the first admin created
+// from the CLI, are audited; customer sign-ups are not.
+func (r *PostgresUserRepository) Create(ctx context.Context, user *model.User) (uint, error) {
+	ctx, span := startSpan(ctx, "UserRepository.Create")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, errors.New("user not found")
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	staff := user.Role != auth.RoleCustomer
+
+	var id uint
+	err = tx.QueryRowContext(ctx, "INSERT INTO users (username, email, password_hash, full_name, role, email_verified) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		user.Username, user.Email, user.PasswordHash, user.FullName, user.Role, user.EmailVerified).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	if staff {
+		created, err := scanUser(tx.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", id))
+		if err != nil {
+			return 0, err
+		}
+		if err := recordAudit(ctx, tx, model.AuditUserCreate, model.AuditEntityUser, id, nil, created); err != nil {
+			return 0, err
 		}
-		return nil, err
 	}
-	return user, nil
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
 }
 
-func (r *PostgresUserRepository) FindByEmail(email string) (*model.User, error) {
-	user := &model.User{}
-	err := r.db.QueryRow("SELECT id, username, email, password_hash, full_name, role FROM users WHERE email = $1", email).
-		Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.FullName, &user.Role)
+func (r *PostgresUserRepository) ExistsByUsernameOrEmail(ctx context.Context, username, email string) (bool, error) {
+	ctx, span := startSpan(ctx, "UserRepository.ExistsByUsernameOrEmail")
+	defer span.End()
+
+	var exists bool
+	err := r.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE username = $1 OR email = $2)", username, email).Scan(&exists)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, errors.New("user not found")
+		return false, err
+	}
+	return exists, nil
+}
+
+func (r *PostgresUserRepository) CountByRole(ctx context.Context, role string) (int, error) {
+	ctx, span := startSpan(ctx, "UserRepository.CountByRole")
+	defer span.End()
+
+	var count int
+	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE role = $1 AND status <> 'deleted'", role).Scan(&count)
+	return count, err
+}
+
+// UpdateProfile changes the fields set in req. A new email address is marked
+// unverified until it is confirmed again.
+func (r *PostgresUserRepository) UpdateProfile(ctx context.Context, id uint, req *model.UpdateProfileRequest) (*model.User, error) {
+	ctx, span := startSpan(ctx, "UserRepository.UpdateProfile")
+	defer span.End()
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, `
+		UPDATE users SET
+			full_name = COALESCE($1, full_name),
+			email = COALESCE($2, email),
+			email_verified = CASE WHEN $2::VARCHAR IS NOT NULL AND $2 <> email THEN FALSE ELSE email_verified END,
+			updated_at = NOW()
+		WHERE id = $3 AND status <> 'deleted'
+		RETURNING `+userColumns,
+		req.FullName, req.Email, id,
+	))
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, errors.New("email already in use")
 		}
 		return nil, err
 	}
 	return user, nil
 }
 
-func (r *PostgresUserRepository) Create(user *model.User) (uint, error) {
-	var id uint
-	err := r.db.QueryRow("INSERT INTO users (username, email, password_hash, full_name, role) VALUES ($1, $2, $3, $4, $5) RETURNING id",
-		user.Username, user.Email, user.PasswordHash, user.FullName, user.Role).Scan(&id)
+func (r *PostgresUserRepository) UpdatePassword(ctx context.Context, id uint, passwordHash string) error {
+	ctx, span := startSpan(ctx, "UserRepository.UpdatePassword")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx, "UPDATE users SET password_hash = $1, password_changed_at = NOW(), updated_at = NOW() WHERE id = $2 AND status <> 'deleted'", passwordHash, id)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	return id, nil
+	return requireRowAffected(result, "user not found")
 }
 
-func (r *PostgresUserRepository) ExistsByUsernameOrEmail(username, email string) (bool, error) {
-	var exists bool
-	err := r.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1 OR email = $2)", username, email).Scan(&exists)
+// MarkEmailVerified verifies the user's email, provided it is still the
+// address the verification was sent to.
+func (r *PostgresUserRepository) MarkEmailVerified(ctx context.Context, id uint, email string) error {
+	ctx, span := startSpan(ctx, "UserRepository.MarkEmailVerified")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx,
+		"UPDATE users SET email_verified = TRUE, updated_at = NOW() WHERE id = $1 AND email = $2 AND status <> 'deleted'",
+		id, email,
+	)
 	if err != nil {
-		return false, err
+		return err
 	}
-	return exists, nil
-}
\ No newline at end of file
+	return requireRowAffected(result, "user not found")
+}
+
+// Anonymize deletes an account by scrubbing its personal data. The row itself
+// stays so orders keep their owner; the account can no longer log in since
+// the password hash is cleared and the username is replaced. Failed logins
+// against the account are deleted with it, since they name it and its
+// clients' addresses; the audit event only records the change of status.
+func (r *PostgresUserRepository) Anonymize(ctx context.Context, id uint) error {
+	ctx, span := startSpan(ctx, "UserRepository.Anonymize")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var username, status string
+	err = tx.QueryRowContext(ctx, "SELECT username, status FROM users WHERE id = $1 AND status <> 'deleted' FOR UPDATE", id).
+		Scan(&username, &status)
+	if err == sql.ErrNoRows {
+		return errors.New("user not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE users SET
+			username = 'deleted-' || id,
+			email = 'deleted-' || id || '@deleted.invalid',
+			full_name = NULL,
+			password_hash = '',
+			email_verified = FALSE,
+			status = 'deleted',
+			deleted_at = NOW(),
+			updated_at = NOW()
+		WHERE id = $1 AND status <> 'deleted'`, id)
+	if err != nil {
+		return err
+	}
+	if err := requireRowAffected(result, "user not found"); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE orders SET shipping_address = '[deleted]', updated_at = NOW() WHERE user_id = $1", id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM cart WHERE user_id = $1", id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_two_factor WHERE user_id = $1", id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = $1", id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_identities WHERE user_id = $1", id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM login_failures WHERE user_id = $1 OR LOWER(username) = LOWER($2)", id, username); err != nil {
+		return err
+	}
+
+	if err := recordAudit(ctx, tx, model.AuditUserAnonymize, model.AuditEntityUser, id,
+		map[string]string{"status": status}, map[string]string{"status": model.UserStatusDeleted}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// List returns a page of users matching filter along with the total number
+// of matches. Query matches username, email or full name.
+func (r *PostgresUserRepository) List(ctx context.Context, filter model.UserFilter) ([]model.User, int, error) {
+	ctx, span := startSpan(ctx, "UserRepository.List")
+	defer span.End()
+
+	where := "WHERE ($1 = '' OR username ILIKE '%' || $1 || '%' OR email ILIKE '%' || $1 || '%' OR full_name ILIKE '%' || $1 || '%')" +
+		" AND ($2 = '' OR role = $2) AND ($3 = '' OR status = $3)"
+	args := []interface{}{escapeLike(filter.Query), filter.Role, filter.Status}
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+userColumns+" FROM users "+where+" ORDER BY id LIMIT $4 OFFSET $5",
+		append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []model.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, *user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+func (r *PostgresUserRepository) UpdateStatus(ctx context.Context, id uint, status string) (*model.User, error) {
+	ctx, span := startSpan(ctx, "UserRepository.UpdateStatus")
+	defer span.End()
+
+	return r.updateAudited(ctx, id, model.AuditUserStatus, "UPDATE users SET status = $1, updated_at = NOW() WHERE id = $2 RETURNING "+userColumns, status)
+}
+
+func (r *PostgresUserRepository) UpdateRole(ctx context.Context, id uint, role string) (*model.User, error) {
+	ctx, span := startSpan(ctx, "UserRepository.UpdateRole")
+	defer span.End()
+
+	return r.updateAudited(ctx, id, model.AuditUserRole, "UPDATE users SET role = $1, updated_at = NOW() WHERE id = $2 RETURNING "+userColumns, role)
+}
+
+// updateAudited runs update with value and id on a user that is not
+// deleted, and records action with the user before and after, in one
+// transaction.
+func (r *PostgresUserRepository) updateAudited(ctx context.Context, id uint, action, update string, value string) (*model.User, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	before, err := scanUser(tx.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1 AND status <> 'deleted' FOR UPDATE", id))
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := scanUser(tx.QueryRowContext(ctx, update, value, id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordAudit(ctx, tx, action, model.AuditEntityUser, id, before, user); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func requireRowAffected(result sql.Result, notFound string) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return errors.New(notFound)
+	}
+	return nil
+}
+
+// escapeLike escapes the LIKE wildcards in s so it matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,438 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"test-ordent/internal/model"
+)
+
+type WebhookRepository interface {
+	CreateEndpoint(ctx context.Context, endpoint *model.WebhookEndpoint) (*model.WebhookEndpoint, error)
+	ListEndpoints(ctx context.Context) ([]model.WebhookEndpoint, error)
+	FindEndpoint(ctx context.Context, id int) (*model.WebhookEndpoint, error)
+	UpdateEndpoint(ctx context.Context, endpoint *model.WebhookEndpoint) (*model.WebhookEndpoint, error)
+	DeleteEndpoint(ctx context.Context, id int) error
+	// Enqueue adds a delivery of event to every active endpoint subscribed
+	// to its type. Enqueueing an event again adds nothing.
+	Enqueue(ctx context.Context, event model.Event) error
+	// CreatePing adds a test delivery to an endpoint, held back from
+	// ClaimDue for lease while the caller sends it.
+	CreatePing(ctx context.Context, endpointID int, payload []byte, lease time.Duration) (*model.WebhookDelivery, error)
+	// ClaimDue returns up to limit pending deliveries that are due, and
+	// holds them back from other callers for lease; a delivery whose
+	// attempt is not recorded by then is claimed again.
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]model.WebhookDispatch, error)
+	// RecordAttempt logs an attempt and moves the delivery to status; a
+	// pending delivery is attempted again after retryAfter.
+	RecordAttempt(ctx context.Context, deliveryID int64, attempt model.WebhookAttempt, status string, retryAfter time.Duration) error
+	ListDeliveries(ctx context.Context, filter model.WebhookDeliveryFilter) ([]model.WebhookDelivery, int, error)
+	// FindDelivery returns a delivery of an endpoint with its attempt log.
+	FindDelivery(ctx context.Context, endpointID int, id int64) (*model.WebhookDelivery, error)
+	// Redeliver makes a delivered or dead delivery pending again, with a
+	// fresh set of attempts.
+	Redeliver(ctx context.Context, endpointID int, id int64) (*model.WebhookDelivery, error)
+}
+
+// ErrDeliveryPending is returned when redelivering a delivery that is still
+// being attempted.
+var ErrDeliveryPending = errors.New("delivery is still pending")
+
+type PostgresWebhookRepository struct {
+	db *sql.DB
+}
+
+func NewWebhookRepository(db *sql.DB) WebhookRepository {
+	return &PostgresWebhookRepository{db: db}
+}
+
+const webhookEndpointColumns = "id, name, url, secret, event_types, active, created_by, created_at, updated_at"
+
+func scanWebhookEndpoint(row rowScanner) (*model.WebhookEndpoint, error) {
+	var e model.WebhookEndpoint
+	var createdBy sql.NullInt64
+	err := row.Scan(&e.ID, &e.Name, &e.URL, &e.Secret, pq.Array(&e.EventTypes), &e.Active, &createdBy, &e.CreatedAt, &e.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("webhook endpoint not found")
+		}
+		return nil, err
+	}
+	if createdBy.Valid {
+		id := uint(createdBy.Int64)
+		e.CreatedBy = &id
+	}
+	return &e, nil
+}
+
+const webhookDeliveryColumns = `d.id, d.endpoint_id, d.event_id, d.event_type, d.payload, d.status, d.attempts, d.next_attempt_at,
+	d.last_status_code, d.last_error, d.created_at, d.delivered_at`
+
+func scanWebhookDelivery(row rowScanner, extra ...interface{}) (*model.WebhookDelivery, error) {
+	var d model.WebhookDelivery
+	var eventID, lastStatusCode sql.NullInt64
+	var payload []byte
+	var nextAttemptAt time.Time
+	dest := []interface{}{&d.ID, &d.EndpointID, &eventID, &d.EventType, &payload, &d.Status, &d.Attempts, &nextAttemptAt,
+		&lastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("webhook delivery not found")
+		}
+		return nil, err
+	}
+	d.Payload = payload
+	if eventID.Valid {
+		d.EventID = &eventID.Int64
+	}
+	if lastStatusCode.Valid {
+		code := int(lastStatusCode.Int64)
+		d.LastStatusCode = &code
+	}
+	if d.Status == model.WebhookDeliveryPending {
+		d.NextAttemptAt = &nextAttemptAt
+	}
+	return &d, nil
+}
+
+func (r *PostgresWebhookRepository) CreateEndpoint(ctx context.Context, endpoint *model.WebhookEndpoint) (*model.WebhookEndpoint, error) {
+	ctx, span := startSpan(ctx, "WebhookRepository.CreateEndpoint")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	created, err := scanWebhookEndpoint(tx.QueryRowContext(ctx,
+		`INSERT INTO webhook_endpoints (name, url, secret, event_types, active, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+webhookEndpointColumns,
+		endpoint.Name, endpoint.URL, endpoint.Secret, pq.Array(endpoint.EventTypes), endpoint.Active, endpoint.CreatedBy,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordAudit(ctx, tx, model.AuditWebhookCreate, model.AuditEntityWebhook, created.ID, nil, created); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+func (r *PostgresWebhookRepository) ListEndpoints(ctx context.Context) ([]model.WebhookEndpoint, error) {
+	ctx, span := startSpan(ctx, "WebhookRepository.ListEndpoints")
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+webhookEndpointColumns+" FROM webhook_endpoints ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	endpoints := []model.WebhookEndpoint{}
+	for rows.Next() {
+		e, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, *e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return endpoints, nil
+}
+
+func (r *PostgresWebhookRepository) FindEndpoint(ctx context.Context, id int) (*model.WebhookEndpoint, error) {
+	ctx, span := startSpan(ctx, "WebhookRepository.FindEndpoint")
+	defer span.End()
+
+	return scanWebhookEndpoint(r.db.QueryRowContext(ctx, "SELECT "+webhookEndpointColumns+" FROM webhook_endpoints WHERE id = $1", id))
+}
+
+// UpdateEndpoint changes the name, URL, event types and active flag; the
+// secret stays the same.
+func (r *PostgresWebhookRepository) UpdateEndpoint(ctx context.Context, endpoint *model.WebhookEndpoint) (*model.WebhookEndpoint, error) {
+	ctx, span := startSpan(ctx, "WebhookRepository.UpdateEndpoint")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	before, err := scanWebhookEndpoint(tx.QueryRowContext(ctx, "SELECT "+webhookEndpointColumns+" FROM webhook_endpoints WHERE id = $1 FOR UPDATE", endpoint.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := scanWebhookEndpoint(tx.QueryRowContext(ctx,
+		`UPDATE webhook_endpoints SET name = $2, url = $3, event_types = $4, active = $5, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+webhookEndpointColumns,
+		endpoint.ID, endpoint.Name, endpoint.URL, pq.Array(endpoint.EventTypes), endpoint.Active,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordAudit(ctx, tx, model.AuditWebhookUpdate, model.AuditEntityWebhook, endpoint.ID, before, updated); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// DeleteEndpoint removes the endpoint along with its deliveries.
+func (r *PostgresWebhookRepository) DeleteEndpoint(ctx context.Context, id int) error {
+	ctx, span := startSpan(ctx, "WebhookRepository.DeleteEndpoint")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := scanWebhookEndpoint(tx.QueryRowContext(ctx, "DELETE FROM webhook_endpoints WHERE id = $1 RETURNING "+webhookEndpointColumns, id))
+	if err != nil {
+		return err
+	}
+
+	if err := recordAudit(ctx, tx, model.AuditWebhookDelete, model.AuditEntityWebhook, id, before, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *PostgresWebhookRepository) Enqueue(ctx context.Context, event model.Event) error {
+	ctx, span := startSpan(ctx, "WebhookRepository.Enqueue")
+	defer span.End()
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (endpoint_id, event_id, event_type, payload)
+		SELECT id, $1::bigint, $2, $3::jsonb FROM webhook_endpoints
+		WHERE active AND ($4 = ANY(event_types) OR '*' = ANY(event_types))
+		ON CONFLICT (endpoint_id, event_id) DO NOTHING
+	`, event.ID, event.Type, string(payload), event.Type)
+	return err
+}
+
+func (r *PostgresWebhookRepository) CreatePing(ctx context.Context, endpointID int, payload []byte, lease time.Duration) (*model.WebhookDelivery, error) {
+	ctx, span := startSpan(ctx, "WebhookRepository.CreatePing")
+	defer span.End()
+
+	return scanWebhookDelivery(r.db.QueryRowContext(ctx, `
+		INSERT INTO webhook_deliveries AS d (endpoint_id, event_type, payload, next_attempt_at)
+		VALUES ($1, $2, $3, NOW() + make_interval(secs => $4))
+		RETURNING `+webhookDeliveryColumns,
+		endpointID, model.EventWebhookPing, string(payload), lease.Seconds(),
+	))
+}
+
+func (r *PostgresWebhookRepository) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]model.WebhookDispatch, error) {
+	ctx, span := startSpan(ctx, "WebhookRepository.ClaimDue")
+	defer span.End()
+
+	rows, err := r.db.QueryContext(ctx, `
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = NOW() + make_interval(secs => $2)
+		FROM webhook_endpoints e
+		WHERE e.id = d.endpoint_id AND d.id IN (
+			SELECT due.id FROM webhook_deliveries due
+			JOIN webhook_endpoints de ON de.id = due.endpoint_id
+			WHERE due.status = 'pending' AND due.next_attempt_at <= NOW() AND de.active
+			ORDER BY due.next_attempt_at, due.id
+			LIMIT $1
+			FOR UPDATE OF due SKIP LOCKED
+		)
+		RETURNING `+webhookDeliveryColumns+`, e.url, e.secret
+	`, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dispatches []model.WebhookDispatch
+	for rows.Next() {
+		var dispatch model.WebhookDispatch
+		d, err := scanWebhookDelivery(rows, &dispatch.URL, &dispatch.Secret)
+		if err != nil {
+			return nil, err
+		}
+		dispatch.Delivery = *d
+		dispatches = append(dispatches, dispatch)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return dispatches, nil
+}
+
+func (r *PostgresWebhookRepository) RecordAttempt(ctx context.Context, deliveryID int64, attempt model.WebhookAttempt, status string, retryAfter time.Duration) error {
+	ctx, span := startSpan(ctx, "WebhookRepository.RecordAttempt")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO webhook_delivery_attempts (delivery_id, status_code, response_body, error, duration_ms)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5)
+	`, deliveryID, attempt.StatusCode, attempt.ResponseBody, attempt.Error, attempt.DurationMS); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET attempts = attempts + 1, status = $2, last_status_code = NULLIF($3, 0), last_error = $4,
+			next_attempt_at = NOW() + make_interval(secs => $5),
+			delivered_at = CASE WHEN $2 = 'delivered' THEN NOW() END
+		WHERE id = $1
+	`, deliveryID, status, attempt.StatusCode, attempt.Error, retryAfter.Seconds()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListDeliveries returns a page of deliveries, newest first, along with the
+// total number of matches.
+func (r *PostgresWebhookRepository) ListDeliveries(ctx context.Context, filter model.WebhookDeliveryFilter) ([]model.WebhookDelivery, int, error) {
+	ctx, span := startSpan(ctx, "WebhookRepository.ListDeliveries")
+	defer span.End()
+
+	where := " FROM webhook_deliveries d WHERE d.endpoint_id = $1 AND ($2 = '' OR d.status = $2)"
+	args := []interface{}{filter.EndpointID, filter.Status}
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*)"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT "+webhookDeliveryColumns+where+" ORDER BY d.id DESC LIMIT $3 OFFSET $4",
+		append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	deliveries := []model.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return deliveries, total, nil
+}
+
+func (r *PostgresWebhookRepository) FindDelivery(ctx context.Context, endpointID int, id int64) (*model.WebhookDelivery, error) {
+	ctx, span := startSpan(ctx, "WebhookRepository.FindDelivery")
+	defer span.End()
+
+	d, err := scanWebhookDelivery(r.db.QueryRowContext(ctx,
+		"SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries d WHERE d.id = $1 AND d.endpoint_id = $2", id, endpointID))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT COALESCE(status_code, 0), response_body, error, duration_ms, created_at
+		FROM webhook_delivery_attempts
+		WHERE delivery_id = $1
+		ORDER BY id
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	d.AttemptLog = []model.WebhookAttempt{}
+	for rows.Next() {
+		var a model.WebhookAttempt
+		if err := rows.Scan(&a.StatusCode, &a.ResponseBody, &a.Error, &a.DurationMS, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		d.AttemptLog = append(d.AttemptLog, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (r *PostgresWebhookRepository) Redeliver(ctx context.Context, endpointID int, id int64) (*model.WebhookDelivery, error) {
+	ctx, span := startSpan(ctx, "WebhookRepository.Redeliver")
+	defer span.End()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	d, err := scanWebhookDelivery(tx.QueryRowContext(ctx,
+		"SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries d WHERE d.id = $1 AND d.endpoint_id = $2 FOR UPDATE", id, endpointID))
+	if err != nil {
+		return nil, err
+	}
+	if d.Status == model.WebhookDeliveryPending {
+		return nil, ErrDeliveryPending
+	}
+
+	d, err = scanWebhookDelivery(tx.QueryRowContext(ctx, `
+		UPDATE webhook_deliveries AS d
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW(), last_error = '', delivered_at = NULL
+		WHERE d.id = $1
+		RETURNING `+webhookDeliveryColumns,
+		id,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
@@ -0,0 +1,106 @@
+// Package security holds the HTTP middleware that hardens every response:
+// CORS, browser security headers and request body limits.
+package security
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+
+	"test-ordent/config"
+	"test-ordent/internal/model"
+)
+
+// CORS builds the CORS middleware from cfg.
+func CORS(cfg config.CORSConfig) (echo.MiddlewareFunc, error) {
+	if cfg.AllowCredentials {
+		for _, origin := range cfg.AllowedOrigins {
+			if origin == "*" {
+				return nil, errors.New("cors: allow_credentials cannot be used with the \"*\" origin")
+			}
+		}
+	}
+
+	return middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins:     cfg.AllowedOrigins,
+		AllowMethods:     cfg.AllowedMethods,
+		AllowHeaders:     cfg.AllowedHeaders,
+		ExposeHeaders:    cfg.ExposedHeaders,
+		AllowCredentials: cfg.AllowCredentials,
+		MaxAge:           int(cfg.MaxAge.Seconds()),
+	}), nil
+}
+
+// Headers sets the security headers from cfg. Requests under docsPrefix get
+// the docs content security policy instead of the API one.
+func Headers(cfg config.SecurityHeadersConfig, docsPrefix string) echo.MiddlewareFunc {
+	secure := middleware.SecureConfig{
+		ContentTypeNosniff:    "nosniff",
+		XFrameOptions:         cfg.FrameOptions,
+		HSTSMaxAge:            int(cfg.HSTSMaxAge.Seconds()),
+		HSTSExcludeSubdomains: !cfg.HSTSIncludeSubdomains,
+		HSTSPreloadEnabled:    cfg.HSTSPreload,
+		ContentSecurityPolicy: cfg.ContentSecurityPolicy,
+		ReferrerPolicy:        cfg.ReferrerPolicy,
+	}
+	api := middleware.SecureWithConfig(secure)
+	secure.ContentSecurityPolicy = cfg.DocsContentSecurityPolicy
+	docs := middleware.SecureWithConfig(secure)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		apiNext, docsNext := api(next), docs(next)
+		return func(c echo.Context) error {
+			if docsPrefix != "" && strings.HasPrefix(c.Request().URL.Path, docsPrefix) {
+				return docsNext(c)
+			}
+			return apiNext(c)
+		}
+	}
+}
+
+// BodyLimit rejects request bodies larger than the limit for their route.
+// Bodies sent without a Content-Length are cut off at the limit.
+func BodyLimit(cfg config.BodyLimitConfig) (echo.MiddlewareFunc, error) {
+	for _, route := range cfg.Routes {
+		if route.Limit <= 0 {
+			return nil, fmt.Errorf("body limit for %v must be positive", route.Paths)
+		}
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			limit := limitFor(cfg, c.Path())
+			if limit <= 0 {
+				return next(c)
+			}
+
+			req := c.Request()
+			if req.ContentLength > limit {
+				return c.JSON(http.StatusRequestEntityTooLarge, model.ErrorResponse{Error: fmt.Sprintf("Request body exceeds the maximum size of %d bytes", limit)})
+			}
+			if req.Body != nil && req.Body != http.NoBody {
+				req.Body = http.MaxBytesReader(c.Response(), req.Body, limit)
+			}
+			return next(c)
+		}
+	}, nil
+}
+
+func limitFor(cfg config.BodyLimitConfig, path string) int64 {
+	for _, route := range cfg.Routes {
+		for _, pattern := range route.Paths {
+			if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern {
+				if strings.HasPrefix(path, prefix) {
+					return route.Limit
+				}
+			} else if path == pattern {
+				return route.Limit
+			}
+		}
+	}
+	return cfg.Default
+}
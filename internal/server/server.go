@@ -0,0 +1,81 @@
+// Package server runs the HTTP server and shuts it down without cutting off
+// requests in flight.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/config"
+	"test-ordent/internal/health"
+	"test-ordent/pkg/logger"
+)
+
+// Configure applies the timeouts in cfg to the server behind e and sets how
+// the client IP is found.
+func Configure(e *echo.Echo, cfg config.ServerConfig) {
+	e.Server.ReadTimeout = cfg.ReadTimeout
+	e.Server.ReadHeaderTimeout = cfg.ReadHeaderTimeout
+	e.Server.WriteTimeout = cfg.WriteTimeout
+	e.Server.IdleTimeout = cfg.IdleTimeout
+	e.IPExtractor = IPExtractor(cfg.TrustedProxies)
+}
+
+// IPExtractor finds the client IP. X-Forwarded-For is only followed through
+// the given proxy ranges, so clients cannot pick their own address; with no
+// ranges the peer address is used and the header ignored.
+func IPExtractor(trustedProxies []string) echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, cidr := range trustedProxies {
+		// Validated by config.
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			options = append(options, echo.TrustIPRange(ipNet))
+		}
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+// Serve starts e on addr and blocks until ctx is cancelled or the server
+// fails. On cancellation readiness fails for cfg.DrainDelay while requests
+// are still served, then the listener is closed and in-flight requests get
+// up to cfg.ShutdownTimeout to finish.
+func Serve(ctx context.Context, e *echo.Echo, addr string, cfg config.ServerConfig, readiness *health.Readiness, logger *logger.Logger) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- e.Start(addr)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("failed to start server: %w", err)
+	case <-ctx.Done():
+	}
+
+	logger.Info("Shutting down: failing readiness before draining", "drain_delay", cfg.DrainDelay.String())
+	readiness.SetDraining()
+	select {
+	case <-time.After(cfg.DrainDelay):
+	case err := <-serveErr:
+		return fmt.Errorf("server failed while draining: %w", err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to drain requests within %s: %w", cfg.ShutdownTimeout, err)
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	logger.Info("HTTP server stopped")
+	return nil
+}
@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceIDHeader returns the trace ID of traced requests, for clients to
+// quote when reporting a problem.
+const TraceIDHeader = "X-Trace-ID"
+
+var tracer = otel.Tracer("test-ordent/internal/tracing")
+
+// Middleware starts a server span for every request, continuing the trace of
+// the caller when the request carries a traceparent header. It has to run
+// before the logger middleware, so log lines carry the trace ID. Spans are
+// named after the route template, such as "GET /api/products/:id".
+func Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+		name := req.Method
+		attrs := []attribute.KeyValue{
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLPath(req.URL.Path),
+			semconv.ClientAddress(c.RealIP()),
+			semconv.UserAgentOriginal(req.UserAgent()),
+		}
+		// The route is known before the handler runs: Echo matches it
+		// ahead of the middleware added with Use.
+		if route := c.Path(); route != "" {
+			name += " " + route
+			attrs = append(attrs, semconv.HTTPRoute(route))
+		}
+		ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+		defer span.End()
+
+		c.SetRequest(req.WithContext(ctx))
+		if sc := span.SpanContext(); sc.HasTraceID() {
+			c.Response().Header().Set(TraceIDHeader, sc.TraceID().String())
+		}
+
+		err := next(c)
+		if err != nil {
+			// Let the error handler write the response, so its status is
+			// the one recorded.
+			c.Error(err)
+			span.RecordError(err)
+		}
+
+		status := c.Response().Status
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		if userID, ok := c.Get("user_id").(uint); ok {
+			span.SetAttributes(semconv.EnduserID(strconv.FormatUint(uint64(userID), 10)))
+		}
+		return nil
+	}
+}
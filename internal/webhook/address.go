@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+)
+
+// ErrForbiddenAddress is returned for endpoints on the server's own network.
+var ErrForbiddenAddress = errors.New("address is not allowed for webhooks")
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), which some
+// clouds use for internal services.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// addressPolicy keeps webhook requests away from the server's own network:
+// loopback, private and link-local addresses, the last of which includes
+// cloud metadata services, unless they are in one of the allowed networks.
+type addressPolicy struct {
+	allowed []*net.IPNet
+}
+
+// newAddressPolicy parses allowed, which config validation has already
+// checked to be CIDR ranges.
+func newAddressPolicy(allowed []string) addressPolicy {
+	var p addressPolicy
+	for _, cidr := range allowed {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			p.allowed = append(p.allowed, network)
+		}
+	}
+	return p
+}
+
+func (p addressPolicy) check(ip net.IP) error {
+	for _, network := range p.allowed {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// control is a net.Dialer Control function. It checks the address actually
+// dialled, after DNS resolution, so a host name that later resolves to an
+// internal address is refused too.
+func (p addressPolicy) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	return p.check(ip)
+}
+
+// CheckURL resolves the host of an endpoint URL and returns
+// ErrForbiddenAddress if any of its addresses may not receive webhooks.
+func (d *Deliverer) CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		return d.addresses.check(ip)
+	}
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		if err := d.addresses.check(ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
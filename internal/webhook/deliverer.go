@@ -0,0 +1,227 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"test-ordent/config"
+	"test-ordent/internal/auth"
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+	"test-ordent/internal/version"
+)
+
+// maxResponseBody is how much of a response is kept in the delivery log.
+const maxResponseBody = 1024
+
+// Deliverer sends webhook deliveries and records every attempt.
+type Deliverer struct {
+	repo        repository.WebhookRepository
+	cipher      *auth.SecretCipher
+	client      *http.Client
+	addresses   addressPolicy
+	timeout     time.Duration
+	concurrency int
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+}
+
+func NewDeliverer(repo repository.WebhookRepository, cipher *auth.SecretCipher, cfg config.WebhooksConfig) *Deliverer {
+	addresses := newAddressPolicy(cfg.AllowedNetworks)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Requests go straight to the endpoint, so the address checked when
+	// dialling is the endpoint's and not that of a proxy.
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   addresses.control,
+	}).DialContext
+
+	return &Deliverer{
+		repo:      repo,
+		cipher:    cipher,
+		addresses: addresses,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+			// A redirect is a failed attempt; the signature is only meant for
+			// the configured URL.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		timeout:     cfg.Timeout,
+		concurrency: cfg.Concurrency,
+		maxAttempts: cfg.MaxAttempts,
+		backoff:     cfg.RetryBackoff,
+		maxBackoff:  cfg.MaxRetryBackoff,
+	}
+}
+
+// DeliverDue sends up to limit due deliveries, concurrency at a time, and
+// returns how many were claimed.
+func (d *Deliverer) DeliverDue(ctx context.Context, limit int) (int, error) {
+	// Long enough for the whole batch; deliveries not recorded by then, e.g.
+	// after a crash, are claimed again.
+	rounds := (limit + d.concurrency - 1) / d.concurrency
+	lease := d.timeout*time.Duration(rounds) + time.Minute
+
+	dispatches, err := d.repo.ClaimDue(ctx, limit, lease)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	sem := make(chan struct{}, d.concurrency)
+	for i := range dispatches {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(dispatch *model.WebhookDispatch) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if _, err := d.deliver(ctx, dispatch.URL, dispatch.Secret, &dispatch.Delivery, true); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("delivery %d: %w", dispatch.Delivery.ID, err))
+				mu.Unlock()
+			}
+		}(&dispatches[i])
+	}
+	wg.Wait()
+
+	return len(dispatches), errors.Join(errs...)
+}
+
+// Ping sends a webhook.ping event to an endpoint, active or not, and returns
+// the delivery with its attempt. Pings are not retried.
+func (d *Deliverer) Ping(ctx context.Context, endpointID int) (*model.WebhookDelivery, error) {
+	endpoint, err := d.repo.FindEndpoint(ctx, endpointID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"endpoint_id": endpoint.ID, "name": endpoint.Name})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(model.Event{
+		Type:          model.EventWebhookPing,
+		AggregateType: model.AggregateWebhook,
+		AggregateID:   strconv.Itoa(endpoint.ID),
+		Payload:       data,
+		OccurredAt:    time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	delivery, err := d.repo.CreatePing(ctx, endpoint.ID, payload, d.timeout+time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := d.deliver(ctx, endpoint.URL, endpoint.Secret, delivery, false); err != nil {
+		return nil, err
+	}
+
+	return d.repo.FindDelivery(ctx, endpoint.ID, delivery.ID)
+}
+
+// deliver makes one attempt and records it. With retry, a failed delivery
+// stays pending until it has used up its attempts; without, it is dead.
+func (d *Deliverer) deliver(ctx context.Context, url, encryptedSecret string, delivery *model.WebhookDelivery, retry bool) (*model.WebhookAttempt, error) {
+	attempt := d.send(ctx, url, encryptedSecret, delivery)
+	if err := ctx.Err(); err != nil {
+		if retry {
+			// Shutting down: the attempt is not the endpoint's fault. The
+			// delivery is claimed again when its lease runs out.
+			return nil, err
+		}
+		// The caller gave up on a ping. It is recorded anyway, so it is
+		// not left pending for the dispatcher to send again.
+		if attempt.Error == "" {
+			attempt.Error = err.Error()
+		}
+		if err := d.repo.RecordAttempt(context.WithoutCancel(ctx), delivery.ID, attempt, model.WebhookDeliveryDead, 0); err != nil {
+			return nil, err
+		}
+		return nil, err
+	}
+
+	status, retryAfter := model.WebhookDeliveryDelivered, time.Duration(0)
+	if attempt.Error != "" {
+		status = model.WebhookDeliveryDead
+		if retry && delivery.Attempts+1 < d.maxAttempts {
+			status, retryAfter = model.WebhookDeliveryPending, d.retryDelay(delivery.Attempts+1)
+		}
+	}
+	if err := d.repo.RecordAttempt(ctx, delivery.ID, attempt, status, retryAfter); err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// retryDelay is the delay after the given number of failed attempts.
+func (d *Deliverer) retryDelay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts && delay < d.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.maxBackoff {
+		delay = d.maxBackoff
+	}
+	return delay
+}
+
+// send posts the delivery's payload, signed with the endpoint's secret.
+func (d *Deliverer) send(ctx context.Context, url, encryptedSecret string, delivery *model.WebhookDelivery) model.WebhookAttempt {
+	sentAt := time.Now()
+	attempt := model.WebhookAttempt{CreatedAt: sentAt}
+
+	secret, err := d.cipher.Decrypt(encryptedSecret)
+	if err != nil {
+		attempt.Error = "cannot decrypt the endpoint secret"
+		return attempt
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ecommerce-api/"+version.Version)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(sentAt.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(secret, sentAt, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	attempt.DurationMS = time.Since(sentAt).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	// Drained so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	attempt.StatusCode = resp.StatusCode
+	attempt.ResponseBody = string(bytes.ToValidUTF8(body, nil))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("endpoint responded %d", resp.StatusCode)
+	}
+	return attempt
+}
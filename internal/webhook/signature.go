@@ -0,0 +1,75 @@
+// Package webhook delivers events to the partner endpoints managed at
+// /admin/webhooks: it signs each request, retries failures with exponential
+// backoff and gives up on a delivery, leaving it dead, after the last
+// attempt.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Request headers. The signature is "v1=" and the hex HMAC-SHA256, keyed with
+// the endpoint secret, of the timestamp header, a dot and the body.
+const (
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+const secretPrefix = "whsec_"
+
+var (
+	ErrMissingSignature = errors.New("missing webhook signature or timestamp")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrStaleTimestamp   = errors.New("webhook timestamp outside tolerance")
+)
+
+// GenerateSecret returns a new random signing secret.
+func GenerateSecret() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return secretPrefix + base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// Sign returns the signature header value of body sent at timestamp.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a received request, as receivers should:
+// the signature has to match and the timestamp has to be within tolerance
+// of now, so captured requests cannot be replayed later.
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	signature, timestamp := header.Get(HeaderSignature), header.Get(HeaderTimestamp)
+	if signature == "" || timestamp == "" {
+		return ErrMissingSignature
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	sent := time.Unix(unix, 0)
+	if now.Sub(sent) > tolerance || sent.Sub(now) > tolerance {
+		return ErrStaleTimestamp
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, sent, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"test-ordent/internal/events"
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+	"test-ordent/pkg/logger"
+)
+
+// purgeEvery is how many relay runs pass between purges of published events.
+const purgeEvery = 600
+
+// OutboxRelay publishes the events recorded in the outbox to a sink. An
+// event is marked published only after the sink accepted it, so every event
+// is delivered at least once. After a failure, later events of the same
+// aggregate wait until the failed one is published, keeping each
+// aggregate's events in order.
+type OutboxRelay struct {
+	outboxRepo repository.OutboxRepository
+	sink       events.Sink
+	interval   time.Duration
+	batchSize  int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	retention  time.Duration
+	logger     *logger.Logger
+	runs       int
+}
+
+func NewOutboxRelay(outboxRepo repository.OutboxRepository, sink events.Sink, interval time.Duration, batchSize int,
+	backoff, maxBackoff, retention time.Duration, logger *logger.Logger) *OutboxRelay {
+	return &OutboxRelay{
+		outboxRepo: outboxRepo,
+		sink:       sink,
+		interval:   interval,
+		batchSize:  batchSize,
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+		retention:  retention,
+		logger:     logger,
+	}
+}
+
+// Run publishes pending events immediately and then on every tick until ctx
+// is cancelled. A full batch is followed by the next one without waiting.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if r.RunOnce(ctx) < r.batchSize {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// RunOnce publishes one batch of due events and returns how many were
+// attempted.
+func (r *OutboxRelay) RunOnce(ctx context.Context) int {
+	ctx, span := tracer.Start(ctx, "OutboxRelay.RunOnce")
+	defer span.End()
+
+	attempted := 0
+	_, err := r.outboxRepo.ProcessDue(ctx, r.batchSize, func(ctx context.Context, batch []model.OutboxEvent) []model.OutboxOutcome {
+		attempted = len(batch)
+		return r.publish(ctx, batch)
+	})
+	span.SetAttributes(attribute.Int("outbox.events", attempted))
+	if err != nil {
+		span.RecordError(err)
+		r.logger.Error("outbox relay: failed to process events", "error", err)
+		// Nothing was saved; the batch is published again.
+		return 0
+	}
+
+	r.runs++
+	if r.runs%purgeEvery == 1 {
+		purged, err := r.outboxRepo.PurgePublished(ctx, r.retention)
+		if err != nil {
+			span.RecordError(err)
+			r.logger.Error("outbox relay: failed to purge published events", "error", err)
+		} else if purged > 0 {
+			r.logger.Info("outbox relay: purged published events", "count", purged)
+		}
+	}
+	return attempted
+}
+
+// publish hands batch to the sink in order. Once an event fails, the rest of
+// its aggregate's events are left for after its retry.
+func (r *OutboxRelay) publish(ctx context.Context, batch []model.OutboxEvent) []model.OutboxOutcome {
+	outcomes := make([]model.OutboxOutcome, 0, len(batch))
+	blocked := make(map[[2]string]bool)
+	for _, e := range batch {
+		aggregate := [2]string{e.AggregateType, e.AggregateID}
+		if blocked[aggregate] {
+			continue
+		}
+		if err := r.sink.Publish(ctx, e.Event); err != nil {
+			blocked[aggregate] = true
+			delay := r.retryDelay(e.Attempts + 1)
+			r.logger.Warn("outbox relay: failed to publish event", "event_id", e.ID, "type", e.Type,
+				"attempts", e.Attempts+1, "retry_in", delay, "error", err)
+			outcomes = append(outcomes, model.OutboxOutcome{EventID: e.ID, Error: err.Error(), RetryAfter: delay})
+			continue
+		}
+		outcomes = append(outcomes, model.OutboxOutcome{EventID: e.ID, Published: true})
+	}
+	return outcomes
+}
+
+// retryDelay is the delay after the given number of failed attempts.
+func (r *OutboxRelay) retryDelay(attempts int) time.Duration {
+	delay := r.backoff
+	for i := 1; i < attempts && delay < r.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.maxBackoff {
+		delay = r.maxBackoff
+	}
+	return delay
+}
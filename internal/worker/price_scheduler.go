@@ -0,0 +1,76 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+
+	"test-ordent/internal/repository"
+	"test-ordent/pkg/logger"
+)
+
+var tracer = otel.Tracer("test-ordent/internal/worker")
+
+// PriceScheduler starts and ends scheduled sale prices. Prices shown to
+// customers follow the schedule window directly, so the scheduler only keeps
+// schedule statuses and the price history up to date.
+type PriceScheduler struct {
+	priceRepo repository.PriceRepository
+	interval  time.Duration
+	logger    *logger.Logger
+	now       func() time.Time
+}
+
+func NewPriceScheduler(priceRepo repository.PriceRepository, interval time.Duration, logger *logger.Logger) *PriceScheduler {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &PriceScheduler{
+		priceRepo: priceRepo,
+		interval:  interval,
+		logger:    logger,
+		now:       time.Now,
+	}
+}
+
+// Run processes due schedules immediately and then on every tick until ctx is
+// cancelled.
+func (s *PriceScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.RunOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce ends expired schedules before starting due ones, so a product whose
+// sale is handed over from one schedule to the next gets its history in order.
+func (s *PriceScheduler) RunOnce(ctx context.Context) {
+	ctx, span := tracer.Start(ctx, "PriceScheduler.RunOnce")
+	defer span.End()
+	now := s.now()
+
+	ended, err := s.priceRepo.EndExpired(ctx, now)
+	if err != nil {
+		span.RecordError(err)
+		s.logger.Error("price scheduler: failed to end expired schedules", "error", err)
+	} else if ended > 0 {
+		s.logger.Info("price scheduler: ended schedules", "count", ended)
+	}
+
+	started, err := s.priceRepo.ActivateDue(ctx, now)
+	if err != nil {
+		span.RecordError(err)
+		s.logger.Error("price scheduler: failed to start due schedules", "error", err)
+	} else if started > 0 {
+		s.logger.Info("price scheduler: started schedules", "count", started)
+	}
+}
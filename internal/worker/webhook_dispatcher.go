@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"test-ordent/internal/webhook"
+	"test-ordent/pkg/logger"
+)
+
+// WebhookDispatcher sends due webhook deliveries. Deliveries are claimed
+// with a lease, so several instances can run it side by side.
+type WebhookDispatcher struct {
+	deliverer *webhook.Deliverer
+	interval  time.Duration
+	batchSize int
+	logger    *logger.Logger
+}
+
+func NewWebhookDispatcher(deliverer *webhook.Deliverer, interval time.Duration, batchSize int, logger *logger.Logger) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		deliverer: deliverer,
+		interval:  interval,
+		batchSize: batchSize,
+		logger:    logger,
+	}
+}
+
+// Run sends due deliveries immediately and then on every tick until ctx is
+// cancelled. A full batch is followed by the next one without waiting.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		if d.RunOnce(ctx) < d.batchSize {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// RunOnce sends one batch of due deliveries and returns how many were
+// claimed.
+func (d *WebhookDispatcher) RunOnce(ctx context.Context) int {
+	ctx, span := tracer.Start(ctx, "WebhookDispatcher.RunOnce")
+	defer span.End()
+
+	claimed, err := d.deliverer.DeliverDue(ctx, d.batchSize)
+	span.SetAttributes(attribute.Int("webhook.deliveries", claimed))
+	if err != nil && ctx.Err() == nil {
+		span.RecordError(err)
+		d.logger.Error("webhook dispatcher: failed to deliver webhooks", "error", err)
+	}
+	return claimed
+}
@@ -0,0 +1,28 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/labstack/echo/v4"
+)
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying l.
+func NewContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or slog's default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// FromEcho returns the request's logger, which carries its request ID.
+func FromEcho(c echo.Context) *slog.Logger {
+	return FromContext(c.Request().Context())
+}
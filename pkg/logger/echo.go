@@ -0,0 +1,93 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// echoLogger lets Echo, and handlers calling c.Logger(), write through slog.
+// Its level, output and prefix are fixed by the slog logger and cannot be
+// changed through the echo.Logger interface.
+type echoLogger struct {
+	logger *slog.Logger
+}
+
+// Echo adapts l to echo.Logger, for Echo#Logger.
+func (l *Logger) Echo() echo.Logger {
+	return &echoLogger{logger: l.Logger}
+}
+
+func (l *echoLogger) log(level slog.Level, msg string) {
+	l.logger.Log(context.Background(), level, msg)
+}
+
+func (l *echoLogger) logj(level slog.Level, j log.JSON) {
+	attrs := make([]any, 0, len(j)*2)
+	for k, v := range j {
+		attrs = append(attrs, k, v)
+	}
+	l.logger.Log(context.Background(), level, "", attrs...)
+}
+
+func (l *echoLogger) Output() io.Writer      { return io.Discard }
+func (l *echoLogger) SetOutput(w io.Writer)  {}
+func (l *echoLogger) Prefix() string         { return "" }
+func (l *echoLogger) SetPrefix(p string)     {}
+func (l *echoLogger) Level() log.Lvl         { return log.DEBUG }
+func (l *echoLogger) SetLevel(v log.Lvl)     {}
+func (l *echoLogger) SetHeader(h string)     {}
+func (l *echoLogger) Print(i ...interface{}) { l.log(slog.LevelInfo, fmt.Sprint(i...)) }
+func (l *echoLogger) Printj(j log.JSON)      { l.logj(slog.LevelInfo, j) }
+func (l *echoLogger) Debug(i ...interface{}) { l.log(slog.LevelDebug, fmt.Sprint(i...)) }
+func (l *echoLogger) Debugj(j log.JSON)      { l.logj(slog.LevelDebug, j) }
+func (l *echoLogger) Info(i ...interface{})  { l.log(slog.LevelInfo, fmt.Sprint(i...)) }
+func (l *echoLogger) Infoj(j log.JSON)       { l.logj(slog.LevelInfo, j) }
+func (l *echoLogger) Warn(i ...interface{})  { l.log(slog.LevelWarn, fmt.Sprint(i...)) }
+func (l *echoLogger) Warnj(j log.JSON)       { l.logj(slog.LevelWarn, j) }
+func (l *echoLogger) Error(i ...interface{}) { l.log(slog.LevelError, fmt.Sprint(i...)) }
+func (l *echoLogger) Errorj(j log.JSON)      { l.logj(slog.LevelError, j) }
+func (l *echoLogger) Panicj(j log.JSON)      { l.logj(slog.LevelError, j); panic(j) }
+func (l *echoLogger) Fatalj(j log.JSON)      { l.logj(slog.LevelError, j); os.Exit(1) }
+func (l *echoLogger) Fatal(i ...interface{}) { l.log(slog.LevelError, fmt.Sprint(i...)); os.Exit(1) }
+func (l *echoLogger) Panic(i ...interface{}) {
+	msg := fmt.Sprint(i...)
+	l.log(slog.LevelError, msg)
+	panic(msg)
+}
+
+func (l *echoLogger) Printf(format string, args ...interface{}) {
+	l.log(slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (l *echoLogger) Debugf(format string, args ...interface{}) {
+	l.log(slog.LevelDebug, fmt.Sprintf(format, args...))
+}
+
+func (l *echoLogger) Infof(format string, args ...interface{}) {
+	l.log(slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (l *echoLogger) Warnf(format string, args ...interface{}) {
+	l.log(slog.LevelWarn, fmt.Sprintf(format, args...))
+}
+
+func (l *echoLogger) Errorf(format string, args ...interface{}) {
+	l.log(slog.LevelError, fmt.Sprintf(format, args...))
+}
+
+func (l *echoLogger) Fatalf(format string, args ...interface{}) {
+	l.log(slog.LevelError, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+func (l *echoLogger) Panicf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	l.log(slog.LevelError, msg)
+	panic(msg)
+}
@@ -1,58 +1,178 @@
+// Package logger writes structured logs with log/slog. Attributes that carry
+// credentials are redacted before they are written, wherever they come from.
 package logger
 
 import (
-	"log"
-	"os"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
 )
 
+// Formats.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Redacted replaces the value of sensitive attributes.
+const Redacted = "[REDACTED]"
+
+// Logger is a slog.Logger whose level can be changed while it is in use.
 type Logger struct {
-	infoLogger  *log.Logger
-	errorLogger *log.Logger
-	debugLogger *log.Logger
-	isDebug     bool
+	*slog.Logger
+	level *slog.LevelVar
 }
 
-func NewLogger(debug bool) *Logger {
-	return &Logger{
-		infoLogger:  log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime),
-		errorLogger: log.New(os.Stderr, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile),
-		debugLogger: log.New(os.Stdout, "DEBUG: ", log.Ldate|log.Ltime|log.Lshortfile),
-		isDebug:     debug,
+// New writes logs to w as format ("json" or "text"), from level ("debug",
+// "info", "warn" or "error") up.
+func New(w io.Writer, format, level string) (*Logger, error) {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	levelVar := &slog.LevelVar{}
+	levelVar.Set(lvl)
+
+	opts := &slog.HandlerOptions{Level: levelVar, ReplaceAttr: redactAttr}
+	var handler slog.Handler
+	switch format {
+	case FormatJSON, "":
+		handler = slog.NewJSONHandler(w, opts)
+	case FormatText:
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
 	}
+	return &Logger{Logger: slog.New(handler), level: levelVar}, nil
 }
 
-func (l *Logger) Info(v ...interface{}) {
-	l.infoLogger.Println(v...)
+// Discard returns a logger that writes nothing, for tests.
+func Discard() *Logger {
+	l, _ := New(io.Discard, FormatText, "error")
+	return l
 }
 
-func (l *Logger) Infof(format string, v ...interface{}) {
-	l.infoLogger.Printf(format, v...)
+// SetLevel changes the minimum level logged.
+func (l *Logger) SetLevel(level string) error {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	l.level.Set(lvl)
+	return nil
+}
+
+// Level returns the minimum level logged.
+func (l *Logger) Level() slog.Level {
+	return l.level.Level()
 }
 
-func (l *Logger) Error(v ...interface{}) {
-	l.errorLogger.Println(v...)
+// ParseLevel parses "debug", "info", "warn" or "error".
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("unknown log level %q", level)
+	}
 }
 
-func (l *Logger) Errorf(format string, v ...interface{}) {
-	l.errorLogger.Printf(format, v...)
+// sensitiveKeys are redacted wherever they appear, as are keys ending in
+// one of sensitiveSuffixes. Keys are compared lower-cased, with "-" read as
+// "_", so header names match too.
+var (
+	sensitiveKeys = map[string]bool{
+		"password":       true,
+		"secret":         true,
+		"token":          true,
+		"authorization":  true,
+		"cookie":         true,
+		"set_cookie":     true,
+		"api_key":        true,
+		"x_api_key":      true,
+		"recovery_code":  true,
+		"recovery_codes": true,
+		"code_verifier":  true,
+	}
+	sensitiveSuffixes = []string{"_password", "_secret", "_token"}
+)
+
+// IsSensitive reports whether an attribute or field named key is redacted.
+func IsSensitive(key string) bool {
+	key = strings.ReplaceAll(strings.ToLower(key), "-", "_")
+	if sensitiveKeys[key] {
+		return true
+	}
+	for _, suffix := range sensitiveSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
 }
 
-func (l *Logger) Debug(v ...interface{}) {
-	if l.isDebug {
-		l.debugLogger.Println(v...)
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if IsSensitive(a.Key) {
+		return slog.String(a.Key, Redacted)
 	}
+	if a.Value.Kind() == slog.KindAny {
+		switch v := a.Value.Any().(type) {
+		case map[string]interface{}:
+			return slog.Any(a.Key, redactMap(v))
+		case map[string]string:
+			return slog.Any(a.Key, redactStrings(v))
+		case map[string][]string:
+			return slog.Any(a.Key, redactHeader(v))
+		case http.Header:
+			return slog.Any(a.Key, http.Header(redactHeader(v)))
+		case url.Values:
+			return slog.Any(a.Key, url.Values(redactHeader(v)))
+		}
+	}
+	return a
 }
 
-func (l *Logger) Debugf(format string, v ...interface{}) {
-	if l.isDebug {
-		l.debugLogger.Printf(format, v...)
+func redactMap(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if IsSensitive(k) {
+			v = Redacted
+		} else if nested, ok := v.(map[string]interface{}); ok {
+			v = redactMap(nested)
+		}
+		out[k] = v
 	}
+	return out
 }
 
-func (l *Logger) Fatal(v ...interface{}) {
-	l.errorLogger.Fatal(v...)
+func redactStrings(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		if IsSensitive(k) {
+			v = Redacted
+		}
+		out[k] = v
+	}
+	return out
 }
 
-func (l *Logger) Fatalf(format string, v ...interface{}) {
-	l.errorLogger.Fatalf(format, v...)
-}
\ No newline at end of file
+// redactHeader handles http.Header and url.Values.
+func redactHeader(m map[string][]string) map[string][]string {
+	out := make(map[string][]string, len(m))
+	for k, v := range m {
+		if IsSensitive(k) {
+			v = []string{Redacted}
+		}
+		out[k] = v
+	}
+	return out
+}
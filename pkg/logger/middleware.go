@@ -0,0 +1,131 @@
+package logger
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the request ID. An ID sent by a proxy is kept, so
+// one request can be followed across services.
+const RequestIDHeader = echo.HeaderXRequestID
+
+const (
+	requestIDKey = "request_id"
+	traceIDKey   = "trace_id"
+	errorKey     = "logger.error"
+	maxIDLength  = 64
+)
+
+// RequestID returns the ID of the request, set by Middleware.
+func RequestID(c echo.Context) string {
+	id, _ := c.Get(requestIDKey).(string)
+	return id
+}
+
+// SetError records err as the cause of the response, for handlers that
+// answer with an error response instead of returning err. It is logged with
+// the request.
+func SetError(c echo.Context, err error) {
+	c.Set(errorKey, err)
+}
+
+// Middleware gives every request an ID and a logger carrying it, and the
+// trace ID when the request is traced, available from FromEcho, FromContext
+// and c.Logger(), and logs the request once it is handled: route, status,
+// latency, user and error. Successful requests to quietRoutes, such as health
+// probes, are logged at debug level.
+func (l *Logger) Middleware(quietRoutes ...string) echo.MiddlewareFunc {
+	quiet := make(map[string]bool, len(quietRoutes))
+	for _, route := range quietRoutes {
+		quiet[route] = true
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			req := c.Request()
+
+			id := req.Header.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			c.Set(requestIDKey, id)
+			c.Response().Header().Set(RequestIDHeader, id)
+
+			reqLogger := l.Logger.With(slog.String(requestIDKey, id))
+			if sc := trace.SpanContextFromContext(req.Context()); sc.HasTraceID() {
+				// Set by the tracing middleware, which runs first.
+				reqLogger = reqLogger.With(slog.String(traceIDKey, sc.TraceID().String()))
+			}
+			c.SetRequest(req.WithContext(NewContext(req.Context(), reqLogger)))
+			c.SetLogger(&echoLogger{logger: reqLogger})
+
+			err := next(c)
+			if err != nil {
+				// Let the error handler write the response, so its status is
+				// the one logged.
+				c.Error(err)
+			}
+
+			res := c.Response()
+			attrs := []slog.Attr{
+				slog.String("method", req.Method),
+				slog.String("route", c.Path()),
+				slog.String("path", req.URL.Path),
+				slog.Int("status", res.Status),
+				slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.Int64("bytes_out", res.Size),
+				slog.String("remote_ip", c.RealIP()),
+			}
+			if userID, ok := c.Get("user_id").(uint); ok {
+				attrs = append(attrs, slog.Uint64("user_id", uint64(userID)))
+			}
+			if apiKeyID, ok := c.Get("api_key_id").(int); ok {
+				attrs = append(attrs, slog.Int("api_key_id", apiKeyID))
+			}
+			if err == nil {
+				err, _ = c.Get(errorKey).(error)
+			}
+			if err != nil {
+				attrs = append(attrs, slog.String("error", err.Error()))
+			}
+
+			level := slog.LevelInfo
+			switch {
+			case res.Status >= 500:
+				level = slog.LevelError
+			case res.Status >= 400:
+				level = slog.LevelWarn
+			case quiet[c.Path()]:
+				level = slog.LevelDebug
+			}
+			reqLogger.LogAttrs(req.Context(), level, "request", attrs...)
+			return nil
+		}
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxIDLength {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' || r == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
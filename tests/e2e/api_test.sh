@@ -56,8 +56,9 @@ test_endpoint() {
 # Start Testing
 echo "=== Starting E2E Tests for E-Commerce API ==="
 
-# 1. Register admin user
-test_endpoint "/auth/admin-register" "POST" 201 '{"username":"'$ADMIN_USERNAME'","email":"admin@test.com","password":"'$ADMIN_PASSWORD'","full_name":"Admin Test","admin_secret":"thisisaverysecretkey"}' "" "Admin Registration"
+# 1. Create the first admin with the bootstrap command (skipped if one exists)
+echo -e "\n=== Bootstrapping admin user ==="
+ADMIN_PASSWORD=$ADMIN_PASSWORD ${ADMIN_CLI:-./admin} users create-admin -username "$ADMIN_USERNAME" -email "admin@test.com" -full-name "Admin Test"
 
 # 2. Login as admin
 test_endpoint "/auth/login" "POST" 200 '{"username":"'$ADMIN_USERNAME'","password":"'$ADMIN_PASSWORD'"}' "" "Admin Login"
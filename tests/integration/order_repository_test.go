@@ -0,0 +1,115 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+)
+
+// placeOrder creates a customer, a product with stock 10 and an order for
+// quantity of it, and returns the order and product IDs.
+func placeOrder(t *testing.T, db *sql.DB, repo repository.OrderRepository, quantity int) (uint, int) {
+	t.Helper()
+	var userID uint
+	var productID, cartID int
+	username := fmt.Sprintf("buyer%d", time.Now().UnixNano())
+	if err := db.QueryRow("INSERT INTO users (username, email, password_hash) VALUES ($1, $1 || '@example.com', 'x') RETURNING id", username).Scan(&userID); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := db.QueryRow("INSERT INTO products (name, price, stock) VALUES ('Mug', 10, 10) RETURNING id").Scan(&productID); err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+	if err := db.QueryRow("INSERT INTO cart (user_id) VALUES ($1) RETURNING id", userID).Scan(&cartID); err != nil {
+		t.Fatalf("Failed to create cart: %v", err)
+	}
+
+	orderID, err := repo.CreateOrder(context.Background(), userID, 10*float64(quantity), "Jl. Sudirman 1", []model.OrderItem{
+		{ProductID: uint(productID), Quantity: quantity, Price: 10, Subtotal: 10 * float64(quantity)},
+	}, uint(cartID))
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+	return orderID, productID
+}
+
+func productStock(t *testing.T, db *sql.DB, productID int) (stock, version int) {
+	t.Helper()
+	if err := db.QueryRow("SELECT stock, version FROM products WHERE id = $1", productID).Scan(&stock, &version); err != nil {
+		t.Fatalf("Failed to read product: %v", err)
+	}
+	return stock, version
+}
+
+func TestCancelOrderRestoresStock(t *testing.T) {
+	db := openTestDB(t)
+	repo := repository.NewOrderRepository(db, 0)
+	orderID, productID := placeOrder(t, db, repo, 3)
+
+	if stock, _ := productStock(t, db, productID); stock != 7 {
+		t.Fatalf("Expected checkout to take stock to 7, got %d", stock)
+	}
+	_, version := productStock(t, db, productID)
+
+	order, err := repo.UpdateStatus(context.Background(), orderID, model.OrderStatusCancelled)
+	if err != nil || order.Status != model.OrderStatusCancelled {
+		t.Fatalf("Expected the order to be cancelled, got %+v: %v", order, err)
+	}
+	if stock, v := productStock(t, db, productID); stock != 10 || v != version+1 {
+		t.Errorf("Expected stock 10 at version %d after cancelling, got %d at version %d", version+1, stock, v)
+	}
+
+	var audits, events int
+	db.QueryRow("SELECT COUNT(*) FROM audit_events WHERE action = $1 AND entity_id = $2::text", model.AuditProductRestock, productID).Scan(&audits)
+	db.QueryRow("SELECT COUNT(*) FROM outbox_events WHERE event_type = $1 AND aggregate_id = $2::text", model.EventProductUpdated, productID).Scan(&events)
+	if audits != 1 || events != 1 {
+		t.Errorf("Expected the restock to be audited and published once, got %d audit events and %d outbox events", audits, events)
+	}
+
+	// A cancelled order cannot be cancelled, and restocked, again.
+	if _, err := repo.UpdateStatus(context.Background(), orderID, model.OrderStatusCancelled); err == nil {
+		t.Error("Expected a second cancellation to be rejected")
+	}
+	if stock, _ := productStock(t, db, productID); stock != 10 {
+		t.Errorf("Expected stock to stay 10, got %d", stock)
+	}
+}
+
+func TestRefundRestocksOnlyUnshippedOrders(t *testing.T) {
+	db := openTestDB(t)
+	repo := repository.NewOrderRepository(db, 0)
+	ctx := context.Background()
+
+	paid, paidProduct := placeOrder(t, db, repo, 3)
+	shipped, shippedProduct := placeOrder(t, db, repo, 4)
+	for _, step := range []struct {
+		order  uint
+		status string
+	}{
+		{paid, model.OrderStatusPaid},
+		{shipped, model.OrderStatusPaid},
+		{shipped, model.OrderStatusShipped},
+	} {
+		if _, err := repo.UpdateStatus(ctx, step.order, step.status); err != nil {
+			t.Fatalf("Failed to move order %d to %s: %v", step.order, step.status, err)
+		}
+	}
+
+	if _, err := repo.Refund(ctx, paid); err != nil {
+		t.Fatalf("Refund failed: %v", err)
+	}
+	if stock, _ := productStock(t, db, paidProduct); stock != 10 {
+		t.Errorf("Expected a refunded paid order to be restocked to 10, got %d", stock)
+	}
+
+	if _, err := repo.Refund(ctx, shipped); err != nil {
+		t.Fatalf("Refund failed: %v", err)
+	}
+	if stock, _ := productStock(t, db, shippedProduct); stock != 6 {
+		t.Errorf("Expected a refunded shipped order to leave stock at 6, got %d", stock)
+	}
+}
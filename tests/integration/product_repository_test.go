@@ -0,0 +1,47 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+)
+
+func TestImportAuditsOnlyWrittenRows(t *testing.T) {
+	db := openTestDB(t)
+	repo := repository.NewProductRepository(db, 0)
+	prefix := fmt.Sprintf("IMP%d-", time.Now().UnixNano())
+
+	results, err := repo.UpsertBySKU(context.Background(), []model.ProductRequest{
+		{SKU: prefix + "1", Name: "Mug", Price: 10, Stock: 5, CategoryID: 1},
+		// Unknown category: this row is rolled back on its own.
+		{SKU: prefix + "2", Name: "Plate", Price: 12, Stock: 5, CategoryID: 999999},
+		{SKU: prefix + "3", Name: "Bowl", Price: 8, Stock: 5, CategoryID: 1},
+	}, false, false, 0)
+	if err != nil {
+		t.Fatalf("UpsertBySKU failed: %v", err)
+	}
+	if len(results) != 3 || results[0].Err != nil || results[1].Err == nil || results[2].Err != nil {
+		t.Fatalf("Expected only the second row to fail, got %+v", results)
+	}
+
+	rows, err := db.Query(`
+		SELECT p.sku FROM audit_events a JOIN products p ON p.id::text = a.entity_id
+		WHERE a.action = $1 ORDER BY a.id`, model.AuditProductImport)
+	if err != nil {
+		t.Fatalf("Failed to read audit events: %v", err)
+	}
+	defer rows.Close()
+	var skus []string
+	for rows.Next() {
+		var sku string
+		rows.Scan(&sku)
+		skus = append(skus, sku)
+	}
+	if len(skus) != 2 || skus[0] != prefix+"1" || skus[1] != prefix+"3" {
+		t.Errorf("Expected the written rows to be audited in order, got %v", skus)
+	}
+}
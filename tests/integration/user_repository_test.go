@@ -0,0 +1,59 @@
+package integration
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+)
+
+func TestAnonymizeRemovesPersonalData(t *testing.T) {
+	db := openTestDB(t)
+	users := repository.NewUserRepository(db)
+	logins := repository.NewLoginAuditRepository(db)
+	ctx := context.Background()
+
+	var userID uint
+	username := fmt.Sprintf("budi%d", time.Now().UnixNano())
+	if err := db.QueryRow("INSERT INTO users (username, email, password_hash) VALUES ($1, $1 || '@example.com', 'x') RETURNING id", username).Scan(&userID); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	for _, failure := range []model.LoginFailure{
+		{Username: username, UserID: userID, IPAddress: "203.0.113.1", Reason: "wrong_password"},
+		// Typed with different case; not linked to the account.
+		{Username: strings.ToUpper(username), IPAddress: "203.0.113.2", Reason: "unknown_user"},
+		{Username: "someone-else", IPAddress: "203.0.113.3", Reason: "unknown_user"},
+	} {
+		if err := logins.RecordFailure(ctx, &failure); err != nil {
+			t.Fatalf("RecordFailure failed: %v", err)
+		}
+	}
+
+	if err := users.Anonymize(ctx, userID); err != nil {
+		t.Fatalf("Anonymize failed: %v", err)
+	}
+
+	var failures int
+	db.QueryRow("SELECT COUNT(*) FROM login_failures").Scan(&failures)
+	if failures != 1 {
+		t.Errorf("Expected only the failures of other accounts to remain, got %d", failures)
+	}
+
+	var before, after string
+	err := db.QueryRow("SELECT before::text, after::text FROM audit_events WHERE action = $1 AND entity_id = $2",
+		model.AuditUserAnonymize, fmt.Sprint(userID)).Scan(&before, &after)
+	if err != nil {
+		t.Fatalf("Expected an audit event: %v", err)
+	}
+	if strings.Contains(before+after, username) || !strings.Contains(after, model.UserStatusDeleted) {
+		t.Errorf("Expected the audit event to hold only the status, got %s -> %s", before, after)
+	}
+
+	if err := users.Anonymize(ctx, userID); err == nil || err.Error() != "user not found" {
+		t.Errorf("Expected a deleted account not to be anonymised twice, got %v", err)
+	}
+}
@@ -0,0 +1,434 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+
+	"test-ordent/internal/account"
+	"test-ordent/internal/auth"
+	"test-ordent/internal/handler"
+	"test-ordent/internal/mail"
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+)
+
+type fakeAccountUserRepo struct {
+	repository.UserRepository
+	users map[uint]*model.User
+}
+
+func (f *fakeAccountUserRepo) FindByEmail(ctx context.Context, email string) (*model.User, error) {
+	for _, u := range f.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
+
+func (f *fakeAccountUserRepo) FindByUsername(ctx context.Context, username string) (*model.User, error) {
+	for _, u := range f.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
+
+func (f *fakeAccountUserRepo) FindByID(ctx context.Context, id uint) (*model.User, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	return u, nil
+}
+
+func (f *fakeAccountUserRepo) Anonymize(ctx context.Context, id uint) error {
+	f.users[id].Status = model.UserStatusDeleted
+	return nil
+}
+
+func (f *fakeAccountUserRepo) UpdatePassword(ctx context.Context, id uint, passwordHash string) error {
+	u, ok := f.users[id]
+	if !ok {
+		return errors.New("user not found")
+	}
+	now := time.Now()
+	u.PasswordHash, u.PasswordChangedAt = passwordHash, &now
+	return nil
+}
+
+func (f *fakeAccountUserRepo) UpdateProfile(ctx context.Context, id uint, req *model.UpdateProfileRequest) (*model.User, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	if req.FullName != nil {
+		u.FullName = *req.FullName
+	}
+	if req.Email != nil && *req.Email != u.Email {
+		u.Email, u.EmailVerified = *req.Email, false
+	}
+	return u, nil
+}
+
+func (f *fakeAccountUserRepo) MarkEmailVerified(ctx context.Context, id uint, email string) error {
+	u, ok := f.users[id]
+	if !ok || u.Email != email {
+		return errors.New("user not found")
+	}
+	u.EmailVerified = true
+	return nil
+}
+
+// fakeActionTokenRepo mirrors the single-use and superseding rules of the
+// Postgres implementation.
+type fakeActionTokenRepo struct {
+	latest map[string]string
+}
+
+func (f *fakeActionTokenRepo) Create(ctx context.Context, userID uint, purpose, nonce string, expiresAt time.Time) error {
+	f.latest[purpose] = nonce
+	return nil
+}
+
+func (f *fakeActionTokenRepo) Consume(ctx context.Context, userID uint, purpose, nonce string) error {
+	if f.latest[purpose] != nonce {
+		return errors.New("token already used")
+	}
+	delete(f.latest, purpose)
+	return nil
+}
+
+func newAccountService(users map[uint]*model.User) (*account.Service, *mail.MemoryMailer) {
+	mailer := mail.NewMemoryMailer()
+	service := account.NewService(
+		&fakeAccountUserRepo{users: users},
+		&fakeActionTokenRepo{latest: map[string]string{}},
+		auth.NewActionTokenSigner("test_secret"),
+		mailer,
+		"http://shop.test/",
+		48*time.Hour,
+		time.Hour,
+	)
+	return service, mailer
+}
+
+func tokenFromMessage(t *testing.T, msg mail.Message) string {
+	t.Helper()
+	start := strings.Index(msg.Text, "http://shop.test/")
+	if start < 0 {
+		t.Fatalf("No link in message: %q", msg.Text)
+	}
+	link, err := url.Parse(strings.Fields(msg.Text[start:])[0])
+	if err != nil {
+		t.Fatalf("Invalid link: %v", err)
+	}
+	return link.Query().Get("token")
+}
+
+func TestActionTokenSigner(t *testing.T) {
+	signer := auth.NewActionTokenSigner("test_secret")
+	_, token, err := signer.Issue(auth.PurposeResetPassword, 7, "", time.Hour)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+
+	claims, err := signer.Parse(token, auth.PurposeResetPassword)
+	if err != nil || claims.UserID != 7 {
+		t.Fatalf("Expected valid token for user 7, got %+v, %v", claims, err)
+	}
+
+	if _, err := signer.Parse(token, auth.PurposeVerifyEmail); err != auth.ErrInvalidActionToken {
+		t.Errorf("Expected token to be rejected for another purpose, got %v", err)
+	}
+	if _, err := auth.NewActionTokenSigner("other_secret").Parse(token, auth.PurposeResetPassword); err != auth.ErrInvalidActionToken {
+		t.Errorf("Expected token to be rejected with another secret, got %v", err)
+	}
+	if _, err := signer.Parse("x"+token, auth.PurposeResetPassword); err != auth.ErrInvalidActionToken {
+		t.Errorf("Expected tampered token to be rejected, got %v", err)
+	}
+
+	_, expired, _ := signer.Issue(auth.PurposeResetPassword, 7, "", -time.Minute)
+	if _, err := signer.Parse(expired, auth.PurposeResetPassword); err != auth.ErrExpiredActionToken {
+		t.Errorf("Expected expired token to be rejected, got %v", err)
+	}
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	users := map[uint]*model.User{1: {ID: 1, Username: "budi", Email: "budi@example.com", Status: model.UserStatusActive}}
+	service, mailer := newAccountService(users)
+	ctx := context.Background()
+
+	service.RequestPasswordReset(ctx, "nobody@example.com")
+	service.Wait()
+	if len(mailer.Messages()) != 0 {
+		t.Fatalf("Expected no email for an unknown address")
+	}
+
+	// The email is sent after the request is over.
+	ctx, cancel := context.WithCancel(ctx)
+	service.RequestPasswordReset(ctx, "budi@example.com")
+	cancel()
+	service.Wait()
+	if len(mailer.Messages()) != 1 {
+		t.Fatalf("Expected a reset email, got %d", len(mailer.Messages()))
+	}
+	msg := mailer.Messages()[0]
+	if msg.To != "budi@example.com" || msg.Subject != "Reset your password" || !strings.Contains(msg.Text, "1 hour") {
+		t.Errorf("Unexpected message: %+v", msg)
+	}
+
+	token := tokenFromMessage(t, msg)
+	if err := service.ResetPassword(context.Background(), token, "new-secret"); err != nil {
+		t.Fatalf("ResetPassword failed: %v", err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(users[1].PasswordHash), []byte("new-secret")) != nil {
+		t.Errorf("Expected password to be changed")
+	}
+	if err := service.ResetPassword(context.Background(), token, "another"); err != account.ErrInvalidToken {
+		t.Errorf("Expected token to be single-use, got %v", err)
+	}
+}
+
+func TestVerifyEmailRejectsChangedAddress(t *testing.T) {
+	users := map[uint]*model.User{1: {ID: 1, Username: "budi", Email: "budi@example.com"}}
+	service, mailer := newAccountService(users)
+
+	if err := service.SendVerification(context.Background(), users[1]); err != nil {
+		t.Fatalf("SendVerification failed: %v", err)
+	}
+	token := tokenFromMessage(t, mailer.Messages()[0])
+
+	users[1].Email = "budi@new.example.com"
+	if err := service.VerifyEmail(context.Background(), token); err != account.ErrInvalidToken {
+		t.Errorf("Expected token for the old address to be rejected, got %v", err)
+	}
+	if users[1].EmailVerified {
+		t.Errorf("Expected email to stay unverified")
+	}
+
+	if err := service.SendVerification(context.Background(), users[1]); err != nil {
+		t.Fatalf("SendVerification failed: %v", err)
+	}
+	if err := service.VerifyEmail(context.Background(), tokenFromMessage(t, mailer.Messages()[1])); err != nil {
+		t.Fatalf("VerifyEmail failed: %v", err)
+	}
+	if !users[1].EmailVerified {
+		t.Errorf("Expected email to be verified")
+	}
+}
+
+type fakeInvitationRepo struct {
+	repository.InvitationRepository
+	tokenHash string
+	email     string
+	role      string
+	accepted  bool
+}
+
+func (f *fakeInvitationRepo) Create(ctx context.Context, email, role string, invitedBy uint, tokenHash string, expiresAt time.Time) (*model.Invitation, error) {
+	f.email, f.role, f.tokenHash = email, role, tokenHash
+	return &model.Invitation{ID: 1, Email: email, Role: role, InvitedBy: &invitedBy, ExpiresAt: expiresAt}, nil
+}
+
+func (f *fakeInvitationRepo) Accept(ctx context.Context, tokenHash string, user *model.User) (*model.User, error) {
+	if f.accepted || tokenHash != f.tokenHash {
+		return nil, errors.New("invitation not found")
+	}
+	f.accepted = true
+	user.ID, user.Email, user.Role = 2, f.email, f.role
+	return user, nil
+}
+
+func TestInvitationFlow(t *testing.T) {
+	repo := &fakeInvitationRepo{}
+	mailer := mail.NewMemoryMailer()
+	inviter := account.NewInviter(repo, mailer, "http://shop.test", 72*time.Hour)
+	admin := &model.User{ID: 1, Username: "root"}
+
+	if _, _, err := inviter.Invite(context.Background(), "new@example.com", "customer", admin); err != account.ErrInvalidRole {
+		t.Errorf("Expected customer role to be rejected, got %v", err)
+	}
+
+	invitation, token, err := inviter.Invite(context.Background(), "new@example.com", "admin", admin)
+	if err != nil {
+		t.Fatalf("Invite failed: %v", err)
+	}
+	if *invitation.InvitedBy != 1 || repo.tokenHash == token {
+		t.Errorf("Expected inviter to be recorded and only a hash of the token stored")
+	}
+
+	msg := mailer.Messages()[0]
+	if msg.To != "new@example.com" || !strings.Contains(msg.Text, "root has invited") || tokenFromMessage(t, msg) != token {
+		t.Errorf("Unexpected invitation email: %+v", msg)
+	}
+
+	user, err := inviter.Accept(context.Background(), token, "newadmin", "secret123", "New Admin")
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	if user.Role != "admin" || user.Email != "new@example.com" {
+		t.Errorf("Expected account with invitation's role and email, got %+v", user)
+	}
+	if _, err := inviter.Accept(context.Background(), token, "again", "secret123", "Again"); err != account.ErrInvalidToken {
+		t.Errorf("Expected invitation to be single-use, got %v", err)
+	}
+}
+
+// tokenIssuedAt signs a token for userID as if the user had logged in at
+// issuedAt.
+func tokenIssuedAt(t *testing.T, userID uint, issuedAt time.Time) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.JWTClaims{
+		UserID: userID,
+		Role:   auth.RoleCustomer,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(24 * time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+		},
+	}).SignedString([]byte("test_secret"))
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return token
+}
+
+func TestSensitiveChangesWithoutPassword(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	repo := &fakeAccountUserRepo{users: map[uint]*model.User{
+		1: {ID: 1, Role: auth.RoleCustomer, Status: model.UserStatusActive, PasswordHash: string(hash)},
+		// Created through social login.
+		2: {ID: 2, Role: auth.RoleCustomer, Status: model.UserStatusActive},
+		3: {ID: 3, Role: auth.RoleCustomer, Status: model.UserStatusActive},
+	}}
+	jwtMiddleware := auth.NewJWTMiddleware("test_secret", repo, nil)
+	h := handler.NewUserHandler(repo, nil)
+	e := echo.New()
+	e.DELETE("/api/users/me", h.DeleteMe, jwtMiddleware.RequireAuth)
+	e.PUT("/api/users/me/password", h.ChangePassword, jwtMiddleware.RequireAuth)
+
+	send := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	now := time.Now()
+	stale := now.Add(-time.Hour)
+
+	rec := send(http.MethodDelete, "/api/users/me", tokenIssuedAt(t, 1, now), `{"password":"wrong"}`)
+	if rec.Code != http.StatusUnauthorized || repo.users[1].Status != model.UserStatusActive {
+		t.Errorf("Expected a wrong password to be rejected, got %d", rec.Code)
+	}
+
+	rec = send(http.MethodDelete, "/api/users/me", tokenIssuedAt(t, 2, stale), `{}`)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "log in again") {
+		t.Errorf("Expected an old login to be rejected for an account without password, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = send(http.MethodDelete, "/api/users/me", tokenIssuedAt(t, 2, now), `{}`)
+	if rec.Code != http.StatusNoContent || repo.users[2].Status != model.UserStatusDeleted {
+		t.Errorf("Expected a fresh login to delete an account without password, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = send(http.MethodPut, "/api/users/me/password", tokenIssuedAt(t, 3, stale), `{"new_password":"secret456"}`)
+	if rec.Code != http.StatusUnauthorized || repo.users[3].PasswordHash != "" {
+		t.Errorf("Expected an old login not to set a password, got %d", rec.Code)
+	}
+	rec = send(http.MethodPut, "/api/users/me/password", tokenIssuedAt(t, 3, now), `{"new_password":"secret456"}`)
+	if rec.Code != http.StatusNoContent || bcrypt.CompareHashAndPassword([]byte(repo.users[3].PasswordHash), []byte("secret456")) != nil {
+		t.Errorf("Expected a fresh login to set the first password, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestChangeEmailRequiresPassword(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	users := map[uint]*model.User{
+		1: {ID: 1, Email: "budi@example.com", EmailVerified: true, Role: auth.RoleCustomer, Status: model.UserStatusActive, PasswordHash: string(hash)},
+	}
+	service, mailer := newAccountService(users)
+	repo := &fakeAccountUserRepo{users: users}
+	h := handler.NewUserHandler(repo, service)
+	e := echo.New()
+	e.PATCH("/api/users/me", h.UpdateMe, auth.NewJWTMiddleware("test_secret", repo, nil).RequireAuth)
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/api/users/me", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", "Bearer "+tokenIssuedAt(t, 1, time.Now()))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A stolen token alone must not be enough to take over password resets.
+	for _, body := range []string{`{"email":"mallory@example.com"}`, `{"email":"mallory@example.com","current_password":"wrong"}`} {
+		if rec := send(body); rec.Code != http.StatusUnauthorized || users[1].Email != "budi@example.com" {
+			t.Errorf("Expected %s to be rejected, got %d", body, rec.Code)
+		}
+	}
+
+	if rec := send(`{"full_name":"Budi Santoso"}`); rec.Code != http.StatusOK || users[1].FullName != "Budi Santoso" {
+		t.Errorf("Expected the name to change without a password, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec := send(`{"email":"budi@example.org","current_password":"secret123"}`)
+	if rec.Code != http.StatusOK || users[1].Email != "budi@example.org" || users[1].EmailVerified {
+		t.Errorf("Expected the email to change with the password, got %d %s", rec.Code, rec.Body.String())
+	}
+	if len(mailer.Messages()) != 1 {
+		t.Errorf("Expected a verification email for the new address, got %d", len(mailer.Messages()))
+	}
+}
+
+func TestPasswordChangeEndsSessions(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	repo := &fakeAccountUserRepo{users: map[uint]*model.User{
+		1: {ID: 1, Role: auth.RoleCustomer, Status: model.UserStatusActive, PasswordHash: string(hash)},
+	}}
+	jwtMiddleware := auth.NewJWTMiddleware("test_secret", repo, nil)
+	h := handler.NewUserHandler(repo, nil)
+	e := echo.New()
+	e.GET("/api/users/me", h.GetMe, jwtMiddleware.RequireAuth)
+	e.PUT("/api/users/me/password", h.ChangePassword, jwtMiddleware.RequireAuth)
+
+	send := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A token taken from another device before the change.
+	stolen := tokenIssuedAt(t, 1, time.Now().Add(-time.Hour))
+	if rec := send(http.MethodGet, "/api/users/me", stolen, ""); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the token to work before the change, got %d", rec.Code)
+	}
+
+	rec := send(http.MethodPut, "/api/users/me/password", tokenIssuedAt(t, 1, time.Now().Add(-time.Minute)),
+		`{"current_password":"secret123","new_password":"secret456"}`)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected the password to change, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := send(http.MethodGet, "/api/users/me", stolen, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected tokens issued before the change to be rejected, got %d", rec.Code)
+	}
+	if rec := send(http.MethodGet, "/api/users/me", tokenIssuedAt(t, 1, time.Now()), ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected a new login to work, got %d %s", rec.Code, rec.Body.String())
+	}
+}
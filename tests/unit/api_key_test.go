@@ -0,0 +1,177 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/internal/auth"
+	"test-ordent/internal/model"
+)
+
+type fakeAPIKeyStore struct {
+	keys    map[string]*model.APIKey
+	used    map[int]time.Time
+	lookups int
+	dbErr   error
+}
+
+func (f *fakeAPIKeyStore) FindByPrefix(ctx context.Context, prefix string) (*model.APIKey, error) {
+	f.lookups++
+	if f.dbErr != nil {
+		return nil, f.dbErr
+	}
+	key, ok := f.keys[prefix]
+	if !ok {
+		return nil, errors.New("api key not found")
+	}
+	return key, nil
+}
+
+func (f *fakeAPIKeyStore) TouchLastUsed(ctx context.Context, id int, at time.Time) error {
+	f.used[id] = at
+	return nil
+}
+
+func (f *fakeAPIKeyStore) add(t *testing.T, id int, createdBy uint, permissions []string, expiresAt time.Time) (string, *model.APIKey) {
+	key, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("Failed to generate API key: %v", err)
+	}
+	apiKey := &model.APIKey{
+		ID:          id,
+		Prefix:      prefix,
+		KeyHash:     auth.HashAPIKey(key),
+		Permissions: permissions,
+		CreatedBy:   &createdBy,
+		ExpiresAt:   expiresAt,
+	}
+	f.keys[prefix] = apiKey
+	return key, apiKey
+}
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey failed: %v", err)
+	}
+	parsed, ok := auth.APIKeyPrefix(key)
+	if !ok || parsed != prefix {
+		t.Errorf("Expected prefix %q from key, got %q (ok %v)", prefix, parsed, ok)
+	}
+	if other, _, _ := auth.GenerateAPIKey(); other == key {
+		t.Error("Expected generated keys to differ")
+	}
+	for _, invalid := range []string{"", "sk_", "sk__secret", "pk_1234_secret"} {
+		if _, ok := auth.APIKeyPrefix(invalid); ok {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	secret := "test_secret"
+	users := fakeUserLookup{
+		1: {ID: 1, Role: auth.RoleAdmin, Status: model.UserStatusActive},
+		2: {ID: 2, Role: auth.RoleAdmin, Status: model.UserStatusSuspended},
+		3: {ID: 3, Role: auth.RoleFulfilmentStaff, Status: model.UserStatusActive},
+	}
+	store := &fakeAPIKeyStore{keys: map[string]*model.APIKey{}, used: map[int]time.Time{}}
+	middleware := auth.NewAPIKeyMiddleware(store, users, auth.NewJWTMiddleware(secret, users, nil))
+
+	scoped := []string{auth.PermProductsWrite}
+	valid, validKey := store.add(t, 1, 1, scoped, time.Now().Add(time.Hour))
+	expired, _ := store.add(t, 2, 1, scoped, time.Now().Add(-time.Minute))
+	revoked, revokedKey := store.add(t, 3, 1, scoped, time.Now().Add(time.Hour))
+	now := time.Now()
+	revokedKey.RevokedAt = &now
+	suspendedCreator, _ := store.add(t, 4, 2, scoped, time.Now().Add(time.Hour))
+	// The creator was demoted after creating the key, so it no longer grants
+	// products:write.
+	demotedCreator, _ := store.add(t, 5, 3, scoped, time.Now().Add(time.Hour))
+	otherScope, _ := store.add(t, 6, 1, []string{auth.PermOrdersRead}, time.Now().Add(time.Hour))
+	jwt, err := auth.GenerateToken(1, auth.RoleAdmin, secret, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	testCases := []struct {
+		name       string
+		apiKey     string
+		bearer     string
+		wantStatus int
+	}{
+		{name: "Valid key", apiKey: valid, wantStatus: http.StatusOK},
+		{name: "Wrong secret", apiKey: valid + "x", wantStatus: http.StatusUnauthorized},
+		{name: "Unknown prefix", apiKey: "sk_00000000_secret", wantStatus: http.StatusUnauthorized},
+		{name: "Expired key", apiKey: expired, wantStatus: http.StatusUnauthorized},
+		{name: "Revoked key", apiKey: revoked, wantStatus: http.StatusUnauthorized},
+		{name: "Suspended creator", apiKey: suspendedCreator, wantStatus: http.StatusUnauthorized},
+		{name: "Demoted creator", apiKey: demotedCreator, wantStatus: http.StatusForbidden},
+		{name: "Key without the permission", apiKey: otherScope, wantStatus: http.StatusForbidden},
+		{name: "JWT without a key", bearer: jwt, wantStatus: http.StatusOK},
+		{name: "No credentials", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			if tc.apiKey != "" {
+				req.Header.Set(auth.APIKeyHeader, tc.apiKey)
+			}
+			if tc.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.bearer)
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			handler := middleware.RequirePermission(auth.PermProductsWrite)(func(c echo.Context) error {
+				if c.Get("user_id").(uint) != 1 {
+					t.Errorf("Expected the request to act as user 1, got %v", c.Get("user_id"))
+				}
+				return c.NoContent(http.StatusOK)
+			})
+			if err := handler(c); err != nil {
+				t.Fatalf("Handler returned error: %v", err)
+			}
+			if rec.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d", tc.wantStatus, rec.Code)
+			}
+		})
+	}
+
+	if _, ok := store.used[validKey.ID]; !ok {
+		t.Error("Expected the valid key's last use to be recorded")
+	}
+	if _, ok := store.used[revokedKey.ID]; ok {
+		t.Error("Expected a rejected key not to be marked as used")
+	}
+}
+
+func TestAPIKeyMiddlewareDatabaseError(t *testing.T) {
+	users := fakeUserLookup{1: {ID: 1, Role: auth.RoleAdmin, Status: model.UserStatusActive}}
+	store := &fakeAPIKeyStore{keys: map[string]*model.APIKey{}, used: map[int]time.Time{}}
+	middleware := auth.NewAPIKeyMiddleware(store, users, auth.NewJWTMiddleware("test_secret", users, nil))
+	key, _ := store.add(t, 1, 1, []string{auth.PermOrdersRead}, time.Now().Add(time.Hour))
+	store.dbErr = errors.New("pq: connection refused")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(auth.APIKeyHeader, key)
+	rec := httptest.NewRecorder()
+	handler := middleware.RequirePermission(auth.PermOrdersRead)(func(c echo.Context) error {
+		t.Error("Expected the request not to reach the handler")
+		return nil
+	})
+	if err := handler(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("Handler returned error: %v", err)
+	}
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected a failed key lookup to be 500, got %d", rec.Code)
+	}
+}
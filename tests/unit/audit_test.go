@@ -0,0 +1,173 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/config"
+	"test-ordent/internal/audit"
+	"test-ordent/internal/handler"
+	"test-ordent/internal/model"
+	"test-ordent/internal/server"
+	"test-ordent/pkg/logger"
+)
+
+// buildAuditChain hashes events in order, as the repositories do when
+// recording them.
+func buildAuditChain(t *testing.T, events []model.AuditEvent) []model.AuditEvent {
+	t.Helper()
+	prev := audit.GenesisHash
+	for i := range events {
+		events[i].ID = int64(i + 1)
+		events[i].PrevHash = prev
+		hash, err := audit.Hash(&events[i])
+		if err != nil {
+			t.Fatalf("Expected event %d to hash, got %v", i, err)
+		}
+		events[i].Hash = hash
+		prev = hash
+	}
+	return events
+}
+
+func verifyAuditChain(events []model.AuditEvent) (int, error) {
+	verifier := audit.NewVerifier()
+	for i := range events {
+		if err := verifier.Next(&events[i]); err != nil {
+			return verifier.Checked(), err
+		}
+	}
+	return verifier.Checked(), nil
+}
+
+func TestAuditHashChain(t *testing.T) {
+	actor := uint(1)
+	before, _ := audit.Snapshot(&model.ProductResponse{ID: 5, Name: "Lamp", Price: 10.5})
+	after, _ := audit.Snapshot(&model.ProductResponse{ID: 5, Name: "Lamp", Price: 12})
+	created := time.Date(2024, 3, 1, 10, 0, 0, 123456000, time.UTC)
+	newChain := func() []model.AuditEvent {
+		return buildAuditChain(t, []model.AuditEvent{
+			{ActorUserID: &actor, Action: model.AuditProductCreate, EntityType: model.AuditEntityProduct, EntityID: "5", After: before, CreatedAt: created},
+			{ActorUserID: &actor, Action: model.AuditProductUpdate, EntityType: model.AuditEntityProduct, EntityID: "5", Before: before, After: after, CreatedAt: created.Add(time.Minute)},
+			{Action: model.AuditUserCreate, EntityType: model.AuditEntityUser, EntityID: "2", IPAddress: "10.0.0.1", CreatedAt: created.Add(time.Hour)},
+		})
+	}
+
+	events := newChain()
+	if checked, err := verifyAuditChain(events); err != nil || checked != 3 {
+		t.Fatalf("Expected an intact chain of 3 events, got %d: %v", checked, err)
+	}
+
+	// JSONB reorders keys and adds spacing; the timestamp may come back in
+	// another location.
+	events[1].After = json.RawMessage(`{"name": "Lamp", "id": 5, "price": 12.0, "stock": 0, "version": 0, "description": "", "category_id": 0, "image_url": "", "created_at": "0001-01-01T00:00:00Z"}`)
+	events[1].CreatedAt = events[1].CreatedAt.In(time.FixedZone("WIB", 7*3600))
+	if _, err := verifyAuditChain(events); err != nil {
+		t.Errorf("Expected the chain to survive a JSONB round trip, got %v", err)
+	}
+
+	events = newChain()
+	tampered, _ := audit.Snapshot(&model.ProductResponse{ID: 5, Name: "Lamp", Price: 1})
+	events[1].After = tampered
+	if checked, err := verifyAuditChain(events); err == nil || checked != 1 || !strings.Contains(err.Error(), "event 2") {
+		t.Errorf("Expected the modified event 2 to be reported, got %d: %v", checked, err)
+	}
+
+	events = newChain()
+	events = append(events[:1], events[2:]...)
+	if _, err := verifyAuditChain(events); err == nil || !strings.Contains(err.Error(), "event 3") {
+		t.Errorf("Expected a removed event to break the chain at event 3, got %v", err)
+	}
+}
+
+func TestAuditContextFromRequest(t *testing.T) {
+	log, _ := logger.New(&bytes.Buffer{}, logger.FormatJSON, "info")
+	e := echo.New()
+	e.Use(log.Middleware())
+
+	var meta audit.Metadata
+	e.POST("/api/products", func(c echo.Context) error {
+		c.Set("user_id", uint(3))
+		c.Set("api_key_id", 9)
+		meta = audit.FromContext(audit.Context(c))
+		return c.NoContent(http.StatusCreated)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/products", nil)
+	req.Header.Set(logger.RequestIDHeader, "req-1")
+	req.Header.Set("User-Agent", "erp-sync/2.0")
+	req.RemoteAddr = "192.0.2.7:5000"
+	e.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := audit.Metadata{ActorUserID: 3, ActorAPIKeyID: 9, IPAddress: "192.0.2.7", UserAgent: "erp-sync/2.0", RequestID: "req-1"}
+	if meta != want {
+		t.Errorf("Expected %+v, got %+v", want, meta)
+	}
+	if got := audit.FromContext(context.Background()); got != (audit.Metadata{}) {
+		t.Errorf("Expected no metadata outside requests, got %+v", got)
+	}
+
+	// Without trusted proxies a forwarded address is ignored, and a value
+	// that is not an IP never reaches the VARCHAR(45) column.
+	server.Configure(e, config.ServerConfig{})
+	req.Header.Set(echo.HeaderXForwardedFor, "198.51.100.1")
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	if meta.IPAddress != "192.0.2.7" {
+		t.Errorf("Expected the peer address, got %q", meta.IPAddress)
+	}
+	e.IPExtractor = nil
+	req.Header.Set(echo.HeaderXForwardedFor, strings.Repeat("x", 100))
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	if meta.IPAddress != "" {
+		t.Errorf("Expected an invalid address to be left out, got %q", meta.IPAddress)
+	}
+}
+
+type fakeAuditEventRepo struct {
+	filter model.AuditFilter
+}
+
+func (r *fakeAuditEventRepo) List(ctx context.Context, filter model.AuditFilter) ([]model.AuditEvent, int, error) {
+	r.filter = filter
+	return []model.AuditEvent{}, 0, nil
+}
+
+func (r *fakeAuditEventRepo) Verify(ctx context.Context) (*model.AuditVerification, error) {
+	return &model.AuditVerification{Valid: true}, nil
+}
+
+func TestListAuditEventsFilters(t *testing.T) {
+	repo := &fakeAuditEventRepo{}
+	h := handler.NewAuditHandler(repo)
+	e := echo.New()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/audit-events?actor_id=4&entity_type=product&entity_id=5&from=2024-03-01T00:00:00Z&page=2&limit=10", nil)
+	if err := h.ListAuditEvents(e.NewContext(req, rec)); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %v", rec.Code, err)
+	}
+	f := repo.filter
+	if f.ActorUserID != 4 || f.EntityType != "product" || f.EntityID != "5" || f.Limit != 10 || f.Offset != 10 {
+		t.Errorf("Expected the query to be parsed into the filter, got %+v", f)
+	}
+	if f.From == nil || !f.From.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) || f.To != nil {
+		t.Errorf("Expected only the lower time bound, got %v %v", f.From, f.To)
+	}
+
+	for _, query := range []string{"from=yesterday", "actor_id=abc", "limit=500"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/audit-events?"+query, nil)
+		h.ListAuditEvents(e.NewContext(req, rec))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", query, rec.Code)
+		}
+	}
+}
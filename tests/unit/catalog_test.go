@@ -0,0 +1,201 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/internal/catalog"
+	"test-ordent/internal/handler"
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+)
+
+// fakeCatalogRepo implements only the ProductRepository methods used by the
+// importer and exporter.
+type fakeCatalogRepo struct {
+	repository.ProductRepository
+	existing []model.ProductResponse
+	upserted []model.ProductRequest
+	failSKU  string
+	// archivedSKU belongs to an archived product.
+	archivedSKU string
+	dbErr       error
+}
+
+func (f *fakeCatalogRepo) UpsertBySKU(ctx context.Context, products []model.ProductRequest, atomic, dryRun bool, actorID uint) ([]model.ProductUpsertResult, error) {
+	if f.dbErr != nil {
+		return nil, f.dbErr
+	}
+	var results []model.ProductUpsertResult
+	for _, p := range products {
+		if p.SKU == f.archivedSKU {
+			results = append(results, model.ProductUpsertResult{Err: repository.ErrArchivedSKU})
+			continue
+		}
+		if p.SKU == f.failSKU {
+			results = append(results, model.ProductUpsertResult{Err: errors.New("category not found")})
+			if atomic {
+				return results, nil
+			}
+			continue
+		}
+		created := true
+		for _, e := range f.existing {
+			created = created && e.SKU != p.SKU
+		}
+		if !dryRun {
+			f.upserted = append(f.upserted, p)
+		}
+		results = append(results, model.ProductUpsertResult{Created: created})
+	}
+	return results, nil
+}
+
+func (f *fakeCatalogRepo) StreamAll(ctx context.Context, fn func(product *model.ProductResponse) error) error {
+	for i := range f.existing {
+		if err := fn(&f.existing[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestImportCSV(t *testing.T) {
+	repo := &fakeCatalogRepo{existing: []model.ProductResponse{{SKU: "TS-001"}}}
+	input := "sku,name,price,stock,category_id\n" +
+		"TS-001,T-Shirt,99.5,10,2\n" +
+		"MUG-01,Mug,25,3,4\n" +
+		"BAD-01,,10,1,1\n" +
+		"BAD-02,Broken,abc,1,1\n" +
+		"MUG-01,Mug again,25,3,4\n"
+
+	report, err := catalog.NewImporter(repo).Import(context.Background(), strings.NewReader(input), catalog.ImportOptions{Format: catalog.FormatCSV})
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	if report.Total != 5 || report.Created != 1 || report.Updated != 1 || report.Failed != 3 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	wantLines := []int{4, 5, 6}
+	for i, e := range report.Errors {
+		if e.Line != wantLines[i] {
+			t.Errorf("Expected error %d on line %d, got line %d (%s)", i, wantLines[i], e.Line, e.Error)
+		}
+	}
+	if len(repo.upserted) != 2 {
+		t.Errorf("Expected 2 rows written, got %d", len(repo.upserted))
+	}
+}
+
+func TestImportAtomicRejectsInvalidRows(t *testing.T) {
+	repo := &fakeCatalogRepo{}
+	input := `{"sku":"A-1","name":"A","price":1,"stock":1,"category_id":1}
+{"sku":"A-2","name":"B","price":-1,"stock":1,"category_id":1}
+`
+	report, err := catalog.NewImporter(repo).Import(context.Background(), strings.NewReader(input), catalog.ImportOptions{Format: catalog.FormatJSONL, Atomic: true})
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if report.Failed != 1 || report.Errors[0].Line != 2 {
+		t.Errorf("Expected line 2 to fail, got %+v", report)
+	}
+	if len(repo.upserted) != 0 {
+		t.Errorf("Expected nothing to be written in atomic mode, got %d rows", len(repo.upserted))
+	}
+
+	repo = &fakeCatalogRepo{failSKU: "A-1"}
+	report, err = catalog.NewImporter(repo).Import(context.Background(), strings.NewReader(input[:strings.Index(input, "\n")+1]), catalog.ImportOptions{Format: catalog.FormatJSONL, Atomic: true})
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if report.Failed != 1 || report.Created != 0 || report.Errors[0].Error != "category not found" {
+		t.Errorf("Expected database error to be reported, got %+v", report)
+	}
+}
+
+func TestImportRejectsArchivedSKU(t *testing.T) {
+	repo := &fakeCatalogRepo{archivedSKU: "OLD-1"}
+	input := "sku,name,price,stock,category_id\n" +
+		"OLD-1,Retired mug,25,3,4\n" +
+		"NEW-1,New mug,30,5,4\n"
+
+	report, err := catalog.NewImporter(repo).Import(context.Background(), strings.NewReader(input), catalog.ImportOptions{Format: catalog.FormatCSV})
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if report.Created != 1 || report.Updated != 0 || report.Failed != 1 {
+		t.Errorf("Expected the archived SKU to fail rather than count as updated, got %+v", report)
+	}
+	if len(report.Errors) != 1 || report.Errors[0].SKU != "OLD-1" || report.Errors[0].Error != repository.ErrArchivedSKU.Error() {
+		t.Errorf("Expected a row error for OLD-1, got %+v", report.Errors)
+	}
+}
+
+func importRequest(t *testing.T, h *handler.CatalogHandler, content string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "products.csv")
+	part.Write([]byte(content))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/products/import", &body)
+	req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.Set("user_id", uint(1))
+	if err := h.ImportProducts(c); err != nil {
+		t.Fatalf("ImportProducts failed: %v", err)
+	}
+	return rec
+}
+
+func TestImportProductsErrorStatus(t *testing.T) {
+	repo := &fakeCatalogRepo{dbErr: errors.New(`pq: relation "products" does not exist`)}
+	h := handler.NewCatalogHandler(catalog.NewImporter(repo), catalog.NewExporter(repo))
+
+	rec := importRequest(t, h, "sku,name,colour\nA-1,Mug,red\n")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `unknown csv column \"colour\"`) {
+		t.Errorf("Expected a malformed file to be 400, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = importRequest(t, h, "sku,name,price,stock,category_id\nA-1,Mug,25,3,4\n")
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "relation") {
+		t.Errorf("Expected a database failure to be a 500 without its cause, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	repo := &fakeCatalogRepo{existing: []model.ProductResponse{
+		{SKU: "TS-001", Name: "T-Shirt, blue", Price: 99.5, Stock: 10, CategoryID: 2},
+		{SKU: "MUG-01", Name: "Mug", Description: "Ceramic \"classic\" mug", Price: 25, Stock: 3, CategoryID: 4},
+	}}
+
+	for _, format := range []catalog.Format{catalog.FormatCSV, catalog.FormatJSONL} {
+		var buf bytes.Buffer
+		if err := catalog.NewExporter(repo).Export(context.Background(), &buf, format); err != nil {
+			t.Fatalf("Export %s failed: %v", format, err)
+		}
+
+		target := &fakeCatalogRepo{}
+		report, err := catalog.NewImporter(target).Import(context.Background(), &buf, catalog.ImportOptions{Format: format, Atomic: true})
+		if err != nil {
+			t.Fatalf("Import %s failed: %v", format, err)
+		}
+		if report.Failed != 0 || len(target.upserted) != 2 {
+			t.Fatalf("Expected %s export to import cleanly, got %+v", format, report)
+		}
+		if target.upserted[0].Name != "T-Shirt, blue" || target.upserted[1].Description != "Ceramic \"classic\" mug" {
+			t.Errorf("Round trip through %s changed data: %+v", format, target.upserted)
+		}
+	}
+}
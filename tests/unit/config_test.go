@@ -0,0 +1,196 @@
+package unit
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"test-ordent/config"
+)
+
+const baseConfig = `
+server:
+  port: 8080
+database:
+  host: localhost
+  user: postgres
+  password: file-password
+  dbname: testordentdb
+auth:
+  jwt_secret: file-secret-0123456789
+  encryption_key: file-key-0123456789
+  token_expiry: 2h
+cors:
+  allowed_origins: [https://shop.example.com]
+`
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	path := writeConfig(t, baseConfig)
+
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.Auth.TokenExpiry != 2*time.Hour || cfg.Database.Password != "file-password" {
+		t.Errorf("Expected values from the file, got %+v", cfg.Auth)
+	}
+	if cfg.Storage.Driver != "local" || cfg.Database.Port != 5432 {
+		t.Errorf("Expected defaults for settings missing from the file, got %q %d", cfg.Storage.Driver, cfg.Database.Port)
+	}
+	if len(cfg.CORS.AllowedOrigins) != 1 || len(cfg.CORS.AllowedMethods) == 0 {
+		t.Errorf("Expected file lists to replace the defaults only where set, got %v %v", cfg.CORS.AllowedOrigins, cfg.CORS.AllowedMethods)
+	}
+
+	t.Setenv("APP_DATABASE_HOST", "db")
+	t.Setenv("APP_SERVER_PORT", "9000")
+	t.Setenv("APP_CORS_ALLOWED_ORIGINS", "https://a.example.com,https://b.example.com")
+	cfg, err = config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.Database.Host != "db" || cfg.Server.Port != 9000 || len(cfg.CORS.AllowedOrigins) != 2 {
+		t.Errorf("Expected environment variables to override the file, got %q %d %v", cfg.Database.Host, cfg.Server.Port, cfg.CORS.AllowedOrigins)
+	}
+
+	cfg, err = config.LoadConfig(path, "server.port=9100", "DATABASE.HOST=override")
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.Server.Port != 9100 || cfg.Database.Host != "override" {
+		t.Errorf("Expected overrides to win over the environment, got %d %q", cfg.Server.Port, cfg.Database.Host)
+	}
+
+	if _, err := config.LoadConfig(path, "server.unknown=1"); err == nil {
+		t.Error("Expected an unknown override to be rejected")
+	}
+	if _, err := config.LoadConfig(path, "server.port"); err == nil {
+		t.Error("Expected an override without a value to be rejected")
+	}
+}
+
+func TestLoadConfigSecretFiles(t *testing.T) {
+	path := writeConfig(t, baseConfig)
+	secret := filepath.Join(t.TempDir(), "jwt_secret")
+	if err := os.WriteFile(secret, []byte("secret-from-a-file-0123\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write secret: %v", err)
+	}
+
+	t.Setenv("APP_AUTH_JWT_SECRET_FILE", secret)
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.Auth.JWTSecret != "secret-from-a-file-0123" {
+		t.Errorf("Expected the secret from the file without its newline, got %q", cfg.Auth.JWTSecret)
+	}
+
+	t.Setenv("APP_AUTH_ENCRYPTION_KEY", "secret-from-a-file-0123")
+	if _, err := config.LoadConfig(path); err == nil || !strings.Contains(err.Error(), "auth.encryption_key must differ") {
+		t.Errorf("Expected the encryption key to be rejected when it equals the JWT secret, got %v", err)
+	}
+	os.Unsetenv("APP_AUTH_ENCRYPTION_KEY")
+
+	t.Setenv("APP_AUTH_JWT_SECRET", "another-secret-0123456")
+	if _, err := config.LoadConfig(path); err == nil {
+		t.Error("Expected setting both a variable and its _FILE form to be rejected")
+	}
+
+	os.Unsetenv("APP_AUTH_JWT_SECRET")
+	t.Setenv("APP_AUTH_JWT_SECRET_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, err := config.LoadConfig(path); err == nil {
+		t.Error("Expected a missing secret file to be rejected")
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  port: 70000
+  trusted_proxies: ["10.0.0.0/8", "10.0.0.1"]
+database:
+  host: localhost
+  user: postgres
+  dbname: testordentdb
+storage:
+  driver: ftp
+mail:
+  driver: smtp
+tracing:
+  enabled: true
+  exporter: zipkin
+  sample_ratio: 2
+events:
+  http_sinks:
+    - url: hooks.example.com/ecommerce
+  nats:
+    enabled: true
+    url: localhost:4222
+`)
+
+	cfg, err := config.LoadConfig(path)
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a ValidationError, got %v", err)
+	}
+	if cfg == nil {
+		t.Error("Expected the configuration to be returned with validation errors")
+	}
+	want := []string{"server.port", "server.trusted_proxies[1]", "auth.jwt_secret is required", "auth.encryption_key is required", "storage.driver", "mail.smtp.host", "tracing.exporter", "tracing.sample_ratio",
+		"events.http_sinks[0].url", "events.nats.url"}
+	for _, problem := range want {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected a problem about %q in:\n%v", problem, err)
+		}
+	}
+	if len(validationErr.Problems) != len(want) {
+		t.Errorf("Expected %d problems, got %v", len(want), validationErr.Problems)
+	}
+
+	path = writeConfig(t, baseConfig+"  unknown_setting: true\n")
+	if _, err := config.LoadConfig(path); err == nil || !strings.Contains(err.Error(), "unknown_setting") {
+		t.Errorf("Expected unknown keys to be rejected, got %v", err)
+	}
+}
+
+func TestConfigPrintRedactsSecrets(t *testing.T) {
+	cfg, err := config.LoadConfig(writeConfig(t, baseConfig))
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := cfg.Print(&out, true); err != nil {
+		t.Fatalf("Print failed: %v", err)
+	}
+	printed := out.String()
+	if strings.Contains(printed, "file-password") || strings.Contains(printed, "file-secret") || strings.Contains(printed, "file-key") {
+		t.Errorf("Expected secrets to be redacted:\n%s", printed)
+	}
+	if !strings.Contains(printed, "jwt_secret: '[REDACTED]'") || !strings.Contains(printed, "encryption_key: '[REDACTED]'") || !strings.Contains(printed, "token_expiry: 2h0m0s") {
+		t.Errorf("Unexpected output:\n%s", printed)
+	}
+	if strings.Contains(printed, "secret_key: '[REDACTED]'") {
+		t.Error("Expected empty secrets to be left empty")
+	}
+
+	out.Reset()
+	if err := cfg.Print(&out, false); err != nil {
+		t.Fatalf("Print failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "password: file-password") {
+		t.Errorf("Expected secrets without redaction:\n%s", out.String())
+	}
+}
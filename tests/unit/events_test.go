@@ -0,0 +1,277 @@
+package unit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"test-ordent/internal/events"
+	"test-ordent/internal/model"
+	"test-ordent/internal/worker"
+	"test-ordent/pkg/logger"
+)
+
+func TestBusDeliversToSubscribers(t *testing.T) {
+	bus := events.NewBus()
+	var got []string
+	bus.Subscribe(model.EventOrderCreated, func(ctx context.Context, e model.Event) error {
+		got = append(got, "orders:"+e.Type)
+		return errors.New("mailer down")
+	})
+	bus.Subscribe(events.AllEvents, func(ctx context.Context, e model.Event) error {
+		got = append(got, "all:"+e.Type)
+		return nil
+	})
+
+	if err := bus.Publish(context.Background(), model.Event{Type: model.EventProductUpdated}); err != nil {
+		t.Errorf("Expected no error without failing subscribers, got %v", err)
+	}
+	err := bus.Publish(context.Background(), model.Event{Type: model.EventOrderCreated})
+	if err == nil || !strings.Contains(err.Error(), "mailer down") {
+		t.Errorf("Expected the subscriber error to be returned, got %v", err)
+	}
+
+	want := []string{"all:product.updated", "orders:order.created", "all:order.created"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected deliveries %v, got %v", want, got)
+	}
+}
+
+// fakeOutboxRepo hands out one batch and records the outcomes.
+type fakeOutboxRepo struct {
+	batch    []model.OutboxEvent
+	outcomes []model.OutboxOutcome
+}
+
+func (f *fakeOutboxRepo) ProcessDue(ctx context.Context, limit int, publish func(ctx context.Context, events []model.OutboxEvent) []model.OutboxOutcome) (bool, error) {
+	f.outcomes = publish(ctx, f.batch)
+	return true, nil
+}
+
+func (f *fakeOutboxRepo) PurgePublished(ctx context.Context, olderThan time.Duration) (int, error) {
+	return 0, nil
+}
+
+type recordingSink struct {
+	failIDs   map[int64]bool
+	published []int64
+}
+
+func (s *recordingSink) Publish(ctx context.Context, e model.Event) error {
+	if s.failIDs[e.ID] {
+		return errors.New("connection refused")
+	}
+	s.published = append(s.published, e.ID)
+	return nil
+}
+
+func outboxEvent(id int64, aggregateID string, attempts int) model.OutboxEvent {
+	return model.OutboxEvent{
+		Event:    model.Event{ID: id, Type: model.EventOrderStatusChanged, AggregateType: model.AggregateOrder, AggregateID: aggregateID},
+		Attempts: attempts,
+	}
+}
+
+func TestOutboxRelayKeepsAggregateOrder(t *testing.T) {
+	repo := &fakeOutboxRepo{batch: []model.OutboxEvent{
+		outboxEvent(1, "7", 3),
+		outboxEvent(2, "8", 0),
+		outboxEvent(3, "7", 0),
+		outboxEvent(4, "8", 0),
+	}}
+	sink := &recordingSink{failIDs: map[int64]bool{1: true}}
+	relay := worker.NewOutboxRelay(repo, sink, time.Second, 10, time.Second, 5*time.Second, time.Hour, logger.Discard())
+
+	if n := relay.RunOnce(context.Background()); n != 4 {
+		t.Errorf("Expected 4 events attempted, got %d", n)
+	}
+	if fmt.Sprint(sink.published) != "[2 4]" {
+		t.Errorf("Expected only order 8's events to be published after order 7's failed, got %v", sink.published)
+	}
+
+	want := []model.OutboxOutcome{
+		// The fourth failure waits 1s doubled three times, capped at 5s.
+		{EventID: 1, Error: "connection refused", RetryAfter: 5 * time.Second},
+		{EventID: 2, Published: true},
+		{EventID: 4, Published: true},
+	}
+	if fmt.Sprint(repo.outcomes) != fmt.Sprint(want) {
+		t.Errorf("Expected outcomes %v, got %v", want, repo.outcomes)
+	}
+}
+
+func TestHTTPSinkPostsEvents(t *testing.T) {
+	var received model.Event
+	var headers http.Header
+	status := http.StatusAccepted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink, err := events.NewHTTPSink(server.URL, time.Second)
+	if err != nil {
+		t.Fatalf("NewHTTPSink failed: %v", err)
+	}
+	event := model.Event{ID: 42, Type: model.EventStockLow, AggregateType: model.AggregateProduct, AggregateID: "5", Payload: json.RawMessage(`{"stock":2}`)}
+	if err := sink.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Expected the event to be accepted, got %v", err)
+	}
+	if headers.Get("X-Event-ID") != "42" || headers.Get("X-Event-Type") != model.EventStockLow || received.AggregateID != "5" || string(received.Payload) != `{"stock":2}` {
+		t.Errorf("Expected the event with its ID and type headers, got %+v %v", received, headers)
+	}
+
+	status = http.StatusInternalServerError
+	if err := sink.Publish(context.Background(), event); err == nil {
+		t.Error("Expected a 500 response to fail the publish")
+	}
+
+	if _, err := events.NewHTTPSink("ftp://example.com", time.Second); err == nil {
+		t.Error("Expected a non-HTTP URL to be rejected")
+	}
+}
+
+// natsMessage is a message received by the NATS stand-in.
+type natsMessage struct {
+	subject string
+	header  string
+	payload string
+}
+
+// natsStandIn speaks enough of the NATS server protocol to accept
+// publishes: it greets with INFO, answers PING and records HPUB messages.
+type natsStandIn struct {
+	listener net.Listener
+	mu       sync.Mutex
+	connects []string
+	messages []natsMessage
+	// rejectNext makes the next publish fail with -ERR and closes the
+	// connection, as a server does on a permissions violation.
+	rejectNext bool
+}
+
+func startNATSStandIn(t *testing.T) *natsStandIn {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &natsStandIn{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *natsStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"stand-in\",\"headers\":true,\"max_payload\":1048576}\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, "CONNECT "):
+			s.mu.Lock()
+			s.connects = append(s.connects, strings.TrimPrefix(line, "CONNECT "))
+			s.mu.Unlock()
+		case line == "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case strings.HasPrefix(line, "HPUB "):
+			fields := strings.Fields(line)
+			headerLen, _ := strconv.Atoi(fields[2])
+			totalLen, _ := strconv.Atoi(fields[3])
+			body := make([]byte, totalLen+2)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return
+			}
+			s.mu.Lock()
+			reject := s.rejectNext
+			s.rejectNext = false
+			if !reject {
+				s.messages = append(s.messages, natsMessage{subject: fields[1], header: string(body[:headerLen]), payload: string(body[headerLen:totalLen])})
+			}
+			s.mu.Unlock()
+			if reject {
+				fmt.Fprint(conn, "-ERR 'Permissions Violation for Publish'\r\n")
+				return
+			}
+			// Servers ping idle clients; the publisher has to answer.
+			fmt.Fprint(conn, "PING\r\n")
+		case line == "PONG":
+		default:
+			fmt.Fprint(conn, "-ERR 'Unknown Protocol Operation'\r\n")
+			return
+		}
+	}
+}
+
+func TestNATSPublisherAgainstStandIn(t *testing.T) {
+	server := startNATSStandIn(t)
+	publisher, err := events.NewNATSPublisher("nats://app:secret@"+server.listener.Addr().String(), "ecommerce", time.Second)
+	if err != nil {
+		t.Fatalf("NewNATSPublisher failed: %v", err)
+	}
+	defer publisher.Close()
+
+	ctx := context.Background()
+	created := model.Event{ID: 1, Type: model.EventOrderCreated, AggregateType: model.AggregateOrder, AggregateID: "9", Payload: json.RawMessage(`{"order_id":9}`)}
+	if err := publisher.Publish(ctx, created); err != nil {
+		t.Fatalf("Expected the event to be published, got %v", err)
+	}
+
+	server.mu.Lock()
+	server.rejectNext = true
+	server.mu.Unlock()
+	paid := model.Event{ID: 2, Type: model.EventOrderStatusChanged, AggregateType: model.AggregateOrder, AggregateID: "9", Payload: json.RawMessage(`{"new_status":"paid"}`)}
+	if err := publisher.Publish(ctx, paid); err == nil || !strings.Contains(err.Error(), "Permissions Violation") {
+		t.Errorf("Expected the server error to fail the publish, got %v", err)
+	}
+	// The relay retries; the publisher reconnects.
+	if err := publisher.Publish(ctx, paid); err != nil {
+		t.Fatalf("Expected the retry to reconnect and publish, got %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.connects) != 2 || !strings.Contains(server.connects[0], `"user":"app"`) || !strings.Contains(server.connects[0], `"pass":"secret"`) {
+		t.Errorf("Expected two authenticated connections, got %v", server.connects)
+	}
+	if len(server.messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %+v", server.messages)
+	}
+	first, second := server.messages[0], server.messages[1]
+	if first.subject != "ecommerce.order.created" || second.subject != "ecommerce.order.status_changed" {
+		t.Errorf("Expected subjects named after the event types, got %q and %q", first.subject, second.subject)
+	}
+	if !strings.Contains(second.header, "Nats-Msg-Id: 2\r\n") {
+		t.Errorf("Expected the event ID as message ID, got %q", second.header)
+	}
+	var event model.Event
+	if err := json.Unmarshal([]byte(first.payload), &event); err != nil || event.ID != 1 || event.AggregateID != "9" {
+		t.Errorf("Expected the event as JSON payload, got %q: %v", first.payload, err)
+	}
+}
@@ -0,0 +1,131 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/internal/health"
+	"test-ordent/internal/version"
+)
+
+func TestReadinessChecks(t *testing.T) {
+	ok := health.CheckerFunc(func(ctx context.Context) error { return nil })
+	failing := health.CheckerFunc(func(ctx context.Context) error { return errors.New("connection refused") })
+	// Ignores its context, like a driver call without deadline support.
+	hanging := health.CheckerFunc(func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	testCases := []struct {
+		name       string
+		checks     []health.Check
+		wantStatus string
+		wantChecks map[string]string
+	}{
+		{
+			name:       "All checks pass",
+			checks:     []health.Check{{Name: "database", Checker: ok}, {Name: "schema", Checker: ok}},
+			wantStatus: health.StatusReady,
+			wantChecks: map[string]string{"database": health.CheckOK, "schema": health.CheckOK},
+		},
+		{
+			name:       "Required check fails",
+			checks:     []health.Check{{Name: "database", Checker: failing}, {Name: "schema", Checker: ok}},
+			wantStatus: health.StatusNotReady,
+			wantChecks: map[string]string{"database": health.CheckFailed, "schema": health.CheckOK},
+		},
+		{
+			name:       "Optional check fails",
+			checks:     []health.Check{{Name: "database", Checker: ok}, {Name: "redis", Checker: failing, Optional: true}},
+			wantStatus: health.StatusReady,
+			wantChecks: map[string]string{"database": health.CheckOK, "redis": health.CheckFailed},
+		},
+		{
+			name:       "Check times out",
+			checks:     []health.Check{{Name: "database", Checker: hanging, Timeout: 50 * time.Millisecond}},
+			wantStatus: health.StatusNotReady,
+			wantChecks: map[string]string{"database": health.CheckFailed},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			readiness := health.NewReadiness(time.Second)
+			for _, check := range tc.checks {
+				readiness.Register(check)
+			}
+
+			start := time.Now()
+			report := readiness.Run(context.Background())
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("Expected checks to be bounded by their timeouts, took %s", elapsed)
+			}
+			if report.Status != tc.wantStatus {
+				t.Errorf("Expected status %q, got %q", tc.wantStatus, report.Status)
+			}
+			for name, status := range tc.wantChecks {
+				if report.Checks[name].Status != status {
+					t.Errorf("Expected check %s to be %q, got %+v", name, status, report.Checks[name])
+				}
+			}
+		})
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	readiness := health.NewReadiness(time.Second)
+	readiness.Register(health.Check{Name: "database", Checker: health.CheckerFunc(func(ctx context.Context) error { return nil })})
+
+	e := echo.New()
+	e.GET("/healthz", health.Liveness)
+	e.GET("/readyz", readiness.Handler)
+	e.GET("/version", version.Handler)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	if rec := get("/healthz"); rec.Code != http.StatusOK {
+		t.Errorf("Expected liveness to pass, got %d", rec.Code)
+	}
+
+	rec := get("/readyz")
+	var report health.Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Failed to decode readiness: %v", err)
+	}
+	if rec.Code != http.StatusOK || report.Checks["database"].Status != health.CheckOK {
+		t.Errorf("Expected readiness to pass, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	readiness.SetDraining()
+	rec = get("/readyz")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected readiness to fail while draining, got %d", rec.Code)
+	}
+	if rec := get("/healthz"); rec.Code != http.StatusOK {
+		t.Errorf("Expected liveness to pass while draining, got %d", rec.Code)
+	}
+
+	version.Version = "v1.2.3"
+	version.Commit = "abc123"
+	t.Cleanup(func() { version.Version, version.Commit = "dev", "" })
+	rec = get("/version")
+	var info version.Info
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Failed to decode version: %v", err)
+	}
+	if info.Version != "v1.2.3" || info.Commit != "abc123" || info.GoVersion == "" {
+		t.Errorf("Unexpected build info %+v", info)
+	}
+}
@@ -0,0 +1,249 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+
+	"test-ordent/config"
+	"test-ordent/internal/account"
+	"test-ordent/internal/auth"
+	"test-ordent/internal/handler"
+	"test-ordent/internal/metrics"
+	"test-ordent/internal/model"
+	"test-ordent/internal/server"
+)
+
+func newTestGuard() *auth.LoginGuard {
+	return auth.NewLoginGuard(auth.NewMemoryAttemptStore(), auth.LockoutPolicy{
+		MaxAccountFailures: 3,
+		MaxIPFailures:      5,
+		BaseDelay:          time.Second,
+		MaxDelay:           4 * time.Second,
+		LockoutDuration:    10 * time.Minute,
+		Window:             5 * time.Minute,
+	})
+}
+
+func mustAttempt(t *testing.T, guard *auth.LoginGuard, username, ip string, now time.Time) time.Duration {
+	t.Helper()
+	wait, err := guard.Attempt(context.Background(), username, ip, now)
+	if err != nil {
+		t.Fatalf("Attempt failed: %v", err)
+	}
+	return wait
+}
+
+// failAttempt makes a login attempt that fails with a wrong password when it
+// is allowed, and returns the wait otherwise.
+func failAttempt(t *testing.T, guard *auth.LoginGuard, username, ip string, now time.Time) time.Duration {
+	t.Helper()
+	wait := mustAttempt(t, guard, username, ip, now)
+	if wait == 0 {
+		if err := guard.Fail(context.Background(), username, ip, now); err != nil {
+			t.Fatalf("Fail failed: %v", err)
+		}
+	}
+	return wait
+}
+
+func TestLoginGuardBackoffAndLockout(t *testing.T) {
+	guard := newTestGuard()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if wait := failAttempt(t, guard, "budi", "10.0.0.1", now); wait != 0 {
+		t.Fatalf("Expected no wait before any failure, got %v", wait)
+	}
+	if wait := failAttempt(t, guard, "Budi", "10.0.0.2", now); wait != time.Second {
+		t.Errorf("Expected 1s backoff after the first failure regardless of case and IP, got %v", wait)
+	}
+
+	if wait := failAttempt(t, guard, "budi", "10.0.0.1", now.Add(time.Second)); wait != 0 {
+		t.Fatalf("Expected the second attempt after the backoff, got %v", wait)
+	}
+	if wait := failAttempt(t, guard, "budi", "10.0.0.3", now.Add(time.Second)); wait != 2*time.Second {
+		t.Errorf("Expected 2s backoff after the second failure, got %v", wait)
+	}
+
+	if wait := failAttempt(t, guard, "budi", "10.0.0.1", now.Add(3*time.Second)); wait != 0 {
+		t.Fatalf("Expected the third attempt after the backoff, got %v", wait)
+	}
+	if wait := failAttempt(t, guard, "budi", "10.0.0.5", now.Add(3*time.Second)); wait != 10*time.Minute {
+		t.Errorf("Expected lockout after the third failure, got %v", wait)
+	}
+
+	// The lockout outlives the failure window.
+	later := now.Add(3*time.Second + 7*time.Minute)
+	if wait := failAttempt(t, guard, "budi", "10.0.0.9", later); wait != 3*time.Minute {
+		t.Errorf("Expected 3m of lockout left, got %v", wait)
+	}
+	if wait := failAttempt(t, guard, "budi", "10.0.0.9", now.Add(11*time.Minute)); wait != 0 {
+		t.Errorf("Expected lockout to expire, got %v", wait)
+	}
+
+	// Attempts blocked by the account did not count against the IP.
+	if wait := failAttempt(t, guard, "other", "10.0.0.2", now); wait != 0 {
+		t.Errorf("Expected the IP of a blocked attempt to stay clear, got %v", wait)
+	}
+}
+
+func TestLoginGuardPerIP(t *testing.T) {
+	guard := newTestGuard()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Spraying one password across many accounts trips the IP limit.
+	for i, name := range []string{"a", "b", "c", "d", "e"} {
+		failAttempt(t, guard, name, "10.0.0.1", now.Add(time.Duration(i)*time.Minute))
+	}
+	last := now.Add(4 * time.Minute)
+	if wait := failAttempt(t, guard, "fresh", "10.0.0.1", last); wait != 10*time.Minute {
+		t.Errorf("Expected IP lockout, got %v", wait)
+	}
+	if wait := failAttempt(t, guard, "fresh", "10.0.0.2", last); wait != 0 {
+		t.Errorf("Expected other IPs to be unaffected, got %v", wait)
+	}
+
+	// A successful login clears the account but not the IP.
+	guard.Succeed(context.Background(), "e")
+	if wait := failAttempt(t, guard, "e", "10.0.0.3", last); wait != 0 {
+		t.Errorf("Expected account counter to be cleared, got %v", wait)
+	}
+	if wait := failAttempt(t, guard, "e", "10.0.0.1", last); wait == 0 {
+		t.Errorf("Expected IP to stay locked after a successful login")
+	}
+}
+
+func TestLoginGuardRelease(t *testing.T) {
+	guard := newTestGuard()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Correct passwords do not build up a backoff.
+	for i := 0; i < 10; i++ {
+		if wait := mustAttempt(t, guard, "budi", "10.0.0.1", now); wait != 0 {
+			t.Fatalf("Expected released attempt %d to go ahead, got %v", i, wait)
+		}
+		if err := guard.Release(context.Background(), "budi", "10.0.0.1"); err != nil {
+			t.Fatalf("Release failed: %v", err)
+		}
+	}
+
+	// Nor do they move the last failure, which the reset window runs from.
+	failAttempt(t, guard, "siti", "10.0.0.2", now)
+	if wait := mustAttempt(t, guard, "ani", "10.0.0.2", now.Add(9*time.Minute)); wait != 0 {
+		t.Fatalf("Expected a login after the backoff, got %v", wait)
+	}
+	guard.Release(context.Background(), "ani", "10.0.0.2")
+	failAttempt(t, guard, "siti", "10.0.0.2", now.Add(10*time.Minute))
+	if wait := mustAttempt(t, guard, "dewi", "10.0.0.2", now.Add(10*time.Minute)); wait != time.Second {
+		t.Errorf("Expected the IP to start over after the window, waiting 1s, got %v", wait)
+	}
+}
+
+func TestLoginGuardParallelAttempts(t *testing.T) {
+	guard := newTestGuard()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if wait := mustAttempt(t, guard, "budi", "10.0.0.1", now); wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Only as many run at once as could fail before the account locks.
+	if allowed != 3 {
+		t.Errorf("Expected a burst of parallel attempts to let 3 through, got %d", allowed)
+	}
+	for i := 0; i < 3; i++ {
+		guard.Fail(context.Background(), "budi", "10.0.0.1", now)
+	}
+	if wait := mustAttempt(t, guard, "budi", "10.0.0.2", now); wait != 10*time.Minute {
+		t.Errorf("Expected the failed burst to lock the account, got %v", wait)
+	}
+}
+
+type fakeLoginAudit struct{}
+
+func (fakeLoginAudit) RecordFailure(ctx context.Context, failure *model.LoginFailure) error {
+	return nil
+}
+
+func TestLoginLockoutIgnoresSpoofedForwardedFor(t *testing.T) {
+	login := func(trustedProxies []string) []int {
+		e := echo.New()
+		server.Configure(e, config.ServerConfig{TrustedProxies: trustedProxies})
+		h := handler.NewAuthHandler(&fakeAccountUserRepo{users: map[uint]*model.User{}}, nil, newTestGuard(), fakeLoginAudit{},
+			"test_secret", time.Hour, nil, nil, false, metrics.New())
+		e.POST("/auth/login", h.Login)
+
+		var codes []int
+		for _, forwarded := range []string{"203.0.113.1", "203.0.113.2"} {
+			req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"username":"`+forwarded+`","password":"x"}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set(echo.HeaderXForwardedFor, forwarded)
+			req.RemoteAddr = "192.0.2.1:1234"
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			codes = append(codes, rec.Code)
+		}
+		return codes
+	}
+
+	// A different X-Forwarded-For on each request is still the same client.
+	if codes := login(nil); codes[0] != http.StatusUnauthorized || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("Expected the second attempt from the same peer to wait, got %v", codes)
+	}
+	// Behind a trusted proxy the forwarded address is the client.
+	if codes := login([]string{"192.0.2.0/24"}); codes[0] != http.StatusUnauthorized || codes[1] != http.StatusUnauthorized {
+		t.Errorf("Expected forwarded clients behind a trusted proxy to be told apart, got %v", codes)
+	}
+}
+
+func TestConcurrentCorrectLogins(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	users := &fakeAccountUserRepo{users: map[uint]*model.User{
+		1: {ID: 1, Username: "budi", PasswordHash: string(hash), Role: auth.RoleCustomer, Status: model.UserStatusActive},
+	}}
+	twoFactor := account.NewTwoFactor(newFakeTwoFactorRepo(), auth.NewSecretCipher("test_secret", auth.CipherLabelTOTP),
+		auth.NewActionTokenSigner("test_secret"), "Shop", nil, time.Minute)
+	h := handler.NewAuthHandler(users, nil, newTestGuard(), fakeLoginAudit{}, "test_secret", time.Hour, nil, twoFactor, false, metrics.New())
+	e := echo.New()
+	e.POST("/auth/login", h.Login)
+
+	// Two devices behind one office NAT log in at the same moment.
+	codes := make([]int, 2)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"username":"budi","password":"secret123"}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.RemoteAddr = "192.0.2.1:1234"
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			codes[i] = rec.Code
+		}(i)
+	}
+	wg.Wait()
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK {
+		t.Errorf("Expected both logins to succeed, got %v", codes)
+	}
+}
@@ -0,0 +1,147 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/pkg/logger"
+)
+
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Expected JSON log lines, got %q: %v", line, err)
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestLoggerRedactsSensitiveAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := logger.New(&buf, logger.FormatJSON, "info")
+	if err != nil {
+		t.Fatalf("Expected logger, got %v", err)
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer abc")
+	header.Set("Accept", "application/json")
+	log.Info("login",
+		"email", "user@example.com",
+		"password", "hunter2",
+		"refresh_token", "r-123",
+		"body", map[string]interface{}{"name": "x", "nested": map[string]interface{}{"client_secret": "s"}},
+		"headers", header,
+	)
+
+	out := buf.String()
+	for _, secret := range []string{"hunter2", "r-123", "Bearer abc", `"s"`} {
+		if strings.Contains(out, secret) {
+			t.Errorf("Expected %q to be redacted, got %s", secret, out)
+		}
+	}
+	if !strings.Contains(out, "user@example.com") || !strings.Contains(out, "application/json") {
+		t.Errorf("Expected non-sensitive values to be kept, got %s", out)
+	}
+	if !strings.Contains(out, logger.Redacted) {
+		t.Errorf("Expected %s placeholder, got %s", logger.Redacted, out)
+	}
+}
+
+func TestLoggerSetLevel(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := logger.New(&buf, logger.FormatText, "warn")
+	if err != nil {
+		t.Fatalf("Expected logger, got %v", err)
+	}
+	log.Info("hidden")
+	if err := log.SetLevel("debug"); err != nil {
+		t.Fatalf("Expected level to change, got %v", err)
+	}
+	log.Debug("shown")
+	if strings.Contains(buf.String(), "hidden") || !strings.Contains(buf.String(), "shown") {
+		t.Errorf("Expected only messages at or above the current level, got %s", buf.String())
+	}
+	if err := log.SetLevel("verbose"); err == nil {
+		t.Error("Expected an unknown level to be rejected")
+	}
+	if _, err := logger.New(&buf, "xml", "info"); err == nil {
+		t.Error("Expected an unknown format to be rejected")
+	}
+}
+
+func TestLoggerMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := logger.New(&buf, logger.FormatJSON, "info")
+	if err != nil {
+		t.Fatalf("Expected logger, got %v", err)
+	}
+
+	e := echo.New()
+	e.Logger = log.Echo()
+	e.Use(log.Middleware("/healthz"))
+	e.GET("/healthz", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	e.GET("/api/orders/:id", func(c echo.Context) error {
+		c.Set("user_id", uint(7))
+		c.Logger().Info("loading order")
+		logger.SetError(c, errors.New("connection refused"))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Database error"})
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/orders/42", nil)
+	req.Header.Set(logger.RequestIDHeader, "trace-1")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(logger.RequestIDHeader); got != "trace-1" {
+		t.Errorf("Expected the incoming request ID to be kept, got %q", got)
+	}
+	lines := decodeLogLines(t, &buf)
+	if len(lines) != 2 {
+		t.Fatalf("Expected handler and request log lines, got %d: %s", len(lines), buf.String())
+	}
+	if lines[0]["request_id"] != "trace-1" {
+		t.Errorf("Expected c.Logger() to carry the request ID, got %v", lines[0])
+	}
+	entry := lines[1]
+	if entry["msg"] != "request" || entry["level"] != "ERROR" {
+		t.Errorf("Expected a request line at error level, got %v", entry)
+	}
+	if entry["route"] != "/api/orders/:id" || entry["path"] != "/api/orders/42" {
+		t.Errorf("Expected route template and path, got %v %v", entry["route"], entry["path"])
+	}
+	if entry["status"] != float64(http.StatusInternalServerError) || entry["user_id"] != float64(7) {
+		t.Errorf("Expected status and user, got %v %v", entry["status"], entry["user_id"])
+	}
+	if entry["error"] != "connection refused" {
+		t.Errorf("Expected the recorded error, got %v", entry["error"])
+	}
+
+	buf.Reset()
+	req = httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set(logger.RequestIDHeader, "bad id\n")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if got := rec.Header().Get(logger.RequestIDHeader); got == "" || got == "bad id\n" {
+		t.Errorf("Expected an invalid request ID to be replaced, got %q", got)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected successful probes to be logged at debug level, got %s", buf.String())
+	}
+}
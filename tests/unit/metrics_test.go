@@ -0,0 +1,91 @@
+package unit
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	_ "github.com/lib/pq"
+
+	"test-ordent/internal/metrics"
+	"test-ordent/internal/model"
+)
+
+func scrapeMetrics(t *testing.T, e *echo.Echo) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected metrics to be served, got %d", rec.Code)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+func TestMetricsMiddlewareLabelsByRoute(t *testing.T) {
+	m := metrics.New()
+	e := echo.New()
+	e.Use(m.Middleware)
+	e.GET("/metrics", m.Handler())
+	e.GET("/api/products/:id", func(c echo.Context) error {
+		if c.Param("id") == "0" {
+			return echo.NewHTTPError(http.StatusNotFound, "Product not found")
+		}
+		return c.NoContent(http.StatusOK)
+	})
+
+	for _, path := range []string{"/api/products/1", "/api/products/2", "/api/products/0", "/no/such/path/123"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	out := scrapeMetrics(t, e)
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/api/products/:id",status="200"} 2`,
+		`http_requests_total{method="GET",route="/api/products/:id",status="404"} 1`,
+		`http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/api/products/:id",status="200"} 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %s in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "/api/products/1") || strings.Contains(out, "/no/such/path") {
+		t.Error("Expected request paths not to be used as labels")
+	}
+}
+
+func TestMetricsBusinessEvents(t *testing.T) {
+	m := metrics.New()
+	m.OrderCreated(150)
+	m.OrderCreated(2500)
+	m.CartItemAdded()
+	m.LoginFailed(model.LoginFailureWrongPassword)
+	m.OutOfStock(metrics.StageCheckout)
+
+	db, err := sql.Open("postgres", "host=127.0.0.1 dbname=shop sslmode=disable")
+	if err != nil {
+		t.Fatalf("Expected a lazily connected pool, got %v", err)
+	}
+	defer db.Close()
+	m.RegisterDB(db, "shop")
+
+	e := echo.New()
+	e.GET("/metrics", m.Handler())
+	out := scrapeMetrics(t, e)
+	for _, want := range []string{
+		"ecommerce_orders_created_total 2",
+		"ecommerce_order_value_sum 2650",
+		"ecommerce_cart_items_added_total 1",
+		`ecommerce_login_failures_total{reason="wrong_password"} 1`,
+		`ecommerce_out_of_stock_rejections_total{stage="checkout"} 1`,
+		`go_sql_max_open_connections{db_name="shop"}`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %s in:\n%s", want, out)
+		}
+	}
+}
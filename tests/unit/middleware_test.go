@@ -0,0 +1,151 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/internal/auth"
+	"test-ordent/internal/model"
+)
+
+type fakeUserLookup map[uint]*model.User
+
+func (f fakeUserLookup) FindByID(ctx context.Context, id uint) (*model.User, error) {
+	if user, ok := f[id]; ok {
+		return user, nil
+	}
+	return nil, errors.New("user not found")
+}
+
+func TestRequireAuthChecksCurrentUserState(t *testing.T) {
+	secret := "test_secret"
+	users := fakeUserLookup{
+		1: {ID: 1, Role: "customer", Status: model.UserStatusActive},
+		2: {ID: 2, Role: "customer", Status: model.UserStatusSuspended},
+		3: {ID: 3, Role: "admin", Status: model.UserStatusDeleted},
+	}
+	middleware := auth.NewJWTMiddleware(secret, users, nil)
+
+	testCases := []struct {
+		name       string
+		userID     uint
+		tokenRole  string
+		wantStatus int
+	}{
+		{name: "Active user", userID: 1, tokenRole: "customer", wantStatus: http.StatusOK},
+		{name: "Demoted admin", userID: 1, tokenRole: "admin", wantStatus: http.StatusForbidden},
+		{name: "Suspended user", userID: 2, tokenRole: "customer", wantStatus: http.StatusForbidden},
+		{name: "Deleted user", userID: 3, tokenRole: "admin", wantStatus: http.StatusUnauthorized},
+		{name: "Unknown user", userID: 4, tokenRole: "customer", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			token, err := auth.GenerateToken(tc.userID, tc.tokenRole, secret, time.Hour)
+			if err != nil {
+				t.Fatalf("Failed to generate token: %v", err)
+			}
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			// The handler requires an admin permission only when the token
+			// claims the admin role, to check that the role comes from the
+			// user record.
+			var handler echo.HandlerFunc = func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+			if tc.tokenRole == "admin" {
+				handler = middleware.RequirePermission(auth.PermUsersManage)(handler)
+			} else {
+				handler = middleware.RequireAuth(handler)
+			}
+
+			if err := handler(c); err != nil {
+				t.Fatalf("Handler returned error: %v", err)
+			}
+			if rec.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d", tc.wantStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	secret := "test_secret"
+	users := fakeUserLookup{
+		1: {ID: 1, Role: auth.RoleCustomer, Status: model.UserStatusActive},
+		2: {ID: 2, Role: auth.RoleCatalogManager, Status: model.UserStatusActive},
+		3: {ID: 3, Role: auth.RoleSupportAgent, Status: model.UserStatusActive},
+		4: {ID: 4, Role: auth.RoleAdmin, Status: model.UserStatusActive},
+		5: {ID: 5, Role: "retired_role", Status: model.UserStatusActive},
+	}
+	middleware := auth.NewJWTMiddleware(secret, users, nil)
+
+	testCases := []struct {
+		name       string
+		userID     uint
+		permission string
+		wantStatus int
+	}{
+		{name: "Customer cannot write products", userID: 1, permission: auth.PermProductsWrite, wantStatus: http.StatusForbidden},
+		{name: "Catalog manager writes products", userID: 2, permission: auth.PermProductsWrite, wantStatus: http.StatusOK},
+		{name: "Catalog manager cannot manage users", userID: 2, permission: auth.PermUsersManage, wantStatus: http.StatusForbidden},
+		{name: "Support agent refunds orders", userID: 3, permission: auth.PermOrdersRefund, wantStatus: http.StatusOK},
+		{name: "Support agent cannot assign roles", userID: 3, permission: auth.PermRolesManage, wantStatus: http.StatusForbidden},
+		{name: "Admin has every permission", userID: 4, permission: auth.PermRolesManage, wantStatus: http.StatusOK},
+		{name: "Unknown role has no permissions", userID: 5, permission: auth.PermOrdersRead, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			token, err := auth.GenerateToken(tc.userID, users[tc.userID].Role, secret, time.Hour)
+			if err != nil {
+				t.Fatalf("Failed to generate token: %v", err)
+			}
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			handler := middleware.RequirePermission(tc.permission)(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+			if err := handler(c); err != nil {
+				t.Fatalf("Handler returned error: %v", err)
+			}
+			if rec.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d", tc.wantStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestTokenCarriesRolePermissions(t *testing.T) {
+	token, err := auth.GenerateToken(1, auth.RoleFulfilmentStaff, "test_secret", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	claims, err := auth.ValidateToken(token, "test_secret")
+	if err != nil {
+		t.Fatalf("Failed to validate token: %v", err)
+	}
+
+	want := []string{auth.PermOrdersRead, auth.PermOrdersFulfil}
+	if len(claims.Permissions) != len(want) {
+		t.Fatalf("Expected permissions %v, got %v", want, claims.Permissions)
+	}
+	for i := range want {
+		if claims.Permissions[i] != want[i] {
+			t.Errorf("Expected permissions %v, got %v", want, claims.Permissions)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package unit
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/config"
+	"test-ordent/internal/account"
+	"test-ordent/internal/auth"
+	"test-ordent/internal/handler"
+	"test-ordent/internal/model"
+	"test-ordent/internal/oidc"
+)
+
+// mockOIDCProvider is a minimal identity provider: discovery, JWKS and a token
+// endpoint that enforces PKCE and returns a signed ID token.
+type mockOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	// codes maps issued authorization codes to what was sent to /authorize.
+	codes map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	challenge string
+	nonce     string
+	claims    map[string]interface{}
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	m := &mockOIDCProvider{key: key, codes: map[string]mockAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                m.server.URL,
+			"authorization_endpoint":                m.server.URL + "/authorize",
+			"token_endpoint":                        m.server.URL + "/token",
+			"jwks_uri":                              m.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &m.key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", m.token)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// authorize plays the user approving the sign-in at authURL and returns the
+// code the provider would send to the callback.
+func (m *mockOIDCProvider) authorize(t *testing.T, authURL string, claims map[string]interface{}) (code, state string) {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("Invalid authorization URL %q: %v", authURL, err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("Expected a PKCE S256 challenge in %q", authURL)
+	}
+	if query.Get("nonce") == "" || query.Get("state") == "" {
+		t.Fatalf("Expected state and nonce in %q", authURL)
+	}
+
+	code = "code-" + query.Get("state")
+	m.codes[code] = mockAuthorization{challenge: query.Get("code_challenge"), nonce: query.Get("nonce"), claims: claims}
+	return code, query.Get("state")
+}
+
+func (m *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+	authorization, ok := m.codes[r.PostForm.Get("code")]
+	if !ok {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	delete(m.codes, r.PostForm.Get("code"))
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != authorization.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	clientID, _, _ := r.BasicAuth()
+	claims := map[string]interface{}{
+		"iss":   m.server.URL,
+		"aud":   clientID,
+		"nonce": authorization.nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}
+	for k, v := range authorization.claims {
+		claims[k] = v
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: m.key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "test"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	idToken, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+// fakeSocialUserRepo adds username lookups to fakeAccountUserRepo.
+type fakeSocialUserRepo struct {
+	fakeAccountUserRepo
+}
+
+func (f *fakeSocialUserRepo) FindByUsername(ctx context.Context, username string) (*model.User, error) {
+	for _, u := range f.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
+
+type fakeIdentityRepo struct {
+	users      map[uint]*model.User
+	identities []model.UserIdentity
+}
+
+func (f *fakeIdentityRepo) FindUser(ctx context.Context, provider, subject string) (*model.User, error) {
+	for _, identity := range f.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return f.users[identity.UserID], nil
+		}
+	}
+	return nil, errors.New("identity not found")
+}
+
+func (f *fakeIdentityRepo) Link(ctx context.Context, userID uint, provider, subject, email string) error {
+	if _, err := f.FindUser(context.Background(), provider, subject); err == nil {
+		return errors.New("identity already linked")
+	}
+	f.identities = append(f.identities, model.UserIdentity{
+		ID: uint(len(f.identities) + 1), UserID: userID, Provider: provider, Subject: subject, Email: email,
+	})
+	return nil
+}
+
+func (f *fakeIdentityRepo) CreateUser(ctx context.Context, user *model.User, provider, subject string) (*model.User, error) {
+	for _, u := range f.users {
+		if u.Username == user.Username || u.Email == user.Email {
+			return nil, errors.New("username or email already exists")
+		}
+	}
+	created := *user
+	created.ID = uint(len(f.users) + 1)
+	created.Status = model.UserStatusActive
+	f.users[created.ID] = &created
+	return &created, f.Link(context.Background(), created.ID, provider, subject, user.Email)
+}
+
+func (f *fakeIdentityRepo) RecordLogin(ctx context.Context, provider, subject string) error {
+	return nil
+}
+
+func (f *fakeIdentityRepo) ListByUser(ctx context.Context, userID uint) ([]model.UserIdentity, error) {
+	var identities []model.UserIdentity
+	for _, identity := range f.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	return identities, nil
+}
+
+func TestOIDCProviderExchange(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider := oidc.NewProvider("mock", config.OIDCProviderConfig{
+		IssuerURL:    mock.server.URL,
+		ClientID:     "shop",
+		ClientSecret: "secret",
+		RedirectURL:  "http://shop.test/api/auth/oidc/mock/callback",
+	})
+
+	state, err := oidc.NewLoginState("mock", time.Minute)
+	if err != nil {
+		t.Fatalf("NewLoginState failed: %v", err)
+	}
+	authURL, err := provider.AuthCodeURL(context.Background(), state.State, state.Nonce, state.Verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	claims := map[string]interface{}{"sub": "42", "email": "jane@example.com", "email_verified": true, "name": "Jane"}
+
+	code, _ := mock.authorize(t, authURL, claims)
+	if _, err := provider.Exchange(context.Background(), code, state.Nonce, "wrong-verifier"); err == nil {
+		t.Error("Expected the exchange to fail with a wrong PKCE verifier")
+	}
+
+	code, _ = mock.authorize(t, authURL, claims)
+	if _, err := provider.Exchange(context.Background(), code, "other-nonce", state.Verifier); err != oidc.ErrNonceMismatch {
+		t.Errorf("Expected ErrNonceMismatch, got %v", err)
+	}
+
+	code, _ = mock.authorize(t, authURL, claims)
+	identity, err := provider.Exchange(context.Background(), code, state.Nonce, state.Verifier)
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if identity.Provider != "mock" || identity.Subject != "42" || identity.Email != "jane@example.com" || !identity.EmailVerified || identity.Name != "Jane" {
+		t.Errorf("Unexpected identity %+v", identity)
+	}
+}
+
+func TestSocialLoginResolve(t *testing.T) {
+	users := map[uint]*model.User{
+		1: {ID: 1, Username: "jane", Email: "jane@example.com", Role: auth.RoleCustomer, Status: model.UserStatusActive},
+	}
+	identities := &fakeIdentityRepo{users: users}
+	social := account.NewSocialLogin(&fakeSocialUserRepo{fakeAccountUserRepo{users: users}}, identities)
+
+	if _, err := social.Resolve(context.Background(), &oidc.Identity{Provider: "mock", Subject: "1", Email: "jane@example.com"}); err != account.ErrEmailNotVerified {
+		t.Errorf("Expected ErrEmailNotVerified for an unverified email, got %v", err)
+	}
+	if _, err := social.Resolve(context.Background(), &oidc.Identity{Provider: "mock", Subject: "2"}); err != account.ErrEmailRequired {
+		t.Errorf("Expected ErrEmailRequired without an email, got %v", err)
+	}
+
+	user, err := social.Resolve(context.Background(), &oidc.Identity{Provider: "mock", Subject: "1", Email: "jane@example.com", EmailVerified: true})
+	if err != nil || user.ID != 1 {
+		t.Fatalf("Expected the identity to be linked to user 1, got %+v (err %v)", user, err)
+	}
+	if !users[1].EmailVerified {
+		t.Error("Expected linking with a verified email to mark the email verified")
+	}
+
+	// Once linked the identity signs in its user even if the email changed.
+	user, err = social.Resolve(context.Background(), &oidc.Identity{Provider: "mock", Subject: "1", Email: "new@example.com"})
+	if err != nil || user.ID != 1 {
+		t.Errorf("Expected the linked identity to resolve to user 1, got %+v (err %v)", user, err)
+	}
+
+	user, err = social.Resolve(context.Background(), &oidc.Identity{Provider: "mock", Subject: "3", Email: "jane@other.example", PreferredUsername: "Jane!"})
+	if err != nil {
+		t.Fatalf("Expected a new user to be created, got %v", err)
+	}
+	if user.ID == 1 || user.Role != auth.RoleCustomer || user.EmailVerified {
+		t.Errorf("Unexpected new user %+v", user)
+	}
+	if len(user.Username) != len("jane-0000") || user.Username[:5] != "jane-" {
+		t.Errorf("Expected a suffixed username since jane is taken, got %q", user.Username)
+	}
+
+	linked, err := social.Identities(context.Background(), 1)
+	if err != nil || len(linked) != 1 || linked[0].Subject != "1" {
+		t.Errorf("Expected one identity for user 1, got %+v (err %v)", linked, err)
+	}
+}
+
+func TestOIDCHandlerLoginAndCallback(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	secret := "test_secret"
+	providers := oidc.NewRegistry(config.OIDCConfig{Providers: map[string]config.OIDCProviderConfig{
+		"mock": {
+			IssuerURL:    mock.server.URL,
+			ClientID:     "shop",
+			ClientSecret: "secret",
+			RedirectURL:  "http://shop.test/api/auth/oidc/mock/callback",
+		},
+	}})
+	users := map[uint]*model.User{}
+	social := account.NewSocialLogin(&fakeSocialUserRepo{fakeAccountUserRepo{users: users}}, &fakeIdentityRepo{users: users})
+	twoFactor := account.NewTwoFactor(newFakeTwoFactorRepo(), auth.NewSecretCipher(secret, auth.CipherLabelTOTP), auth.NewActionTokenSigner(secret),
+		"Test Shop", nil, time.Minute)
+	h := handler.NewOIDCHandler(providers, social, twoFactor, auth.NewSecretCipher(secret, auth.CipherLabelOIDCState),
+		secret, time.Hour, time.Minute, "", false)
+
+	e := echo.New()
+	login := func() (string, *http.Cookie) {
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/login", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("provider")
+		c.SetParamValues("mock")
+		if err := h.Login(c); err != nil {
+			t.Fatalf("Login returned error: %v", err)
+		}
+		if rec.Code != http.StatusFound {
+			t.Fatalf("Expected redirect, got %d: %s", rec.Code, rec.Body.String())
+		}
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 || !cookies[0].HttpOnly {
+			t.Fatalf("Expected one HttpOnly state cookie, got %+v", cookies)
+		}
+		return rec.Header().Get("Location"), cookies[0]
+	}
+	callback := func(query url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/callback?"+query.Encode(), nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("provider")
+		c.SetParamValues("mock")
+		if err := h.Callback(c); err != nil {
+			t.Fatalf("Callback returned error: %v", err)
+		}
+		return rec
+	}
+	claims := map[string]interface{}{"sub": "42", "email": "jane@example.com", "email_verified": true}
+
+	t.Run("Completes sign-in", func(t *testing.T) {
+		authURL, cookie := login()
+		code, state := mock.authorize(t, authURL, claims)
+
+		rec := callback(url.Values{"code": {code}, "state": {state}}, cookie)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var response model.LoginResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		claims, err := auth.ValidateToken(response.Token, secret)
+		if err != nil || claims.UserID != response.User.ID || claims.Role != auth.RoleCustomer {
+			t.Errorf("Unexpected token claims %+v (err %v)", claims, err)
+		}
+		if cleared := rec.Result().Cookies(); len(cleared) != 1 || cleared[0].MaxAge >= 0 {
+			t.Errorf("Expected the state cookie to be cleared, got %+v", cleared)
+		}
+	})
+
+	t.Run("Rejects a state that does not match the cookie", func(t *testing.T) {
+		authURL, cookie := login()
+		code, _ := mock.authorize(t, authURL, claims)
+
+		rec := callback(url.Values{"code": {code}, "state": {"forged"}}, cookie)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Rejects a callback without the state cookie", func(t *testing.T) {
+		authURL, _ := login()
+		code, state := mock.authorize(t, authURL, claims)
+
+		rec := callback(url.Values{"code": {code}, "state": {state}}, nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/internal/handler"
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+)
+
+// fakeAdminOrderRepo implements the OrderRepository reads and status
+// changes used by staff, over a single order.
+type fakeAdminOrderRepo struct {
+	repository.OrderRepository
+	order  model.Order
+	filter model.OrderFilter
+	action string
+}
+
+func (f *fakeAdminOrderRepo) List(ctx context.Context, filter model.OrderFilter) ([]model.Order, int, error) {
+	f.filter = filter
+	return []model.Order{f.order}, 1, nil
+}
+
+func (f *fakeAdminOrderRepo) FindByID(ctx context.Context, id uint) (*model.Order, error) {
+	if id != f.order.ID {
+		return nil, errors.New("order not found")
+	}
+	order := f.order
+	return &order, nil
+}
+
+func (f *fakeAdminOrderRepo) GetOrderItems(ctx context.Context, orderID uint) ([]model.OrderItemDetail, error) {
+	return []model.OrderItemDetail{{ProductID: 3, Name: "Mug", Price: 10, Quantity: 2, Subtotal: 20}}, nil
+}
+
+func (f *fakeAdminOrderRepo) change(id uint, status, action string) (*model.Order, error) {
+	if id != f.order.ID {
+		return nil, errors.New("order not found")
+	}
+	if !model.CanTransitionOrder(f.order.Status, status) {
+		return nil, fmt.Errorf("%w from %s to %s", repository.ErrInvalidStatusTransition, f.order.Status, status)
+	}
+	f.order.Status, f.action = status, action
+	order := f.order
+	return &order, nil
+}
+
+func (f *fakeAdminOrderRepo) UpdateStatus(ctx context.Context, id uint, status string) (*model.Order, error) {
+	return f.change(id, status, model.AuditOrderStatus)
+}
+
+func (f *fakeAdminOrderRepo) Refund(ctx context.Context, id uint) (*model.Order, error) {
+	return f.change(id, model.OrderStatusRefunded, model.AuditOrderRefund)
+}
+
+func orderRequest(method, target, id, body string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	if id != "" {
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+	}
+	c.Set("user_id", uint(1))
+	return c, rec
+}
+
+func TestListAdminOrders(t *testing.T) {
+	repo := &fakeAdminOrderRepo{order: model.Order{ID: 7, Status: model.OrderStatusPaid}}
+	h := handler.NewOrderHandler(repo, nil, nil, nil, nil)
+
+	c, rec := orderRequest(http.MethodGet, "/?status=paid&user_id=4&page=3&limit=10", "", "")
+	if err := h.ListAdminOrders(c); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %v", rec.Code, err)
+	}
+	want := model.OrderFilter{Status: model.OrderStatusPaid, UserID: 4, Limit: 10, Offset: 20}
+	if repo.filter != want {
+		t.Errorf("Expected filter %+v, got %+v", want, repo.filter)
+	}
+
+	for _, query := range []string{"status=lost", "user_id=0", "page=0", "limit=101"} {
+		c, rec := orderRequest(http.MethodGet, "/?"+query, "", "")
+		h.ListAdminOrders(c)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", query, rec.Code)
+		}
+	}
+
+	c, rec = orderRequest(http.MethodGet, "/", "7", "")
+	if err := h.GetAdminOrder(c); err != nil || rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"name":"Mug"`) {
+		t.Errorf("Expected the order with its items, got %d %s", rec.Code, rec.Body.String())
+	}
+	c, rec = orderRequest(http.MethodGet, "/", "8", "")
+	if h.GetAdminOrder(c); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown order to be 404, got %d", rec.Code)
+	}
+}
+
+func TestRefundOrder(t *testing.T) {
+	repo := &fakeAdminOrderRepo{order: model.Order{ID: 7, Status: model.OrderStatusPending}}
+	h := handler.NewOrderHandler(repo, nil, nil, nil, nil)
+
+	c, rec := orderRequest(http.MethodPost, "/", "7", "")
+	if h.RefundOrder(c); rec.Code != http.StatusConflict {
+		t.Errorf("Expected an unpaid order not to be refunded, got %d", rec.Code)
+	}
+
+	// Status changes for fulfilment cannot be used to refund.
+	repo.order.Status = model.OrderStatusDelivered
+	c, rec = orderRequest(http.MethodPut, "/", "7", `{"status":"refunded"}`)
+	if h.UpdateOrderStatus(c); rec.Code != http.StatusBadRequest || repo.order.Status != model.OrderStatusDelivered {
+		t.Errorf("Expected a refund through the status endpoint to be rejected, got %d", rec.Code)
+	}
+
+	c, rec = orderRequest(http.MethodPost, "/", "7", "")
+	if err := h.RefundOrder(c); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %v", rec.Code, err)
+	}
+	if repo.order.Status != model.OrderStatusRefunded || repo.action != model.AuditOrderRefund {
+		t.Errorf("Expected the order to be refunded and audited as a refund, got %s %s", repo.order.Status, repo.action)
+	}
+
+	c, rec = orderRequest(http.MethodPost, "/", "7", "")
+	if h.RefundOrder(c); rec.Code != http.StatusConflict {
+		t.Errorf("Expected a second refund to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestOrderReturnsStock(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{model.OrderStatusPending, model.OrderStatusCancelled, true},
+		{model.OrderStatusPaid, model.OrderStatusCancelled, true},
+		{model.OrderStatusPaid, model.OrderStatusRefunded, true},
+		{model.OrderStatusShipped, model.OrderStatusRefunded, false},
+		{model.OrderStatusDelivered, model.OrderStatusRefunded, false},
+		{model.OrderStatusPaid, model.OrderStatusShipped, false},
+	}
+	for _, tt := range tests {
+		if got := model.OrderReturnsStock(tt.from, tt.to); got != tt.want {
+			t.Errorf("OrderReturnsStock(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+	"test-ordent/internal/worker"
+	"test-ordent/pkg/logger"
+)
+
+// fakePriceRepo records the scheduler calls made against it.
+type fakePriceRepo struct {
+	repository.PriceRepository
+	calls    []string
+	failEnd  bool
+	runCount chan struct{}
+}
+
+func (f *fakePriceRepo) EndExpired(ctx context.Context, now time.Time) (int, error) {
+	f.calls = append(f.calls, "end")
+	if f.failEnd {
+		return 0, errors.New("connection refused")
+	}
+	return 1, nil
+}
+
+func (f *fakePriceRepo) ActivateDue(ctx context.Context, now time.Time) (int, error) {
+	f.calls = append(f.calls, "activate")
+	if f.runCount != nil {
+		f.runCount <- struct{}{}
+	}
+	return 1, nil
+}
+
+func TestEffectivePrice(t *testing.T) {
+	product := model.ProductResponse{Price: 100}
+	if product.EffectivePrice() != 100 {
+		t.Errorf("Expected base price without a sale, got %v", product.EffectivePrice())
+	}
+
+	sale := 79.5
+	product.SalePrice = &sale
+	if product.EffectivePrice() != 79.5 {
+		t.Errorf("Expected sale price, got %v", product.EffectivePrice())
+	}
+}
+
+func TestPriceSchedulerEndsBeforeStarting(t *testing.T) {
+	repo := &fakePriceRepo{failEnd: true}
+	worker.NewPriceScheduler(repo, time.Minute, logger.Discard()).RunOnce(context.Background())
+
+	if len(repo.calls) != 2 || repo.calls[0] != "end" || repo.calls[1] != "activate" {
+		t.Errorf("Expected end then activate even after a failure, got %v", repo.calls)
+	}
+}
+
+func TestPriceSchedulerStopsOnCancel(t *testing.T) {
+	repo := &fakePriceRepo{runCount: make(chan struct{}, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		worker.NewPriceScheduler(repo, time.Hour, logger.Discard()).Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-repo.runCount:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the scheduler to run immediately")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the scheduler to stop after cancel")
+	}
+}
@@ -0,0 +1,169 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/internal/handler"
+	"test-ordent/internal/model"
+	"test-ordent/internal/repository"
+)
+
+// fakeProductWriteRepo implements only the ProductRepository writes used by
+// the product handler, with a product at version 3.
+type fakeProductWriteRepo struct {
+	repository.ProductRepository
+	version int
+	patch   *model.ProductPatch
+	calls   int
+	dbErr   error
+}
+
+func (f *fakeProductWriteRepo) write(version int) error {
+	f.calls++
+	f.version = version
+	if f.dbErr != nil {
+		return f.dbErr
+	}
+	if version != 0 && version != 3 {
+		return errors.New("product version mismatch")
+	}
+	return nil
+}
+
+func (f *fakeProductWriteRepo) Patch(ctx context.Context, id int, version int, patch *model.ProductPatch, actorID uint) (*model.ProductResponse, error) {
+	f.patch = patch
+	if err := f.write(version); err != nil {
+		return nil, err
+	}
+	return &model.ProductResponse{ID: id, Version: 4}, nil
+}
+
+func (f *fakeProductWriteRepo) Delete(ctx context.Context, id int, version int) error {
+	return f.write(version)
+}
+
+func (f *fakeProductWriteRepo) Restore(ctx context.Context, id int, version int) (*model.ProductResponse, error) {
+	if err := f.write(version); err != nil {
+		return nil, err
+	}
+	return &model.ProductResponse{ID: id, Version: 4}, nil
+}
+
+func productRequest(method, ifMatch, contentType, body string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	if contentType != "" {
+		req.Header.Set(echo.HeaderContentType, contentType)
+	}
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("7")
+	c.Set("user_id", uint(1))
+	return c, rec
+}
+
+func TestProductWritesRequireIfMatch(t *testing.T) {
+	tests := []struct {
+		ifMatch     string
+		wantStatus  int
+		wantVersion int
+	}{
+		{"", http.StatusPreconditionRequired, -1},
+		{"abc", http.StatusPreconditionFailed, -1},
+		{`"0"`, http.StatusPreconditionFailed, -1},
+		{`"2"`, http.StatusPreconditionFailed, 2},
+		{`"3"`, http.StatusOK, 3},
+		{` "3" `, http.StatusOK, 3},
+		{"3", http.StatusOK, 3},
+		{"*", http.StatusOK, 0},
+	}
+
+	writes := map[string]func(h *handler.ProductHandler, c echo.Context) error{
+		"PATCH":   func(h *handler.ProductHandler, c echo.Context) error { return h.PatchProduct(c) },
+		"DELETE":  func(h *handler.ProductHandler, c echo.Context) error { return h.DeleteProduct(c) },
+		"restore": func(h *handler.ProductHandler, c echo.Context) error { return h.RestoreProduct(c) },
+	}
+	for name, write := range writes {
+		for _, tt := range tests {
+			repo := &fakeProductWriteRepo{version: -1}
+			h := handler.NewProductHandler(repo, nil)
+			c, rec := productRequest(http.MethodPost, tt.ifMatch, echo.MIMEApplicationJSON, `{"stock":5}`)
+			if err := write(h, c); err != nil {
+				t.Fatalf("%s: unexpected error %v", name, err)
+			}
+			if rec.Code != tt.wantStatus || repo.version != tt.wantVersion {
+				t.Errorf("%s with If-Match %q: expected %d at version %d, got %d at version %d", name, tt.ifMatch, tt.wantStatus, tt.wantVersion, rec.Code, repo.version)
+			}
+			if tt.wantStatus == http.StatusOK && name != "DELETE" && rec.Header().Get("ETag") != `"4"` {
+				t.Errorf("%s: expected the new ETag, got %q", name, rec.Header().Get("ETag"))
+			}
+		}
+	}
+}
+
+func TestProductWriteHidesDatabaseErrors(t *testing.T) {
+	repo := &fakeProductWriteRepo{dbErr: errors.New(`pq: relation "products" does not exist`)}
+	h := handler.NewProductHandler(repo, nil)
+
+	c, rec := productRequest(http.MethodDelete, `"3"`, "", "")
+	if err := h.DeleteProduct(c); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "pq:") {
+		t.Errorf("Expected a 500 without the database error, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestPatchProductBody(t *testing.T) {
+	repo := &fakeProductWriteRepo{}
+	h := handler.NewProductHandler(repo, nil)
+
+	c, rec := productRequest(http.MethodPatch, `"3"`, "application/merge-patch+json; charset=utf-8", `{"name":"Mug","description":null,"stock":0}`)
+	if err := h.PatchProduct(c); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %v", rec.Code, err)
+	}
+	p := repo.patch
+	if p.Name == nil || *p.Name != "Mug" || p.Stock == nil || *p.Stock != 0 {
+		t.Errorf("Expected name and stock to be set, got %+v", p)
+	}
+	if p.Description == nil || *p.Description != "" {
+		t.Errorf("Expected a null description to clear it, got %v", p.Description)
+	}
+	if p.CategoryID != nil || p.Price != nil || p.SKU != nil || p.ImageURL != nil {
+		t.Errorf("Expected omitted fields to stay unset, got %+v", p)
+	}
+
+	rejected := map[string]string{
+		`{"category_id":null}`: "Category ID must be a positive integer",
+		`{"category_id":0}`:    "Category ID must be a positive integer",
+		`{"name":""}`:          "Product name must be a non-empty string",
+		`{"price":-1}`:         "Price must be greater than 0",
+		`{"version":9}`:        "Unknown or read-only field: version",
+		`[1,2]`:                "Request body must be a JSON object",
+		`null`:                 "Request body must be a JSON object",
+	}
+	for body, message := range rejected {
+		repo.calls = 0
+		c, rec := productRequest(http.MethodPatch, `"3"`, echo.MIMEApplicationJSON, body)
+		h.PatchProduct(c)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), message) || repo.calls != 0 {
+			t.Errorf("Expected %s to be rejected with %q, got %d %s", body, message, rec.Code, rec.Body.String())
+		}
+	}
+
+	c, rec = productRequest(http.MethodPatch, `"3"`, "text/plain", `{"name":"Mug"}`)
+	h.PatchProduct(c)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected a non-JSON body to be 415, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,228 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+
+	"test-ordent/config"
+	"test-ordent/internal/auth"
+	"test-ordent/internal/model"
+	"test-ordent/internal/ratelimit"
+	"test-ordent/internal/server"
+)
+
+func TestRateLimitStores(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	stores := map[string]ratelimit.Store{
+		"memory": ratelimit.NewMemoryStore(),
+		"redis":  ratelimit.NewRedisStore(client),
+	}
+
+	// Burst of 3, refilling one token every 10 seconds.
+	rate := ratelimit.Rate{Requests: 6, Per: time.Minute, Burst: 3}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			start := time.Unix(1700000000, 0)
+
+			for i := 0; i < 3; i++ {
+				res, err := store.Take(ctx, "login:ip:1.2.3.4", rate, start)
+				if err != nil {
+					t.Fatalf("Take failed: %v", err)
+				}
+				if !res.Allowed || res.Limit != 3 || res.Remaining != 2-i {
+					t.Fatalf("Request %d: unexpected result %+v", i+1, res)
+				}
+			}
+
+			res, err := store.Take(ctx, "login:ip:1.2.3.4", rate, start)
+			if err != nil {
+				t.Fatalf("Take failed: %v", err)
+			}
+			if res.Allowed || res.RetryAfter != 10*time.Second || res.Reset != 30*time.Second {
+				t.Errorf("Expected rejection with a 10s retry, got %+v", res)
+			}
+
+			if res, _ := store.Take(ctx, "login:ip:5.6.7.8", rate, start); !res.Allowed {
+				t.Error("Expected another key to have its own bucket")
+			}
+
+			res, err = store.Take(ctx, "login:ip:1.2.3.4", rate, start.Add(10*time.Second))
+			if err != nil {
+				t.Fatalf("Take failed: %v", err)
+			}
+			if !res.Allowed || res.Remaining != 0 {
+				t.Errorf("Expected one token after 10s, got %+v", res)
+			}
+
+			res, err = store.Take(ctx, "login:ip:1.2.3.4", rate, start.Add(time.Hour))
+			if err != nil {
+				t.Fatalf("Take failed: %v", err)
+			}
+			if !res.Allowed || res.Remaining != 2 {
+				t.Errorf("Expected the bucket to refill up to the burst, got %+v", res)
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	secret := "test_secret"
+	limiter, err := ratelimit.New(ratelimit.NewMemoryStore(), config.RateLimitConfig{
+		Default: config.RateLimitPolicy{Requests: 2, Per: time.Hour, Key: ratelimit.KeyUser},
+		Policies: []config.RateLimitPolicy{
+			{Name: "login", Methods: []string{"POST"}, Paths: []string{"/api/auth/login"}, Requests: 1, Per: time.Hour, Key: ratelimit.KeyIP},
+			{Name: "open", Paths: []string{"/api/health*"}},
+		},
+	}, secret, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	e := echo.New()
+	e.Use(limiter.Middleware)
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.POST("/api/auth/login", ok)
+	e.GET("/api/products/:id", ok)
+	e.GET("/api/health/ready", ok)
+
+	do := func(method, path, ip, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-Real-IP", ip)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/api/auth/login", "10.0.0.1", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "1" || rec.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("Unexpected first login response %d %v", rec.Code, rec.Header())
+	}
+	if got := rec.Header().Get("RateLimit-Policy"); got != "1;w=3600;burst=1" {
+		t.Errorf("Unexpected RateLimit-Policy %q", got)
+	}
+
+	rec = do(http.MethodPost, "/api/auth/login", "10.0.0.1", "")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "3600" {
+		t.Errorf("Expected 429 with Retry-After, got %d %v", rec.Code, rec.Header())
+	}
+	if rec := do(http.MethodPost, "/api/auth/login", "10.0.0.2", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected another IP to be allowed, got %d", rec.Code)
+	}
+
+	// The default policy counts by user, across IPs, for the route pattern.
+	token, err := auth.GenerateToken(7, auth.RoleCustomer, secret, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	do(http.MethodGet, "/api/products/1", "10.0.0.3", token)
+	do(http.MethodGet, "/api/products/2", "10.0.0.4", token)
+	if rec := do(http.MethodGet, "/api/products/3", "10.0.0.5", token); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the user's third request to be limited, got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/api/products/1", "10.0.0.3", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected an anonymous request to be counted by IP, got %d", rec.Code)
+	}
+
+	for i := 0; i < 5; i++ {
+		rec := do(http.MethodGet, "/api/health/ready", "10.0.0.1", "")
+		if rec.Code != http.StatusOK || rec.Header().Get("RateLimit-Limit") != "" {
+			t.Fatalf("Expected a policy without requests not to limit, got %d %v", rec.Code, rec.Header())
+		}
+	}
+}
+
+func TestRateLimitByAPIKey(t *testing.T) {
+	users := fakeUserLookup{1: {ID: 1, Role: auth.RoleAdmin, Status: model.UserStatusActive}}
+	store := &fakeAPIKeyStore{keys: map[string]*model.APIKey{}, used: map[int]time.Time{}}
+	keys := auth.NewAPIKeyMiddleware(store, users, auth.NewJWTMiddleware("test_secret", users, nil))
+	valid, validKey := store.add(t, 1, 1, []string{auth.PermProductsWrite}, time.Now().Add(time.Hour))
+	prefix, _ := auth.APIKeyPrefix(valid)
+
+	limiter, err := ratelimit.New(ratelimit.NewMemoryStore(), config.RateLimitConfig{
+		Default: config.RateLimitPolicy{Requests: 1, Per: time.Hour, Key: ratelimit.KeyAPIKey},
+	}, "test_secret", keys.Identify)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	e := echo.New()
+	server.Configure(e, config.ServerConfig{})
+	e.Use(limiter.Middleware)
+	e.POST("/api/products", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, keys.RequirePermission(auth.PermProductsWrite))
+
+	do := func(key, peer, forwarded string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/products", nil)
+		req.Header.Set(auth.APIKeyHeader, key)
+		req.Header.Set(echo.HeaderXForwardedFor, forwarded)
+		req.RemoteAddr = peer + ":1234"
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := do(valid, "192.0.2.1", ""); code != http.StatusOK {
+		t.Fatalf("Expected the first keyed request to pass, got %d", code)
+	}
+	if store.lookups != 1 {
+		t.Errorf("Expected the key to be looked up once per request, got %d", store.lookups)
+	}
+	if code := do(valid, "192.0.2.2", ""); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the key to be counted across IPs, got %d", code)
+	}
+
+	// A forged key with the real prefix is counted by IP, not against the key.
+	if code := do(prefix+"_forged", "192.0.2.3", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected a forged key to get its own bucket, got %d", code)
+	}
+	// Neither fresh prefixes nor X-Forwarded-For buy a new bucket.
+	if code := do("sk_00000000_forged", "192.0.2.3", "198.51.100.7"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected unauthenticated keys to share the IP bucket, got %d", code)
+	}
+	if _, ok := store.used[validKey.ID]; !ok {
+		t.Error("Expected the valid key's use to be recorded")
+	}
+}
+
+func TestRateLimitRejectsInvalidPolicies(t *testing.T) {
+	invalid := []config.RateLimitConfig{
+		{Policies: []config.RateLimitPolicy{{Name: "a", Requests: 1, Per: time.Minute, Key: "session"}}},
+		{Policies: []config.RateLimitPolicy{{Name: "a", Requests: 1}}},
+		{Policies: []config.RateLimitPolicy{{Requests: 1, Per: time.Minute}}},
+		{Policies: []config.RateLimitPolicy{{Name: "a"}, {Name: "a"}}},
+	}
+	for i, cfg := range invalid {
+		if _, err := ratelimit.New(ratelimit.NewMemoryStore(), cfg, "secret", nil); err == nil {
+			t.Errorf("Case %d: expected an error", i)
+		}
+	}
+}
+
+func TestRedisStoreCheck(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	store := ratelimit.NewRedisStore(client)
+
+	if err := store.Check(context.Background()); err != nil {
+		t.Errorf("Expected Redis to be reachable, got %v", err)
+	}
+	server.Close()
+	if err := store.Check(context.Background()); err == nil {
+		t.Error("Expected an error once Redis is down")
+	}
+}
@@ -0,0 +1,185 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/config"
+	"test-ordent/internal/reload"
+	"test-ordent/pkg/logger"
+)
+
+func TestConfigDiff(t *testing.T) {
+	old := config.Default()
+	old.Auth.JWTSecret = "old-secret-0123456789"
+	next := config.Default()
+	next.Auth.JWTSecret = "new-secret-0123456789"
+	next.CORS.AllowedOrigins = []string{"https://a.example.com", "https://b.example.com"}
+	next.RateLimit.Default.Requests = 50
+	next.Database.Host = "db"
+
+	changes := config.Diff(old, next)
+	want := map[string]config.Change{
+		"database.host":               {Old: "localhost", New: "db", Reloadable: false},
+		"auth.jwt_secret":             {Old: config.Redacted, New: config.Redacted, Reloadable: false},
+		"cors.allowed_origins":        {Old: "[*]", New: "[https://a.example.com, https://b.example.com]", Reloadable: true},
+		"rate_limit.default.requests": {Old: "0", New: "50", Reloadable: true},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("Expected %d changes, got %v", len(want), changes)
+	}
+	for _, change := range changes {
+		expected, ok := want[change.Key]
+		if !ok {
+			t.Errorf("Unexpected change %v", change)
+			continue
+		}
+		if change.Old != expected.Old || change.New != expected.New || change.Reloadable != expected.Reloadable {
+			t.Errorf("Expected %s: %s -> %s (reloadable %v), got %v (reloadable %v)",
+				change.Key, expected.Old, expected.New, expected.Reloadable, change, change.Reloadable)
+		}
+	}
+
+	if changes := config.Diff(old, old); len(changes) != 0 {
+		t.Errorf("Expected no changes, got %v", changes)
+	}
+}
+
+func TestReloaderAppliesReloadableChanges(t *testing.T) {
+	path := writeConfig(t, baseConfig)
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	reloader := reload.New(path, nil, cfg, logger.Discard())
+	var applied int32
+	reloader.Register(func(next *config.Config) (func(), error) {
+		if next.CORS.AllowedOrigins[0] == "https://rejected.example.com" {
+			return nil, errors.New("origin rejected")
+		}
+		return func() { atomic.AddInt32(&applied, 1) }, nil
+	})
+
+	changes, err := reloader.Reload()
+	if err != nil || len(changes) != 0 || applied != 0 {
+		t.Fatalf("Expected an unchanged file to be a no-op, got %v %v %d", changes, err, applied)
+	}
+
+	testCases := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "Immutable setting", content: strings.Replace(baseConfig, "host: localhost", "host: db", 1), wantErr: "database.host require a restart"},
+		{name: "Invalid file", content: strings.Replace(baseConfig, "port: 8080", "port: 0", 1), wantErr: "server.port"},
+		{name: "Rejected by a component", content: strings.Replace(baseConfig, "shop.example.com", "rejected.example.com", 1), wantErr: "origin rejected"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+			if _, err := reloader.Reload(); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("Expected an error about %q, got %v", tc.wantErr, err)
+			}
+			if applied != 0 || reloader.Current() != cfg {
+				t.Error("Expected the running configuration to be kept")
+			}
+		})
+	}
+
+	content := strings.Replace(baseConfig, "[https://shop.example.com]", "[https://new.example.com]", 1)
+	content = strings.Replace(content, "port: 8080", "port: 8080\nlog:\n  level: debug", 1)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	changes, err = reloader.Reload()
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if len(changes) != 2 || applied != 1 {
+		t.Errorf("Expected two changes applied once, got %v (%d)", changes, applied)
+	}
+	current := reloader.Current()
+	if current.CORS.AllowedOrigins[0] != "https://new.example.com" || current.Log.Level != "debug" {
+		t.Errorf("Expected the new configuration to be in effect, got %+v", current.CORS)
+	}
+}
+
+func TestReloaderWatchesFile(t *testing.T) {
+	path := writeConfig(t, baseConfig)
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	reloader := reload.New(path, nil, cfg, logger.Discard())
+	reloaded := make(chan *config.Config, 1)
+	reloader.Register(func(next *config.Config) (func(), error) {
+		return func() { reloaded <- next }, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- reloader.Watch(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Give the watcher time to start before writing.
+	time.Sleep(100 * time.Millisecond)
+	content := strings.Replace(baseConfig, "[https://shop.example.com]", "[https://watched.example.com]", 1)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	select {
+	case next := <-reloaded:
+		if next.CORS.AllowedOrigins[0] != "https://watched.example.com" {
+			t.Errorf("Unexpected origins %v", next.CORS.AllowedOrigins)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the file change to be reloaded")
+	}
+}
+
+func TestReloadableMiddleware(t *testing.T) {
+	header := func(value string) echo.MiddlewareFunc {
+		return func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) error {
+				c.Response().Header().Add("X-Chain", value)
+				return next(c)
+			}
+		}
+	}
+
+	mw := reload.NewMiddleware(reload.Chain(header("a"), header("b")))
+	e := echo.New()
+	e.Use(mw.Handle)
+	e.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	get := func() []string {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Header().Values("X-Chain")
+	}
+	if got := get(); strings.Join(got, ",") != "a,b" {
+		t.Errorf("Expected the chain to run in order, got %v", got)
+	}
+
+	mw.Swap(header("c"))
+	if got := get(); strings.Join(got, ",") != "c" {
+		t.Errorf("Expected the swapped middleware, got %v", got)
+	}
+}
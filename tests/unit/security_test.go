@@ -0,0 +1,166 @@
+package unit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/config"
+	"test-ordent/internal/security"
+)
+
+func TestCORSHonoursConfig(t *testing.T) {
+	cors, err := security.CORS(config.CORSConfig{
+		AllowedOrigins:   []string{"https://shop.example.com"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		ExposedHeaders:   []string{"ETag", "Retry-After"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("CORS failed: %v", err)
+	}
+
+	e := echo.New()
+	e.Use(cors)
+	e.GET("/api/products", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	req := httptest.NewRequest(http.MethodGet, "/api/products", nil)
+	req.Header.Set("Origin", "https://shop.example.com")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://shop.example.com" {
+		t.Errorf("Expected the configured origin to be allowed, got %q", got)
+	}
+	if rec.Header().Get("Access-Control-Allow-Credentials") != "true" || rec.Header().Get("Access-Control-Expose-Headers") != "ETag,Retry-After" {
+		t.Errorf("Expected credentials and exposed headers, got %v", rec.Header())
+	}
+
+	req = httptest.NewRequest(http.MethodOptions, "/api/products", nil)
+	req.Header.Set("Origin", "https://shop.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Methods") != "GET,POST" || rec.Header().Get("Access-Control-Max-Age") != "3600" {
+		t.Errorf("Unexpected preflight response %d %v", rec.Code, rec.Header())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/products", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Expected other origins to be refused, got %q", got)
+	}
+
+	if _, err := security.CORS(config.CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}); err == nil {
+		t.Error("Expected credentials with the wildcard origin to be rejected")
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	e := echo.New()
+	e.Use(security.Headers(config.SecurityHeadersConfig{
+		HSTSMaxAge:                365 * 24 * time.Hour,
+		HSTSIncludeSubdomains:     true,
+		ContentSecurityPolicy:     "default-src 'none'",
+		DocsContentSecurityPolicy: "default-src 'self'",
+		FrameOptions:              "DENY",
+		ReferrerPolicy:            "no-referrer",
+	}, "/swagger/"))
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/api/products", ok)
+	e.GET("/swagger/*", ok)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/products", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	want := map[string]string{
+		"Content-Security-Policy": "default-src 'none'",
+		"X-Frame-Options":         "DENY",
+		"Referrer-Policy":         "no-referrer",
+		"X-Content-Type-Options":  "nosniff",
+	}
+	for header, value := range want {
+		if got := rec.Header().Get(header); got != value {
+			t.Errorf("Expected %s %q, got %q", header, value, got)
+		}
+	}
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("Expected no HSTS over plain HTTP, got %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/products", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "max-age=31536000; includeSubdomains" {
+		t.Errorf("Unexpected HSTS header %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/swagger/index.html", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if got := rec.Header().Get("Content-Security-Policy"); got != "default-src 'self'" {
+		t.Errorf("Expected the docs policy for Swagger UI, got %q", got)
+	}
+}
+
+func TestBodyLimitPerRoute(t *testing.T) {
+	bodyLimit, err := security.BodyLimit(config.BodyLimitConfig{
+		Default: 10,
+		Routes:  []config.BodyLimitRoute{{Paths: []string{"/api/products/:id/images"}, Limit: 100}},
+	})
+	if err != nil {
+		t.Fatalf("BodyLimit failed: %v", err)
+	}
+
+	e := echo.New()
+	e.Use(bodyLimit)
+	read := func(c echo.Context) error {
+		if _, err := io.ReadAll(c.Request().Body); err != nil {
+			return c.NoContent(http.StatusRequestEntityTooLarge)
+		}
+		return c.NoContent(http.StatusOK)
+	}
+	e.POST("/api/products", read)
+	e.POST("/api/products/:id/images", read)
+
+	testCases := []struct {
+		name       string
+		path       string
+		size       int
+		chunked    bool
+		wantStatus int
+	}{
+		{name: "Within default", path: "/api/products", size: 10, wantStatus: http.StatusOK},
+		{name: "Over default", path: "/api/products", size: 11, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "Over default without Content-Length", path: "/api/products", size: 11, chunked: true, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "Route limit", path: "/api/products/1/images", size: 100, wantStatus: http.StatusOK},
+		{name: "Over route limit", path: "/api/products/1/images", size: 101, wantStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var body io.Reader = strings.NewReader(strings.Repeat("x", tc.size))
+			if tc.chunked {
+				body = io.MultiReader(body)
+			}
+			req := httptest.NewRequest(http.MethodPost, tc.path, body)
+			if tc.chunked {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d", tc.wantStatus, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/config"
+	"test-ordent/internal/health"
+	"test-ordent/internal/server"
+	"test-ordent/pkg/logger"
+)
+
+func TestServeDrainsBeforeShutdown(t *testing.T) {
+	cfg := config.ServerConfig{
+		ReadTimeout:     time.Second,
+		WriteTimeout:    5 * time.Second,
+		DrainDelay:      300 * time.Millisecond,
+		ShutdownTimeout: 5 * time.Second,
+	}
+	readiness := health.NewReadiness(0)
+
+	e := echo.New()
+	e.HideBanner, e.HidePort = true, true
+	server.Configure(e, cfg)
+	if e.Server.ReadTimeout != time.Second || e.Server.WriteTimeout != 5*time.Second {
+		t.Errorf("Expected timeouts to be applied, got %s %s", e.Server.ReadTimeout, e.Server.WriteTimeout)
+	}
+	started := make(chan struct{})
+	e.GET("/readyz", readiness.Handler)
+	e.GET("/checkout", func(c echo.Context) error {
+		close(started)
+		time.Sleep(500 * time.Millisecond)
+		return c.NoContent(http.StatusOK)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx, e, "127.0.0.1:0", cfg, readiness, logger.Discard()) }()
+
+	var base string
+	for i := 0; i < 100 && base == ""; i++ {
+		if addr := e.ListenerAddr(); addr != nil {
+			base = "http://" + addr.String()
+		} else {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if base == "" {
+		t.Fatal("Server did not start")
+	}
+
+	if resp, err := http.Get(base + "/readyz"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the server to be ready, got %v %v", resp, err)
+	}
+
+	checkout := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(base + "/checkout")
+		if err != nil {
+			checkout <- 0
+			return
+		}
+		resp.Body.Close()
+		checkout <- resp.StatusCode
+	}()
+	<-started
+	cancel()
+
+	time.Sleep(100 * time.Millisecond)
+	resp, err := http.Get(base + "/readyz")
+	if err != nil {
+		t.Fatalf("Expected requests to be served while draining, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || readiness.Ready() {
+		t.Errorf("Expected readiness to fail while draining, got %d", resp.StatusCode)
+	}
+
+	if status := <-checkout; status != http.StatusOK {
+		t.Errorf("Expected the in-flight request to finish, got %d", status)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return")
+	}
+	if _, err := http.Get(base + "/readyz"); err == nil {
+		t.Error("Expected the listener to be closed")
+	}
+}
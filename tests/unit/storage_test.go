@@ -0,0 +1,245 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/config"
+	"test-ordent/internal/handler"
+	"test-ordent/internal/repository"
+	"test-ordent/internal/storage"
+	"test-ordent/pkg/imaging"
+)
+
+func TestLocalStorage(t *testing.T) {
+	store, err := storage.NewLocalStorage(t.TempDir(), "/uploads/")
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "products/1/abc/original.png", []byte("data"), "image/png"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	data, err := store.Get(ctx, "products/1/abc/original.png")
+	if err != nil || string(data) != "data" {
+		t.Errorf("Expected to read back stored data, got %q (err: %v)", data, err)
+	}
+
+	if url := store.URL("products/1/abc/original.png"); url != "/uploads/products/1/abc/original.png" {
+		t.Errorf("Unexpected URL %q", url)
+	}
+
+	if err := store.Delete(ctx, "products/1/abc/original.png"); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if _, err := store.Get(ctx, "products/1/abc/original.png"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+
+	for _, key := range []string{"../escape.png", "products/../../escape.png", "/absolute.png", ""} {
+		if err := store.Put(ctx, key, []byte("x"), "image/png"); err == nil {
+			t.Errorf("Expected key %q to be rejected", key)
+		}
+	}
+}
+
+// fakeS3 is a minimal in-memory stand-in for an S3-compatible server.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=test-access/") || !strings.Contains(auth, "Signature=") {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+			http.Error(w, "XAmzContentSHA256Mismatch", http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = body
+	case http.MethodGet:
+		body, ok := f.objects[r.URL.Path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(body)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3Storage(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store, err := storage.NewS3Storage(config.S3StorageConfig{
+		Endpoint:  server.URL,
+		Bucket:    "catalog",
+		AccessKey: "test-access",
+		SecretKey: "test-secret",
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create s3 storage: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "products/1/abc/small.jpg", []byte("thumb"), "image/jpeg"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, ok := fake.objects["/catalog/products/1/abc/small.jpg"]; !ok {
+		t.Fatalf("Expected object to be stored under the bucket path, got %v", fake.objects)
+	}
+
+	data, err := store.Get(ctx, "products/1/abc/small.jpg")
+	if err != nil || string(data) != "thumb" {
+		t.Errorf("Expected to read back stored data, got %q (err: %v)", data, err)
+	}
+
+	if url := store.URL("products/1/abc/small.jpg"); url != server.URL+"/catalog/products/1/abc/small.jpg" {
+		t.Errorf("Unexpected URL %q", url)
+	}
+
+	if err := store.Delete(ctx, "products/1/abc/small.jpg"); err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+	if _, err := store.Get(ctx, "products/1/abc/small.jpg"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func TestImagingThumbnail(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	for x := 0; x < 800; x++ {
+		for y := 0; y < 400; y++ {
+			src.Set(x, y, color.RGBA{R: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+
+	contentType, err := imaging.DetectContentType(buf.Bytes())
+	if err != nil || contentType != "image/png" {
+		t.Fatalf("Expected image/png, got %q (err: %v)", contentType, err)
+	}
+
+	if _, err := imaging.DetectContentType([]byte("<html><body>not an image</body></html>")); err != imaging.ErrUnsupportedType {
+		t.Errorf("Expected HTML to be rejected, got %v", err)
+	}
+
+	img, err := imaging.Decode(buf.Bytes(), 1000, 1000*1000)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	thumb := imaging.Thumbnail(img, 200)
+	if b := thumb.Bounds(); b.Dx() != 200 || b.Dy() != 100 {
+		t.Errorf("Expected 200x100 thumbnail, got %dx%d", b.Dx(), b.Dy())
+	}
+
+	if same := imaging.Thumbnail(img, 1000); same.Bounds() != img.Bounds() {
+		t.Errorf("Expected small image to be left unchanged")
+	}
+
+	if _, encodedType, err := imaging.Encode(thumb, contentType); err != nil || encodedType != "image/png" {
+		t.Errorf("Expected PNG thumbnail, got %q (err: %v)", encodedType, err)
+	}
+
+	if _, err := imaging.Decode(buf.Bytes(), 799, 1000*1000); err != imaging.ErrTooManyPixels {
+		t.Errorf("Expected a side over the limit to be rejected, got %v", err)
+	}
+	if _, err := imaging.Decode(buf.Bytes(), 1000, 800*400-1); err != imaging.ErrTooManyPixels {
+		t.Errorf("Expected too many pixels to be rejected, got %v", err)
+	}
+}
+
+// hugePNG returns a PNG of a few dozen bytes whose header declares a
+// width x height image.
+func hugePNG(t *testing.T, width, height uint32) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+	data := buf.Bytes()
+	// The IHDR chunk follows the 8-byte signature: length, type, then the
+	// width and height, covered by the CRC after its 13 bytes of data.
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+type fakeImageProductRepo struct {
+	repository.ProductRepository
+}
+
+func (fakeImageProductRepo) ExistsByID(ctx context.Context, id int) (bool, error) {
+	return true, nil
+}
+
+func TestUploadImageRejectsHugeDimensions(t *testing.T) {
+	store, err := storage.NewLocalStorage(t.TempDir(), "/uploads/")
+	if err != nil {
+		t.Fatalf("Failed to create local storage: %v", err)
+	}
+	h := handler.NewProductImageHandler(fakeImageProductRepo{}, nil, store, 5<<20, 10000, 40000000, map[string]int{"small": 150})
+
+	data := hugePNG(t, 50000, 50000)
+	if len(data) > 100 {
+		t.Fatalf("Expected a small file, got %d bytes", len(data))
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("image", "huge.png")
+	part.Write(data)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/", &body)
+	req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("7")
+	if err := h.UploadImage(c); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected a 50000x50000 image to be 413, got %d %s", rec.Code, rec.Body.String())
+	}
+}
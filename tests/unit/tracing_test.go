@@ -0,0 +1,133 @@
+package unit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"test-ordent/internal/handler"
+	"test-ordent/internal/model"
+	"test-ordent/internal/tracing"
+	"test-ordent/pkg/logger"
+)
+
+var (
+	spanRecorder    = tracetest.NewSpanRecorder()
+	installRecorder sync.Once
+)
+
+// recordSpans installs a tracer provider recording every span. Tracers
+// obtained before the first provider is set keep using it, so it is
+// installed once for the whole package; tests tell their spans apart by
+// trace ID.
+func recordSpans() *tracetest.SpanRecorder {
+	installRecorder.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	return spanRecorder
+}
+
+func newTracedEcho(t *testing.T, buf *bytes.Buffer) *echo.Echo {
+	t.Helper()
+	log, err := logger.New(buf, logger.FormatJSON, "info")
+	if err != nil {
+		t.Fatalf("Expected logger, got %v", err)
+	}
+	e := echo.New()
+	e.Logger = log.Echo()
+	e.HTTPErrorHandler = handler.HTTPErrorHandler
+	e.Use(tracing.Middleware)
+	e.Use(log.Middleware())
+	return e
+}
+
+func TestTracingContinuesIncomingTrace(t *testing.T) {
+	recorder := recordSpans()
+	var buf bytes.Buffer
+	e := newTracedEcho(t, &buf)
+	e.GET("/api/orders/:id", func(c echo.Context) error {
+		c.Set("user_id", uint(7))
+		return errors.New("connection refused")
+	})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/api/orders/42", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected 500, got %d", rec.Code)
+	}
+	if got := rec.Header().Get(tracing.TraceIDHeader); got != traceID {
+		t.Errorf("Expected the caller's trace ID in %s, got %q", tracing.TraceIDHeader, got)
+	}
+	var body model.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected an ErrorResponse, got %s", rec.Body.String())
+	}
+	if body.TraceID != traceID || body.Error == "connection refused" {
+		t.Errorf("Expected the trace ID and no internal details, got %+v", body)
+	}
+	lines := decodeLogLines(t, &buf)
+	if len(lines) != 1 || lines[0]["trace_id"] != traceID {
+		t.Errorf("Expected the request log line to carry the trace ID, got %v", lines)
+	}
+
+	var found bool
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID().String() != traceID {
+			continue
+		}
+		found = true
+		if span.Name() != "GET /api/orders/:id" {
+			t.Errorf("Expected the span to be named after the route, got %q", span.Name())
+		}
+		if span.Parent().SpanID().String() != "00f067aa0ba902b7" || !span.Parent().IsRemote() {
+			t.Errorf("Expected the caller's span as parent, got %v", span.Parent())
+		}
+		if span.Status().Code != codes.Error {
+			t.Errorf("Expected a server error to fail the span, got %v", span.Status())
+		}
+		attrs := map[string]interface{}{}
+		for _, kv := range span.Attributes() {
+			attrs[string(kv.Key)] = kv.Value.AsInterface()
+		}
+		if attrs["http.response.status_code"] != int64(500) || attrs["enduser.id"] != "7" {
+			t.Errorf("Expected status and user attributes, got %v", attrs)
+		}
+	}
+	if !found {
+		t.Error("Expected a server span in the caller's trace")
+	}
+}
+
+func TestTracingErrorResponses(t *testing.T) {
+	recordSpans()
+	e := newTracedEcho(t, &bytes.Buffer{})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/no/such/path", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404, got %d", rec.Code)
+	}
+	var body model.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error != "Not Found" || body.TraceID != "" {
+		t.Errorf("Expected an ErrorResponse without trace ID for client errors, got %s", rec.Body.String())
+	}
+	if rec.Header().Get(tracing.TraceIDHeader) == "" {
+		t.Error("Expected a new trace to be started for requests without traceparent")
+	}
+}
@@ -0,0 +1,224 @@
+package unit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pquerna/otp/totp"
+
+	"test-ordent/internal/account"
+	"test-ordent/internal/auth"
+	"test-ordent/internal/model"
+)
+
+// fakeTwoFactorRepo mirrors the replay and single-use rules of the Postgres
+// implementation.
+type fakeTwoFactorRepo struct {
+	entries       map[uint]*model.TwoFactor
+	recoveryCodes map[uint]map[string]bool
+}
+
+func newFakeTwoFactorRepo() *fakeTwoFactorRepo {
+	return &fakeTwoFactorRepo{
+		entries:       map[uint]*model.TwoFactor{},
+		recoveryCodes: map[uint]map[string]bool{},
+	}
+}
+
+func (f *fakeTwoFactorRepo) Find(ctx context.Context, userID uint) (*model.TwoFactor, error) {
+	tf, ok := f.entries[userID]
+	if !ok {
+		return nil, errors.New("two-factor not found")
+	}
+	copied := *tf
+	return &copied, nil
+}
+
+func (f *fakeTwoFactorRepo) SavePending(ctx context.Context, userID uint, secret string) error {
+	if tf, ok := f.entries[userID]; ok && tf.ConfirmedAt != nil {
+		return errors.New("two-factor already enabled")
+	}
+	f.entries[userID] = &model.TwoFactor{UserID: userID, Secret: secret}
+	return nil
+}
+
+func (f *fakeTwoFactorRepo) Activate(ctx context.Context, userID uint, step int64, hashes []string) error {
+	tf, ok := f.entries[userID]
+	if !ok || tf.ConfirmedAt != nil {
+		return errors.New("two-factor not found")
+	}
+	now := time.Now()
+	tf.ConfirmedAt = &now
+	tf.LastStep = step
+	return f.ReplaceRecoveryCodes(context.Background(), userID, hashes)
+}
+
+func (f *fakeTwoFactorRepo) UseStep(ctx context.Context, userID uint, step int64) (bool, error) {
+	tf, ok := f.entries[userID]
+	if !ok || tf.ConfirmedAt == nil || tf.LastStep >= step {
+		return false, nil
+	}
+	tf.LastStep = step
+	return true, nil
+}
+
+func (f *fakeTwoFactorRepo) UseRecoveryCode(ctx context.Context, userID uint, hash string) (bool, error) {
+	if !f.recoveryCodes[userID][hash] {
+		return false, nil
+	}
+	f.recoveryCodes[userID][hash] = false
+	return true, nil
+}
+
+func (f *fakeTwoFactorRepo) ReplaceRecoveryCodes(ctx context.Context, userID uint, hashes []string) error {
+	f.recoveryCodes[userID] = map[string]bool{}
+	for _, hash := range hashes {
+		f.recoveryCodes[userID][hash] = true
+	}
+	return nil
+}
+
+func (f *fakeTwoFactorRepo) CountRecoveryCodes(ctx context.Context, userID uint) (int, error) {
+	count := 0
+	for _, unused := range f.recoveryCodes[userID] {
+		if unused {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (f *fakeTwoFactorRepo) Delete(ctx context.Context, userID uint) error {
+	if _, ok := f.entries[userID]; !ok {
+		return errors.New("two-factor not found")
+	}
+	delete(f.entries, userID)
+	delete(f.recoveryCodes, userID)
+	return nil
+}
+
+func TestTwoFactorFlow(t *testing.T) {
+	repo := newFakeTwoFactorRepo()
+	secret := "test_secret"
+	twoFactor := account.NewTwoFactor(repo, auth.NewSecretCipher(secret, auth.CipherLabelTOTP), auth.NewActionTokenSigner(secret),
+		"Test Shop", []string{auth.RoleAdmin}, time.Minute)
+	user := &model.User{ID: 1, Email: "user@example.com", Role: auth.RoleCustomer}
+
+	if _, err := twoFactor.Activate(context.Background(), user.ID, "000000"); err != account.ErrTwoFactorNotEnrolled {
+		t.Fatalf("Expected ErrTwoFactorNotEnrolled before enrolment, got %v", err)
+	}
+
+	enrolment, err := twoFactor.Enroll(context.Background(), user)
+	if err != nil {
+		t.Fatalf("Enroll failed: %v", err)
+	}
+	uri, err := url.Parse(enrolment.OTPAuthURI)
+	if err != nil || uri.Scheme != "otpauth" || uri.Query().Get("secret") != enrolment.Secret || uri.Query().Get("issuer") != "Test Shop" {
+		t.Fatalf("Unexpected otpauth URI %q", enrolment.OTPAuthURI)
+	}
+	if repo.entries[user.ID].Secret == enrolment.Secret {
+		t.Error("Expected the secret to be stored encrypted")
+	}
+
+	if _, err := twoFactor.Activate(context.Background(), user.ID, "000000"); err != account.ErrInvalidCode {
+		t.Fatalf("Expected ErrInvalidCode for a wrong code, got %v", err)
+	}
+
+	code, err := totp.GenerateCode(enrolment.Secret, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to generate code: %v", err)
+	}
+	recoveryCodes, err := twoFactor.Activate(context.Background(), user.ID, code)
+	if err != nil {
+		t.Fatalf("Activate failed: %v", err)
+	}
+	if len(recoveryCodes) != 10 {
+		t.Fatalf("Expected 10 recovery codes, got %d", len(recoveryCodes))
+	}
+	user.TwoFactorEnabled = true
+
+	if _, err := twoFactor.Enroll(context.Background(), user); err != account.ErrTwoFactorEnabled {
+		t.Errorf("Expected ErrTwoFactorEnabled when enrolling again, got %v", err)
+	}
+
+	// The code used for activation cannot be replayed at login.
+	if err := twoFactor.Verify(context.Background(), user.ID, code); err != account.ErrInvalidCode {
+		t.Errorf("Expected a used code to be rejected, got %v", err)
+	}
+
+	if err := twoFactor.VerifyRecoveryCode(context.Background(), user.ID, recoveryCodes[0]); err != nil {
+		t.Errorf("Expected recovery code to be accepted, got %v", err)
+	}
+	if err := twoFactor.VerifyRecoveryCode(context.Background(), user.ID, recoveryCodes[0]); err != account.ErrInvalidCode {
+		t.Errorf("Expected recovery code to be single-use, got %v", err)
+	}
+	status, err := twoFactor.Status(context.Background(), user)
+	if err != nil || !status.Enabled || status.Required || status.RecoveryCodesRemaining != 9 {
+		t.Errorf("Unexpected status %+v (err %v)", status, err)
+	}
+
+	challenge, err := twoFactor.IssueChallenge(user.ID)
+	if err != nil {
+		t.Fatalf("IssueChallenge failed: %v", err)
+	}
+	if userID, err := twoFactor.ParseChallenge(challenge); err != nil || userID != user.ID {
+		t.Errorf("Expected challenge for user %d, got %d (err %v)", user.ID, userID, err)
+	}
+	if _, err := twoFactor.ParseChallenge(challenge + "x"); err != account.ErrInvalidToken {
+		t.Errorf("Expected a tampered challenge to be rejected, got %v", err)
+	}
+
+	admin := &model.User{ID: 2, Role: auth.RoleAdmin, TwoFactorEnabled: true}
+	if err := twoFactor.Disable(context.Background(), admin, "000000"); err != account.ErrTwoFactorRequired {
+		t.Errorf("Expected ErrTwoFactorRequired for admin, got %v", err)
+	}
+}
+
+func TestRequirePermissionEnforcesRequiredTwoFactor(t *testing.T) {
+	secret := "test_secret"
+	users := fakeUserLookup{
+		1: {ID: 1, Role: auth.RoleAdmin, Status: model.UserStatusActive},
+		2: {ID: 2, Role: auth.RoleAdmin, Status: model.UserStatusActive, TwoFactorEnabled: true},
+		3: {ID: 3, Role: auth.RoleCatalogManager, Status: model.UserStatusActive},
+	}
+	middleware := auth.NewJWTMiddleware(secret, users, []string{auth.RoleAdmin})
+
+	testCases := []struct {
+		name       string
+		userID     uint
+		wantStatus int
+	}{
+		{name: "Admin without 2FA", userID: 1, wantStatus: http.StatusForbidden},
+		{name: "Admin with 2FA", userID: 2, wantStatus: http.StatusOK},
+		{name: "Role without 2FA requirement", userID: 3, wantStatus: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			token, err := auth.GenerateToken(tc.userID, users[tc.userID].Role, secret, time.Hour)
+			if err != nil {
+				t.Fatalf("Failed to generate token: %v", err)
+			}
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			handler := middleware.RequirePermission(auth.PermProductsWrite)(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+			if err := handler(c); err != nil {
+				t.Fatalf("Handler returned error: %v", err)
+			}
+			if rec.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d", tc.wantStatus, rec.Code)
+			}
+		})
+	}
+}
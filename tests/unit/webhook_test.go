@@ -0,0 +1,368 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"test-ordent/config"
+	"test-ordent/internal/auth"
+	"test-ordent/internal/handler"
+	"test-ordent/internal/model"
+	"test-ordent/internal/webhook"
+)
+
+func TestWebhookSignature(t *testing.T) {
+	secret, err := webhook.GenerateSecret()
+	if err != nil || !strings.HasPrefix(secret, "whsec_") {
+		t.Fatalf("Expected a whsec_ secret, got %q: %v", secret, err)
+	}
+	sentAt := time.Unix(1700000000, 0)
+	body := []byte(`{"type":"order.status_changed"}`)
+	header := http.Header{}
+	header.Set(webhook.HeaderTimestamp, "1700000000")
+	header.Set(webhook.HeaderSignature, webhook.Sign(secret, sentAt, body))
+
+	if err := webhook.Verify(secret, header, body, 5*time.Minute, sentAt.Add(time.Minute)); err != nil {
+		t.Errorf("Expected the signature to verify, got %v", err)
+	}
+	if err := webhook.Verify(secret, header, []byte(`{"type":"order.created"}`), 5*time.Minute, sentAt); !errors.Is(err, webhook.ErrInvalidSignature) {
+		t.Errorf("Expected a tampered body to be rejected, got %v", err)
+	}
+	if err := webhook.Verify("whsec_other", header, body, 5*time.Minute, sentAt); !errors.Is(err, webhook.ErrInvalidSignature) {
+		t.Errorf("Expected another secret to be rejected, got %v", err)
+	}
+	if err := webhook.Verify(secret, header, body, 5*time.Minute, sentAt.Add(10*time.Minute)); !errors.Is(err, webhook.ErrStaleTimestamp) {
+		t.Errorf("Expected a replayed request to be rejected, got %v", err)
+	}
+	if err := webhook.Verify(secret, http.Header{}, body, 5*time.Minute, sentAt); !errors.Is(err, webhook.ErrMissingSignature) {
+		t.Errorf("Expected an unsigned request to be rejected, got %v", err)
+	}
+}
+
+// fakeWebhookRepo keeps endpoints and deliveries in memory and records the
+// attempts reported by the deliverer.
+type fakeWebhookRepo struct {
+	mu         sync.Mutex
+	endpoints  map[int]*model.WebhookEndpoint
+	due        []model.WebhookDispatch
+	deliveries map[int64]*model.WebhookDelivery
+	retries    map[int64]time.Duration
+}
+
+func newFakeWebhookRepo() *fakeWebhookRepo {
+	return &fakeWebhookRepo{
+		endpoints:  map[int]*model.WebhookEndpoint{},
+		deliveries: map[int64]*model.WebhookDelivery{},
+		retries:    map[int64]time.Duration{},
+	}
+}
+
+func (f *fakeWebhookRepo) CreateEndpoint(ctx context.Context, endpoint *model.WebhookEndpoint) (*model.WebhookEndpoint, error) {
+	endpoint.ID = len(f.endpoints) + 1
+	f.endpoints[endpoint.ID] = endpoint
+	return endpoint, nil
+}
+
+func (f *fakeWebhookRepo) ListEndpoints(ctx context.Context) ([]model.WebhookEndpoint, error) {
+	return nil, nil
+}
+
+func (f *fakeWebhookRepo) FindEndpoint(ctx context.Context, id int) (*model.WebhookEndpoint, error) {
+	endpoint, ok := f.endpoints[id]
+	if !ok {
+		return nil, errors.New("webhook endpoint not found")
+	}
+	return endpoint, nil
+}
+
+func (f *fakeWebhookRepo) UpdateEndpoint(ctx context.Context, endpoint *model.WebhookEndpoint) (*model.WebhookEndpoint, error) {
+	return endpoint, nil
+}
+
+func (f *fakeWebhookRepo) DeleteEndpoint(ctx context.Context, id int) error {
+	return nil
+}
+
+func (f *fakeWebhookRepo) Enqueue(ctx context.Context, event model.Event) error {
+	return nil
+}
+
+func (f *fakeWebhookRepo) CreatePing(ctx context.Context, endpointID int, payload []byte, lease time.Duration) (*model.WebhookDelivery, error) {
+	d := &model.WebhookDelivery{ID: int64(len(f.deliveries) + 1), EndpointID: endpointID, EventType: model.EventWebhookPing,
+		Payload: payload, Status: model.WebhookDeliveryPending}
+	f.deliveries[d.ID] = d
+	return d, nil
+}
+
+func (f *fakeWebhookRepo) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]model.WebhookDispatch, error) {
+	for _, dispatch := range f.due {
+		d := dispatch.Delivery
+		f.deliveries[d.ID] = &d
+	}
+	return f.due, nil
+}
+
+func (f *fakeWebhookRepo) RecordAttempt(ctx context.Context, deliveryID int64, attempt model.WebhookAttempt, status string, retryAfter time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d := f.deliveries[deliveryID]
+	d.Status = status
+	d.Attempts++
+	d.AttemptLog = append(d.AttemptLog, attempt)
+	f.retries[deliveryID] = retryAfter
+	return nil
+}
+
+func (f *fakeWebhookRepo) ListDeliveries(ctx context.Context, filter model.WebhookDeliveryFilter) ([]model.WebhookDelivery, int, error) {
+	return nil, 0, nil
+}
+
+func (f *fakeWebhookRepo) FindDelivery(ctx context.Context, endpointID int, id int64) (*model.WebhookDelivery, error) {
+	d, ok := f.deliveries[id]
+	if !ok || d.EndpointID != endpointID {
+		return nil, errors.New("webhook delivery not found")
+	}
+	return d, nil
+}
+
+func (f *fakeWebhookRepo) Redeliver(ctx context.Context, endpointID int, id int64) (*model.WebhookDelivery, error) {
+	return nil, errors.New("webhook delivery not found")
+}
+
+var testWebhooksConfig = config.WebhooksConfig{
+	BatchSize:       10,
+	Concurrency:     2,
+	Timeout:         time.Second,
+	MaxAttempts:     3,
+	RetryBackoff:    time.Second,
+	MaxRetryBackoff: 5 * time.Second,
+	// The test receivers listen on loopback.
+	AllowedNetworks: []string{"127.0.0.0/8"},
+}
+
+// webhookReceiver accepts signed requests, failing those whose delivery ID
+// is in failIDs.
+func webhookReceiver(t *testing.T, secret string, failIDs map[string]bool) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := webhook.Verify(secret, r.Header, body, time.Minute, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if failIDs[r.Header.Get(webhook.HeaderDelivery)] {
+			http.Error(w, "warehouse unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDelivererRetriesAndDeadLetters(t *testing.T) {
+	cipher := auth.NewSecretCipher("test_secret", auth.CipherLabelWebhook)
+	encrypted, _ := cipher.Encrypt("whsec_partner")
+	server := webhookReceiver(t, "whsec_partner", map[string]bool{"2": true, "3": true})
+
+	repo := newFakeWebhookRepo()
+	for i, attempts := range []int{0, 1, 2} {
+		id := int64(i + 1)
+		repo.due = append(repo.due, model.WebhookDispatch{
+			Delivery: model.WebhookDelivery{ID: id, EndpointID: 1, EventType: model.EventOrderStatusChanged,
+				Payload: json.RawMessage(`{"new_status":"paid"}`), Status: model.WebhookDeliveryPending, Attempts: attempts},
+			URL:    server.URL,
+			Secret: encrypted,
+		})
+	}
+	// A wrong secret fails every signature check.
+	wrong, _ := cipher.Encrypt("whsec_wrong")
+	repo.due = append(repo.due, model.WebhookDispatch{
+		Delivery: model.WebhookDelivery{ID: 4, EndpointID: 1, Payload: json.RawMessage(`{}`), Status: model.WebhookDeliveryPending},
+		URL:      server.URL,
+		Secret:   wrong,
+	})
+
+	deliverer := webhook.NewDeliverer(repo, cipher, testWebhooksConfig)
+	claimed, err := deliverer.DeliverDue(context.Background(), 10)
+	if err != nil || claimed != 4 {
+		t.Fatalf("Expected 4 deliveries claimed, got %d: %v", claimed, err)
+	}
+
+	want := []struct {
+		status     string
+		code       int
+		retryAfter time.Duration
+	}{
+		{model.WebhookDeliveryDelivered, http.StatusOK, 0},
+		// The second failure waits 1s doubled once.
+		{model.WebhookDeliveryPending, http.StatusServiceUnavailable, 2 * time.Second},
+		// The third failure uses up max_attempts.
+		{model.WebhookDeliveryDead, http.StatusServiceUnavailable, 0},
+		{model.WebhookDeliveryPending, http.StatusUnauthorized, time.Second},
+	}
+	for i, w := range want {
+		id := int64(i + 1)
+		d := repo.deliveries[id]
+		if d.Status != w.status || len(d.AttemptLog) != 1 || d.AttemptLog[0].StatusCode != w.code || repo.retries[id] != w.retryAfter {
+			t.Errorf("Delivery %d: expected %s after a %d, retrying after %v; got %+v retrying after %v", id, w.status, w.code, w.retryAfter, d, repo.retries[id])
+		}
+	}
+	if attempt := repo.deliveries[3].AttemptLog[0]; attempt.ResponseBody != "warehouse unavailable\n" || attempt.Error != "endpoint responded 503" {
+		t.Errorf("Expected the response to be logged, got %+v", attempt)
+	}
+}
+
+func newWebhookContext(e *echo.Echo, method, body string, rec *httptest.ResponseRecorder) echo.Context {
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c := e.NewContext(req, rec)
+	c.Set("user_id", uint(1))
+	return c
+}
+
+func TestCreateWebhookAndPing(t *testing.T) {
+	cipher := auth.NewSecretCipher("test_secret", auth.CipherLabelWebhook)
+	repo := newFakeWebhookRepo()
+	h := handler.NewWebhookHandler(repo, webhook.NewDeliverer(repo, cipher, testWebhooksConfig), cipher)
+	e := echo.New()
+
+	for _, body := range []string{
+		`{"name":"","url":"https://partner.example.com/hooks","event_types":["order.status_changed"]}`,
+		`{"name":"Partner","url":"partner.example.com/hooks","event_types":["order.status_changed"]}`,
+		`{"name":"Partner","url":"ftp://partner.example.com","event_types":["order.status_changed"]}`,
+		`{"name":"Partner","url":"https://partner.example.com/hooks","event_types":[]}`,
+		`{"name":"Partner","url":"https://partner.example.com/hooks","event_types":["order.paid"]}`,
+	} {
+		rec := httptest.NewRecorder()
+		h.CreateWebhook(newWebhookContext(e, http.MethodPost, body, rec))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", body, rec.Code)
+		}
+	}
+
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	rec := httptest.NewRecorder()
+	body := `{"name":"Fulfilment partner","url":"` + server.URL + `","event_types":["order.status_changed"]}`
+	if err := h.CreateWebhook(newWebhookContext(e, http.MethodPost, body, rec)); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %v", rec.Code, err)
+	}
+	var created model.CreateWebhookEndpointResponse
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if !created.Endpoint.Active || created.Endpoint.CreatedBy == nil || strings.Contains(rec.Body.String(), repo.endpoints[1].Secret) {
+		t.Errorf("Expected an active endpoint without its stored secret, got %s", rec.Body.String())
+	}
+	if stored, err := cipher.Decrypt(repo.endpoints[1].Secret); err != nil || stored != created.Secret {
+		t.Errorf("Expected the returned secret to be stored encrypted, got %q: %v", stored, err)
+	}
+
+	rec = httptest.NewRecorder()
+	c := newWebhookContext(e, http.MethodPost, "", rec)
+	c.SetParamNames("id")
+	c.SetParamValues(strconv.Itoa(created.Endpoint.ID))
+	if err := h.PingWebhook(c); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %v", rec.Code, err)
+	}
+	var delivery model.WebhookDelivery
+	json.Unmarshal(rec.Body.Bytes(), &delivery)
+	if delivery.Status != model.WebhookDeliveryDelivered || len(delivery.AttemptLog) != 1 || delivery.AttemptLog[0].StatusCode != http.StatusNoContent {
+		t.Errorf("Expected a delivered ping with its attempt, got %s", rec.Body.String())
+	}
+	if received.Get(webhook.HeaderEvent) != model.EventWebhookPing || received.Get(webhook.HeaderSignature) == "" {
+		t.Errorf("Expected a signed ping, got headers %v", received)
+	}
+
+	rec = httptest.NewRecorder()
+	c = newWebhookContext(e, http.MethodPost, "", rec)
+	c.SetParamNames("id")
+	c.SetParamValues("99")
+	h.PingWebhook(c)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown endpoint to be 404, got %d", rec.Code)
+	}
+}
+
+func TestCancelledPingIsRecorded(t *testing.T) {
+	cipher := auth.NewSecretCipher("test_secret", auth.CipherLabelWebhook)
+	encrypted, _ := cipher.Encrypt("whsec_partner")
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The admin gives up while the endpoint is still answering.
+		cancel()
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	repo := newFakeWebhookRepo()
+	repo.endpoints[1] = &model.WebhookEndpoint{ID: 1, Name: "Partner", URL: server.URL, Secret: encrypted}
+	deliverer := webhook.NewDeliverer(repo, cipher, testWebhooksConfig)
+	if _, err := deliverer.Ping(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the ping to be cancelled, got %v", err)
+	}
+
+	d := repo.deliveries[1]
+	if d.Status != model.WebhookDeliveryDead || len(d.AttemptLog) != 1 || d.AttemptLog[0].Error == "" {
+		t.Errorf("Expected the cancelled ping to be recorded as dead, got %+v", d)
+	}
+}
+
+func TestWebhookRejectsInternalAddresses(t *testing.T) {
+	cipher := auth.NewSecretCipher("test_secret", auth.CipherLabelWebhook)
+	repo := newFakeWebhookRepo()
+	cfg := testWebhooksConfig
+	cfg.AllowedNetworks = nil
+	deliverer := webhook.NewDeliverer(repo, cipher, cfg)
+
+	for _, target := range []string{"http://127.0.0.1:8080/hooks", "http://localhost/hooks", "http://[::1]/hooks", "http://10.1.2.3/hooks",
+		"http://192.168.1.1/hooks", "http://169.254.169.254/latest/meta-data", "http://100.100.100.200/", "http://0.0.0.0/"} {
+		if err := deliverer.CheckURL(context.Background(), target); !errors.Is(err, webhook.ErrForbiddenAddress) {
+			t.Errorf("Expected %s to be refused, got %v", target, err)
+		}
+	}
+	if err := deliverer.CheckURL(context.Background(), "https://203.0.113.10/hooks"); err != nil {
+		t.Errorf("Expected a public address to be allowed, got %v", err)
+	}
+
+	h := handler.NewWebhookHandler(repo, deliverer, cipher)
+	rec := httptest.NewRecorder()
+	body := `{"name":"Metadata","url":"http://169.254.169.254/latest/meta-data","event_types":["order.status_changed"]}`
+	h.CreateWebhook(newWebhookContext(echo.New(), http.MethodPost, body, rec))
+	if rec.Code != http.StatusBadRequest || len(repo.endpoints) != 0 {
+		t.Errorf("Expected a link-local endpoint to be rejected, got %d", rec.Code)
+	}
+
+	// An endpoint saved before, or whose host name now resolves to an
+	// internal address, is refused when dialled.
+	received := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+	}))
+	defer server.Close()
+	encrypted, _ := cipher.Encrypt("whsec_partner")
+	repo.endpoints[1] = &model.WebhookEndpoint{ID: 1, Name: "Partner", URL: server.URL, Secret: encrypted}
+	delivery, err := deliverer.Ping(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	if received || delivery.Status != model.WebhookDeliveryDead || !strings.Contains(delivery.AttemptLog[0].Error, webhook.ErrForbiddenAddress.Error()) {
+		t.Errorf("Expected the ping to be refused before connecting, got %+v", delivery)
+	}
+}